@@ -0,0 +1,47 @@
+// Package alert publishes security and operational events raised by the
+// detectors of ns-flows to the configured sinks: the daemon log and an
+// optional HTTP webhook.
+package alert
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Alert types.
+const (
+	TypeNotableFlow = "notable_flow"
+	TypeNewDevice   = "new_device"
+	TypeScanSuspect = "scan_suspect"
+)
+
+// Alert is a detected event. Details holds the evidence and is marshalled to
+// JSON as-is.
+type Alert struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Message string    `json:"message"`
+	Details any       `json:"details,omitempty"`
+}
+
+// Sink receives alerts. Implementations must not block.
+type Sink interface {
+	Publish(alert Alert)
+}
+
+// Publisher fans alerts out to several sinks.
+type Publisher []Sink
+
+// Publish sends alert to every sink.
+func (p Publisher) Publish(alert Alert) {
+	for _, sink := range p {
+		sink.Publish(alert)
+	}
+}
+
+// LogSink writes alerts to the default logger.
+type LogSink struct{}
+
+func (LogSink) Publish(alert Alert) {
+	slog.Warn(alert.Message, "alert", alert.Type, "details", alert.Details)
+}
@@ -0,0 +1,105 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// Webhook POSTs every alert as a JSON object to a URL. Alerts are queued and
+// sent by Run; they are dropped when the queue is full.
+type Webhook struct {
+	url string
+	// endpoint is the scheme and host of url, safe to log: the path and query
+	// often carry a token.
+	endpoint string
+	client   *http.Client
+	queue    chan Alert
+	dropped  atomic.Uint64
+}
+
+// NewWebhook creates a webhook posting to target, an http or https URL. Call
+// Run to start sending.
+func NewWebhook(target string) (*Webhook, error) {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		// The error quotes the URL.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("parse alert webhook: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("alert webhook scheme %q must be http or https", parsed.Scheme)
+	}
+	return &Webhook{
+		url:      target,
+		endpoint: parsed.Scheme + "://" + parsed.Host,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan Alert, 256),
+	}, nil
+}
+
+// Endpoint returns the scheme and host of the webhook URL, for logging.
+func (w *Webhook) Endpoint() string {
+	return w.endpoint
+}
+
+// Publish queues alert for delivery.
+func (w *Webhook) Publish(alert Alert) {
+	select {
+	case w.queue <- alert:
+	default:
+		if w.dropped.Add(1)%100 == 1 {
+			slog.Warn("Alert webhook queue full, dropping alerts", "dropped", w.dropped.Load())
+		}
+	}
+}
+
+// Run delivers queued alerts until ctx is cancelled. Failed deliveries are
+// logged and not retried.
+func (w *Webhook) Run(ctx context.Context) {
+	for {
+		select {
+		case alert := <-w.queue:
+			if err := w.send(ctx, alert); err != nil {
+				slog.Error("Failed to deliver alert", "type", alert.Type, "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Webhook) send(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := w.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = w.endpoint
+		}
+		return err
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
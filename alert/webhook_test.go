@@ -0,0 +1,84 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestWebhook(t *testing.T) {
+	received := make(chan Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		received <- alert
+	}))
+	defer server.Close()
+
+	webhook, err := NewWebhook(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webhook.Run(ctx)
+
+	sent := Alert{
+		Time:    time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		Type:    TypeNotableFlow,
+		Message: "Flow exceeded limits",
+		Details: map[string]any{"digest": "a1b2"},
+	}
+	Publisher{LogSink{}, webhook}.Publish(sent)
+
+	select {
+	case alert := <-received:
+		assert.Equal(t, sent, alert)
+	case <-time.After(5 * time.Second):
+		t.Fatal("alert not delivered")
+	}
+}
+
+func TestNewWebhook(t *testing.T) {
+	for _, target := range []string{"ftp://example.com", "://"} {
+		if _, err := NewWebhook(target); err == nil {
+			t.Errorf("expected error for %q", target)
+		}
+	}
+}
+
+func TestWebhookRedactsURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	target := server.URL + "/hooks/secret?token=secret"
+	// Deliveries fail once the server is gone.
+	server.Close()
+
+	webhook, err := NewWebhook(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, server.URL, webhook.Endpoint())
+	err = webhook.send(context.Background(), Alert{Type: TypeNotableFlow})
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("expected an error without the URL path, got %v", err)
+	}
+
+	_, err = NewWebhook("ftp://example.com/secret")
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("expected an error without the URL path, got %v", err)
+	}
+	_, err = NewWebhook("http://example.com/%zz?secret")
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("expected an error without the URL, got %v", err)
+	}
+}
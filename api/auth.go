@@ -0,0 +1,30 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// RequireToken rejects requests that do not carry "Authorization: Bearer
+// <token>". An empty token rejects every request.
+func RequireToken(token string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		provided, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || token == "" ||
+			subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			slog.Warn(
+				"Rejected unauthenticated request",
+				"method", c.Method(),
+				"path", c.Path(),
+				"remote_addr", c.IP(),
+			)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "missing or invalid API token",
+			})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"sort"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// Category kinds accepted by GET /flows/categories.
+const (
+	categoryByApplication = "application"
+	categoryByProtocol    = "protocol"
+	categoryByDomain      = "domain"
+)
+
+// uncategorized groups the flows without a category of the requested kind.
+const uncategorized = "unknown"
+
+type CategoriesResponse struct {
+	By         string            `json:"by"`
+	Categories []CategorySummary `json:"categories"`
+}
+
+// CategorySummary aggregates the active flows of a category.
+type CategorySummary struct {
+	Category     string               `json:"category"`
+	Flows        int                  `json:"flows"`
+	UploadRate   float64              `json:"upload_rate"`
+	DownloadRate float64              `json:"download_rate"`
+	TotalBytes   int64                `json:"total_bytes"`
+	Applications []ApplicationSummary `json:"applications"`
+}
+
+type ApplicationSummary struct {
+	Name  string `json:"name"`
+	Flows int    `json:"flows"`
+}
+
+// categorySummary groups the active flows by the category of the kind given
+// in the "by" query parameter. Categories are sorted by total bytes.
+func (f *FlowApi) categorySummary(c fiber.Ctx) error {
+	by := c.Query("by", categoryByApplication)
+	switch by {
+	case categoryByApplication, categoryByProtocol, categoryByDomain:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid query parameters: by must be application, protocol or domain",
+		})
+	}
+
+	summaries := make(map[string]*CategorySummary)
+	applications := make(map[string]map[string]int)
+	for _, ev := range f.accessor.GetEvents() {
+		flow, ok := ev.Flow.(flows.FlowComplete)
+		if !ok {
+			continue
+		}
+		f.enrich(&ev)
+
+		category := uncategorized
+		if ev.Enrichment != nil && ev.Enrichment.Categories != nil {
+			var name string
+			switch by {
+			case categoryByApplication:
+				name = ev.Enrichment.Categories.Application
+			case categoryByProtocol:
+				name = ev.Enrichment.Categories.Protocol
+			case categoryByDomain:
+				name = ev.Enrichment.Categories.Domain
+			}
+			if name != "" {
+				category = name
+			}
+		}
+
+		summary, ok := summaries[category]
+		if !ok {
+			summary = &CategorySummary{Category: category}
+			summaries[category] = summary
+			applications[category] = make(map[string]int)
+		}
+		summary.Flows++
+		summary.UploadRate += flow.LocalRate
+		summary.DownloadRate += flow.OtherRate
+		summary.TotalBytes += flow.TotalBytes
+
+		application := flow.DetectedApplicationName
+		if application == "" && ev.Enrichment != nil {
+			application = ev.Enrichment.Application
+		}
+		if application != "" {
+			applications[category][application]++
+		}
+	}
+
+	response := CategoriesResponse{By: by, Categories: make([]CategorySummary, 0, len(summaries))}
+	for category, summary := range summaries {
+		summary.Applications = make([]ApplicationSummary, 0, len(applications[category]))
+		for name, count := range applications[category] {
+			summary.Applications = append(summary.Applications, ApplicationSummary{Name: name, Flows: count})
+		}
+		sort.Slice(summary.Applications, func(i, j int) bool {
+			a, b := summary.Applications[i], summary.Applications[j]
+			if a.Flows != b.Flows {
+				return a.Flows > b.Flows
+			}
+			return a.Name < b.Name
+		})
+		response.Categories = append(response.Categories, *summary)
+	}
+	sort.Slice(response.Categories, func(i, j int) bool {
+		a, b := response.Categories[i], response.Categories[j]
+		if a.TotalBytes != b.TotalBytes {
+			return a.TotalBytes > b.TotalBytes
+		}
+		return a.Category < b.Category
+	})
+	return c.JSON(response)
+}
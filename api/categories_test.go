@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// categoryEnricher assigns categories by detected application ID.
+type categoryEnricher map[int]flows.CategoryInfo
+
+func (e categoryEnricher) Enrich(event *flows.FlowEvent) {
+	flow := event.Flow.(flows.FlowComplete)
+	if categories, ok := e[flow.DetectedApplication]; ok {
+		event.EnsureEnrichment().Categories = &categories
+	}
+}
+
+func categoryFlows() *MockFlowAccessor {
+	flow := func(digest string, app int, name string, bytes int64) flows.FlowEvent {
+		return flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{
+				FlowBase:                flows.FlowBase{Digest: digest},
+				DetectedApplication:     app,
+				DetectedApplicationName: name,
+				Stats:                   flows.Stats{TotalBytes: bytes, LocalRate: 10, OtherRate: 100},
+			},
+		}
+	}
+	return &MockFlowAccessor{events: map[string]flows.FlowEvent{
+		"f-001": flow("f-001", 119, "netify.youtube", 1000),
+		"f-002": flow("f-002", 133, "netify.netflix", 5000),
+		"f-003": flow("f-003", 119, "netify.youtube", 2000),
+		"f-004": flow("f-004", 142, "netify.facebook", 100),
+		"f-005": flow("f-005", 0, "", 50),
+	}}
+}
+
+var testCategories = categoryEnricher{
+	119: {Application: "streaming-media", Protocol: "web"},
+	133: {Application: "streaming-media", Protocol: "web"},
+	142: {Application: "social-networking", Protocol: "web"},
+}
+
+func TestFlowsCategoryFilter(t *testing.T) {
+	app := fiber.New()
+	NewFlowApi(categoryFlows(), &MockFlowIngestor{}, WithEnrichers(testCategories)).Setup(app)
+
+	tests := []struct {
+		category string
+		expected int
+	}{
+		{category: "streaming-media", expected: 3},
+		{category: "web", expected: 4},
+		{category: "gaming", expected: 0},
+		{category: "", expected: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.category, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/flows?category="+tt.category, nil)
+			res, err := app.Test(req)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			var body FlowsResponse
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.expected, len(body.Data))
+		})
+	}
+}
+
+func TestFlowsCategories(t *testing.T) {
+	app := fiber.New()
+	NewFlowApi(categoryFlows(), &MockFlowIngestor{}, WithEnrichers(testCategories)).Setup(app)
+
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/flows/categories", nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var body CategoriesResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, CategoriesResponse{
+		By: "application",
+		Categories: []CategorySummary{
+			{
+				Category:     "streaming-media",
+				Flows:        3,
+				UploadRate:   30,
+				DownloadRate: 300,
+				TotalBytes:   8000,
+				Applications: []ApplicationSummary{
+					{Name: "netify.youtube", Flows: 2},
+					{Name: "netify.netflix", Flows: 1},
+				},
+			},
+			{
+				Category:     "social-networking",
+				Flows:        1,
+				UploadRate:   10,
+				DownloadRate: 100,
+				TotalBytes:   100,
+				Applications: []ApplicationSummary{{Name: "netify.facebook", Flows: 1}},
+			},
+			{
+				Category:     "unknown",
+				Flows:        1,
+				UploadRate:   10,
+				DownloadRate: 100,
+				TotalBytes:   50,
+				Applications: []ApplicationSummary{},
+			},
+		},
+	}, body)
+
+	res, err = app.Test(httptest.NewRequest(http.MethodGet, "/flows/categories?by=protocol", nil))
+	assert.Equal(t, nil, err)
+	body = CategoriesResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(body.Categories))
+	assert.Equal(t, "web", body.Categories[0].Category)
+	assert.Equal(t, 4, body.Categories[0].Flows)
+
+	res, err = app.Test(httptest.NewRequest(http.MethodGet, "/flows/categories?by=color", nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/inventory"
+)
+
+type DevicesResponse struct {
+	Devices []inventory.Device `json:"devices"`
+}
+
+type DeviceKnownRequest struct {
+	Known bool `json:"known"`
+}
+
+// DeviceApi serves the device inventory.
+type DeviceApi struct {
+	inventory *inventory.Inventory
+	token     string
+}
+
+// NewDeviceApi serves inv. Every endpoint requires token as a bearer token;
+// none is served when token is empty.
+func NewDeviceApi(inv *inventory.Inventory, token string) *DeviceApi {
+	return &DeviceApi{inventory: inv, token: token}
+}
+
+func (d *DeviceApi) Setup(app *fiber.App) {
+	if d.token == "" {
+		return
+	}
+	auth := RequireToken(d.token)
+	app.Get("/devices", auth, d.listDevices)
+	app.Put("/devices/:mac/known", auth, d.setKnown)
+}
+
+func (d *DeviceApi) listDevices(c fiber.Ctx) error {
+	devices, err := d.inventory.Devices(c.Context(), fiber.Query(c, "unknown", false))
+	if err != nil {
+		slog.Error("Failed to list devices", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to list devices",
+		})
+	}
+	return c.JSON(DevicesResponse{Devices: devices})
+}
+
+func (d *DeviceApi) setKnown(c fiber.Ctx) error {
+	var request DeviceKnownRequest
+	if err := c.Bind().Body(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request: " + err.Error(),
+		})
+	}
+
+	mac := c.Params("mac")
+	if err := d.inventory.SetKnown(c.Context(), mac, request.Known); err != nil {
+		if errors.Is(err, inventory.ErrUnknownDevice) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		slog.Error("Failed to update device", "mac", mac, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to update device",
+		})
+	}
+	slog.Info("Device updated", "mac", mac, "known", request.Known, "remote_addr", c.IP())
+	return c.SendStatus(fiber.StatusNoContent)
+}
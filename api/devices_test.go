@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/inventory"
+)
+
+func TestDevices(t *testing.T) {
+	ctx := context.Background()
+	inv, err := inventory.Open(ctx, filepath.Join(t.TempDir(), "devices.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inv.Close() //nolint:errcheck
+	inv.Observe(inventory.Sighting{Mac: "aa:bb:cc:dd:ee:01", Ip: "192.168.1.10", Time: time.Now()})
+	inv.Observe(inventory.Sighting{Mac: "aa:bb:cc:dd:ee:02", Ip: "192.168.1.20", Time: time.Now()})
+	if err := inv.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	NewDeviceApi(inv, "secret").Setup(app)
+
+	list := func(t *testing.T, url string) []inventory.Device {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		res, err := app.Test(req)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var body DevicesResponse
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Devices
+	}
+	assert.Equal(t, 2, len(list(t, "/devices")))
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/devices", nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	tests := []struct {
+		name   string
+		mac    string
+		token  string
+		body   string
+		status int
+	}{
+		{name: "missing token", mac: "aa:bb:cc:dd:ee:01", body: `{"known":true}`, status: 401},
+		{
+			name:   "unknown device",
+			mac:    "aa:bb:cc:dd:ee:99",
+			token:  "secret",
+			body:   `{"known":true}`,
+			status: 404,
+		},
+		{name: "bad body", mac: "aa:bb:cc:dd:ee:01", token: "secret", body: `{`, status: 400},
+		{
+			name:   "mark known",
+			mac:    "aa:bb:cc:dd:ee:01",
+			token:  "secret",
+			body:   `{"known":true}`,
+			status: 204,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(
+				http.MethodPut,
+				"/devices/"+tt.mac+"/known",
+				bytes.NewBufferString(tt.body),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			res, err := app.Test(req)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+
+	unknown := list(t, "/devices?unknown=true")
+	assert.Equal(t, 1, len(unknown))
+	assert.Equal(t, "aa:bb:cc:dd:ee:02", unknown[0].Mac)
+}
+
+func TestDevicesWithoutToken(t *testing.T) {
+	inv, err := inventory.Open(
+		context.Background(),
+		filepath.Join(t.TempDir(), "devices.db"),
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inv.Close() //nolint:errcheck
+
+	app := fiber.New()
+	NewDeviceApi(inv, "").Setup(app)
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/devices", nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// Formats of GET /flows, selected by ?format= or the Accept header.
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatTSV  = "tsv"
+
+	mimeTextCSV = "text/csv"
+	mimeTextTSV = "text/tab-separated-values"
+)
+
+// csvColumn is a column of the CSV and TSV exports, after the digest, type
+// and interface columns. Columns are left empty for events that are not
+// flow_dpi_complete.
+type csvColumn struct {
+	name  string
+	value func(event flows.FlowEvent, flow flows.FlowComplete) string
+}
+
+// csvColumns is the documented column set of the exports; append new columns
+// at the end so that existing spreadsheets keep working.
+var csvColumns = []csvColumn{
+	{"first_seen_at", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.FirstSeenAt)
+	}},
+	{"last_seen_at", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.LastSeenAt)
+	}},
+	{"duration_ms", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.LastSeenAt - f.FirstSeenAt)
+	}},
+	{"ip_version", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.IpVersion)
+	}},
+	{"ip_protocol", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.IpProtocol)
+	}},
+	{"vlan_id", func(_ flows.FlowEvent, f flows.FlowComplete) string { return formatInt(f.VlanId) }},
+	{"local_origin", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return strconv.FormatBool(f.LocalOrigin)
+	}},
+	{"local_ip", func(_ flows.FlowEvent, f flows.FlowComplete) string { return f.LocalIp }},
+	{"local_mac", func(_ flows.FlowEvent, f flows.FlowComplete) string { return f.LocalMac }},
+	{"local_port", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.LocalPort)
+	}},
+	{"local_hostname", func(ev flows.FlowEvent, _ flows.FlowComplete) string {
+		if ev.Enrichment == nil || ev.Enrichment.LocalHost == nil {
+			return ""
+		}
+		return ev.Enrichment.LocalHost.Hostname
+	}},
+	{"other_ip", func(_ flows.FlowEvent, f flows.FlowComplete) string { return f.OtherIp }},
+	{"other_mac", func(_ flows.FlowEvent, f flows.FlowComplete) string { return f.OtherMac }},
+	{"other_port", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.OtherPort)
+	}},
+	{"other_type", func(_ flows.FlowEvent, f flows.FlowComplete) string { return f.OtherType }},
+	{"other_hostname", func(ev flows.FlowEvent, _ flows.FlowComplete) string {
+		if ev.Enrichment == nil || ev.Enrichment.OtherHost == nil {
+			return ""
+		}
+		return ev.Enrichment.OtherHost.Hostname
+	}},
+	{"other_country", func(ev flows.FlowEvent, _ flows.FlowComplete) string {
+		if ev.Enrichment == nil || ev.Enrichment.OtherGeo == nil {
+			return ""
+		}
+		return ev.Enrichment.OtherGeo.Country
+	}},
+	{"other_asn", func(ev flows.FlowEvent, _ flows.FlowComplete) string {
+		if ev.Enrichment == nil || ev.Enrichment.OtherAsn == nil {
+			return ""
+		}
+		return strconv.FormatUint(uint64(ev.Enrichment.OtherAsn.Number), 10)
+	}},
+	{"other_asn_organization", func(ev flows.FlowEvent, _ flows.FlowComplete) string {
+		if ev.Enrichment == nil || ev.Enrichment.OtherAsn == nil {
+			return ""
+		}
+		return ev.Enrichment.OtherAsn.Organization
+	}},
+	{"detected_protocol_name", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return f.DetectedProtocolName
+	}},
+	{"detected_application_name", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return f.DetectedApplicationName
+	}},
+	{"category", func(ev flows.FlowEvent, _ flows.FlowComplete) string {
+		if ev.Enrichment == nil || ev.Enrichment.Categories == nil {
+			return ""
+		}
+		return ev.Enrichment.Categories.Application
+	}},
+	{"host_server_name", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return f.HostServerName
+	}},
+	{"dns_host_name", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return f.DnsHostName
+	}},
+	{"ssl_version", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		if f.Ssl == nil {
+			return ""
+		}
+		return f.Ssl.Version
+	}},
+	{"ssl_client_sni", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		if f.Ssl == nil {
+			return ""
+		}
+		return f.Ssl.ClientSni
+	}},
+	{"ssl_cipher_suite", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		if f.Ssl == nil {
+			return ""
+		}
+		return f.Ssl.CipherSuite
+	}},
+	{"ssl_client_ja4", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		if f.Ssl == nil {
+			return ""
+		}
+		return f.Ssl.ClientJa4
+	}},
+	{"http_url", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		if f.Http == nil {
+			return ""
+		}
+		return f.Http.Url
+	}},
+	{"http_user_agent", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		if f.Http == nil {
+			return ""
+		}
+		return f.Http.UserAgent
+	}},
+	{"risk_score", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.Risks.NdpiRiskScore)
+	}},
+	{"risk_score_client", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.Risks.NdpiRiskScoreClient)
+	}},
+	{"risk_score_server", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.Risks.NdpiRiskScoreServer)
+	}},
+	{"risks", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		risks := make([]string, len(f.Risks.Risks))
+		for i, risk := range f.Risks.Risks {
+			risks[i] = strconv.Itoa(risk)
+		}
+		return strings.Join(risks, " ")
+	}},
+	{"local_bytes", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.LocalBytes)
+	}},
+	{"other_bytes", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.OtherBytes)
+	}},
+	{"total_bytes", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.TotalBytes)
+	}},
+	{"local_packets", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.LocalPackets)
+	}},
+	{"other_packets", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.OtherPackets)
+	}},
+	{"total_packets", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return formatInt(f.TotalPackets)
+	}},
+	{"local_rate", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return strconv.FormatFloat(f.LocalRate, 'f', -1, 64)
+	}},
+	{"other_rate", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		return strconv.FormatFloat(f.OtherRate, 'f', -1, 64)
+	}},
+	{"tcp_resets", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		if f.Tcp == nil {
+			return ""
+		}
+		return formatInt(f.Tcp.Resets)
+	}},
+	{"tcp_retrans", func(_ flows.FlowEvent, f flows.FlowComplete) string {
+		if f.Tcp == nil {
+			return ""
+		}
+		return formatInt(f.Tcp.Retrans)
+	}},
+	{"threats", func(ev flows.FlowEvent, _ flows.FlowComplete) string {
+		if ev.Enrichment == nil {
+			return ""
+		}
+		var feeds []string
+		for _, match := range ev.Enrichment.Threats {
+			if !slices.Contains(feeds, match.Feed) {
+				feeds = append(feeds, match.Feed)
+			}
+		}
+		return strings.Join(feeds, " ")
+	}},
+	{"hostname_mismatch", func(ev flows.FlowEvent, _ flows.FlowComplete) string {
+		return strconv.FormatBool(ev.Enrichment != nil && ev.Enrichment.HostnameMismatch != nil)
+	}},
+}
+
+func formatInt[T int | int64](value T) string {
+	return strconv.FormatInt(int64(value), 10)
+}
+
+// writeCSV streams one row per flow, preceded by the header. keep is called
+// on each entry right before it is written, so that enrichment happens row by
+// row rather than for the whole table at once.
+func writeCSV(
+	w *bufio.Writer,
+	comma rune,
+	entries []flowEntry,
+	keep func(event *flows.FlowEvent) bool,
+) {
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+
+	record := make([]string, 0, len(csvColumns)+3)
+	record = append(record, "digest", "type", "interface")
+	for _, column := range csvColumns {
+		record = append(record, column.name)
+	}
+	if err := writer.Write(record); err != nil {
+		slog.Debug("Failed to write flow export", "error", err)
+		return
+	}
+
+	for _, entry := range entries {
+		event := entry.event
+		if !keep(&event) {
+			continue
+		}
+		flow, complete := event.Flow.(flows.FlowComplete)
+		record = append(record[:0], entry.digest, event.Type, event.Interface)
+		for _, column := range csvColumns {
+			value := ""
+			if complete {
+				value = neutralize(column.value(event, flow))
+			}
+			record = append(record, value)
+		}
+		if err := writer.Write(record); err != nil {
+			// The client went away.
+			slog.Debug("Failed to write flow export", "error", err)
+			return
+		}
+	}
+	writer.Flush()
+}
+
+// neutralize keeps spreadsheets from evaluating values controlled by remote
+// hosts, such as user agents or URLs, as formulas.
+func neutralize(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+func TestFlowsExport(t *testing.T) {
+	accessor := &MockFlowAccessor{
+		events: map[string]flows.FlowEvent{
+			"f-001": {
+				Type:      flows.FlowTypeDpiComplete,
+				Interface: "br-lan",
+				Flow: flows.FlowComplete{
+					FlowBase:                flows.FlowBase{Digest: "f-001"},
+					LocalIp:                 "192.168.1.10",
+					OtherIp:                 "203.0.113.5",
+					OtherPort:               443,
+					FirstSeenAt:             1000,
+					LastSeenAt:              4000,
+					DetectedApplicationName: "netify.example",
+					Ssl:                     &flows.Ssl{ClientSni: "www.example.com"},
+					Http:                    &flows.Http{UserAgent: "=HYPERLINK(\"x\")"},
+					Stats:                   flows.Stats{OtherRate: 2048.5, TotalBytes: 10},
+				},
+			},
+			"f-002": {
+				Type: flows.FlowTypeStats,
+				Flow: flows.FlowStats{FlowBase: flows.FlowBase{Digest: "f-002"}},
+			},
+		},
+	}
+	app := setupApi(t, accessor, &MockFlowIngestor{})
+
+	column := func(header []string, name string) int {
+		for i, value := range header {
+			if value == name {
+				return i
+			}
+		}
+		t.Fatalf("missing column %s", name)
+		return -1
+	}
+
+	tests := []struct {
+		name        string
+		target      string
+		accept      string
+		contentType string
+		comma       rune
+	}{
+		{"csv by query", "/flows?format=csv", "", "text/csv; charset=utf-8", ','},
+		{
+			"tsv by accept header",
+			"/flows",
+			"text/tab-separated-values",
+			"text/tab-separated-values; charset=utf-8",
+			'\t',
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			res, err := app.Test(req)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, tt.contentType, res.Header.Get("Content-Type"))
+
+			reader := csv.NewReader(res.Body)
+			reader.Comma = tt.comma
+			records, err := reader.ReadAll()
+			assert.Equal(t, nil, err)
+			assert.Equal(t, 3, len(records))
+			header := records[0]
+			assert.Equal(t, []string{"digest", "type", "interface"}, header[:3])
+			assert.Equal(t, len(csvColumns)+3, len(header))
+
+			// Sorted by descending download rate; flow_stats events carry it too.
+			complete, stats := records[1], records[2]
+			assert.Equal(t, "f-002", stats[0])
+			assert.Equal(t, "f-001", complete[0])
+			assert.Equal(t, "br-lan", complete[column(header, "interface")])
+			assert.Equal(t, "3000", complete[column(header, "duration_ms")])
+			assert.Equal(t, "443", complete[column(header, "other_port")])
+			assert.Equal(t, "www.example.com", complete[column(header, "ssl_client_sni")])
+			assert.Equal(t, "2048.5", complete[column(header, "other_rate")])
+			assert.Equal(t, "", complete[column(header, "tcp_resets")])
+			assert.Equal(t, "false", complete[column(header, "hostname_mismatch")])
+			assert.Equal(t, `'=HYPERLINK("x")`, complete[column(header, "http_user_agent")])
+
+			assert.Equal(t, flows.FlowTypeStats, stats[1])
+			assert.Equal(t, "", stats[column(header, "total_bytes")])
+		})
+	}
+
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, "/flows?format=xlsx", nil))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
@@ -1,11 +1,21 @@
 package api
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"log/slog"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/conntrack"
 	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/hostcheck"
+	"github.com/nethserver/nethsecurity-monitoring/internal/audit"
+	"github.com/nethserver/nethsecurity-monitoring/notable"
+	"github.com/nethserver/nethsecurity-monitoring/scan"
 )
 
 type FlowsResponse struct {
@@ -13,29 +23,178 @@ type FlowsResponse struct {
 }
 
 type FlowApi struct {
-	accessor flows.FlowAccessor
-	ingestor flows.FlowIngestor
+	accessor   flows.FlowAccessor
+	ingestor   flows.FlowIngestor
+	enrichers  []flows.Enricher
+	terminator *flowTerminator
+	capture    EventRecorder
+	traffic    flows.TrafficAccessor
+	notable    *notable.Detector
+	scan       *scan.Detector
+	hostnames  *hostcheck.Checker
 }
 
-func NewFlowApi(accessor flows.FlowAccessor, ingestor flows.FlowIngestor) *FlowApi {
-	return &FlowApi{accessor: accessor, ingestor: ingestor}
+// EventRecorder stores the raw body of every event posted to /flows, such as
+// a capture.Writer.
+type EventRecorder interface {
+	Record(at time.Time, raw []byte) error
 }
 
-func (f *FlowApi) Setup(app *fiber.App) {
-	app.Get("/flows", func(c fiber.Ctx) error {
-		eventsMap := f.accessor.GetEvents()
-		eventsSlice := make([]flows.FlowEvent, 0, len(eventsMap))
-		for _, ev := range eventsMap {
-			eventsSlice = append(eventsSlice, ev)
+type flowTerminator struct {
+	backend  conntrack.Backend
+	token    string
+	recorder audit.Recorder
+}
+
+// FlowApiOption configures optional FlowApi features.
+type FlowApiOption func(*FlowApi)
+
+// WithEnrichers adds enrichers applied to every flow returned by GET /flows.
+func WithEnrichers(enrichers ...flows.Enricher) FlowApiOption {
+	return func(f *FlowApi) {
+		f.enrichers = append(f.enrichers, enrichers...)
+	}
+}
+
+// WithFlowTermination enables DELETE /flows/{digest}, which removes the
+// conntrack entry of a flow through backend. Requests must carry token as a
+// bearer token and every attempt is recorded to recorder.
+func WithFlowTermination(
+	backend conntrack.Backend,
+	token string,
+	recorder audit.Recorder,
+) FlowApiOption {
+	return func(f *FlowApi) {
+		f.terminator = &flowTerminator{backend: backend, token: token, recorder: recorder}
+	}
+}
+
+// WithCapture records every event posted to /flows, as received, to recorder.
+func WithCapture(recorder EventRecorder) FlowApiOption {
+	return func(f *FlowApi) {
+		f.capture = recorder
+	}
+}
+
+func NewFlowApi(
+	accessor flows.FlowAccessor,
+	ingestor flows.FlowIngestor,
+	opts ...FlowApiOption,
+) *FlowApi {
+	f := &FlowApi{accessor: accessor, ingestor: ingestor}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// enrich applies all configured enrichers to the event.
+func (f *FlowApi) enrich(event *flows.FlowEvent) {
+	// The enrichment stored at ingestion is shared by every copy of the flow.
+	if event.Enrichment != nil {
+		enrichment := *event.Enrichment
+		event.Enrichment = &enrichment
+	}
+	for _, enricher := range f.enrichers {
+		enricher.Enrich(event)
+	}
+}
+
+// listFlows returns the active flows, filtered and sorted, as JSON or as a
+// streamed CSV or TSV table.
+func (f *FlowApi) listFlows(c fiber.Ctx) error {
+	format := c.Query("format")
+	if format == "" {
+		switch c.Accepts(fiber.MIMEApplicationJSON, mimeTextCSV, mimeTextTSV) {
+		case mimeTextCSV:
+			format = formatCSV
+		case mimeTextTSV:
+			format = formatTSV
+		default:
+			format = formatJSON
+		}
+	}
+	if format != formatJSON && format != formatCSV && format != formatTSV {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid query parameters: format must be json, csv or tsv",
+		})
+	}
+	sortBy := c.Query("sort_by", sortByDownloadRate)
+	if !slices.Contains(sortKeys, sortBy) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid query parameters: sort_by must be one of " +
+				strings.Join(sortKeys, ", "),
+		})
+	}
+	// The busiest flows come first unless asked otherwise.
+	rate := sortBy == sortByDownloadRate || sortBy == sortByUploadRate
+	desc := fiber.Query(c, "desc", rate)
+	category := c.Query("category")
+	mismatchOnly := fiber.Query(c, "hostname_mismatch", false)
+
+	// keep enriches the event and reports whether it passes the filters.
+	keep := func(ev *flows.FlowEvent) bool {
+		f.enrich(ev)
+		if category != "" && (ev.Enrichment == nil || !ev.Enrichment.Categories.Has(category)) {
+			return false
 		}
+		if mismatchOnly && (ev.Enrichment == nil || ev.Enrichment.HostnameMismatch == nil) {
+			return false
+		}
+		return true
+	}
+	entries := sortedFlows(f.accessor.GetEvents(), sortBy, desc)
 
-		// Response
-		return c.JSON(FlowsResponse{
-			Data: eventsSlice,
+	if format != formatJSON {
+		contentType, comma := mimeTextCSV, ','
+		if format == formatTSV {
+			contentType, comma = mimeTextTSV, '\t'
+		}
+		c.Set(fiber.HeaderContentType, contentType+"; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="flows.`+format+`"`)
+		return c.SendStreamWriter(func(w *bufio.Writer) {
+			writeCSV(w, comma, entries, keep)
 		})
+	}
+
+	eventsSlice := make([]flows.FlowEvent, 0, len(entries))
+	for _, entry := range entries {
+		if keep(&entry.event) {
+			eventsSlice = append(eventsSlice, entry.event)
+		}
+	}
+	return c.JSON(FlowsResponse{
+		Data: eventsSlice,
 	})
+}
+
+func (f *FlowApi) Setup(app *fiber.App) {
+	app.Get("/flows", f.listFlows)
+
+	app.Get("/flows/ja4", f.ja4Summary)
+	app.Get("/flows/threats", f.threatMatches)
+	app.Get("/flows/categories", f.categorySummary)
+	app.Get("/flows/quality", f.qualityRanking)
+	if f.hostnames != nil {
+		app.Get("/flows/hostnames", f.hostnameMismatches)
+	}
+	if f.traffic != nil {
+		app.Get("/flows/traffic", f.trafficTotals)
+	}
+	if f.notable != nil {
+		app.Get("/flows/notable", f.notableFlows)
+	}
+	if f.scan != nil {
+		app.Get("/flows/suspects", f.scanSuspects)
+	}
 
 	app.Post("/flows", func(c fiber.Ctx) error {
+		if f.capture != nil {
+			if err := f.capture.Record(time.Now(), c.Body()); err != nil {
+				slog.Warn("Failed to capture flow event", "error", err)
+			}
+		}
+
 		var event flows.FlowEvent
 		if err := c.Bind().Body(&event); err != nil {
 			// Check if error is due to unsupported flow type
@@ -53,4 +212,67 @@ func (f *FlowApi) Setup(app *fiber.App) {
 		f.ingestor.Process(event)
 		return c.Status(fiber.StatusOK).Send(nil)
 	})
+
+	if f.terminator != nil {
+		app.Delete("/flows/:digest", RequireToken(f.terminator.token), f.terminateFlow)
+	}
+}
+
+// terminateFlow deletes the conntrack entry of the flow identified by digest.
+func (f *FlowApi) terminateFlow(c fiber.Ctx) error {
+	digest := c.Params("digest")
+	record := audit.Record{
+		Time:       time.Now(),
+		Action:     "terminate_flow",
+		Target:     digest,
+		RemoteAddr: c.IP(),
+	}
+	fail := func(status int, message string) error {
+		record.Result = audit.ResultFailure
+		record.Error = message
+		f.recordAudit(record)
+		return c.Status(status).JSON(fiber.Map{"error": message})
+	}
+
+	event, ok := f.accessor.GetEvents()[digest]
+	if !ok {
+		return fail(fiber.StatusNotFound, "flow not found")
+	}
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok {
+		return fail(fiber.StatusNotFound, "flow not found")
+	}
+
+	entry, err := conntrack.EntryFromFlow(flow)
+	if err != nil {
+		return fail(fiber.StatusUnprocessableEntity, err.Error())
+	}
+	record.Details = map[string]any{
+		"conntrack_id": entry.ID,
+		"local_ip":     flow.LocalIp,
+		"other_ip":     flow.OtherIp,
+		"other_port":   flow.OtherPort,
+		"ip_protocol":  flow.IpProtocol,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := f.terminator.backend.Delete(ctx, entry); err != nil {
+		if errors.Is(err, conntrack.ErrNotFound) {
+			return fail(fiber.StatusNotFound, err.Error())
+		}
+		slog.Error("Failed to terminate flow", "digest", digest, "error", err)
+		return fail(fiber.StatusInternalServerError, "failed to terminate flow: "+err.Error())
+	}
+
+	record.Result = audit.ResultSuccess
+	f.recordAudit(record)
+	slog.Info("Terminated flow", "digest", digest, "conntrack_id", entry.ID)
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+func (f *FlowApi) recordAudit(record audit.Record) {
+	if err := f.terminator.recorder.Record(record); err != nil {
+		slog.Error("Failed to write audit record", "action", record.Action, "error", err)
+	}
 }
@@ -2,15 +2,21 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"testing"
+	"time"
 
 	"github.com/go-playground/assert/v2"
 	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/conntrack"
 	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/internal/audit"
 )
 
 type MockFlowAccessor struct {
@@ -177,3 +183,230 @@ func TestFlowsPost(t *testing.T) {
 		})
 	}
 }
+
+type hostnameEnricher struct{}
+
+func (hostnameEnricher) Enrich(event *flows.FlowEvent) {
+	event.EnsureEnrichment().LocalHost = &flows.HostInfo{Hostname: "laptop-anna"}
+}
+
+func TestFlowsEnrichment(t *testing.T) {
+	accessor := &MockFlowAccessor{
+		events: map[string]flows.FlowEvent{
+			"f-001": {
+				Type: flows.FlowTypeDpiComplete,
+				Flow: flows.FlowComplete{FlowBase: flows.FlowBase{Digest: "f-001"}},
+			},
+		},
+	}
+	app := fiber.New()
+	NewFlowApi(accessor, &MockFlowIngestor{}, WithEnrichers(hostnameEnricher{})).Setup(app)
+
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/flows", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, res.StatusCode)
+	// FlowEvent does not decode enrichment, which is computed locally.
+	var body struct {
+		Data []struct {
+			Enrichment flows.Enrichment `json:"enrichment"`
+		} `json:"flows"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(body.Data))
+	assert.Equal(t, "laptop-anna", body.Data[0].Enrichment.LocalHost.Hostname)
+	// The stored event must not be modified by enrichers.
+	if accessor.events["f-001"].Enrichment != nil {
+		t.Fatal("expected stored event to stay untouched")
+	}
+}
+
+type fakeConntrack struct {
+	deleted []conntrack.Entry
+	err     error
+}
+
+func (f *fakeConntrack) Delete(_ context.Context, entry conntrack.Entry) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.deleted = append(f.deleted, entry)
+	return nil
+}
+
+type memoryRecorder struct {
+	records []audit.Record
+}
+
+func (m *memoryRecorder) Record(record audit.Record) error {
+	m.records = append(m.records, record)
+	return nil
+}
+
+func TestFlowsDelete(t *testing.T) {
+	accessor := &MockFlowAccessor{
+		events: map[string]flows.FlowEvent{
+			"f-001": {
+				Type: flows.FlowTypeDpiComplete,
+				Flow: flows.FlowComplete{
+					FlowBase:    flows.FlowBase{Digest: "f-001"},
+					IpProtocol:  6,
+					LocalIp:     "192.168.1.10",
+					LocalPort:   51000,
+					OtherIp:     "1.1.1.1",
+					OtherPort:   443,
+					LocalOrigin: true,
+				},
+			},
+			"f-002": {
+				Type: flows.FlowTypeDpiComplete,
+				Flow: flows.FlowComplete{FlowBase: flows.FlowBase{Digest: "f-002"}},
+			},
+			"f-003": {
+				Type: flows.FlowTypeDpiComplete,
+				Flow: flows.FlowComplete{
+					FlowBase:   flows.FlowBase{Digest: "f-003"},
+					IpProtocol: 1,
+					LocalIp:    "192.168.1.10",
+					OtherIp:    "1.1.1.1",
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		digest     string
+		token      string
+		backendErr error
+		status     int
+		result     string
+	}{
+		{name: "missing token", digest: "f-001", status: http.StatusUnauthorized},
+		{name: "wrong token", digest: "f-001", token: "nope", status: http.StatusUnauthorized},
+		{
+			name:   "unknown flow",
+			digest: "f-999",
+			token:  "secret",
+			status: http.StatusNotFound,
+			result: audit.ResultFailure,
+		},
+		{
+			name:   "no tuple",
+			digest: "f-002",
+			token:  "secret",
+			status: http.StatusUnprocessableEntity,
+			result: audit.ResultFailure,
+		},
+		{
+			name:   "icmp",
+			digest: "f-003",
+			token:  "secret",
+			status: http.StatusUnprocessableEntity,
+			result: audit.ResultFailure,
+		},
+		{
+			name:       "entry already gone",
+			digest:     "f-001",
+			token:      "secret",
+			backendErr: conntrack.ErrNotFound,
+			status:     http.StatusNotFound,
+			result:     audit.ResultFailure,
+		},
+		{
+			name:       "backend failure",
+			digest:     "f-001",
+			token:      "secret",
+			backendErr: errors.New("permission denied"),
+			status:     http.StatusInternalServerError,
+			result:     audit.ResultFailure,
+		},
+		{
+			name:   "terminated",
+			digest: "f-001",
+			token:  "secret",
+			status: http.StatusNoContent,
+			result: audit.ResultSuccess,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeConntrack{err: tt.backendErr}
+			recorder := &memoryRecorder{}
+			app := fiber.New()
+			termination := WithFlowTermination(backend, "secret", recorder)
+			NewFlowApi(accessor, &MockFlowIngestor{}, termination).Setup(app)
+
+			req := httptest.NewRequest(http.MethodDelete, "/flows/"+tt.digest, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			res, err := app.Test(req)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, tt.status, res.StatusCode)
+
+			if tt.result == "" {
+				assert.Equal(t, 0, len(recorder.records))
+				return
+			}
+			assert.Equal(t, 1, len(recorder.records))
+			assert.Equal(t, "terminate_flow", recorder.records[0].Action)
+			assert.Equal(t, tt.digest, recorder.records[0].Target)
+			assert.Equal(t, tt.result, recorder.records[0].Result)
+			if tt.result == audit.ResultSuccess {
+				assert.Equal(t, []conntrack.Entry{{
+					Orig: &conntrack.Tuple{
+						Src:      netip.MustParseAddr("192.168.1.10"),
+						Dst:      netip.MustParseAddr("1.1.1.1"),
+						SrcPort:  51000,
+						DstPort:  443,
+						Protocol: 6,
+					},
+				}}, backend.deleted)
+			}
+		})
+	}
+}
+
+func TestFlowsDeleteDisabled(t *testing.T) {
+	app := setupApi(t, &MockFlowAccessor{events: map[string]flows.FlowEvent{}}, &MockFlowIngestor{})
+
+	res, err := app.Test(httptest.NewRequest(http.MethodDelete, "/flows/f-001", nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+type memoryCapture struct {
+	events []string
+}
+
+func (m *memoryCapture) Record(_ time.Time, raw []byte) error {
+	m.events = append(m.events, string(raw))
+	return nil
+}
+
+func TestFlowsCapture(t *testing.T) {
+	recorder := &memoryCapture{}
+	ingestor := &MockFlowIngestor{}
+	app := fiber.New()
+	NewFlowApi(&MockFlowAccessor{}, ingestor, WithCapture(recorder)).Setup(app)
+
+	payloads := []string{
+		`{"type":"flow_purge","flow":{"digest":"f-001","last_seen_at":1234567890}}`,
+		`{"type":"agent_status","flow":{}}`,
+	}
+	for _, payload := range payloads {
+		req := httptest.NewRequest(http.MethodPost, "/flows", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	// Unsupported events are captured too, so that replays are faithful.
+	assert.Equal(t, payloads, recorder.events)
+	assert.Equal(t, 1, len(ingestor.processedEvents))
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/stats"
+)
+
+const (
+	defaultHistoryRange = 24 * time.Hour
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 1000
+)
+
+// StatsRange echoes the time range and the resolution of a stats query.
+type StatsRange struct {
+	From       int64            `json:"from"`
+	To         int64            `json:"to"`
+	Resolution stats.Resolution `json:"resolution"`
+}
+
+// HostStatsResponse is the traffic of a local host over a time range, with the
+// breakdowns of an hourly report and its traffic per period.
+type HostStatsResponse struct {
+	LocalIp string `json:"local_ip"`
+	StatsRange
+	stats.HourReport
+	Series []stats.Bucket `json:"series"`
+}
+
+// StatsGroup is the traffic of one value of a dimension, such as a local host
+// or an application.
+type StatsGroup struct {
+	Key string `json:"key"`
+	stats.Totals
+	Series []stats.Bucket `json:"series,omitempty"`
+}
+
+// StatsGroupsResponse is a page of the groups of a dimension, largest first.
+// Count is the number of groups across all pages.
+type StatsGroupsResponse struct {
+	StatsRange
+	By     stats.Dimension `json:"by"`
+	Count  int             `json:"count"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+	Groups []StatsGroup    `json:"groups"`
+}
+
+// WithStatsHistory enables the GET /stats endpoints, which read the rollups of
+// store. Requests must carry token as a bearer token; the endpoints are
+// disabled when token is empty.
+func WithStatsHistory(store *stats.Store, token string) StatsApiOption {
+	return func(s *StatsApi) {
+		if token != "" {
+			s.history = store
+			s.token = token
+		}
+	}
+}
+
+// WithStatsHostLookup adds the DHCP hostname of the local host returned by
+// GET /stats/hosts/{ip}, as stats.HourReport.SetHostname does.
+func WithStatsHostLookup(hosts stats.HostLookup) StatsApiOption {
+	return func(s *StatsApi) {
+		s.hosts = hosts
+	}
+}
+
+// parseRange reads the from, to and resolution query parameters. Times are
+// Unix timestamps or RFC 3339; the range defaults to the last day and the
+// resolution to the coarsest one giving a useful series.
+func parseRange(c fiber.Ctx) (stats.Range, error) {
+	to, err := parseTime(c.Query("to"), time.Now())
+	if err != nil {
+		return stats.Range{}, errors.New("to must be a Unix timestamp or an RFC 3339 time")
+	}
+	from, err := parseTime(c.Query("from"), to.Add(-defaultHistoryRange))
+	if err != nil {
+		return stats.Range{}, errors.New("from must be a Unix timestamp or an RFC 3339 time")
+	}
+	if !from.Before(to) {
+		return stats.Range{}, errors.New("from must be before to")
+	}
+
+	resolution := stats.Resolution(c.Query("resolution"))
+	switch resolution {
+	case stats.Hourly, stats.Daily, stats.Monthly:
+	case "":
+		switch span := to.Sub(from); {
+		case span <= 2*24*time.Hour:
+			resolution = stats.Hourly
+		case span <= 62*24*time.Hour:
+			resolution = stats.Daily
+		default:
+			resolution = stats.Monthly
+		}
+	default:
+		return stats.Range{}, errors.New("resolution must be hourly, daily or monthly")
+	}
+	return stats.Range{Resolution: resolution, From: from, To: to}, nil
+}
+
+func parseTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(epoch, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func rangeResponse(r stats.Range) StatsRange {
+	return StatsRange{From: r.From.Unix(), To: r.To.Unix(), Resolution: r.Resolution}
+}
+
+// hostStats reports the traffic of one local host.
+func (s *StatsApi) hostStats(c fiber.Ctx) error {
+	ip := c.Params("ip")
+	if net.ParseIP(ip) == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid local IP: " + ip,
+		})
+	}
+	r, err := parseRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid query parameters: " + err.Error(),
+		})
+	}
+
+	filter := stats.Filter{stats.DimensionLocalIP: ip}
+	rows, err := s.history.QueryRange(c.Context(), r, filter)
+	if err != nil {
+		return historyError(c, err)
+	}
+	series, err := s.history.QuerySeries(c.Context(), r, filter)
+	if err != nil {
+		return historyError(c, err)
+	}
+
+	report := stats.BuildReport(rows)
+	report.SetHostname(s.hosts, ip)
+	return c.JSON(HostStatsResponse{
+		LocalIp:    ip,
+		StatsRange: rangeResponse(r),
+		HourReport: report,
+		Series:     series,
+	})
+}
+
+// groupsHandler lists the traffic of each value of dimension.
+func (s *StatsApi) groupsHandler(dimension stats.Dimension) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return s.listGroups(c, dimension)
+	}
+}
+
+// topGroups ranks the values of the dimension given by the by query
+// parameter.
+func (s *StatsApi) topGroups(c fiber.Ctx) error {
+	by := stats.Dimension(c.Query("by"))
+	if !slices.Contains(stats.Dimensions, by) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid query parameters: by must be one of " + dimensionNames(),
+		})
+	}
+	return s.listGroups(c, by)
+}
+
+// listGroups serves a page of the traffic of each value of dimension, largest
+// first, filtered by the query parameters named after the dimensions.
+func (s *StatsApi) listGroups(c fiber.Ctx, dimension stats.Dimension) error {
+	r, err := parseRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid query parameters: " + err.Error(),
+		})
+	}
+	limit := fiber.Query(c, "limit", defaultHistoryLimit)
+	offset := fiber.Query(c, "offset", 0)
+	if limit < 1 || limit > maxHistoryLimit || offset < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid query parameters: limit must be between 1 and " +
+				strconv.Itoa(maxHistoryLimit) + " and offset not negative",
+		})
+	}
+	filter := stats.Filter{}
+	for _, name := range stats.Dimensions {
+		if value := c.Query(string(name)); value != "" {
+			filter[name] = value
+		}
+	}
+	if port, ok := filter[stats.DimensionOtherPort]; ok {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid query parameters: other_port must be a port number",
+			})
+		}
+	}
+
+	groups, count, err := s.history.QueryGroups(c.Context(), stats.GroupQuery{
+		Range:     r,
+		Filter:    filter,
+		Dimension: dimension,
+		Limit:     limit,
+		Offset:    offset,
+		Series:    fiber.Query(c, "series", false),
+	})
+	if err != nil {
+		return historyError(c, err)
+	}
+
+	response := StatsGroupsResponse{
+		StatsRange: rangeResponse(r),
+		By:         dimension,
+		Count:      count,
+		Limit:      limit,
+		Offset:     offset,
+		Groups:     make([]StatsGroup, 0, len(groups)),
+	}
+	for _, group := range groups {
+		response.Groups = append(response.Groups, StatsGroup{
+			Key:    group.Key,
+			Totals: group.Totals,
+			Series: group.Series,
+		})
+	}
+	return c.JSON(response)
+}
+
+func dimensionNames() string {
+	names := make([]string, 0, len(stats.Dimensions))
+	for _, dimension := range stats.Dimensions {
+		names = append(names, string(dimension))
+	}
+	return strings.Join(names, ", ")
+}
+
+func historyError(c fiber.Ctx, err error) error {
+	slog.Error("Failed to query stats history", "path", c.Path(), "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to query stats history",
+	})
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/stats"
+)
+
+type staticHosts map[string][2]string
+
+func (h staticHosts) LookupHost(ip string) (string, string, bool) {
+	host, ok := h[ip]
+	return host[0], host[1], ok
+}
+
+func setupHistoryApi(t *testing.T, hour time.Time) *fiber.App {
+	t.Helper()
+
+	store, err := stats.NewStore(context.Background(), filepath.Join(t.TempDir(), "stats.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	for i, entry := range []struct {
+		localIp string
+		app     string
+		otherIp string
+		bytes   int64
+	}{
+		{"10.0.0.1", "netify.youtube", "142.250.1.1", 100},
+		{"10.0.0.1", "netify.netflix", "45.57.1.1", 10},
+		{"10.0.0.2", "netify.youtube", "142.250.1.1", 1000},
+	} {
+		if err := store.Save(context.Background(), stats.AggregatorPayload{
+			LogTimeEnd: hour.Add(time.Duration(i+1) * time.Minute).Unix(),
+			Stats: []stats.AggregatorEntry{{
+				DetectedApplicationName: entry.app,
+				DetectedProtocolName:    "HTTP/S",
+				LocalIp:                 entry.localIp,
+				LocalMac:                "02:00:00:00:00:01",
+				LocalBytes:              entry.bytes,
+				OtherIp:                 entry.otherIp,
+			}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Rollup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	NewStatsApi(
+		&mockSaver{},
+		WithStatsHistory(store, "secret"),
+		WithStatsHostLookup(staticHosts{"10.0.0.1": {"laptop", "02:00:00:00:00:01"}}),
+	).Setup(app)
+	return app
+}
+
+func getJSON(t *testing.T, app *fiber.App, target string, response any) int {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode
+}
+
+func TestStatsHistory(t *testing.T) {
+	hour := time.Date(2026, 5, 4, 9, 0, 0, 0, time.UTC)
+	app := setupHistoryApi(t, hour)
+	query := fmt.Sprintf("from=%d&to=%s", hour.Unix(), hour.Add(time.Hour).Format(time.RFC3339))
+
+	t.Run("host", func(t *testing.T) {
+		var response HostStatsResponse
+		status := getJSON(t, app, "/stats/hosts/10.0.0.1?"+query, &response)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "10.0.0.1", response.LocalIp)
+		assert.Equal(t, stats.Hourly, response.Resolution)
+		assert.Equal(t, "laptop", response.Hostname)
+		assert.Equal(t, "02:00:00:00:00:01", response.Mac)
+		assert.Equal(t, int64(110), response.Total)
+		assert.Equal(t, map[string]int64{
+			"netify.youtube": 100,
+			"netify.netflix": 10,
+		}, response.Application)
+		assert.Equal(t, []stats.Bucket{{
+			Start:  hour.Unix(),
+			Totals: stats.Totals{Entries: 2, LocalBytes: 110, Total: 110},
+		}}, response.Series)
+	})
+
+	t.Run("applications", func(t *testing.T) {
+		var response StatsGroupsResponse
+		status := getJSON(t, app, "/stats/applications?series=true&limit=1&"+query, &response)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 2, response.Count)
+		assert.Equal(t, 1, len(response.Groups))
+		assert.Equal(t, "netify.youtube", response.Groups[0].Key)
+		assert.Equal(t, int64(1100), response.Groups[0].Total)
+		assert.Equal(t, 1, len(response.Groups[0].Series))
+
+		var next StatsGroupsResponse
+		status = getJSON(t, app, "/stats/applications?limit=1&offset=1&"+query, &next)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "netify.netflix", next.Groups[0].Key)
+		assert.Equal(t, 0, len(next.Groups[0].Series))
+	})
+
+	t.Run("destinations of a host", func(t *testing.T) {
+		var response StatsGroupsResponse
+		status := getJSON(t, app, "/stats/destinations?local_ip=10.0.0.2&"+query, &response)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 1, response.Count)
+		assert.Equal(t, "142.250.1.1", response.Groups[0].Key)
+		assert.Equal(t, int64(1000), response.Groups[0].LocalBytes)
+	})
+
+	t.Run("hosts", func(t *testing.T) {
+		var response StatsGroupsResponse
+		status := getJSON(t, app, "/stats/hosts?"+query, &response)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 2, len(response.Groups))
+		assert.Equal(t, "10.0.0.2", response.Groups[0].Key)
+		assert.Equal(t, "10.0.0.1", response.Groups[1].Key)
+	})
+
+	t.Run("top", func(t *testing.T) {
+		var response StatsGroupsResponse
+		status := getJSON(t, app, "/stats/top?by=local_ip&application=netify.youtube&"+query, &response)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, stats.DimensionLocalIP, response.By)
+		assert.Equal(t, 2, len(response.Groups))
+		assert.Equal(t, "10.0.0.2", response.Groups[0].Key)
+		assert.Equal(t, int64(100), response.Groups[1].Total)
+	})
+
+	for _, target := range []string{
+		"/stats/top",
+		"/stats/top?by=country",
+		"/stats/top?by=host&other_port=https",
+		"/stats/hosts/laptop",
+		"/stats/hosts?resolution=weekly",
+		"/stats/hosts?from=yesterday",
+		"/stats/hosts?from=2000&to=1000",
+		"/stats/applications?limit=0",
+		"/stats/destinations?offset=-1",
+	} {
+		status := getJSON(t, app, target, nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	}
+
+	// The history is only served with the API token.
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/stats/hosts", nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestStatsHistoryDisabled(t *testing.T) {
+	app := setupStatsApi(t, &mockSaver{})
+	status := getJSON(t, app, "/stats/hosts", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/hostcheck"
+)
+
+type HostnamesResponse struct {
+	Hosts []HostnameMismatchSummary `json:"hosts"`
+}
+
+// HostnameMismatchSummary counts the flows of a local host whose names belong
+// to different domains.
+type HostnameMismatchSummary struct {
+	LocalIp  string   `json:"local_ip"`
+	LocalMac string   `json:"local_mac,omitempty"`
+	Hostname string   `json:"hostname,omitempty"`
+	Flows    int      `json:"flows"`
+	Domains  []string `json:"domains"`
+}
+
+// WithHostnameChecker enables GET /flows/hostnames, which reports the flows
+// flagged by checker when they were ingested.
+func WithHostnameChecker(checker *hostcheck.Checker) FlowApiOption {
+	return func(f *FlowApi) {
+		f.hostnames = checker
+	}
+}
+
+// hostnameMismatches counts, per local host, the flows flagged by the
+// hostname consistency check since startup, most flows first.
+func (f *FlowApi) hostnameMismatches(c fiber.Ctx) error {
+	hosts := f.hostnames.Hosts()
+	response := HostnamesResponse{Hosts: make([]HostnameMismatchSummary, 0, len(hosts))}
+	for _, host := range hosts {
+		summary := HostnameMismatchSummary{
+			LocalIp:  host.LocalIp,
+			LocalMac: host.LocalMac,
+			Flows:    host.Flows,
+			Domains:  host.Domains,
+		}
+		ev := flows.FlowEvent{
+			Flow: flows.FlowComplete{LocalIp: host.LocalIp, LocalMac: host.LocalMac},
+		}
+		f.enrich(&ev)
+		if ev.Enrichment != nil && ev.Enrichment.LocalHost != nil {
+			summary.Hostname = ev.Enrichment.LocalHost.Hostname
+		}
+		response.Hosts = append(response.Hosts, summary)
+	}
+	return c.JSON(response)
+}
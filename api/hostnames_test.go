@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/hostcheck"
+)
+
+// applicationEnricher sets the application name of every flow.
+type applicationEnricher struct{}
+
+func (applicationEnricher) Enrich(event *flows.FlowEvent) {
+	event.EnsureEnrichment().Application = "example"
+}
+
+func TestFlowsHostnames(t *testing.T) {
+	tlsFlow := func(digest, localIp, sni, hostServerName string) flows.FlowEvent {
+		return flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{
+				FlowBase:       flows.FlowBase{Digest: digest},
+				LocalIp:        localIp,
+				OtherIp:        "203.0.113.5",
+				Ssl:            &flows.Ssl{ClientSni: sni},
+				HostServerName: hostServerName,
+			},
+		}
+	}
+	checker := hostcheck.New("")
+	processor := flows.NewFlowProcessor(flows.WithSinks(checker), flows.WithAnalyzers(checker))
+	for _, event := range []flows.FlowEvent{
+		tlsFlow("f-001", "192.168.1.10", "www.example.com", "c2.example.org"),
+		tlsFlow("f-002", "192.168.1.10", "www.example.com", "www.example.com"),
+		tlsFlow("f-003", "192.168.1.20", "www.example.com", "c2.example.org"),
+		tlsFlow("f-004", "192.168.1.20", "api.example.com", "tracker.example.net"),
+	} {
+		processor.Process(event)
+	}
+	app := fiber.New()
+	NewFlowApi(
+		processor,
+		processor,
+		WithHostnameChecker(checker),
+		WithEnrichers(applicationEnricher{}),
+	).Setup(app)
+
+	expected := []HostnameMismatchSummary{
+		{
+			LocalIp: "192.168.1.20",
+			Flows:   2,
+			Domains: []string{"example.com", "example.net", "example.org"},
+		},
+		{LocalIp: "192.168.1.10", Flows: 1, Domains: []string{"example.com", "example.org"}},
+	}
+	hosts := func() []HostnameMismatchSummary {
+		t.Helper()
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, "/flows/hostnames", nil))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var body HostnamesResponse
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Hosts
+	}
+	assert.Equal(t, expected, hosts())
+
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/flows?hostname_mismatch=true", nil))
+	assert.Equal(t, nil, err)
+	var flowsBody FlowsResponse
+	if err := json.NewDecoder(res.Body).Decode(&flowsBody); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(flowsBody.Data))
+	// The check result stored at ingestion is not modified by the enrichers.
+	stored := processor.GetEvents()["f-001"].Enrichment
+	assert.Equal(t, "", stored.Application)
+	assert.NotEqual(t, nil, stored.HostnameMismatch)
+
+	// Flows stay counted once they finish.
+	processor.PurgeFlowsOlderThan(0)
+	assert.Equal(t, 0, len(processor.GetEvents()))
+	assert.Equal(t, expected, hosts())
+}
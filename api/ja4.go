@@ -0,0 +1,74 @@
+package api
+
+import (
+	"sort"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+type Ja4Response struct {
+	Hosts []Ja4HostSummary `json:"hosts"`
+}
+
+// Ja4HostSummary lists the distinct JA4 fingerprints seen from a local host.
+type Ja4HostSummary struct {
+	LocalIp      string                  `json:"local_ip"`
+	LocalMac     string                  `json:"local_mac,omitempty"`
+	Hostname     string                  `json:"hostname,omitempty"`
+	Fingerprints []Ja4FingerprintSummary `json:"fingerprints"`
+}
+
+type Ja4FingerprintSummary struct {
+	flows.Ja4Info
+	Flows int `json:"flows"`
+}
+
+// ja4Summary groups the TLS client fingerprints of the active flows by local
+// host. Fingerprints are labelled only when a JA4 database is configured.
+func (f *FlowApi) ja4Summary(c fiber.Ctx) error {
+	hosts := make(map[string]*Ja4HostSummary)
+	fingerprints := make(map[string]map[string]*Ja4FingerprintSummary)
+	for _, ev := range f.accessor.GetEvents() {
+		flow, ok := ev.Flow.(flows.FlowComplete)
+		if !ok || flow.Ssl == nil || flow.Ssl.ClientJa4 == "" {
+			continue
+		}
+		f.enrich(&ev)
+
+		host, ok := hosts[flow.LocalIp]
+		if !ok {
+			host = &Ja4HostSummary{LocalIp: flow.LocalIp, LocalMac: flow.LocalMac}
+			hosts[flow.LocalIp] = host
+			fingerprints[flow.LocalIp] = make(map[string]*Ja4FingerprintSummary)
+		}
+		if ev.Enrichment != nil && ev.Enrichment.LocalHost != nil && host.Hostname == "" {
+			host.Hostname = ev.Enrichment.LocalHost.Hostname
+		}
+
+		summary, ok := fingerprints[flow.LocalIp][flow.Ssl.ClientJa4]
+		if !ok {
+			summary = &Ja4FingerprintSummary{Ja4Info: flows.Ja4Info{Fingerprint: flow.Ssl.ClientJa4}}
+			if ev.Enrichment != nil && ev.Enrichment.Ja4 != nil {
+				summary.Ja4Info = *ev.Enrichment.Ja4
+			}
+			fingerprints[flow.LocalIp][flow.Ssl.ClientJa4] = summary
+		}
+		summary.Flows++
+	}
+
+	response := Ja4Response{Hosts: make([]Ja4HostSummary, 0, len(hosts))}
+	for ip, host := range hosts {
+		for _, summary := range fingerprints[ip] {
+			host.Fingerprints = append(host.Fingerprints, *summary)
+		}
+		sort.Slice(host.Fingerprints, func(i, j int) bool {
+			return host.Fingerprints[i].Fingerprint < host.Fingerprints[j].Fingerprint
+		})
+		response.Hosts = append(response.Hosts, *host)
+	}
+	sort.Slice(response.Hosts, func(i, j int) bool {
+		return response.Hosts[i].LocalIp < response.Hosts[j].LocalIp
+	})
+	return c.JSON(response)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+type ja4Enricher map[string]flows.Ja4Info
+
+func (e ja4Enricher) Enrich(event *flows.FlowEvent) {
+	flow := event.Flow.(flows.FlowComplete)
+	info, ok := e[flow.Ssl.ClientJa4]
+	if !ok {
+		info = flows.Ja4Info{Fingerprint: flow.Ssl.ClientJa4, Verdict: "unknown"}
+	}
+	event.EnsureEnrichment().Ja4 = &info
+}
+
+func tlsFlow(digest, localIp, fingerprint string) flows.FlowEvent {
+	return flows.FlowEvent{
+		Type: flows.FlowTypeDpiComplete,
+		Flow: flows.FlowComplete{
+			FlowBase: flows.FlowBase{Digest: digest},
+			LocalIp:  localIp,
+			LocalMac: "aa:bb:cc:dd:ee:ff",
+			Ssl:      &flows.Ssl{ClientJa4: fingerprint},
+		},
+	}
+}
+
+func TestFlowsJa4(t *testing.T) {
+	chrome := flows.Ja4Info{
+		Fingerprint: "t13d1516h2_8daaf6152771_02713d6af862",
+		Client:      "Chrome",
+		Category:    "client",
+		Verdict:     "known",
+	}
+	accessor := &MockFlowAccessor{
+		events: map[string]flows.FlowEvent{
+			"f-001": tlsFlow("f-001", "192.168.1.20", chrome.Fingerprint),
+			"f-002": tlsFlow("f-002", "192.168.1.10", chrome.Fingerprint),
+			"f-003": tlsFlow("f-003", "192.168.1.10", chrome.Fingerprint),
+			"f-004": tlsFlow("f-004", "192.168.1.10", "t12d0909h1_1d5e2c6a5a4b_ffffffffffff"),
+			"f-005": {
+				Type: flows.FlowTypeDpiComplete,
+				Flow: flows.FlowComplete{FlowBase: flows.FlowBase{Digest: "f-005"}, LocalIp: "192.168.1.30"},
+			},
+		},
+	}
+	app := fiber.New()
+	enricher := ja4Enricher{chrome.Fingerprint: chrome}
+	NewFlowApi(accessor, &MockFlowIngestor{}, WithEnrichers(enricher)).Setup(app)
+
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/flows/ja4", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var body Ja4Response
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Ja4Response{Hosts: []Ja4HostSummary{
+		{
+			LocalIp:  "192.168.1.10",
+			LocalMac: "aa:bb:cc:dd:ee:ff",
+			Fingerprints: []Ja4FingerprintSummary{
+				{
+					Ja4Info: flows.Ja4Info{
+						Fingerprint: "t12d0909h1_1d5e2c6a5a4b_ffffffffffff",
+						Verdict:     "unknown",
+					},
+					Flows: 1,
+				},
+				{Ja4Info: chrome, Flows: 2},
+			},
+		},
+		{
+			LocalIp:      "192.168.1.20",
+			LocalMac:     "aa:bb:cc:dd:ee:ff",
+			Fingerprints: []Ja4FingerprintSummary{{Ja4Info: chrome, Flows: 1}},
+		},
+	}}, body)
+}
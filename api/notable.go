@@ -0,0 +1,22 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/notable"
+)
+
+type NotableResponse struct {
+	Flows []notable.Flow `json:"flows"`
+}
+
+// WithNotable enables GET /flows/notable, which lists the flows flagged by
+// detector.
+func WithNotable(detector *notable.Detector) FlowApiOption {
+	return func(f *FlowApi) {
+		f.notable = detector
+	}
+}
+
+func (f *FlowApi) notableFlows(c fiber.Ctx) error {
+	return c.JSON(NotableResponse{Flows: f.notable.Notable()})
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/notable"
+)
+
+func TestFlowsNotable(t *testing.T) {
+	events := map[string]flows.FlowEvent{
+		"f-001": {
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{
+				FlowBase: flows.FlowBase{Digest: "f-001"},
+				LocalIp:  "192.168.1.10",
+				OtherIp:  "203.0.113.5",
+				Stats:    flows.Stats{TotalBytes: 4096},
+			},
+		},
+	}
+	detector := notable.New(notable.Limits{Bytes: 1024}, nil)
+	detector.Check(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC), events)
+
+	app := fiber.New()
+	accessor := &MockFlowAccessor{events: events}
+	NewFlowApi(accessor, &MockFlowIngestor{}, WithNotable(detector)).Setup(app)
+
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/flows/notable", nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var body NotableResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, detector.Notable(), body.Flows)
+}
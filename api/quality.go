@@ -0,0 +1,112 @@
+package api
+
+import (
+	"sort"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// Groupings accepted by GET /flows/quality.
+const (
+	qualityByHost        = "host"
+	qualityByApplication = "application"
+)
+
+const (
+	defaultQualityLimit      = 20
+	defaultQualityMinPackets = 100
+)
+
+type QualityResponse struct {
+	By       string           `json:"by"`
+	Rankings []QualitySummary `json:"rankings"`
+}
+
+// QualitySummary aggregates the TCP counters of the active flows of a local
+// host or an application.
+type QualitySummary struct {
+	Key          string  `json:"key"`
+	LocalMac     string  `json:"local_mac,omitempty"`
+	Hostname     string  `json:"hostname,omitempty"`
+	Flows        int     `json:"flows"`
+	Packets      int64   `json:"packets"`
+	Retrans      int64   `json:"retrans"`
+	Resets       int64   `json:"resets"`
+	SeqErrors    int64   `json:"seq_errors"`
+	RetransRatio float64 `json:"retrans_ratio"`
+}
+
+// qualityRanking ranks local hosts or applications by the retransmission
+// ratio of their active TCP flows, worst first. Groups with fewer packets than
+// min_packets are left out as their ratio is not meaningful.
+func (f *FlowApi) qualityRanking(c fiber.Ctx) error {
+	by := c.Query("by", qualityByHost)
+	if by != qualityByHost && by != qualityByApplication {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid query parameters: by must be host or application",
+		})
+	}
+	limit := fiber.Query(c, "limit", defaultQualityLimit)
+	minPackets := fiber.Query[int64](c, "min_packets", defaultQualityMinPackets)
+	if limit < 1 || minPackets < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid query parameters: limit must be positive and min_packets not negative",
+		})
+	}
+
+	summaries := make(map[string]*QualitySummary)
+	for _, ev := range f.accessor.GetEvents() {
+		flow, ok := ev.Flow.(flows.FlowComplete)
+		if !ok || flow.Tcp == nil {
+			continue
+		}
+
+		key := flow.LocalIp
+		if by == qualityByApplication {
+			key = flow.DetectedApplicationName
+		}
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &QualitySummary{Key: key}
+			summaries[key] = summary
+		}
+		if by == qualityByHost {
+			summary.LocalMac = flow.LocalMac
+			if summary.Hostname == "" {
+				f.enrich(&ev)
+				if ev.Enrichment != nil && ev.Enrichment.LocalHost != nil {
+					summary.Hostname = ev.Enrichment.LocalHost.Hostname
+				}
+			}
+		}
+		summary.Flows++
+		summary.Packets += int64(flow.TotalPackets)
+		summary.Retrans += int64(flow.Tcp.Retrans)
+		summary.Resets += int64(flow.Tcp.Resets)
+		summary.SeqErrors += int64(flow.Tcp.SeqErrors)
+	}
+
+	response := QualityResponse{By: by, Rankings: make([]QualitySummary, 0, len(summaries))}
+	for _, summary := range summaries {
+		if summary.Packets == 0 || summary.Packets < minPackets {
+			continue
+		}
+		summary.RetransRatio = float64(summary.Retrans) / float64(summary.Packets)
+		response.Rankings = append(response.Rankings, *summary)
+	}
+	sort.Slice(response.Rankings, func(i, j int) bool {
+		a, b := response.Rankings[i], response.Rankings[j]
+		if a.RetransRatio != b.RetransRatio {
+			return a.RetransRatio > b.RetransRatio
+		}
+		if a.Resets != b.Resets {
+			return a.Resets > b.Resets
+		}
+		return a.Key < b.Key
+	})
+	if len(response.Rankings) > limit {
+		response.Rankings = response.Rankings[:limit]
+	}
+	return c.JSON(response)
+}
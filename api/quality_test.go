@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+func TestFlowsQuality(t *testing.T) {
+	flow := func(ip, app string, packets int, tcp *flows.Tcp) flows.FlowEvent {
+		return flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{
+				FlowBase:                flows.FlowBase{Digest: ip + app},
+				LocalIp:                 ip,
+				LocalMac:                "aa:bb:cc:dd:ee:ff",
+				DetectedApplicationName: app,
+				Tcp:                     tcp,
+				Stats:                   flows.Stats{TotalPackets: packets},
+			},
+		}
+	}
+	events := []flows.FlowEvent{
+		flow("192.168.1.10", "netify.youtube", 1000, &flows.Tcp{Retrans: 10, Resets: 1}),
+		flow("192.168.1.10", "netify.zoom", 1000, &flows.Tcp{Retrans: 90}),
+		flow("192.168.1.20", "netify.youtube", 2000, &flows.Tcp{Retrans: 20, SeqErrors: 3}),
+		// Too few packets to be ranked.
+		flow("192.168.1.30", "netify.ssh", 50, &flows.Tcp{Retrans: 25}),
+		// Not TCP.
+		flow("192.168.1.40", "netify.dns", 5000, nil),
+	}
+	accessor := &MockFlowAccessor{events: map[string]flows.FlowEvent{}}
+	for _, ev := range events {
+		accessor.events[ev.Flow.(flows.FlowComplete).Digest] = ev
+	}
+	app := fiber.New()
+	NewFlowApi(accessor, &MockFlowIngestor{}, WithEnrichers(hostnameEnricher{})).Setup(app)
+
+	get := func(t *testing.T, url string) QualityResponse {
+		t.Helper()
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, url, nil))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var body QualityResponse
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	t.Run("by host", func(t *testing.T) {
+		assert.Equal(t, QualityResponse{
+			By: "host",
+			Rankings: []QualitySummary{
+				{
+					Key:          "192.168.1.10",
+					LocalMac:     "aa:bb:cc:dd:ee:ff",
+					Hostname:     "laptop-anna",
+					Flows:        2,
+					Packets:      2000,
+					Retrans:      100,
+					Resets:       1,
+					RetransRatio: 0.05,
+				},
+				{
+					Key:          "192.168.1.20",
+					LocalMac:     "aa:bb:cc:dd:ee:ff",
+					Hostname:     "laptop-anna",
+					Flows:        1,
+					Packets:      2000,
+					Retrans:      20,
+					SeqErrors:    3,
+					RetransRatio: 0.01,
+				},
+			},
+		}, get(t, "/flows/quality"))
+	})
+
+	t.Run("by application", func(t *testing.T) {
+		body := get(t, "/flows/quality?by=application&min_packets=0&limit=2")
+		assert.Equal(t, 2, len(body.Rankings))
+		assert.Equal(t, "netify.ssh", body.Rankings[0].Key)
+		assert.Equal(t, 0.5, body.Rankings[0].RetransRatio)
+		assert.Equal(t, "netify.zoom", body.Rankings[1].Key)
+		assert.Equal(t, "", body.Rankings[1].Hostname)
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, url := range []string{"/flows/quality?by=mac", "/flows/quality?limit=0"} {
+			res, err := app.Test(httptest.NewRequest(http.MethodGet, url, nil))
+			assert.Equal(t, nil, err)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// Sort keys accepted by GET /flows.
+const (
+	sortByDuration     = "duration"
+	sortByLastSeenAt   = "last_seen_at"
+	sortByDownloadRate = "download_rate"
+	sortByUploadRate   = "upload_rate"
+)
+
+var sortKeys = []string{sortByDuration, sortByLastSeenAt, sortByDownloadRate, sortByUploadRate}
+
+// flowEntry is an active flow with the digest it is stored under.
+type flowEntry struct {
+	digest string
+	event  flows.FlowEvent
+}
+
+// sortMetric returns the value of key for event, or false when the event does
+// not carry it.
+func sortMetric(event flows.FlowEvent, key string) (float64, bool) {
+	switch flow := event.Flow.(type) {
+	case flows.FlowComplete:
+		switch key {
+		case sortByDuration:
+			return float64(flow.LastSeenAt - flow.FirstSeenAt), true
+		case sortByLastSeenAt:
+			return float64(flow.LastSeenAt), true
+		case sortByDownloadRate:
+			return flow.OtherRate, true
+		case sortByUploadRate:
+			return flow.LocalRate, true
+		}
+	case flows.FlowStats:
+		switch key {
+		case sortByLastSeenAt:
+			return float64(flow.LastSeenAt), true
+		case sortByDownloadRate:
+			return flow.OtherRate, true
+		case sortByUploadRate:
+			return flow.LocalRate, true
+		}
+	}
+	return 0, false
+}
+
+// sortedFlows returns the events ordered by key, ascending unless desc. Events
+// without the metric come last; ties are broken by ascending digest.
+func sortedFlows(events map[string]flows.FlowEvent, key string, desc bool) []flowEntry {
+	type sortable struct {
+		flowEntry
+		metric float64
+		ok     bool
+	}
+	entries := make([]sortable, 0, len(events))
+	for digest, event := range events {
+		metric, ok := sortMetric(event, key)
+		entries = append(entries, sortable{flowEntry{digest, event}, metric, ok})
+	}
+	slices.SortFunc(entries, func(a, b sortable) int {
+		if a.ok != b.ok {
+			if a.ok {
+				return -1
+			}
+			return 1
+		}
+		if a.ok && a.metric != b.metric {
+			order := 1
+			if a.metric < b.metric {
+				order = -1
+			}
+			if desc {
+				order = -order
+			}
+			return order
+		}
+		return strings.Compare(a.digest, b.digest)
+	})
+
+	sorted := make([]flowEntry, len(entries))
+	for i, entry := range entries {
+		sorted[i] = entry.flowEntry
+	}
+	return sorted
+}
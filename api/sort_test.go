@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+func TestFlowsSort(t *testing.T) {
+	complete := func(digest string, first, last int64, localRate, otherRate float64) flows.FlowEvent {
+		return flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{
+				FlowBase:    flows.FlowBase{Digest: digest},
+				FirstSeenAt: first,
+				LastSeenAt:  last,
+				Stats:       flows.Stats{LocalRate: localRate, OtherRate: otherRate},
+			},
+		}
+	}
+	accessor := &MockFlowAccessor{
+		events: map[string]flows.FlowEvent{
+			"f-001": complete("f-001", 0, 5000, 10, 300),
+			"f-002": complete("f-002", 1000, 2000, 30, 100),
+			"f-003": complete("f-003", 2000, 9000, 20, 100),
+			"f-004": {
+				Type: flows.FlowTypeStats,
+				Flow: flows.FlowStats{
+					FlowBase:   flows.FlowBase{Digest: "f-004"},
+					LastSeenAt: 8000,
+					Stats:      flows.Stats{LocalRate: 5, OtherRate: 50},
+				},
+			},
+		},
+	}
+	app := setupApi(t, accessor, &MockFlowIngestor{})
+
+	tests := []struct {
+		query    string
+		status   int
+		expected []string
+	}{
+		// Rates sort in descending order by default.
+		{"", http.StatusOK, []string{"f-001", "f-002", "f-003", "f-004"}},
+		{"?desc=false", http.StatusOK, []string{"f-004", "f-002", "f-003", "f-001"}},
+		{"?sort_by=upload_rate", http.StatusOK, []string{"f-002", "f-003", "f-001", "f-004"}},
+		{
+			"?sort_by=upload_rate&desc=false",
+			http.StatusOK,
+			[]string{"f-004", "f-001", "f-003", "f-002"},
+		},
+		{"?sort_by=last_seen_at&desc=true", http.StatusOK, []string{"f-003", "f-004", "f-001", "f-002"}},
+		// flow_stats events have no duration and come last in both orders.
+		{"?sort_by=duration", http.StatusOK, []string{"f-002", "f-001", "f-003", "f-004"}},
+		{"?sort_by=duration&desc=true", http.StatusOK, []string{"f-003", "f-001", "f-002", "f-004"}},
+		{"?sort_by=bytes", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			res, err := app.Test(httptest.NewRequest(http.MethodGet, "/flows"+tt.query, nil))
+			assert.Equal(t, nil, err)
+			assert.Equal(t, tt.status, res.StatusCode)
+			if tt.expected == nil {
+				return
+			}
+			var body struct {
+				Flows []struct {
+					Flow flows.FlowBase `json:"flow"`
+				} `json:"flows"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			digests := make([]string, len(body.Flows))
+			for i, flow := range body.Flows {
+				digests[i] = flow.Flow.Digest
+			}
+			assert.Equal(t, tt.expected, digests)
+		})
+	}
+}
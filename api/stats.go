@@ -9,14 +9,33 @@ import (
 )
 
 type StatsApi struct {
-	saver stats.Saver
+	saver   stats.Saver
+	history *stats.Store
+	token   string
+	hosts   stats.HostLookup
 }
 
-func NewStatsApi(saver stats.Saver) *StatsApi {
-	return &StatsApi{saver: saver}
+// StatsApiOption configures optional StatsApi features.
+type StatsApiOption func(*StatsApi)
+
+func NewStatsApi(saver stats.Saver, opts ...StatsApiOption) *StatsApi {
+	s := &StatsApi{saver: saver}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *StatsApi) Setup(app *fiber.App) {
+	if s.history != nil {
+		auth := RequireToken(s.token)
+		app.Get("/stats/hosts", auth, s.groupsHandler(stats.DimensionLocalIP))
+		app.Get("/stats/hosts/:ip", auth, s.hostStats)
+		app.Get("/stats/applications", auth, s.groupsHandler(stats.DimensionApplication))
+		app.Get("/stats/destinations", auth, s.groupsHandler(stats.DimensionHost))
+		app.Get("/stats/top", auth, s.topGroups)
+	}
+
 	app.Post("/stats", func(c fiber.Ctx) error {
 		var payload stats.AggregatorPayload
 		if err := c.Bind().Body(&payload); err != nil {
@@ -0,0 +1,22 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/scan"
+)
+
+type SuspectsResponse struct {
+	Suspects []scan.Suspect `json:"suspects"`
+}
+
+// WithScanDetector enables GET /flows/suspects, which lists the local hosts
+// detector currently flags as scanning.
+func WithScanDetector(detector *scan.Detector) FlowApiOption {
+	return func(f *FlowApi) {
+		f.scan = detector
+	}
+}
+
+func (f *FlowApi) scanSuspects(c fiber.Ctx) error {
+	return c.JSON(SuspectsResponse{Suspects: f.scan.Suspects()})
+}
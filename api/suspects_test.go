@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/scan"
+)
+
+func TestFlowsSuspects(t *testing.T) {
+	detector := scan.New(scan.Limits{Window: time.Hour, Ports: 2}, nil)
+	for _, port := range []int{22, 23} {
+		detector.Analyze(&flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{
+				FlowBase:    flows.FlowBase{Digest: fmt.Sprintf("f-%d", port)},
+				LocalOrigin: true,
+				LocalIp:     "192.168.1.10",
+				OtherIp:     "192.168.1.1",
+				OtherPort:   port,
+				FirstSeenAt: time.Now().UnixMilli(),
+				LastSeenAt:  time.Now().UnixMilli(),
+			},
+		})
+	}
+
+	app := fiber.New()
+	NewFlowApi(&MockFlowAccessor{}, &MockFlowIngestor{}, WithScanDetector(detector)).Setup(app)
+
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/flows/suspects", nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var body SuspectsResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(body.Suspects))
+	assert.Equal(t, "192.168.1.10", body.Suspects[0].LocalIp)
+	assert.Equal(t, []int{22, 23}, body.Suspects[0].SamplePorts)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+type ThreatsResponse struct {
+	Flows []ThreatFlow `json:"flows"`
+}
+
+// ThreatFlow is an active flow matching at least one threat-intelligence feed.
+type ThreatFlow struct {
+	Digest                  string              `json:"digest"`
+	Interface               string              `json:"interface,omitempty"`
+	LocalIp                 string              `json:"local_ip"`
+	LocalMac                string              `json:"local_mac,omitempty"`
+	OtherIp                 string              `json:"other_ip"`
+	OtherPort               int                 `json:"other_port"`
+	DetectedApplicationName string              `json:"detected_application_name,omitempty"`
+	Threats                 []flows.ThreatMatch `json:"threats"`
+}
+
+// threatMatches lists the active flows tagged by the threat-intelligence
+// enricher, optionally restricted to a single feed.
+func (f *FlowApi) threatMatches(c fiber.Ctx) error {
+	feed := c.Query("feed")
+	response := ThreatsResponse{Flows: []ThreatFlow{}}
+	for _, ev := range f.accessor.GetEvents() {
+		flow, ok := ev.Flow.(flows.FlowComplete)
+		if !ok {
+			continue
+		}
+		f.enrich(&ev)
+		if ev.Enrichment == nil || len(ev.Enrichment.Threats) == 0 {
+			continue
+		}
+		threats := ev.Enrichment.Threats
+		if feed != "" {
+			threats = slices.DeleteFunc(slices.Clone(threats), func(match flows.ThreatMatch) bool {
+				return match.Feed != feed
+			})
+			if len(threats) == 0 {
+				continue
+			}
+		}
+		response.Flows = append(response.Flows, ThreatFlow{
+			Digest:                  flow.Digest,
+			Interface:               ev.Interface,
+			LocalIp:                 flow.LocalIp,
+			LocalMac:                flow.LocalMac,
+			OtherIp:                 flow.OtherIp,
+			OtherPort:               flow.OtherPort,
+			DetectedApplicationName: flow.DetectedApplicationName,
+			Threats:                 threats,
+		})
+	}
+	sort.Slice(response.Flows, func(i, j int) bool {
+		return response.Flows[i].Digest < response.Flows[j].Digest
+	})
+	return c.JSON(response)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+type threatEnricher map[string][]flows.ThreatMatch
+
+func (e threatEnricher) Enrich(event *flows.FlowEvent) {
+	flow := event.Flow.(flows.FlowComplete)
+	if matches, ok := e[flow.OtherIp]; ok {
+		event.EnsureEnrichment().Threats = matches
+	}
+}
+
+func TestFlowsThreats(t *testing.T) {
+	remoteFlow := func(digest, otherIp string) flows.FlowEvent {
+		return flows.FlowEvent{
+			Type:      flows.FlowTypeDpiComplete,
+			Interface: "eth0",
+			Flow: flows.FlowComplete{
+				FlowBase:  flows.FlowBase{Digest: digest},
+				LocalIp:   "192.168.1.10",
+				OtherIp:   otherIp,
+				OtherPort: 443,
+			},
+		}
+	}
+	accessor := &MockFlowAccessor{
+		events: map[string]flows.FlowEvent{
+			"f-002": remoteFlow("f-002", "5.6.7.8"),
+			"f-001": remoteFlow("f-001", "6.6.6.6"),
+			"f-003": remoteFlow("f-003", "9.9.9.9"),
+		},
+	}
+	firehol := flows.ThreatMatch{Feed: "firehol_level1", Field: "other_ip", Indicator: "6.6.6.6"}
+	spamhaus := flows.ThreatMatch{Feed: "spamhaus_drop", Field: "other_ip", Indicator: "6.6.6.6"}
+	malware := flows.ThreatMatch{Feed: "malware", Field: "dns_host_name", Indicator: "evil.example"}
+	enricher := threatEnricher{
+		"6.6.6.6": {firehol, spamhaus},
+		"5.6.7.8": {malware},
+	}
+	app := fiber.New()
+	NewFlowApi(accessor, &MockFlowIngestor{}, WithEnrichers(enricher)).Setup(app)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected map[string][]flows.ThreatMatch
+		order    []string
+	}{
+		{
+			name:  "lists all matching flows",
+			query: "",
+			expected: map[string][]flows.ThreatMatch{
+				"f-001": {firehol, spamhaus},
+				"f-002": {malware},
+			},
+			order: []string{"f-001", "f-002"},
+		},
+		{
+			name:     "filters by feed",
+			query:    "?feed=spamhaus_drop",
+			expected: map[string][]flows.ThreatMatch{"f-001": {spamhaus}},
+			order:    []string{"f-001"},
+		},
+		{
+			name:     "returns an empty list",
+			query:    "?feed=unknown",
+			expected: map[string][]flows.ThreatMatch{},
+			order:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/flows/threats"+tt.query, nil)
+			res, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			var body ThreatsResponse
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			digests := make([]string, 0, len(body.Flows))
+			for _, flow := range body.Flows {
+				digests = append(digests, flow.Digest)
+				assert.Equal(t, tt.expected[flow.Digest], flow.Threats)
+				assert.Equal(t, "eth0", flow.Interface)
+			}
+			assert.Equal(t, tt.order, digests)
+		})
+	}
+	// Enrichers must not modify the stored events.
+	assert.Equal(t, (*flows.Enrichment)(nil), accessor.events["f-001"].Enrichment)
+}
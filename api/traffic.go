@@ -0,0 +1,18 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// WithTraffic enables GET /flows/traffic, which reports the live totals of
+// every interface and VLAN.
+func WithTraffic(traffic flows.TrafficAccessor) FlowApiOption {
+	return func(f *FlowApi) {
+		f.traffic = traffic
+	}
+}
+
+func (f *FlowApi) trafficTotals(c fiber.Ctx) error {
+	return c.JSON(f.traffic.Traffic())
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+type fixedTraffic flows.Traffic
+
+func (f fixedTraffic) Traffic() flows.Traffic {
+	return flows.Traffic(f)
+}
+
+func TestFlowsTraffic(t *testing.T) {
+	traffic := flows.Traffic{
+		Since: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		Interfaces: []flows.InterfaceTraffic{{
+			Interface: "eth1",
+			TrafficTotals: flows.TrafficTotals{
+				ActiveFlows:   3,
+				UploadRate:    1200.5,
+				DownloadRate:  80000,
+				UploadBytes:   4096,
+				DownloadBytes: 1 << 20,
+			},
+		}},
+		Vlans: []flows.VlanTraffic{{VlanId: 20, TrafficTotals: flows.TrafficTotals{ActiveFlows: 1}}},
+	}
+	app := fiber.New()
+	accessor := &MockFlowAccessor{}
+	NewFlowApi(accessor, &MockFlowIngestor{}, WithTraffic(fixedTraffic(traffic))).Setup(app)
+
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/flows/traffic", nil))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var body flows.Traffic
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, traffic, body)
+}
@@ -0,0 +1,222 @@
+// Package asn maps remote IP addresses to the autonomous system announcing
+// them. Two database formats are supported: MaxMind-format ASN databases
+// (GeoLite2-ASN, DB-IP ASN Lite) and the tab-separated IP-to-ASN ranges
+// published by iptoasn.com.
+package asn
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/internal/mmdb"
+)
+
+// Info identifies an autonomous system.
+type Info struct {
+	Number       uint32
+	Organization string
+}
+
+// String formats the system as "AS15169 Google LLC".
+func (i Info) String() string {
+	if i.Organization == "" {
+		return fmt.Sprintf("AS%d", i.Number)
+	}
+	return fmt.Sprintf("AS%d %s", i.Number, i.Organization)
+}
+
+// table is the parsed content of a database file.
+type table interface {
+	lookup(addr netip.Addr) (Info, bool)
+}
+
+// DB is a reloadable ASN database. The file can be replaced at runtime: Reload
+// swaps the in-memory copy only once the new file parsed correctly.
+type DB struct {
+	path  string
+	table atomic.Pointer[table]
+}
+
+// New creates a DB reading from path. Call Reload to load the file.
+func New(path string) *DB {
+	return &DB{path: path}
+}
+
+// Path returns the database file path, for change detection.
+func (d *DB) Path() string {
+	return d.path
+}
+
+// Reload reads the database file, detecting its format from the content. On
+// failure the previously loaded database, if any, stays in use.
+func (d *DB) Reload() error {
+	content, err := os.ReadFile(d.path)
+	if err != nil {
+		return fmt.Errorf("load asn database %s: %w", d.path, err)
+	}
+
+	var loaded table
+	if reader, mmdbErr := mmdb.FromBytes(content); mmdbErr == nil {
+		loaded = mmdbTable{reader: reader}
+	} else {
+		ranges, err := parseTSV(bytes.NewReader(content))
+		if err != nil {
+			return fmt.Errorf("load asn database %s: %w", d.path, err)
+		}
+		loaded = ranges
+	}
+
+	d.table.Store(&loaded)
+	slog.Debug("Loaded ASN database", "path", d.path)
+	return nil
+}
+
+// Lookup returns the autonomous system announcing ip.
+func (d *DB) Lookup(ip string) (Info, bool) {
+	loaded := d.table.Load()
+	if loaded == nil {
+		return Info{}, false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Info{}, false
+	}
+	return (*loaded).lookup(addr.Unmap())
+}
+
+// LookupAsn returns the AS number and organisation announcing ip.
+func (d *DB) LookupAsn(ip string) (uint32, string, bool) {
+	info, ok := d.Lookup(ip)
+	return info.Number, info.Organization, ok
+}
+
+// Enrich attaches the autonomous system of the remote endpoint to a flow.
+func (d *DB) Enrich(event *flows.FlowEvent) {
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok {
+		return
+	}
+	info, ok := d.Lookup(flow.OtherIp)
+	if !ok {
+		return
+	}
+	event.EnsureEnrichment().OtherAsn = &flows.AsnInfo{
+		Number:       info.Number,
+		Organization: info.Organization,
+	}
+}
+
+type mmdbTable struct {
+	reader *mmdb.Reader
+}
+
+func (t mmdbTable) lookup(addr netip.Addr) (Info, bool) {
+	record, ok, err := t.reader.Lookup(addr)
+	if err != nil || !ok {
+		return Info{}, false
+	}
+	var info Info
+	switch number := mmdb.Path(record, "autonomous_system_number").(type) {
+	case uint32:
+		info.Number = number
+	case uint16:
+		info.Number = uint32(number)
+	case uint64:
+		info.Number = uint32(number)
+	default:
+		return Info{}, false
+	}
+	info.Organization, _ = mmdb.Path(record, "autonomous_system_organization").(string)
+	return info, info.Number != 0
+}
+
+type asnRange struct {
+	start netip.Addr
+	end   netip.Addr
+	info  Info
+}
+
+// rangeTable is a sorted list of address ranges, as loaded from a TSV file.
+type rangeTable []asnRange
+
+// parseTSV reads iptoasn.com TSV data. Each line has the format:
+//
+//	<range_start>\t<range_end>\t<as_number>\t<country_code>\t<as_description>
+//
+// Ranges announced by AS 0 ("Not routed") are skipped.
+func parseTSV(r io.Reader) (rangeTable, error) {
+	var ranges rangeTable
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected at least 3 fields", lineNo)
+		}
+		start, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid range start: %w", lineNo, err)
+		}
+		end, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid range end: %w", lineNo, err)
+		}
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return nil, fmt.Errorf("line %d: invalid range %s-%s", lineNo, start, end)
+		}
+		number, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid AS number: %w", lineNo, err)
+		}
+		if number == 0 {
+			continue
+		}
+		var organization string
+		if len(fields) >= 5 {
+			organization = strings.TrimSpace(fields[4])
+		}
+		ranges = append(ranges, asnRange{
+			start: start.Unmap(),
+			end:   end.Unmap(),
+			info:  Info{Number: uint32(number), Organization: organization},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read asn ranges: %w", err)
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start.Less(ranges[j].start)
+	})
+	return ranges, nil
+}
+
+func (r rangeTable) lookup(addr netip.Addr) (Info, bool) {
+	// Find the last range starting at or before addr.
+	i := sort.Search(len(r), func(i int) bool {
+		return addr.Less(r[i].start)
+	}) - 1
+	if i < 0 {
+		return Info{}, false
+	}
+	candidate := r[i]
+	if candidate.start.Is4() != addr.Is4() || candidate.end.Less(addr) {
+		return Info{}, false
+	}
+	return candidate.info, true
+}
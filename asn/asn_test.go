@@ -0,0 +1,155 @@
+package asn
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/internal/mmdb/mmdbtest"
+)
+
+const tsvRanges = "1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n" +
+	"1.0.1.0\t1.0.3.255\t0\tNone\tNot routed\n" +
+	"8.8.8.0\t8.8.8.255\t15169\tUS\tGOOGLE\n" +
+	"2600:1f00::\t2600:1fff:ffff:ffff:ffff:ffff:ffff:ffff\t16509\tUS\tAMAZON-02\n"
+
+func TestParseTSV(t *testing.T) {
+	t.Run("looks up ranges", func(t *testing.T) {
+		ranges, err := parseTSV(strings.NewReader(tsvRanges))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 3, len(ranges))
+
+		tests := []struct {
+			ip       string
+			expected Info
+			found    bool
+		}{
+			{"1.0.0.1", Info{13335, "CLOUDFLARENET"}, true},
+			{"1.0.0.255", Info{13335, "CLOUDFLARENET"}, true},
+			{"1.0.2.1", Info{}, false},
+			{"8.8.8.8", Info{15169, "GOOGLE"}, true},
+			{"8.8.9.1", Info{}, false},
+			{"0.0.0.1", Info{}, false},
+			{"2600:1f14::1", Info{16509, "AMAZON-02"}, true},
+			{"2a00::1", Info{}, false},
+		}
+		for _, tt := range tests {
+			info, ok := ranges.lookup(netip.MustParseAddr(tt.ip))
+			assert.Equal(t, tt.found, ok)
+			assert.Equal(t, tt.expected, info)
+		}
+	})
+
+	t.Run("rejects malformed lines", func(t *testing.T) {
+		inputs := []string{
+			"1.0.0.0\t1.0.0.255\n",
+			"1.0.0.x\t1.0.0.255\t1\tUS\tX\n",
+			"1.0.0.255\t1.0.0.0\t1\tUS\tX\n",
+			"1.0.0.0\t::1\t1\tUS\tX\n",
+			"1.0.0.0\t1.0.0.255\tABC\tUS\tX\n",
+		}
+		for _, input := range inputs {
+			if _, err := parseTSV(strings.NewReader(input)); err == nil {
+				t.Errorf("expected error for %q", input)
+			}
+		}
+	})
+}
+
+func TestDB(t *testing.T) {
+	t.Run("loads TSV databases", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "ip2asn-combined.tsv")
+		if err := os.WriteFile(path, []byte(tsvRanges), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		db := New(path)
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		number, organization, ok := db.LookupAsn("8.8.8.8")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, uint32(15169), number)
+		assert.Equal(t, "GOOGLE", organization)
+	})
+
+	t.Run("loads MMDB databases", func(t *testing.T) {
+		w := mmdbtest.NewWriter("GeoLite2-ASN")
+		if err := w.Insert(netip.MustParsePrefix("8.8.8.0/24"), map[string]any{
+			"autonomous_system_number":       uint32(15169),
+			"autonomous_system_organization": "Google LLC",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := w.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "asn.mmdb")
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		db := New(path)
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		info, ok := db.Lookup("8.8.8.8")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "AS15169 Google LLC", info.String())
+
+		_, ok = db.Lookup("1.1.1.1")
+		assert.Equal(t, false, ok)
+	})
+
+	t.Run("keeps the previous database when the new one is invalid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "ip2asn.tsv")
+		if err := os.WriteFile(path, []byte(tsvRanges), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		db := New(path)
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("garbage\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Reload(); err == nil {
+			t.Fatal("expected error for invalid database")
+		}
+		if _, ok := db.Lookup("8.8.8.8"); !ok {
+			t.Fatal("expected previous database to stay in use")
+		}
+	})
+
+	t.Run("enriches the remote endpoint", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "ip2asn.tsv")
+		if err := os.WriteFile(path, []byte(tsvRanges), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		db := New(path)
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+
+		event := flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{LocalIp: "192.168.1.10", OtherIp: "1.0.0.1"},
+		}
+		db.Enrich(&event)
+		assert.Equal(
+			t,
+			&flows.AsnInfo{Number: 13335, Organization: "CLOUDFLARENET"},
+			event.Enrichment.OtherAsn,
+		)
+	})
+
+	t.Run("formats systems without organisation", func(t *testing.T) {
+		assert.Equal(t, "AS64500", Info{Number: 64500}.String())
+	})
+}
@@ -0,0 +1,173 @@
+// Package capture records the raw events received by ns-flows together with
+// their arrival time, and plays recordings back with the original pacing.
+// A capture is a JSON-lines file of {"at": <RFC 3339 time>, "event": <event>};
+// bodies that are not valid JSON are stored base64-encoded in "raw" instead.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one recorded event.
+type Entry struct {
+	At    time.Time       `json:"at"`
+	Event json.RawMessage `json:"event,omitempty"`
+	// Raw holds the body verbatim when it is not valid JSON.
+	Raw []byte `json:"raw,omitempty"`
+}
+
+// Body returns the recorded request body.
+func (e Entry) Body() []byte {
+	if e.Event != nil {
+		return e.Event
+	}
+	return e.Raw
+}
+
+// Writer appends entries to a capture file.
+type Writer struct {
+	mu   sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+}
+
+// Create opens path for writing, appending to an existing capture.
+func Create(path string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open capture file: %w", err)
+	}
+	return &Writer{file: file, buf: bufio.NewWriter(file)}, nil
+}
+
+// Record appends raw, received at at. Valid JSON is compacted so that each
+// entry fits on one line, anything else is kept verbatim.
+func (w *Writer) Record(at time.Time, raw []byte) error {
+	entry := Entry{At: at}
+	var event bytes.Buffer
+	if err := json.Compact(&event, raw); err == nil {
+		entry.Event = event.Bytes()
+	} else {
+		entry.Raw = raw
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("capture event: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.buf.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write capture: %w", err)
+	}
+	// Flush each entry so that a capture survives a crash.
+	return w.buf.Flush()
+}
+
+// Close flushes and closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.buf.Flush(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Reader reads entries from a capture.
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader reads a capture from r.
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next entry, or io.EOF at the end of the capture.
+func (r *Reader) Next() (Entry, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return Entry{}, fmt.Errorf("capture line %d: %w", r.line, err)
+		}
+		return entry, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Entry{}, err
+	}
+	return Entry{}, io.EOF
+}
+
+// Replay delivers every entry of r in order. With speed 1 the original gaps
+// between events are kept, with speed N they are divided by N and with speed
+// 0 events are delivered as fast as possible. Replay stops at the first
+// delivery error.
+func Replay(ctx context.Context, r *Reader, speed float64, deliver func(Entry) error) (int, error) {
+	return replay(ctx, r, speed, deliver, sleep)
+}
+
+func replay(
+	ctx context.Context,
+	r *Reader,
+	speed float64,
+	deliver func(Entry) error,
+	wait func(context.Context, time.Duration) error,
+) (int, error) {
+	var previous time.Time
+	count := 0
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		if speed > 0 && !previous.IsZero() {
+			if gap := entry.At.Sub(previous); gap > 0 {
+				if err := wait(ctx, time.Duration(float64(gap)/speed)); err != nil {
+					return count, err
+				}
+			}
+		}
+		previous = entry.At
+
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		if err := deliver(entry); err != nil {
+			return count, fmt.Errorf("deliver event %d: %w", count+1, err)
+		}
+		count++
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,128 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestWriterReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	if err := w.Record(start, []byte("{\n  \"type\": \"flow_purge\"\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Record(start.Add(time.Second), []byte(`{"type": "flow`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Record(start.Add(2*time.Second), []byte(`{"type":"flow_stats"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	r := NewReader(file)
+	first, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, start, first.At)
+	assert.Equal(t, `{"type":"flow_purge"}`, string(first.Event))
+	assert.Equal(t, `{"type":"flow_purge"}`, string(first.Body()))
+	// Invalid payloads are kept verbatim.
+	invalid, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(invalid.Event))
+	assert.Equal(t, `{"type": "flow`, string(invalid.Body()))
+	second, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, start.Add(2*time.Second), second.At)
+	if _, err := r.Next(); err == nil || err.Error() != "EOF" {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+const recording = `{"at":"2026-10-18T12:00:00Z","event":{"n":1}}
+{"at":"2026-10-18T12:00:02Z","event":{"n":2}}
+
+{"at":"2026-10-18T12:00:02Z","event":{"n":3}}
+{"at":"2026-10-18T12:00:06Z","event":{"n":4}}
+`
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		speed    float64
+		expected []time.Duration
+	}{
+		{name: "real time", speed: 1, expected: []time.Duration{2 * time.Second, 4 * time.Second}},
+		{name: "4x", speed: 4, expected: []time.Duration{500 * time.Millisecond, time.Second}},
+		{name: "as fast as possible", speed: 0, expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var waits []time.Duration
+			wait := func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+			var delivered []string
+			deliver := func(entry Entry) error {
+				delivered = append(delivered, string(entry.Event))
+				return nil
+			}
+
+			r := NewReader(strings.NewReader(recording))
+			count, err := replay(context.Background(), r, tt.speed, deliver, wait)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, 4, count)
+			assert.Equal(t, []string{`{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`}, delivered)
+			assert.Equal(t, tt.expected, waits)
+		})
+	}
+
+	t.Run("stops on delivery errors", func(t *testing.T) {
+		deliver := func(entry Entry) error {
+			if string(entry.Event) == `{"n":3}` {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+		count, err := Replay(context.Background(), NewReader(strings.NewReader(recording)), 0, deliver)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("rejects malformed lines", func(t *testing.T) {
+		r := NewReader(strings.NewReader("{oops\n"))
+		_, err := Replay(context.Background(), r, 0, func(Entry) error { return nil })
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
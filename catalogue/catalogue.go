@@ -0,0 +1,311 @@
+// Package catalogue names the numeric application, protocol and category IDs
+// reported by netifyd using the catalogue files it keeps on disk:
+// netify-categories.json, the application list (netify-apps.conf or the
+// output of "netifyd --dump-apps") and the protocol list (the output of
+// "netifyd --dump-protos").
+package catalogue
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// Category kinds, matching the tag indexes of netify-categories.json.
+const (
+	KindApplication = "application"
+	KindProtocol    = "protocol"
+	KindDomain      = "domain"
+	KindNetwork     = "network"
+)
+
+// entries is the parsed content of the catalogue files.
+type entries struct {
+	applications map[int]string
+	protocols    map[int]string
+	// categories maps a kind to the names of its category IDs.
+	categories map[string]map[int]string
+	// applicationCategory and protocolCategory map IDs to their category.
+	applicationCategory map[int]int
+	protocolCategory    map[int]int
+}
+
+// Catalogue is a reloadable set of catalogue files. Any file may be omitted.
+// Reload swaps the in-memory copy only once every file parsed correctly.
+type Catalogue struct {
+	categoriesPath   string
+	applicationsPath string
+	protocolsPath    string
+	entries          atomic.Pointer[entries]
+}
+
+// New creates a Catalogue reading the given files; empty paths are skipped.
+// Call Reload to load the files.
+func New(categories, applications, protocols string) *Catalogue {
+	return &Catalogue{
+		categoriesPath:   categories,
+		applicationsPath: applications,
+		protocolsPath:    protocols,
+	}
+}
+
+// Paths returns the configured file paths, for change detection.
+func (c *Catalogue) Paths() []string {
+	var paths []string
+	for _, path := range []string{c.categoriesPath, c.applicationsPath, c.protocolsPath} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Reload reads all catalogue files. On failure the previously loaded
+// catalogue, if any, stays in use.
+func (c *Catalogue) Reload() error {
+	loaded := &entries{
+		categories:          make(map[string]map[int]string),
+		applicationCategory: make(map[int]int),
+		protocolCategory:    make(map[int]int),
+	}
+	if c.categoriesPath != "" {
+		content, err := os.ReadFile(c.categoriesPath)
+		if err != nil {
+			return fmt.Errorf("load categories %s: %w", c.categoriesPath, err)
+		}
+		if err := loaded.parseCategories(content); err != nil {
+			return fmt.Errorf("load categories %s: %w", c.categoriesPath, err)
+		}
+	}
+	var err error
+	if loaded.applications, err = readNames(c.applicationsPath, "app"); err != nil {
+		return fmt.Errorf("load applications %s: %w", c.applicationsPath, err)
+	}
+	if loaded.protocols, err = readNames(c.protocolsPath, ""); err != nil {
+		return fmt.Errorf("load protocols %s: %w", c.protocolsPath, err)
+	}
+
+	c.entries.Store(loaded)
+	slog.Debug(
+		"Loaded Netify catalogue",
+		"applications", len(loaded.applications),
+		"protocols", len(loaded.protocols),
+		"application_categories", len(loaded.categories[KindApplication]),
+		"protocol_categories", len(loaded.categories[KindProtocol]),
+	)
+	return nil
+}
+
+// ApplicationName returns the name of an application ID.
+func (c *Catalogue) ApplicationName(id int) (string, bool) {
+	loaded := c.entries.Load()
+	if loaded == nil {
+		return "", false
+	}
+	name, ok := loaded.applications[id]
+	return name, ok
+}
+
+// ProtocolName returns the name of a protocol ID.
+func (c *Catalogue) ProtocolName(id int) (string, bool) {
+	loaded := c.entries.Load()
+	if loaded == nil {
+		return "", false
+	}
+	name, ok := loaded.protocols[id]
+	return name, ok
+}
+
+// CategoryName returns the name of a category ID of the given kind.
+func (c *Catalogue) CategoryName(kind string, id int) (string, bool) {
+	loaded := c.entries.Load()
+	if loaded == nil {
+		return "", false
+	}
+	return loaded.categoryName(kind, id)
+}
+
+// Categories names the categories of flow. IDs reported by netifyd take
+// precedence; missing application and protocol categories are looked up from
+// the detected application and protocol. It returns nil when nothing is
+// known.
+func (c *Catalogue) Categories(flow flows.FlowComplete) *flows.CategoryInfo {
+	loaded := c.entries.Load()
+	if loaded == nil {
+		return nil
+	}
+
+	var ids flows.Category
+	if flow.Category != nil {
+		ids = *flow.Category
+	}
+	if ids.Application == 0 {
+		ids.Application = loaded.applicationCategory[flow.DetectedApplication]
+	}
+	if ids.Protocol == 0 {
+		ids.Protocol = loaded.protocolCategory[flow.DetectedProtocol]
+	}
+
+	name := func(kind string, id int) string {
+		name, _ := loaded.categoryName(kind, id)
+		return name
+	}
+	info := flows.CategoryInfo{
+		Application:  name(KindApplication, ids.Application),
+		Protocol:     name(KindProtocol, ids.Protocol),
+		Domain:       name(KindDomain, ids.Domain),
+		LocalNetwork: name(KindNetwork, ids.LocalNetwork),
+		OtherNetwork: name(KindNetwork, ids.OtherNetwork),
+		Overlay:      name(KindApplication, ids.Overlay),
+	}
+	if info == (flows.CategoryInfo{}) {
+		return nil
+	}
+	return &info
+}
+
+// Enrich attaches the category names and the catalogue names of the detected
+// application and protocol to a flow.
+func (c *Catalogue) Enrich(event *flows.FlowEvent) {
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok || c.entries.Load() == nil {
+		return
+	}
+	if categories := c.Categories(flow); categories != nil {
+		event.EnsureEnrichment().Categories = categories
+	}
+	if name, ok := c.ApplicationName(flow.DetectedApplication); ok {
+		event.EnsureEnrichment().Application = name
+	}
+	if name, ok := c.ProtocolName(flow.DetectedProtocol); ok {
+		event.EnsureEnrichment().Protocol = name
+	}
+}
+
+// categoryName looks id up in the tag index of kind. Domain categories share
+// the application categories when the file has no domain index.
+func (e *entries) categoryName(kind string, id int) (string, bool) {
+	if id == 0 {
+		return "", false
+	}
+	names, ok := e.categories[kind]
+	if !ok && kind == KindDomain {
+		names = e.categories[KindApplication]
+	}
+	name, ok := names[id]
+	return name, ok
+}
+
+// categoriesFile is the layout of netify-categories.json. Tag indexes map
+// category names to IDs, indexes map category IDs to their members.
+type categoriesFile struct {
+	ApplicationTagIndex map[string]int   `json:"application_tag_index"`
+	ApplicationIndex    map[string][]int `json:"application_index"`
+	ProtocolTagIndex    map[string]int   `json:"protocol_tag_index"`
+	ProtocolIndex       map[string][]int `json:"protocol_index"`
+	DomainTagIndex      map[string]int   `json:"domain_tag_index"`
+	NetworkTagIndex     map[string]int   `json:"network_tag_index"`
+}
+
+func (e *entries) parseCategories(content []byte) error {
+	var file categoriesFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return err
+	}
+
+	tags := map[string]map[string]int{
+		KindApplication: file.ApplicationTagIndex,
+		KindProtocol:    file.ProtocolTagIndex,
+		KindDomain:      file.DomainTagIndex,
+		KindNetwork:     file.NetworkTagIndex,
+	}
+	for kind, index := range tags {
+		if index == nil {
+			continue
+		}
+		names := make(map[int]string, len(index))
+		for name, id := range index {
+			names[id] = name
+		}
+		e.categories[kind] = names
+	}
+
+	members := []struct {
+		index  map[string][]int
+		target map[int]int
+	}{
+		{file.ApplicationIndex, e.applicationCategory},
+		{file.ProtocolIndex, e.protocolCategory},
+	}
+	for _, m := range members {
+		for key, ids := range m.index {
+			category, err := strconv.Atoi(key)
+			if err != nil {
+				return fmt.Errorf("invalid category ID %q", key)
+			}
+			for _, id := range ids {
+				m.target[id] = category
+			}
+		}
+	}
+	return nil
+}
+
+// readNames reads an ID to name list from path. An empty path yields an empty
+// list.
+func readNames(path, prefix string) (map[int]string, error) {
+	if path == "" {
+		return map[int]string{}, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck
+	return parseNames(file, prefix)
+}
+
+// parseNames reads "<id>: <name>" lines, as printed by netifyd --dump-apps
+// and --dump-protos. When prefix is set, "<prefix>:<id>:<name>" lines of
+// netify-apps.conf are accepted as well and lines with other prefixes are
+// ignored. Blank lines and lines starting with # are skipped.
+func parseNames(r io.Reader, prefix string) (map[int]string, error) {
+	names := make(map[int]string)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, ":", 3)
+		if len(fields) == 3 {
+			if prefix == "" || fields[0] != prefix {
+				continue
+			}
+			fields = fields[1:]
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"<id>: <name>\"", line)
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil {
+			if prefix != "" {
+				// Other netify-apps.conf entries, such as "dom:" rules.
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid ID %q", line, fields[0])
+		}
+		names[id] = strings.TrimSpace(fields[1])
+	}
+	return names, scanner.Err()
+}
@@ -0,0 +1,108 @@
+package catalogue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+const categories = `{
+  "last_update": 1760000000,
+  "application_tag_index": {"streaming-media": 27, "social-networking": 31, "vpn": 40},
+  "application_index": {"27": [119, 133], "31": [142]},
+  "protocol_tag_index": {"web": 3, "messaging": 5},
+  "protocol_index": {"3": [7, 91]},
+  "network_tag_index": {"cdn": 2}
+}`
+
+const applications = `# Netify applications
+app:119:netify.youtube
+app:133:netify.netflix
+dom:133:netflix.com
+app:142:netify.facebook
+`
+
+const protocols = `     7: HTTP
+    91: TLS
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCatalogue(t *testing.T) {
+	c := New(
+		writeFile(t, "netify-categories.json", categories),
+		writeFile(t, "netify-apps.conf", applications),
+		writeFile(t, "protocols.txt", protocols),
+	)
+	flow := flows.FlowComplete{DetectedApplication: 119, DetectedProtocol: 91}
+
+	// Nothing is known before the first load.
+	assert.Equal(t, (*flows.CategoryInfo)(nil), c.Categories(flow))
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	name, ok := c.ApplicationName(133)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "netify.netflix", name)
+	name, ok = c.ProtocolName(7)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "HTTP", name)
+	_, ok = c.CategoryName(KindApplication, 99)
+	assert.Equal(t, false, ok)
+
+	t.Run("categories from the detected IDs", func(t *testing.T) {
+		assert.Equal(t, &flows.CategoryInfo{
+			Application: "streaming-media",
+			Protocol:    "web",
+		}, c.Categories(flow))
+	})
+
+	t.Run("categories reported by netifyd win", func(t *testing.T) {
+		flow := flow
+		flow.Category = &flows.Category{Application: 31, Domain: 40, OtherNetwork: 2, Protocol: 5}
+		assert.Equal(t, &flows.CategoryInfo{
+			Application:  "social-networking",
+			Protocol:     "messaging",
+			Domain:       "vpn",
+			OtherNetwork: "cdn",
+		}, c.Categories(flow))
+	})
+
+	t.Run("enrich", func(t *testing.T) {
+		event := flows.FlowEvent{Type: flows.FlowTypeDpiComplete, Flow: flow}
+		c.Enrich(&event)
+		assert.Equal(t, "netify.youtube", event.Enrichment.Application)
+		assert.Equal(t, "TLS", event.Enrichment.Protocol)
+		assert.Equal(t, true, event.Enrichment.Categories.Has("streaming-media"))
+
+		unknown := flows.FlowEvent{Type: flows.FlowTypeDpiComplete, Flow: flows.FlowComplete{}}
+		c.Enrich(&unknown)
+		assert.Equal(t, (*flows.Enrichment)(nil), unknown.Enrichment)
+	})
+
+	t.Run("failed reload keeps the catalogue", func(t *testing.T) {
+		broken := New(writeFile(t, "broken.json", "{"), "", "")
+		if err := broken.Reload(); err == nil {
+			t.Fatal("expected error")
+		}
+		if err := os.WriteFile(c.protocolsPath, []byte("oops: TLS\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Reload(); err == nil {
+			t.Fatal("expected error")
+		}
+		name, _ := c.ProtocolName(91)
+		assert.Equal(t, "TLS", name)
+	})
+}
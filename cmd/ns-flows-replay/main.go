@@ -0,0 +1,191 @@
+// Command ns-flows-replay feeds a capture recorded by ns-flows --capture back
+// into a running ns-flows, or into an in-memory processor whose final state is
+// printed as JSON.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nethserver/nethsecurity-monitoring/api"
+	"github.com/nethserver/nethsecurity-monitoring/capture"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/internal/logger"
+)
+
+func main() {
+	var capturePath string
+	flag.StringVar(&capturePath, "file", "", "Capture file to replay (- for stdin)")
+
+	var target string
+	flag.StringVar(
+		&target,
+		"url",
+		"http://127.0.0.1:8080/flows",
+		"ns-flows endpoint receiving the events",
+	)
+
+	var direct bool
+	flag.BoolVar(
+		&direct,
+		"direct",
+		false,
+		"Feed an in-memory processor and print the resulting flows instead of posting them",
+	)
+
+	var speedValue string
+	flag.StringVar(
+		&speedValue,
+		"speed",
+		"max",
+		"Replay speed: realtime, a factor such as 10x, or max for as fast as possible",
+	)
+
+	var debug bool
+	flag.BoolVar(&debug, "debug", false, "Enable debug logging")
+
+	flag.Parse()
+
+	logLevel := slog.LevelInfo
+	if debug {
+		logLevel = slog.LevelDebug
+	}
+	slog.SetDefault(slog.New(logger.New(os.Stderr, logLevel)))
+
+	if capturePath == "" {
+		log.Fatal("Missing --file")
+	}
+	speed, err := parseSpeed(speedValue)
+	if err != nil {
+		log.Fatalf("Invalid --speed: %v", err)
+	}
+
+	var input io.Reader = os.Stdin
+	if capturePath != "-" {
+		file, err := os.Open(capturePath)
+		if err != nil {
+			log.Fatalf("Failed to open capture: %v", err)
+		}
+		defer file.Close() //nolint:errcheck
+		input = file
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var deliver func(capture.Entry) error
+	var processor *flows.FlowProcessor
+	if direct {
+		processor = flows.NewFlowProcessor()
+		deliver = processEntry(processor)
+	} else {
+		deliver = postEntry(ctx, &http.Client{Timeout: 10 * time.Second}, target)
+	}
+
+	start := time.Now()
+	count, err := capture.Replay(ctx, capture.NewReader(input), speed, deliver)
+	slog.Info("Replay finished", "events", count, "elapsed", time.Since(start))
+	if err != nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+
+	if processor != nil {
+		if err := printFlows(os.Stdout, processor.GetEvents()); err != nil {
+			log.Fatalf("Failed to print flows: %v", err)
+		}
+	}
+}
+
+// parseSpeed accepts "max", "realtime" or a factor such as "10" or "10x".
+func parseSpeed(value string) (float64, error) {
+	switch value {
+	case "max":
+		return 0, nil
+	case "realtime":
+		return 1, nil
+	}
+	factor, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if factor <= 0 {
+		return 0, fmt.Errorf("factor must be positive, got %s", value)
+	}
+	return factor, nil
+}
+
+// postEntry sends each event to target the way netifyd does.
+func postEntry(ctx context.Context, client *http.Client, target string) func(capture.Entry) error {
+	return func(entry capture.Entry) error {
+		req, err := http.NewRequestWithContext(
+			ctx,
+			http.MethodPost,
+			target,
+			bytes.NewReader(entry.Body()),
+		)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close() //nolint:errcheck
+		_, _ = io.Copy(io.Discard, res.Body)
+		if res.StatusCode != http.StatusOK {
+			// The daemon rejected this event; keep going with the next ones.
+			slog.Warn("Event rejected", "status", res.Status, "recorded_at", entry.At)
+		}
+		return nil
+	}
+}
+
+// processEntry feeds each event to processor, skipping unsupported ones.
+func processEntry(processor *flows.FlowProcessor) func(capture.Entry) error {
+	return func(entry capture.Entry) error {
+		var event flows.FlowEvent
+		if err := json.Unmarshal(entry.Body(), &event); err != nil {
+			if errors.Is(err, flows.ErrUnsupportedFlowType) {
+				slog.Debug("Ignoring flow event with unsupported type", "error", err)
+				return nil
+			}
+			slog.Warn("Invalid flow event", "error", err, "recorded_at", entry.At)
+			return nil
+		}
+		processor.Process(event)
+		return nil
+	}
+}
+
+// printFlows writes events in the /flows response format, sorted by digest.
+func printFlows(out io.Writer, events map[string]flows.FlowEvent) error {
+	digests := make([]string, 0, len(events))
+	for digest := range events {
+		digests = append(digests, digest)
+	}
+	slices.Sort(digests)
+
+	response := api.FlowsResponse{Data: make([]flows.FlowEvent, 0, len(events))}
+	for _, digest := range digests {
+		response.Data = append(response.Data, events[digest])
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(response)
+}
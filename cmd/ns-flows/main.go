@@ -3,18 +3,39 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v3"
+	"github.com/nethserver/nethsecurity-monitoring/alert"
 	"github.com/nethserver/nethsecurity-monitoring/api"
+	"github.com/nethserver/nethsecurity-monitoring/asn"
+	"github.com/nethserver/nethsecurity-monitoring/capture"
+	"github.com/nethserver/nethsecurity-monitoring/catalogue"
+	"github.com/nethserver/nethsecurity-monitoring/conntrack"
+	"github.com/nethserver/nethsecurity-monitoring/flowlog"
 	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/geoip"
+	"github.com/nethserver/nethsecurity-monitoring/hostcheck"
+	"github.com/nethserver/nethsecurity-monitoring/internal/audit"
+	"github.com/nethserver/nethsecurity-monitoring/internal/config"
+	"github.com/nethserver/nethsecurity-monitoring/internal/filewatch"
 	"github.com/nethserver/nethsecurity-monitoring/internal/logger"
+	"github.com/nethserver/nethsecurity-monitoring/inventory"
+	"github.com/nethserver/nethsecurity-monitoring/ipfix"
+	"github.com/nethserver/nethsecurity-monitoring/ja4"
+	"github.com/nethserver/nethsecurity-monitoring/leases"
+	"github.com/nethserver/nethsecurity-monitoring/notable"
+	"github.com/nethserver/nethsecurity-monitoring/scan"
+	"github.com/nethserver/nethsecurity-monitoring/threatintel"
 )
 
 func main() {
@@ -37,35 +58,541 @@ func main() {
 		"Purge expired flows older than this duration",
 	)
 
+	var purgeInterval time.Duration
+	flag.DurationVar(
+		&purgeInterval,
+		"purge-interval",
+		10*time.Second,
+		"How often expired flows are purged",
+	)
+
+	var dnsmasqLeases string
+	flag.StringVar(
+		&dnsmasqLeases,
+		"dhcp-leases",
+		leases.DefaultDnsmasqPath,
+		"dnsmasq lease file (empty to disable)",
+	)
+
+	var odhcpdLeases string
+	flag.StringVar(
+		&odhcpdLeases,
+		"odhcpd-leases",
+		leases.DefaultOdhcpdPath,
+		"odhcpd lease file (empty to disable)",
+	)
+
+	var staticLeases string
+	flag.StringVar(
+		&staticLeases,
+		"dhcp-config",
+		leases.DefaultStaticPath,
+		"UCI dhcp config with static leases (empty to disable)",
+	)
+
+	var geoipPath string
+	flag.StringVar(
+		&geoipPath,
+		"geoip-db",
+		"",
+		"MaxMind-format GeoIP city or country database (optional)",
+	)
+
+	var asnPath string
+	flag.StringVar(
+		&asnPath,
+		"asn-db",
+		"",
+		"MaxMind-format ASN database or iptoasn.com TSV file (optional)",
+	)
+
+	var ja4Path string
+	flag.StringVar(
+		&ja4Path,
+		"ja4-db",
+		"",
+		"JA4 client fingerprint database, TSV or ja4db.com JSON (optional)",
+	)
+
+	var netifyCategories string
+	flag.StringVar(
+		&netifyCategories,
+		"netify-categories",
+		"",
+		"netifyd category catalogue, netify-categories.json (optional)",
+	)
+
+	var netifyApps string
+	flag.StringVar(
+		&netifyApps,
+		"netify-apps",
+		"",
+		"netifyd application list, netify-apps.conf or netifyd --dump-apps output (optional)",
+	)
+
+	var netifyProtocols string
+	flag.StringVar(
+		&netifyProtocols,
+		"netify-protocols",
+		"",
+		"netifyd protocol list, netifyd --dump-protos output (optional)",
+	)
+
+	var apiTokenFile string
+	flag.StringVar(
+		&apiTokenFile,
+		"api-token-file",
+		"",
+		"File holding the bearer token for the device and write endpoints (unset disables them)",
+	)
+
+	var auditLog string
+	flag.StringVar(
+		&auditLog,
+		"audit-log",
+		"",
+		"Append audit records to this file (default: log them)",
+	)
+
+	var threatFeeds string
+	flag.StringVar(
+		&threatFeeds,
+		"threat-feeds",
+		"",
+		"Comma-separated IP/CIDR or domain blocklist files (optional)",
+	)
+
+	var hostnameAllowlist string
+	flag.StringVar(
+		&hostnameAllowlist,
+		"hostname-allowlist",
+		"",
+		"File of CDN domains ignored by the hostname consistency check, one per line (optional)",
+	)
+
+	var ipfixCollector string
+	flag.StringVar(
+		&ipfixCollector,
+		"ipfix-collector",
+		"",
+		"Export finished flows to this IPFIX collector, udp://host:port or tcp://host:port",
+	)
+
+	var ipfixPen uint
+	flag.UintVar(
+		&ipfixPen,
+		"ipfix-pen",
+		0,
+		"Private enterprise number of the IPFIX nDPI fields, which are only exported when set",
+	)
+
+	var ipfixDomain uint
+	flag.UintVar(&ipfixDomain, "ipfix-domain-id", 0, "IPFIX observation domain ID")
+
+	var ipfixTemplateInterval time.Duration
+	flag.DurationVar(
+		&ipfixTemplateInterval,
+		"ipfix-template-interval",
+		time.Minute,
+		"How often IPFIX templates are sent again",
+	)
+
+	var flowLogPath string
+	flag.StringVar(
+		&flowLogPath,
+		"flow-log",
+		"",
+		"Append one JSON line per finished flow to this file (optional)",
+	)
+
+	var flowLogMaxSize int64
+	flag.Int64Var(
+		&flowLogMaxSize,
+		"flow-log-max-size",
+		10,
+		"Rotate the flow log once it reaches this size in MiB (0 disables)",
+	)
+
+	var flowLogMaxAge time.Duration
+	flag.DurationVar(
+		&flowLogMaxAge,
+		"flow-log-max-age",
+		24*time.Hour,
+		"Rotate the flow log once it is this old (0 disables)",
+	)
+
+	var flowLogKeep int
+	flag.IntVar(&flowLogKeep, "flow-log-keep", 7, "Number of rotated flow logs to keep (0 keeps all)")
+
+	var flowLogCompress bool
+	flag.BoolVar(&flowLogCompress, "flow-log-compress", true, "Gzip rotated flow logs")
+
+	var alertWebhook string
+	flag.StringVar(
+		&alertWebhook,
+		"alert-webhook",
+		"",
+		"POST alerts as JSON to this http(s) URL in addition to logging them (optional)",
+	)
+
+	var notableDuration time.Duration
+	flag.DurationVar(
+		&notableDuration,
+		"notable-duration",
+		12*time.Hour,
+		"Flag flows open for longer than this (0 disables)",
+	)
+
+	var notableBytes int64
+	flag.Int64Var(
+		&notableBytes,
+		"notable-bytes",
+		10240,
+		"Flag flows that transferred more than this many MiB (0 disables)",
+	)
+
+	var notableRate float64
+	flag.Float64Var(
+		&notableRate,
+		"notable-rate",
+		0,
+		"Flag flows whose rate stays above this many bytes/s (0 disables)",
+	)
+
+	var notableRateFor time.Duration
+	flag.DurationVar(
+		&notableRateFor,
+		"notable-rate-for",
+		time.Minute,
+		"How long a flow rate must stay above --notable-rate",
+	)
+
+	var scanWindow time.Duration
+	flag.DurationVar(
+		&scanWindow,
+		"scan-window",
+		5*time.Minute,
+		"Window over which the flows opened by each local host are counted (0 disables)",
+	)
+
+	var scanDestinations int
+	flag.IntVar(
+		&scanDestinations,
+		"scan-destinations",
+		200,
+		"Flag local hosts contacting this many distinct IPs within --scan-window (0 disables)",
+	)
+
+	var scanPorts int
+	flag.IntVar(
+		&scanPorts,
+		"scan-ports",
+		100,
+		"Flag local hosts contacting this many distinct ports within --scan-window (0 disables)",
+	)
+
+	var scanResets int
+	flag.IntVar(
+		&scanResets,
+		"scan-resets",
+		100,
+		"Flag local hosts with this many short flows reset within --scan-window (0 disables)",
+	)
+
+	var scanShortFlow time.Duration
+	flag.DurationVar(
+		&scanShortFlow,
+		"scan-short-flow",
+		5*time.Second,
+		"Reset flows shorter than this count towards --scan-resets",
+	)
+
+	var deviceDB string
+	flag.StringVar(
+		&deviceDB,
+		"device-db",
+		"",
+		"SQLite database of the devices seen on the LAN, shareable with ns-stats (optional)",
+	)
+
+	var capturePath string
+	flag.StringVar(
+		&capturePath,
+		"capture",
+		"",
+		"Record every received event with its arrival time to this file (optional)",
+	)
+
+	var logFormat string
+	flag.StringVar(&logFormat, "log-format", logger.FormatText, "Log format (text, json)")
+
+	var logOutput string
+	flag.StringVar(&logOutput, "log-output", logger.OutputStderr, "Log output (stderr, syslog)")
+
+	var configPath string
+	flag.StringVar(&configPath, "config", config.DefaultPath, "UCI configuration file")
+
 	flag.Parse()
 
-	var logLevel slog.Level
-	switch debugLevel {
-	case "debug":
-		logLevel = slog.LevelDebug
-	case "info":
-		logLevel = slog.LevelInfo
-	case "warn":
-		logLevel = slog.LevelWarn
-	case "error":
-		logLevel = slog.LevelError
-	default:
-		log.Fatalf("Invalid log level: %s", debugLevel)
+	configRequired := false
+	flag.Visit(func(f *flag.Flag) {
+		configRequired = configRequired || f.Name == "config"
+	})
+	loader := config.NewLoader(flag.CommandLine, configPath, config.SectionFlows, configRequired)
+	settings, err := loader.Load()
+	if err == nil {
+		err = validate(settings)
 	}
+	if err == nil {
+		err = loader.Apply(settings)
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	var current atomic.Pointer[config.Settings]
+	current.Store(&settings)
 
-	loggerHandler := logger.New(os.Stderr, logLevel)
-	slog.SetDefault(slog.New(loggerHandler))
+	level, err := logger.ParseLevel(debugLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var logLevel slog.LevelVar
+	logLevel.Set(level)
+	if err := logger.Setup(logOutput, logFormat, "ns-flows", &logLevel); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
 
-	processor := flows.NewFlowProcessor()
+	alerts := alert.Publisher{alert.LogSink{}}
+	var webhook *alert.Webhook
+	if alertWebhook != "" {
+		var err error
+		webhook, err = alert.NewWebhook(alertWebhook)
+		if err != nil {
+			log.Fatalf("Failed to configure alert webhook: %v", err)
+		}
+		alerts = append(alerts, webhook)
+	}
+
+	var sinks []flows.FlowSink
+	var ipfixExporter *ipfix.Exporter
+	if ipfixCollector != "" {
+		var err error
+		ipfixExporter, err = ipfix.New(
+			ipfixCollector,
+			ipfix.WithEnterpriseNumber(uint32(ipfixPen)),
+			ipfix.WithObservationDomain(uint32(ipfixDomain)),
+			ipfix.WithTemplateInterval(ipfixTemplateInterval),
+		)
+		if err != nil {
+			log.Fatalf("Invalid IPFIX configuration: %v", err)
+		}
+		sinks = append(sinks, ipfixExporter)
+	}
+	var flowLog *flowlog.Writer
+	if flowLogPath != "" {
+		var err error
+		flowLog, err = flowlog.New(
+			flowLogPath,
+			flowlog.WithMaxSize(flowLogMaxSize<<20),
+			flowlog.WithMaxAge(flowLogMaxAge),
+			flowlog.WithRetention(flowLogKeep),
+			flowlog.WithCompression(flowLogCompress),
+		)
+		if err != nil {
+			log.Fatalf("Failed to open flow log: %v", err)
+		}
+		sinks = append(sinks, flowLog)
+	}
+	scanLimits := scan.Limits{
+		Window:       scanWindow,
+		Destinations: scanDestinations,
+		Ports:        scanPorts,
+		Resets:       scanResets,
+		ShortFlow:    scanShortFlow,
+	}
+	// Flows are counted as they are ingested, and resets as they finish.
+	var analyzers []flows.FlowAnalyzer
+	var scanDetector *scan.Detector
+	if scanLimits.Enabled() {
+		scanDetector = scan.New(scanLimits, alerts)
+		sinks = append(sinks, scanDetector)
+		analyzers = append(analyzers, scanDetector)
+	}
+
+	// Hostnames are checked once per flow, when it is ingested.
+	hostnames := hostcheck.New(hostnameAllowlist)
+	if hostnameAllowlist != "" {
+		if err := hostnames.Reload(); err != nil {
+			slog.Error("Failed to load hostname allowlist", "error", err)
+		}
+	}
+	sinks = append(sinks, hostnames)
+	analyzers = append(analyzers, hostnames)
+
+	processor := flows.NewFlowProcessor(
+		flows.WithSinks(sinks...),
+		flows.WithAnalyzers(analyzers...),
+	)
+
+	leaseTable := leases.NewTable(dnsmasqLeases, odhcpdLeases, staticLeases)
+	if err := leaseTable.Reload(); err != nil {
+		slog.Error("Failed to load DHCP leases", "error", err)
+	}
+
+	enrichers := []flows.Enricher{leaseTable}
+
+	var geoDB *geoip.DB
+	if geoipPath != "" {
+		geoDB = geoip.New(geoipPath)
+		if err := geoDB.Reload(); err != nil {
+			slog.Error("Failed to load GeoIP database", "error", err)
+		}
+		enrichers = append(enrichers, geoDB)
+	}
+
+	var asnDB *asn.DB
+	if asnPath != "" {
+		asnDB = asn.New(asnPath)
+		if err := asnDB.Reload(); err != nil {
+			slog.Error("Failed to load ASN database", "error", err)
+		}
+		enrichers = append(enrichers, asnDB)
+	}
+
+	var ja4DB *ja4.DB
+	if ja4Path != "" {
+		ja4DB = ja4.New(ja4Path)
+		if err := ja4DB.Reload(); err != nil {
+			slog.Error("Failed to load JA4 database", "error", err)
+		}
+		enrichers = append(enrichers, ja4DB)
+	}
+
+	var netifyCatalogue *catalogue.Catalogue
+	if netifyCategories != "" || netifyApps != "" || netifyProtocols != "" {
+		netifyCatalogue = catalogue.New(netifyCategories, netifyApps, netifyProtocols)
+		if err := netifyCatalogue.Reload(); err != nil {
+			slog.Error("Failed to load Netify catalogue", "error", err)
+		}
+		enrichers = append(enrichers, netifyCatalogue)
+	}
+
+	var intel *threatintel.Intel
+	if threatFeeds != "" {
+		intel = threatintel.New(splitList(threatFeeds)...)
+		if err := intel.Reload(); err != nil {
+			slog.Error("Failed to load threat feeds", "error", err)
+		}
+		enrichers = append(enrichers, intel)
+	}
+
+	flowOpts := []api.FlowApiOption{
+		api.WithEnrichers(enrichers...),
+		api.WithTraffic(processor),
+		api.WithHostnameChecker(hostnames),
+	}
+	var token string
+	if apiTokenFile != "" {
+		data, err := os.ReadFile(apiTokenFile)
+		if err != nil {
+			log.Fatalf("Failed to read API token: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+		if token == "" {
+			log.Fatalf("API token file %s is empty", apiTokenFile)
+		}
+
+		var recorder audit.Recorder = audit.LogRecorder{}
+		if auditLog != "" {
+			fileRecorder, err := audit.OpenFile(auditLog)
+			if err != nil {
+				log.Fatalf("Failed to open audit log: %v", err)
+			}
+			defer fileRecorder.Close() //nolint:errcheck
+			recorder = fileRecorder
+		}
+
+		flowOpts = append(flowOpts, api.WithFlowTermination(
+			conntrack.NewNetlink(),
+			token,
+			recorder,
+		))
+	}
+
+	limits := notable.Limits{
+		Duration: notableDuration,
+		Bytes:    notableBytes << 20,
+		Rate:     notableRate,
+		RateFor:  notableRateFor,
+	}
+	var notableDetector *notable.Detector
+	if limits.Enabled() {
+		notableDetector = notable.New(limits, alerts)
+		flowOpts = append(flowOpts, api.WithNotable(notableDetector))
+	}
+	if scanDetector != nil {
+		flowOpts = append(flowOpts, api.WithScanDetector(scanDetector))
+	}
+
+	var devices *inventory.Inventory
+	if deviceDB != "" {
+		var err error
+		devices, err = inventory.Open(context.Background(), deviceDB, alerts)
+		if err != nil {
+			log.Fatalf("Failed to open device inventory: %v", err)
+		}
+		defer devices.Close() //nolint:errcheck
+	}
+
+	if capturePath != "" {
+		writer, err := capture.Create(capturePath)
+		if err != nil {
+			log.Fatalf("Failed to open capture file: %v", err)
+		}
+		defer writer.Close() //nolint:errcheck
+		slog.Info("Capturing received events", "path", capturePath)
+		flowOpts = append(flowOpts, api.WithCapture(writer))
+	}
 
 	app := fiber.New()
-	api.NewFlowApi(processor, processor).Setup(app)
+	api.NewFlowApi(processor, processor, flowOpts...).Setup(app)
+	if devices != nil {
+		api.NewDeviceApi(devices, token).Setup(app)
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
 	var wg sync.WaitGroup
 
+	// File watchers (DHCP leases, GeoIP, ASN and JA4 databases, threat feeds,
+	// hostname allowlist)
+	watchFiles(ctx, &wg, "DHCP leases", leaseTable.Reload, leaseTable.Paths()...)
+	if geoDB != nil {
+		watchFiles(ctx, &wg, "GeoIP database", geoDB.Reload, geoDB.Path())
+	}
+	if asnDB != nil {
+		watchFiles(ctx, &wg, "ASN database", asnDB.Reload, asnDB.Path())
+	}
+	if ja4DB != nil {
+		watchFiles(ctx, &wg, "JA4 database", ja4DB.Reload, ja4DB.Path())
+	}
+	if netifyCatalogue != nil {
+		watchFiles(
+			ctx,
+			&wg,
+			"Netify catalogue",
+			netifyCatalogue.Reload,
+			netifyCatalogue.Paths()...,
+		)
+	}
+	if intel != nil {
+		watchFiles(ctx, &wg, "threat feeds", intel.Reload, intel.Paths()...)
+	}
+	if hostnameAllowlist != "" {
+		watchFiles(ctx, &wg, "hostname allowlist", hostnames.Reload, hostnames.Path())
+	}
+
 	// Start the HTTP API server on 127.0.0.1 only.
 	wg.Add(1)
 	go func() {
@@ -78,19 +605,85 @@ func main() {
 		}
 	}()
 
+	// IPFIX exporter
+	if ipfixExporter != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slog.Info("Starting IPFIX exporter", "collector", ipfixCollector)
+			ipfixExporter.Run(ctx)
+			slog.Info("Stopping IPFIX exporter")
+		}()
+	}
+
+	// Alert webhook
+	if webhook != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slog.Info("Starting alert webhook", "endpoint", webhook.Endpoint())
+			webhook.Run(ctx)
+			slog.Info("Stopping alert webhook")
+		}()
+	}
+
+	// Notable flow detection
+	if notableDetector != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			notableDetector.Run(ctx, processor, 10*time.Second)
+		}()
+	}
+
+	// Device inventory
+	if devices != nil {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			devices.Run(ctx, 30*time.Second)
+		}()
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(10 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					devices.ObserveFlows(processor.GetEvents())
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// Flow log writer
+	if flowLog != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slog.Info("Starting flow log writer", "path", flowLogPath)
+			flowLog.Run(ctx)
+			slog.Info("Stopping flow log writer")
+		}()
+	}
+
 	// Flow cleanup (purge flows older than expiredPersistence)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		ticker := time.NewTicker(10 * time.Second)
+		ticker := time.NewTicker(purgeInterval)
 		defer ticker.Stop()
 
 		slog.Info("Starting flow cleanup process")
 		for {
 			select {
 			case <-ticker.C:
-				processor.PurgeFlowsOlderThan(expiredPersistence)
+				settings := *current.Load()
+				processor.PurgeFlowsOlderThan(settings.Duration("expired-persistence"))
+				ticker.Reset(settings.Duration("purge-interval"))
 			case <-ctx.Done():
 				slog.Info("Stopping flow cleanup")
 				return
@@ -98,6 +691,31 @@ func main() {
 		}
 	}()
 
+	// Debug logging toggled by SIGUSR1
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		logger.ToggleDebugOnSignal(ctx, &logLevel)
+	}()
+
+	// Configuration reload on SIGHUP
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		loader.Run(ctx, settings, reloadable, func(settings config.Settings) error {
+			if err := validate(settings); err != nil {
+				return err
+			}
+			level, err := logger.ParseLevel(settings.String("log-level"))
+			if err != nil {
+				return err
+			}
+			logLevel.Set(level)
+			current.Store(&settings)
+			return nil
+		})
+	}()
+
 	<-ctx.Done()
 	stop()
 
@@ -109,3 +727,57 @@ func main() {
 	wg.Wait()
 	slog.Info("All processes completed, exiting")
 }
+
+// reloadable lists the flags applied on SIGHUP without a restart.
+var reloadable = []string{"log-level", "expired-persistence", "purge-interval"}
+
+// validate checks the settings that would otherwise fail at runtime.
+func validate(settings config.Settings) error {
+	if _, err := logger.ParseLevel(settings.String("log-level")); err != nil {
+		return err
+	}
+	if err := logger.ValidateFormat(settings.String("log-format")); err != nil {
+		return err
+	}
+	for _, name := range []string{"expired-persistence", "purge-interval"} {
+		if settings.Duration(name) <= 0 {
+			return fmt.Errorf("--%s must be positive", name)
+		}
+	}
+	return nil
+}
+
+// watchFiles reloads a file-backed source whenever one of its paths changes.
+func watchFiles(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	name string,
+	reload func() error,
+	paths ...string,
+) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		slog.Info("Starting file watcher", "source", name)
+		filewatch.New(paths...).Run(ctx, 10*time.Second, func() {
+			if err := reload(); err != nil {
+				slog.Error("Failed to reload file", "source", name, "error", err)
+				return
+			}
+			slog.Info("Reloaded file", "source", name)
+		})
+		slog.Info("Stopping file watcher", "source", name)
+	}()
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
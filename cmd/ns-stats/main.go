@@ -2,22 +2,35 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"log/slog"
 	"net"
+	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v3"
 	fiberlogger "github.com/gofiber/fiber/v3/middleware/logger"
 	airRecover "github.com/gofiber/fiber/v3/middleware/recover"
+	"github.com/nethserver/nethsecurity-monitoring/alert"
 	"github.com/nethserver/nethsecurity-monitoring/api"
+	"github.com/nethserver/nethsecurity-monitoring/asn"
+	"github.com/nethserver/nethsecurity-monitoring/geoip"
+	"github.com/nethserver/nethsecurity-monitoring/internal/config"
+	"github.com/nethserver/nethsecurity-monitoring/internal/filewatch"
 	"github.com/nethserver/nethsecurity-monitoring/internal/logger"
+	"github.com/nethserver/nethsecurity-monitoring/inventory"
+	"github.com/nethserver/nethsecurity-monitoring/leases"
 	"github.com/nethserver/nethsecurity-monitoring/reverse_dns"
 	"github.com/nethserver/nethsecurity-monitoring/stats"
+	"github.com/nethserver/nethsecurity-monitoring/threatintel"
 )
 
 func main() {
@@ -34,58 +47,333 @@ func main() {
 	var debugLevel string
 	flag.StringVar(&debugLevel, "log-level", "info", "Log level (debug, info, warn, error)")
 
+	var dnsmasqLeases string
+	flag.StringVar(
+		&dnsmasqLeases,
+		"dhcp-leases",
+		leases.DefaultDnsmasqPath,
+		"dnsmasq lease file (empty to disable)",
+	)
+
+	var odhcpdLeases string
+	flag.StringVar(
+		&odhcpdLeases,
+		"odhcpd-leases",
+		leases.DefaultOdhcpdPath,
+		"odhcpd lease file (empty to disable)",
+	)
+
+	var staticLeases string
+	flag.StringVar(
+		&staticLeases,
+		"dhcp-config",
+		leases.DefaultStaticPath,
+		"UCI dhcp config with static leases (empty to disable)",
+	)
+
+	var geoipPath string
+	flag.StringVar(
+		&geoipPath,
+		"geoip-db",
+		"",
+		"MaxMind-format GeoIP city or country database (optional)",
+	)
+
+	var asnPath string
+	flag.StringVar(
+		&asnPath,
+		"asn-db",
+		"",
+		"MaxMind-format ASN database or iptoasn.com TSV file (optional)",
+	)
+
+	var deviceDB string
+	flag.StringVar(
+		&deviceDB,
+		"device-db",
+		"",
+		"SQLite database of the devices seen on the LAN, shareable with ns-flows (optional)",
+	)
+
+	var apiTokenFile string
+	flag.StringVar(
+		&apiTokenFile,
+		"api-token-file",
+		"",
+		"File holding the bearer token for the stats history and devices endpoints "+
+			"(unset disables them)",
+	)
+
+	var alertWebhook string
+	flag.StringVar(
+		&alertWebhook,
+		"alert-webhook",
+		"",
+		"POST alerts as JSON to this http(s) URL in addition to logging them (optional)",
+	)
+
+	var threatFeeds string
+	flag.StringVar(
+		&threatFeeds,
+		"threat-feeds",
+		"",
+		"Comma-separated IP/CIDR or domain blocklist files (optional)",
+	)
+
+	var retention time.Duration
+	flag.DurationVar(&retention, "retention", 3*time.Hour, "Keep raw stats for this long")
+
+	var hourlyRetention time.Duration
+	flag.DurationVar(
+		&hourlyRetention,
+		"hourly-retention",
+		7*24*time.Hour,
+		"Keep hourly rollups for this long",
+	)
+
+	var dailyRetention time.Duration
+	flag.DurationVar(
+		&dailyRetention,
+		"daily-retention",
+		90*24*time.Hour,
+		"Keep daily rollups for this long",
+	)
+
+	var monthlyRetention time.Duration
+	flag.DurationVar(
+		&monthlyRetention,
+		"monthly-retention",
+		2*365*24*time.Hour,
+		"Keep monthly rollups for this long",
+	)
+
+	var exportWindow int
+	flag.IntVar(
+		&exportWindow,
+		"export-window",
+		2,
+		"Number of recent hours exported in full at startup and on reload",
+	)
+
+	var exportRetention time.Duration
+	flag.DurationVar(
+		&exportRetention,
+		"export-retention",
+		90*24*time.Hour,
+		"Keep export files for this long, 0 to keep them forever",
+	)
+
+	var exportMaxSize int
+	flag.IntVar(
+		&exportMaxSize,
+		"export-max-size",
+		100,
+		"Maximum size of the export files in MiB, 0 for no limit",
+	)
+
+	var pruneInterval time.Duration
+	flag.DurationVar(
+		&pruneInterval,
+		"prune-interval",
+		30*time.Second,
+		"How often expired stats are deleted",
+	)
+
+	var exportInterval time.Duration
+	flag.DurationVar(
+		&exportInterval,
+		"export-interval",
+		30*time.Second,
+		"How often stats are exported",
+	)
+
+	var resolveInterval time.Duration
+	flag.DurationVar(
+		&resolveInterval,
+		"resolve-interval",
+		time.Minute,
+		"How often unresolved remote IPs are looked up",
+	)
+
+	var resolverServer string
+	flag.StringVar(
+		&resolverServer,
+		"resolver-server",
+		"",
+		"DNS server for reverse lookups, host or host:port (default: system resolver)",
+	)
+
+	var resolverCacheTTL time.Duration
+	flag.DurationVar(
+		&resolverCacheTTL,
+		"resolver-cache-ttl",
+		5*time.Minute,
+		"How long reverse lookups are cached",
+	)
+
+	var resolverCacheSize int
+	flag.IntVar(
+		&resolverCacheSize,
+		"resolver-cache-size",
+		10000,
+		"Maximum number of cached reverse lookups",
+	)
+
+	var logFormat string
+	flag.StringVar(&logFormat, "log-format", logger.FormatText, "Log format (text, json)")
+
+	var logOutput string
+	flag.StringVar(&logOutput, "log-output", logger.OutputStderr, "Log output (stderr, syslog)")
+
+	var configPath string
+	flag.StringVar(&configPath, "config", config.DefaultPath, "UCI configuration file")
+
 	flag.Parse()
 
+	configRequired := false
+	flag.Visit(func(f *flag.Flag) {
+		configRequired = configRequired || f.Name == "config"
+	})
+	loader := config.NewLoader(flag.CommandLine, configPath, config.SectionStats, configRequired)
+	settings, err := loader.Load()
+	if err == nil {
+		err = validate(settings)
+	}
+	if err == nil {
+		err = loader.Apply(settings)
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	var current atomic.Pointer[config.Settings]
+	current.Store(&settings)
+
 	// Validate required flags
 	if exportPath == "" {
 		log.Fatalf("--export-path is required")
 	}
 
-	// Fixed retention and export window
-	retention := 3 * time.Hour
-	exportWindowHours := 2
-
 	// slog setup
-	var logLevel slog.Level
-	switch debugLevel {
-	case "debug":
-		logLevel = slog.LevelDebug
-	case "info":
-		logLevel = slog.LevelInfo
-	case "warn":
-		logLevel = slog.LevelWarn
-	case "error":
-		logLevel = slog.LevelError
-	default:
-		log.Fatalf("Invalid log level: %s", debugLevel)
-	}
-	slog.SetLogLoggerLevel(logLevel)
-
-	store, err := stats.NewStore(context.Background(), dbPath)
+	level, err := logger.ParseLevel(debugLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var logLevel slog.LevelVar
+	logLevel.Set(level)
+	if err := logger.Setup(logOutput, logFormat, "ns-stats", &logLevel); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	var store *stats.Store
+	var storeOpts []stats.StoreOption
+	var geoDB *geoip.DB
+	if geoipPath != "" {
+		geoDB = geoip.New(geoipPath)
+		if err := geoDB.Reload(); err != nil {
+			slog.Error("Failed to load GeoIP database", "error", err)
+		}
+		storeOpts = append(storeOpts, stats.WithGeoLocator(geoDB))
+	}
+	var asnDB *asn.DB
+	if asnPath != "" {
+		asnDB = asn.New(asnPath)
+		if err := asnDB.Reload(); err != nil {
+			slog.Error("Failed to load ASN database", "error", err)
+		}
+		storeOpts = append(storeOpts, stats.WithAsnLookup(asnDB))
+	}
+	var intel *threatintel.Intel
+	if threatFeeds != "" {
+		intel = threatintel.New(splitList(threatFeeds)...)
+		if err := intel.Reload(); err != nil {
+			slog.Error("Failed to load threat feeds", "error", err)
+		}
+		storeOpts = append(storeOpts, stats.WithThreatMatcher(intel))
+	}
+
+	store, err = stats.NewStore(context.Background(), dbPath, storeOpts...)
 	if err != nil {
 		log.Fatalf("Failed to initialize SQLite schema: %v", err)
 	}
 	defer store.Close() //nolint:errcheck
 
+	alerts := alert.Publisher{alert.LogSink{}}
+	var webhook *alert.Webhook
+	if alertWebhook != "" {
+		webhook, err = alert.NewWebhook(alertWebhook)
+		if err != nil {
+			log.Fatalf("Failed to configure alert webhook: %v", err)
+		}
+		alerts = append(alerts, webhook)
+	}
+
+	var saver stats.Saver = store
+	var devices *inventory.Inventory
+	if deviceDB != "" {
+		devices, err = inventory.Open(context.Background(), deviceDB, alerts)
+		if err != nil {
+			log.Fatalf("Failed to open device inventory: %v", err)
+		}
+		defer devices.Close() //nolint:errcheck
+		saver = observingSaver{Saver: store, devices: devices}
+	}
+
+	leaseTable := leases.NewTable(dnsmasqLeases, odhcpdLeases, staticLeases)
+	if err := leaseTable.Reload(); err != nil {
+		slog.Error("Failed to load DHCP leases", "error", err)
+	}
+
 	// Concurrent managers
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
 	var wg sync.WaitGroup
 
+	// File watchers (DHCP leases, GeoIP and ASN databases, threat feeds)
+	watchFiles(ctx, &wg, "DHCP leases", leaseTable.Reload, leaseTable.Paths()...)
+	if geoDB != nil {
+		watchFiles(ctx, &wg, "GeoIP database", geoDB.Reload, geoDB.Path())
+	}
+	if asnDB != nil {
+		watchFiles(ctx, &wg, "ASN database", asnDB.Reload, asnDB.Path())
+	}
+	if intel != nil {
+		watchFiles(ctx, &wg, "threat feeds", intel.Reload, intel.Paths()...)
+	}
+
+	// The read endpoints expose the history of every host and are only served
+	// with a token.
+	var token string
+	if apiTokenFile != "" {
+		data, err := os.ReadFile(apiTokenFile)
+		if err != nil {
+			log.Fatalf("Failed to read API token: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+		if token == "" {
+			log.Fatalf("API token file %s is empty", apiTokenFile)
+		}
+	}
+
 	// API Server
 	server := fiber.New(fiber.Config{
 		AppName: "ns-stats",
 	})
-	if logLevel == slog.LevelDebug {
-		server.Use(fiberlogger.New(fiberlogger.Config{
-			Format:     "${method} ${path} ${status} ${latency}\n",
-			TimeFormat: "15:04:05",
-			Stream:     &logger.FiberWriter{},
-		}))
-	}
+	// Requests are logged at debug level, which can be enabled at runtime.
+	server.Use(fiberlogger.New(fiberlogger.Config{
+		Format:     "${method} ${path} ${status} ${latency}\n",
+		TimeFormat: "15:04:05",
+		Stream:     &logger.FiberWriter{},
+	}))
 	server.Use(airRecover.New())
-	api.NewStatsApi(store).Setup(server)
+	api.NewStatsApi(
+		saver,
+		api.WithStatsHistory(store, token),
+		api.WithStatsHostLookup(leaseTable),
+	).Setup(server)
+	if devices != nil {
+		api.NewDeviceApi(devices, token).Setup(server)
+	}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -96,21 +384,54 @@ func main() {
 		}
 	}()
 
+	// Alert webhook
+	if webhook != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			webhook.Run(ctx)
+		}()
+	}
+
+	// Device inventory
+	if devices != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			devices.Run(ctx, 30*time.Second)
+		}()
+	}
+
 	// Pruner
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		ticker := time.NewTicker(30 * time.Second)
+		ticker := time.NewTicker(pruneInterval)
 		defer ticker.Stop()
 
 		prune := func() {
-			cutoff := time.Now().Add(-retention).Unix()
+			settings := *current.Load()
+			now := time.Now()
+			// Raw stats are only deleted once their totals are rolled up.
+			if err := store.Rollup(ctx); err != nil {
+				slog.Error("Failed to roll up stats", "error", err)
+				return
+			}
+			cutoff := now.Add(-settings.Duration("retention")).Unix()
 			if err := store.DeleteOlderThan(ctx, cutoff); err != nil {
 				slog.Error("Failed to delete expired stats", "error", err)
 				return
 			}
 			slog.Debug("Pruned expired stats", "cutoff", time.Unix(cutoff, 0).Format(time.RFC3339))
+
+			for _, resolution := range stats.Resolutions {
+				keep := settings.Duration(string(resolution) + "-retention")
+				cutoff := now.Add(-keep).Unix()
+				if err := store.DeleteRollupsOlderThan(ctx, resolution, cutoff); err != nil {
+					slog.Error("Failed to delete expired rollups", "error", err)
+				}
+			}
 		}
 
 		slog.Info("Starting stats cleanup process")
@@ -120,6 +441,7 @@ func main() {
 			select {
 			case <-ticker.C:
 				prune()
+				ticker.Reset((*current.Load()).Duration("prune-interval"))
 			case <-ctx.Done():
 				slog.Info("Stopping stats cleanup")
 				return
@@ -128,14 +450,45 @@ func main() {
 	}()
 
 	// Exporter
-	exporter := stats.NewExporter(exportPath, exportWindowHours)
+	exporter := stats.NewExporter(exportPath, exportWindow, stats.WithHostLookup(leaseTable))
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		ticker := time.NewTicker(30 * time.Second)
+		ticker := time.NewTicker(exportInterval)
 		defer ticker.Stop()
 
+		var lastCleanup time.Time
+		cleanup := func() {
+			settings := *current.Load()
+			var cutoff time.Time
+			if keep := settings.Duration("export-retention"); keep > 0 {
+				cutoff = time.Now().Add(-keep)
+			}
+			maxBytes := int64(settings.Int("export-max-size")) << 20
+			result, err := exporter.PruneExports(cutoff, maxBytes)
+			if err != nil {
+				slog.Error("Failed to prune export files", "error", err)
+				return
+			}
+			if result.Files > 0 {
+				slog.Info(
+					"Pruned export files",
+					"days", result.Days,
+					"months", result.Months,
+					"files", result.Files,
+					"bytes", result.Bytes,
+					"size", result.Size,
+				)
+			}
+			if maxBytes > 0 && result.Size > maxBytes {
+				slog.Warn(
+					"Export files exceed --export-max-size with the newest day alone",
+					"size", result.Size,
+				)
+			}
+		}
+
 		export := func() {
 			timestamp := time.Now()
 			err := exporter.ExportAll(ctx, store)
@@ -144,6 +497,12 @@ func main() {
 				return
 			}
 			slog.Debug("Exported stats successfully", "duration", time.Since(timestamp))
+
+			// Cleaning up after exporting never races with the writes.
+			if time.Since(lastCleanup) >= exportCleanupInterval {
+				cleanup()
+				lastCleanup = time.Now()
+			}
 		}
 
 		slog.Info("Starting exporter process")
@@ -153,6 +512,7 @@ func main() {
 			select {
 			case <-ticker.C:
 				export()
+				ticker.Reset((*current.Load()).Duration("export-interval"))
 			case <-ctx.Done():
 				slog.Info("Stopping exporter process")
 				return
@@ -161,15 +521,23 @@ func main() {
 	}()
 
 	// IP Resolver (DNS reverse lookup with caching)
-	dnsResolver := reverse_dns.New(net.DefaultResolver.LookupAddr, 5*time.Minute, 10000)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		ticker := time.NewTicker(1 * time.Minute)
+		resolverSettings := *current.Load()
+		dnsResolver := newResolver(resolverSettings)
+		ticker := time.NewTicker(resolveInterval)
 		defer ticker.Stop()
 
 		resolve := func() {
+			// A new cache is started when the resolver settings change.
+			if settings := *current.Load(); resolverChanged(settings, resolverSettings) {
+				resolverSettings = settings
+				dnsResolver = newResolver(settings)
+				slog.Info("Restarted IP resolver with new settings")
+			}
+
 			ips, err := store.QueryUnresolvedIPs(ctx)
 			if err != nil {
 				slog.Error("Failed to query unresolved IPs", "error", err)
@@ -211,6 +579,7 @@ func main() {
 			select {
 			case <-ticker.C:
 				resolve()
+				ticker.Reset((*current.Load()).Duration("resolve-interval"))
 			case <-ctx.Done():
 				slog.Info("Stopping IP resolver")
 				return
@@ -218,6 +587,32 @@ func main() {
 		}
 	}()
 
+	// Debug logging toggled by SIGUSR1
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		logger.ToggleDebugOnSignal(ctx, &logLevel)
+	}()
+
+	// Configuration reload on SIGHUP
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		loader.Run(ctx, settings, reloadable, func(settings config.Settings) error {
+			if err := validate(settings); err != nil {
+				return err
+			}
+			level, err := logger.ParseLevel(settings.String("log-level"))
+			if err != nil {
+				return err
+			}
+			logLevel.Set(level)
+			exporter.SetWindow(settings.Int("export-window"))
+			current.Store(&settings)
+			return nil
+		})
+	}()
+
 	<-ctx.Done()
 
 	slog.Info("Shutting down API server")
@@ -228,3 +623,159 @@ func main() {
 	wg.Wait()
 	slog.Info("All processes completed, exiting")
 }
+
+// exportCleanupInterval is how often the export files are checked against
+// --export-retention and --export-max-size, which requires walking the tree.
+const exportCleanupInterval = time.Hour
+
+// reloadable lists the flags applied on SIGHUP without a restart.
+var reloadable = []string{
+	"log-level",
+	"retention",
+	"hourly-retention",
+	"daily-retention",
+	"monthly-retention",
+	"export-window",
+	"export-retention",
+	"export-max-size",
+	"prune-interval",
+	"export-interval",
+	"resolve-interval",
+	"resolver-server",
+	"resolver-cache-ttl",
+	"resolver-cache-size",
+}
+
+// validate checks the settings that would otherwise fail at runtime.
+func validate(settings config.Settings) error {
+	if _, err := logger.ParseLevel(settings.String("log-level")); err != nil {
+		return err
+	}
+	if err := logger.ValidateFormat(settings.String("log-format")); err != nil {
+		return err
+	}
+	for _, name := range []string{
+		"retention",
+		"hourly-retention",
+		"daily-retention",
+		"monthly-retention",
+		"prune-interval",
+		"export-interval",
+		"resolve-interval",
+		"resolver-cache-ttl",
+	} {
+		if settings.Duration(name) <= 0 {
+			return fmt.Errorf("--%s must be positive", name)
+		}
+	}
+	for _, name := range []string{"export-window", "resolver-cache-size"} {
+		if settings.Int(name) < 1 {
+			return fmt.Errorf("--%s must be positive", name)
+		}
+	}
+	if settings.Duration("export-retention") < 0 || settings.Int("export-max-size") < 0 {
+		return errors.New("--export-retention and --export-max-size must not be negative")
+	}
+	// Every hour must be rolled up at least once after it ends, and each
+	// rollup level must outlive the periods still being computed from it.
+	retention := settings.Duration("retention")
+	if retention < time.Hour+settings.Duration("prune-interval") {
+		return errors.New("--retention must be at least one hour plus --prune-interval")
+	}
+	if settings.Duration("hourly-retention") < retention+48*time.Hour {
+		return errors.New("--hourly-retention must be at least two days longer than --retention")
+	}
+	if settings.Duration("daily-retention") < retention+32*24*time.Hour {
+		return errors.New("--daily-retention must be at least 32 days longer than --retention")
+	}
+	return nil
+}
+
+// newResolver creates a caching reverse resolver querying the configured DNS
+// server, or the system resolver.
+func newResolver(settings config.Settings) *reverse_dns.Resolver {
+	resolver := net.DefaultResolver
+	if server := settings.String("resolver-server"); server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return reverse_dns.New(
+		resolver.LookupAddr,
+		settings.Duration("resolver-cache-ttl"),
+		settings.Int("resolver-cache-size"),
+	)
+}
+
+// resolverChanged reports whether the resolver settings differ.
+func resolverChanged(a, b config.Settings) bool {
+	return a.String("resolver-server") != b.String("resolver-server") ||
+		a.Duration("resolver-cache-ttl") != b.Duration("resolver-cache-ttl") ||
+		a.Int("resolver-cache-size") != b.Int("resolver-cache-size")
+}
+
+// watchFiles reloads a file-backed source whenever one of its paths changes.
+func watchFiles(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	name string,
+	reload func() error,
+	paths ...string,
+) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		slog.Info("Starting file watcher", "source", name)
+		filewatch.New(paths...).Run(ctx, 10*time.Second, func() {
+			if err := reload(); err != nil {
+				slog.Error("Failed to reload file", "source", name, "error", err)
+				return
+			}
+			slog.Info("Reloaded file", "source", name)
+		})
+		slog.Info("Stopping file watcher", "source", name)
+	}()
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// observingSaver records the local devices of every aggregator entry in the
+// device inventory once the payload is saved. Rejected payloads are not
+// observed.
+type observingSaver struct {
+	stats.Saver
+	devices *inventory.Inventory
+}
+
+func (o observingSaver) Save(ctx context.Context, payload stats.AggregatorPayload) error {
+	if err := o.Saver.Save(ctx, payload); err != nil {
+		return err
+	}
+	seen := time.Unix(payload.LogTimeEnd, 0)
+	for _, entry := range payload.Stats {
+		o.devices.Observe(inventory.Sighting{
+			Mac:       entry.LocalMac,
+			Ip:        entry.LocalIp,
+			Interface: entry.Interface,
+			Time:      seen,
+		})
+	}
+	return nil
+}
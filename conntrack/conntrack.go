@@ -0,0 +1,112 @@
+// Package conntrack terminates connections tracked by the kernel.
+package conntrack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+var (
+	// ErrNotFound is returned when the kernel has no matching entry, usually
+	// because the connection already ended.
+	ErrNotFound = errors.New("conntrack entry not found")
+	// ErrNoTuple is returned when a flow carries no usable address tuple.
+	ErrNoTuple = errors.New("flow has no conntrack tuple")
+	// ErrUnsupportedProtocol is returned for ICMP and ICMPv6 flows: their
+	// tuples need the ICMP id, type and code, which flows do not report.
+	ErrUnsupportedProtocol = errors.New("conntrack tuple not supported for ICMP flows")
+)
+
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+// Tuple identifies one direction of a connection.
+type Tuple struct {
+	Src      netip.Addr
+	Dst      netip.Addr
+	SrcPort  uint16
+	DstPort  uint16
+	Protocol uint8
+}
+
+// Entry selects the conntrack entry to delete. At least one tuple must be
+// set; ID, when not zero, must also match.
+type Entry struct {
+	ID    uint32
+	Orig  *Tuple
+	Reply *Tuple
+}
+
+// Backend deletes conntrack entries.
+type Backend interface {
+	Delete(ctx context.Context, entry Entry) error
+}
+
+// EntryFromFlow builds the entry matching a flow. The reply tuple reported by
+// netifyd is used when available since it reflects NAT; otherwise the
+// original tuple is rebuilt from the flow endpoints.
+func EntryFromFlow(flow flows.FlowComplete) (Entry, error) {
+	entry := Entry{ID: uint32(flow.Conntrack.Id)}
+	protocol := uint8(flow.IpProtocol)
+	if protocol == protocolICMP || protocol == protocolICMPv6 {
+		return Entry{}, ErrUnsupportedProtocol
+	}
+
+	replySrc, srcErr := netip.ParseAddr(flow.Conntrack.ReplySrcIp)
+	replyDst, dstErr := netip.ParseAddr(flow.Conntrack.ReplyDstIp)
+	if srcErr == nil && dstErr == nil {
+		entry.Reply = &Tuple{
+			Src:      replySrc.Unmap(),
+			Dst:      replyDst.Unmap(),
+			SrcPort:  uint16(flow.Conntrack.ReplySrcPort),
+			DstPort:  uint16(flow.Conntrack.ReplyDstPort),
+			Protocol: protocol,
+		}
+		return entry, nil
+	}
+
+	local, localErr := netip.ParseAddr(flow.LocalIp)
+	other, otherErr := netip.ParseAddr(flow.OtherIp)
+	if localErr != nil || otherErr != nil {
+		return Entry{}, ErrNoTuple
+	}
+	orig := &Tuple{
+		Src:      local.Unmap(),
+		Dst:      other.Unmap(),
+		SrcPort:  uint16(flow.LocalPort),
+		DstPort:  uint16(flow.OtherPort),
+		Protocol: protocol,
+	}
+	if !flow.LocalOrigin {
+		orig.Src, orig.Dst = orig.Dst, orig.Src
+		orig.SrcPort, orig.DstPort = orig.DstPort, orig.SrcPort
+	}
+	entry.Orig = orig
+	return entry, nil
+}
+
+// validate rejects entries that would match more than one connection: the
+// kernel flushes the whole table when a delete request carries no tuple.
+func (e Entry) validate() error {
+	tuple := e.Orig
+	if tuple == nil {
+		tuple = e.Reply
+	}
+	if tuple == nil {
+		return ErrNoTuple
+	}
+	if !tuple.Src.IsValid() || !tuple.Dst.IsValid() || tuple.Src.Is4() != tuple.Dst.Is4() {
+		return fmt.Errorf("%w: invalid addresses", ErrNoTuple)
+	}
+	// The kernel rejects ICMP tuples without id, type and code.
+	if tuple.Protocol == protocolICMP || tuple.Protocol == protocolICMPv6 {
+		return ErrUnsupportedProtocol
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+func TestEntryFromFlow(t *testing.T) {
+	t.Run("prefers the reply tuple", func(t *testing.T) {
+		flow := flows.FlowComplete{
+			Conntrack: flows.Conntrack{
+				Id:           163461572,
+				ReplySrcIp:   "203.0.113.88",
+				ReplySrcPort: 443,
+				ReplyDstIp:   "198.51.100.1",
+				ReplyDstPort: 44743,
+			},
+			IpProtocol: 6,
+			LocalIp:    "192.168.1.100",
+			OtherIp:    "203.0.113.88",
+		}
+		entry, err := EntryFromFlow(flow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, uint32(163461572), entry.ID)
+		assert.Equal(t, (*Tuple)(nil), entry.Orig)
+		assert.Equal(t, &Tuple{
+			Src:      netip.MustParseAddr("203.0.113.88"),
+			Dst:      netip.MustParseAddr("198.51.100.1"),
+			SrcPort:  443,
+			DstPort:  44743,
+			Protocol: 6,
+		}, entry.Reply)
+	})
+
+	t.Run("rebuilds the original tuple from the endpoints", func(t *testing.T) {
+		flow := flows.FlowComplete{
+			IpProtocol:  17,
+			LocalIp:     "192.168.1.100",
+			LocalPort:   5353,
+			OtherIp:     "203.0.113.88",
+			OtherPort:   53,
+			LocalOrigin: false,
+		}
+		entry, err := EntryFromFlow(flow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, &Tuple{
+			Src:      netip.MustParseAddr("203.0.113.88"),
+			Dst:      netip.MustParseAddr("192.168.1.100"),
+			SrcPort:  53,
+			DstPort:  5353,
+			Protocol: 17,
+		}, entry.Orig)
+	})
+
+	t.Run("fails without addresses", func(t *testing.T) {
+		_, err := EntryFromFlow(flows.FlowComplete{})
+		if !errors.Is(err, ErrNoTuple) {
+			t.Fatalf("expected ErrNoTuple, got %v", err)
+		}
+	})
+
+	t.Run("refuses ICMP flows", func(t *testing.T) {
+		for _, protocol := range []int{1, 58} {
+			_, err := EntryFromFlow(flows.FlowComplete{
+				IpProtocol: protocol,
+				LocalIp:    "192.168.1.100",
+				OtherIp:    "203.0.113.88",
+			})
+			if !errors.Is(err, ErrUnsupportedProtocol) {
+				t.Fatalf("protocol %d: expected ErrUnsupportedProtocol, got %v", protocol, err)
+			}
+		}
+	})
+}
+
+func TestBuildDeleteMessage(t *testing.T) {
+	t.Run("encodes an IPv4 delete request", func(t *testing.T) {
+		entry := Entry{
+			ID: 42,
+			Reply: &Tuple{
+				Src:      netip.MustParseAddr("203.0.113.88"),
+				Dst:      netip.MustParseAddr("198.51.100.1"),
+				SrcPort:  443,
+				DstPort:  44743,
+				Protocol: 6,
+			},
+		}
+		msg, err := buildDeleteMessage(entry, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, uint32(len(msg)), binary.NativeEndian.Uint32(msg[0:4]))
+		assert.Equal(
+			t,
+			uint16(nfnlSubsysCtnetlink<<8|ipctnlMsgCtDelete),
+			binary.NativeEndian.Uint16(msg[4:6]),
+		)
+		assert.Equal(t, uint16(nlmFRequest|nlmFAck), binary.NativeEndian.Uint16(msg[6:8]))
+		assert.Equal(t, uint32(7), binary.NativeEndian.Uint32(msg[8:12]))
+		assert.Equal(t, byte(2), msg[16]) // AF_INET
+
+		attrs := parseAttrs(t, msg[20:])
+		assert.Equal(t, 2, len(attrs))
+		reply := parseAttrs(t, attrs[ctaTupleReply|nlaFNested])
+		ip := parseAttrs(t, reply[ctaTupleIP|nlaFNested])
+		assert.Equal(t, []byte{203, 0, 113, 88}, ip[ctaIPv4Src])
+		assert.Equal(t, []byte{198, 51, 100, 1}, ip[ctaIPv4Dst])
+		proto := parseAttrs(t, reply[ctaTupleProto|nlaFNested])
+		assert.Equal(t, []byte{6}, proto[ctaProtoNum])
+		assert.Equal(t, []byte{0x01, 0xbb}, proto[ctaProtoSrcPort])
+		assert.Equal(t, []byte{0xae, 0xc7}, proto[ctaProtoDstPort])
+		assert.Equal(t, []byte{0, 0, 0, 42}, attrs[ctaID])
+	})
+
+	t.Run("encodes an IPv6 request without ports", func(t *testing.T) {
+		entry := Entry{
+			Orig: &Tuple{
+				Src:      netip.MustParseAddr("fd00::1"),
+				Dst:      netip.MustParseAddr("2001:db8::1"),
+				Protocol: 47, // GRE
+			},
+		}
+		msg, err := buildDeleteMessage(entry, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, byte(10), msg[16]) // AF_INET6
+		attrs := parseAttrs(t, msg[20:])
+		orig := parseAttrs(t, attrs[ctaTupleOrig|nlaFNested])
+		ip := parseAttrs(t, orig[ctaTupleIP|nlaFNested])
+		assert.Equal(t, 16, len(ip[ctaIPv6Src]))
+		proto := parseAttrs(t, orig[ctaTupleProto|nlaFNested])
+		assert.Equal(t, 1, len(proto))
+	})
+
+	t.Run("refuses requests that would flush the table", func(t *testing.T) {
+		if _, err := buildDeleteMessage(Entry{ID: 42}, 1); !errors.Is(err, ErrNoTuple) {
+			t.Fatalf("expected ErrNoTuple, got %v", err)
+		}
+		mixed := Entry{Orig: &Tuple{
+			Src: netip.MustParseAddr("10.0.0.1"),
+			Dst: netip.MustParseAddr("fd00::1"),
+		}}
+		if _, err := buildDeleteMessage(mixed, 1); !errors.Is(err, ErrNoTuple) {
+			t.Fatalf("expected ErrNoTuple, got %v", err)
+		}
+	})
+
+	t.Run("refuses ICMP tuples", func(t *testing.T) {
+		icmp := Entry{Orig: &Tuple{
+			Src:      netip.MustParseAddr("fd00::1"),
+			Dst:      netip.MustParseAddr("2001:db8::1"),
+			Protocol: 58,
+		}}
+		if _, err := buildDeleteMessage(icmp, 1); !errors.Is(err, ErrUnsupportedProtocol) {
+			t.Fatalf("expected ErrUnsupportedProtocol, got %v", err)
+		}
+	})
+}
+
+func TestParseAck(t *testing.T) {
+	ack := func(seq uint32, code int32) []byte {
+		msg := make([]byte, 36)
+		binary.NativeEndian.PutUint32(msg[0:4], 36)
+		binary.NativeEndian.PutUint16(msg[4:6], nlmsgError)
+		binary.NativeEndian.PutUint32(msg[8:12], seq)
+		binary.NativeEndian.PutUint32(msg[16:20], uint32(code))
+		return msg
+	}
+
+	if err := parseAck(ack(3, 0), 3); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if err := parseAck(ack(3, -2), 3); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := parseAck(ack(3, -1), 3); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected permission error, got %v", err)
+	}
+	if err := parseAck(ack(2, 0), 3); err == nil {
+		t.Fatal("expected error for mismatched sequence")
+	}
+}
+
+// parseAttrs splits a netlink attribute stream by type.
+func parseAttrs(t *testing.T, data []byte) map[uint16][]byte {
+	t.Helper()
+	attrs := make(map[uint16][]byte)
+	for len(data) >= 4 {
+		length := int(binary.NativeEndian.Uint16(data[0:2]))
+		kind := binary.NativeEndian.Uint16(data[2:4])
+		if length < 4 || length > len(data) {
+			t.Fatalf("malformed attribute length %d", length)
+		}
+		attrs[kind] = data[4:length]
+		data = data[min((length+3)&^3, len(data)):]
+	}
+	return attrs
+}
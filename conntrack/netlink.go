@@ -0,0 +1,137 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// ctnetlink message and attribute types, from
+// include/uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	nfnlSubsysCtnetlink = 1
+	ipctnlMsgCtDelete   = 2
+
+	ctaTupleOrig  = 1
+	ctaTupleReply = 2
+	ctaID         = 12
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+
+	nlaFNested = 0x8000
+
+	nlmFRequest = 0x1
+	nlmFAck     = 0x4
+	nlmsgError  = 0x2
+
+	nlmsgHeaderLen = 16
+)
+
+// buildDeleteMessage encodes an IPCTNL_MSG_CT_DELETE request for entry.
+func buildDeleteMessage(entry Entry, seq uint32) ([]byte, error) {
+	if err := entry.validate(); err != nil {
+		return nil, err
+	}
+
+	primary := entry.Orig
+	if primary == nil {
+		primary = entry.Reply
+	}
+	family := byte(syscall.AF_INET)
+	if primary.Src.Is6() {
+		family = syscall.AF_INET6
+	}
+
+	// nfgenmsg: family, version, resource id (big endian).
+	payload := []byte{family, 0, 0, 0}
+	if entry.Orig != nil {
+		payload = appendAttr(payload, ctaTupleOrig|nlaFNested, encodeTuple(*entry.Orig))
+	}
+	if entry.Reply != nil {
+		payload = appendAttr(payload, ctaTupleReply|nlaFNested, encodeTuple(*entry.Reply))
+	}
+	if entry.ID != 0 {
+		payload = appendAttr(payload, ctaID, binary.BigEndian.AppendUint32(nil, entry.ID))
+	}
+
+	msg := make([]byte, nlmsgHeaderLen, nlmsgHeaderLen+len(payload))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(nlmsgHeaderLen+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:6], nfnlSubsysCtnetlink<<8|ipctnlMsgCtDelete)
+	binary.NativeEndian.PutUint16(msg[6:8], nlmFRequest|nlmFAck)
+	binary.NativeEndian.PutUint32(msg[8:12], seq)
+	return append(msg, payload...), nil
+}
+
+func encodeTuple(tuple Tuple) []byte {
+	var ip []byte
+	if tuple.Src.Is4() {
+		src, dst := tuple.Src.As4(), tuple.Dst.As4()
+		ip = appendAttr(ip, ctaIPv4Src, src[:])
+		ip = appendAttr(ip, ctaIPv4Dst, dst[:])
+	} else {
+		src, dst := tuple.Src.As16(), tuple.Dst.As16()
+		ip = appendAttr(ip, ctaIPv6Src, src[:])
+		ip = appendAttr(ip, ctaIPv6Dst, dst[:])
+	}
+
+	proto := appendAttr(nil, ctaProtoNum, []byte{tuple.Protocol})
+	if tuple.SrcPort != 0 || tuple.DstPort != 0 {
+		proto = appendAttr(proto, ctaProtoSrcPort, binary.BigEndian.AppendUint16(nil, tuple.SrcPort))
+		proto = appendAttr(proto, ctaProtoDstPort, binary.BigEndian.AppendUint16(nil, tuple.DstPort))
+	}
+
+	out := appendAttr(nil, ctaTupleIP|nlaFNested, ip)
+	return appendAttr(out, ctaTupleProto|nlaFNested, proto)
+}
+
+// appendAttr appends a netlink attribute, padded to a 4 byte boundary.
+func appendAttr(buf []byte, kind uint16, value []byte) []byte {
+	length := 4 + len(value)
+	buf = binary.NativeEndian.AppendUint16(buf, uint16(length))
+	buf = binary.NativeEndian.AppendUint16(buf, kind)
+	buf = append(buf, value...)
+	for length%4 != 0 {
+		buf = append(buf, 0)
+		length++
+	}
+	return buf
+}
+
+// parseAck extracts the result of a request from a netlink response.
+func parseAck(response []byte, seq uint32) error {
+	for len(response) >= nlmsgHeaderLen {
+		length := int(binary.NativeEndian.Uint32(response[0:4]))
+		if length < nlmsgHeaderLen || length > len(response) {
+			return errors.New("malformed netlink response")
+		}
+		kind := binary.NativeEndian.Uint16(response[4:6])
+		msgSeq := binary.NativeEndian.Uint32(response[8:12])
+		if kind == nlmsgError && msgSeq == seq {
+			if length < nlmsgHeaderLen+4 {
+				return errors.New("truncated netlink error")
+			}
+			code := int32(binary.NativeEndian.Uint32(response[16:20]))
+			switch {
+			case code == 0:
+				return nil
+			case syscall.Errno(-code) == syscall.ENOENT:
+				return ErrNotFound
+			default:
+				return fmt.Errorf("delete conntrack entry: %w", syscall.Errno(-code))
+			}
+		}
+		response = response[(length+3)&^3:]
+	}
+	return errors.New("no acknowledgement in netlink response")
+}
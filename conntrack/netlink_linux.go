@@ -0,0 +1,70 @@
+//go:build linux
+
+package conntrack
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Netlink deletes entries through the kernel ctnetlink interface. It requires
+// CAP_NET_ADMIN.
+type Netlink struct {
+	seq atomic.Uint32
+}
+
+// NewNetlink creates a Netlink backend.
+func NewNetlink() *Netlink {
+	n := &Netlink{}
+	n.seq.Store(uint32(time.Now().Unix()))
+	return n
+}
+
+func (n *Netlink) Delete(ctx context.Context, entry Entry) error {
+	seq := n.seq.Add(1)
+	msg, err := buildDeleteMessage(entry, seq)
+	if err != nil {
+		return err
+	}
+
+	fd, err := syscall.Socket(
+		syscall.AF_NETLINK,
+		syscall.SOCK_RAW|syscall.SOCK_CLOEXEC,
+		syscall.NETLINK_NETFILTER,
+	)
+	if err != nil {
+		return fmt.Errorf("open netlink socket: %w", err)
+	}
+	defer syscall.Close(fd) //nolint:errcheck
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("bind netlink socket: %w", err)
+	}
+
+	timeout := time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return ctx.Err()
+		}
+	}
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("set netlink timeout: %w", err)
+	}
+
+	kernel := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+	if err := syscall.Sendto(fd, msg, 0, kernel); err != nil {
+		return fmt.Errorf("send netlink request: %w", err)
+	}
+
+	buf := make([]byte, syscall.Getpagesize())
+	read, _, err := syscall.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return fmt.Errorf("receive netlink response: %w", err)
+	}
+	return parseAck(buf[:read], seq)
+}
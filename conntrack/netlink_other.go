@@ -0,0 +1,20 @@
+//go:build !linux
+
+package conntrack
+
+import (
+	"context"
+	"errors"
+)
+
+// Netlink is only available on Linux.
+type Netlink struct{}
+
+// NewNetlink creates a Netlink backend.
+func NewNetlink() *Netlink {
+	return &Netlink{}
+}
+
+func (n *Netlink) Delete(_ context.Context, _ Entry) error {
+	return errors.New("conntrack deletion is only supported on linux")
+}
@@ -0,0 +1,356 @@
+// Package flowlog writes one JSON line per finished flow to a file, rotating
+// it by size and age. Rotated files are optionally gzipped and only the most
+// recent ones are kept.
+package flowlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// rotatedTimeFormat is the timestamp added to rotated file names. It sorts
+// lexically in chronological order.
+const rotatedTimeFormat = "20060102T150405.000"
+
+// Writer is a flows.FlowSink appending finished flows to a log file.
+type Writer struct {
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	keep     int
+	compress bool
+	now      func() time.Time
+
+	queue   chan Record
+	dropped atomic.Uint64
+
+	file     *os.File
+	buf      *bufio.Writer
+	size     int64
+	openedAt time.Time
+}
+
+// Option configures optional Writer features.
+type Option func(*Writer)
+
+// WithMaxSize rotates the file once it reaches size bytes (0 disables).
+func WithMaxSize(size int64) Option {
+	return func(w *Writer) {
+		w.maxSize = size
+	}
+}
+
+// WithMaxAge rotates the file once it has been open for age (0 disables).
+func WithMaxAge(age time.Duration) Option {
+	return func(w *Writer) {
+		w.maxAge = age
+	}
+}
+
+// WithRetention keeps only the count most recent rotated files (0 keeps all).
+func WithRetention(count int) Option {
+	return func(w *Writer) {
+		w.keep = count
+	}
+}
+
+// WithCompression gzips rotated files.
+func WithCompression(compress bool) Option {
+	return func(w *Writer) {
+		w.compress = compress
+	}
+}
+
+// New opens (or creates) the log at path in append mode.
+func New(path string, opts ...Option) (*Writer, error) {
+	w := &Writer{
+		path:  path,
+		now:   time.Now,
+		queue: make(chan Record, 4096),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create flow log directory: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("open flow log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("stat flow log: %w", err)
+	}
+	w.file = file
+	w.buf = bufio.NewWriter(file)
+	w.size = info.Size()
+	w.openedAt = w.now()
+	// An existing log keeps its age across restarts.
+	if w.size > 0 {
+		w.openedAt = startedAt(w.path, info)
+	}
+	return nil
+}
+
+// startedAt returns when an existing log was started: the time of its first
+// record, or its modification time when that cannot be read.
+func startedAt(path string, info os.FileInfo) time.Time {
+	file, err := os.Open(path)
+	if err != nil {
+		return info.ModTime()
+	}
+	defer file.Close() //nolint:errcheck
+
+	var first struct {
+		LoggedAt time.Time `json:"logged_at"`
+	}
+	line, _ := bufio.NewReader(file).ReadBytes('\n')
+	if err := json.Unmarshal(line, &first); err != nil || first.LoggedAt.IsZero() {
+		return info.ModTime()
+	}
+	return first.LoggedAt
+}
+
+// FlowFinished queues a flow for writing. Flows are dropped when the queue is
+// full.
+func (w *Writer) FlowFinished(event flows.FlowEvent, reason string) {
+	record, ok := NewRecord(event, reason, w.now())
+	if !ok {
+		return
+	}
+	select {
+	case w.queue <- record:
+	default:
+		if w.dropped.Add(1)%1000 == 1 {
+			slog.Warn("Flow log queue full, dropping flows", "dropped", w.dropped.Load())
+		}
+	}
+}
+
+// Run writes queued records until ctx is cancelled, then drains the queue and
+// closes the file.
+func (w *Writer) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	defer func() {
+		if err := w.Close(); err != nil {
+			slog.Error("Failed to close flow log", "error", err)
+		}
+	}()
+
+	for {
+		select {
+		case record := <-w.queue:
+			w.handle(record)
+			// Flush once the burst is written.
+			if len(w.queue) == 0 {
+				w.flush()
+			}
+		case <-ticker.C:
+			w.flush()
+			if err := w.rotateIfExpired(); err != nil {
+				slog.Error("Failed to rotate flow log", "error", err)
+			}
+		case <-ctx.Done():
+			for {
+				select {
+				case record := <-w.queue:
+					w.handle(record)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *Writer) handle(record Record) {
+	if err := w.write(record); err != nil {
+		slog.Error("Failed to write flow log", "error", err)
+	}
+}
+
+// write appends a record, rotating the file first when it is full.
+func (w *Writer) write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal flow record: %w", err)
+	}
+	line = append(line, '\n')
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(line)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	n, err := w.buf.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+func (w *Writer) flush() {
+	if w.buf == nil {
+		return
+	}
+	if err := w.buf.Flush(); err != nil {
+		slog.Error("Failed to flush flow log", "error", err)
+	}
+}
+
+// rotateIfExpired rotates a non-empty file open for longer than maxAge.
+func (w *Writer) rotateIfExpired() error {
+	if w.maxAge <= 0 || w.size == 0 || w.now().Sub(w.openedAt) < w.maxAge {
+		return nil
+	}
+	return w.rotate()
+}
+
+// rotate renames the current file with a timestamp, compresses it if
+// requested, prunes old files and opens a new one.
+func (w *Writer) rotate() error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(w.path)
+	base := strings.TrimSuffix(w.path, ext)
+	stamp := w.now().UTC().Format(rotatedTimeFormat)
+	rotated := fmt.Sprintf("%s-%s%s", base, stamp, ext)
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s-%s-%d%s", base, stamp, i, ext)
+	}
+	if err := os.Rename(w.path, rotated); err != nil {
+		return fmt.Errorf("rotate flow log: %w", err)
+	}
+	if w.compress {
+		if err := gzipFile(rotated); err != nil {
+			slog.Error("Failed to compress rotated flow log", "path", rotated, "error", err)
+		}
+	}
+	w.prune()
+	return w.open()
+}
+
+// prune removes the oldest rotated files beyond the retention count.
+func (w *Writer) prune() {
+	if w.keep <= 0 {
+		return
+	}
+	dir := filepath.Dir(w.path)
+	ext := filepath.Ext(w.path)
+	base := strings.TrimSuffix(filepath.Base(w.path), ext)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Error("Failed to list rotated flow logs", "dir", dir, "error", err)
+		return
+	}
+	var matches []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isRotated(entry.Name(), base, ext) {
+			matches = append(matches, filepath.Join(dir, entry.Name()))
+		}
+	}
+	// Timestamps sort lexically, oldest first.
+	slices.Sort(matches)
+	for _, old := range matches[:max(len(matches)-w.keep, 0)] {
+		if err := os.Remove(old); err != nil {
+			slog.Error("Failed to remove old flow log", "path", old, "error", err)
+			continue
+		}
+		slog.Debug("Removed old flow log", "path", old)
+	}
+}
+
+// isRotated reports whether name is a rotated log: base, a rotation timestamp
+// with an optional counter, ext and an optional ".gz".
+func isRotated(name, base, ext string) bool {
+	rest, ok := strings.CutPrefix(name, base+"-")
+	if !ok {
+		return false
+	}
+	rest, ok = strings.CutSuffix(strings.TrimSuffix(rest, ".gz"), ext)
+	if !ok {
+		return false
+	}
+	stamp, counter, found := strings.Cut(rest, "-")
+	if found && (counter == "" || strings.Trim(counter, "0123456789") != "") {
+		return false
+	}
+	_, err := time.Parse(rotatedTimeFormat, stamp)
+	return err == nil
+}
+
+// Close flushes and closes the current file.
+func (w *Writer) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	flushErr := w.buf.Flush()
+	closeErr := w.file.Close()
+	w.file, w.buf, w.size = nil, nil, 0
+	if flushErr != nil {
+		return fmt.Errorf("flush flow log: %w", flushErr)
+	}
+	return closeErr
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// gzipFile replaces path with path.gz.
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close() //nolint:errcheck
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
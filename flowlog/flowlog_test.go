@@ -0,0 +1,266 @@
+package flowlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+func finishedFlow(digest string) flows.FlowEvent {
+	flow := flows.FlowComplete{
+		FlowBase:                flows.FlowBase{Digest: digest},
+		DetectedApplicationName: "netify.youtube",
+		DetectedProtocolName:    "QUIC",
+		FirstSeenAt:             1760000000000,
+		LastSeenAt:              1760000060500,
+		IpProtocol:              17,
+		IpVersion:               4,
+		LocalIp:                 "192.168.1.10",
+		LocalOrigin:             true,
+		OtherIp:                 "142.250.80.46",
+		OtherPort:               443,
+		Ssl:                     &flows.Ssl{ClientSni: "www.youtube.com"},
+		Stats:                   flows.Stats{TotalBytes: 10000, TotalPackets: 20},
+	}
+	return flows.FlowEvent{
+		Type:      flows.FlowTypeDpiComplete,
+		Interface: "br-lan",
+		Reason:    "closed",
+		Flow:      flow,
+	}
+}
+
+func readLines(t *testing.T, path string) []Record {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	var scanner *bufio.Scanner
+	if filepath.Ext(path) == ".gz" {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner = bufio.NewScanner(zr)
+	} else {
+		scanner = bufio.NewScanner(file)
+	}
+	var records []Record
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestNewRecord(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	record, ok := NewRecord(finishedFlow("f-001"), flows.FinishPurged, now)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, SchemaVersion, record.SchemaVersion)
+	assert.Equal(t, now, record.LoggedAt)
+	assert.Equal(t, "purged", record.FinishReason)
+	assert.Equal(t, "closed", record.PurgeReason)
+	assert.Equal(t, "br-lan", record.Interface)
+	assert.Equal(t, int64(60500), record.DurationMs)
+	assert.Equal(t, time.UnixMilli(1760000000000).UTC(), record.FirstSeenAt)
+	assert.Equal(t, "www.youtube.com", record.ClientSni)
+
+	_, ok = NewRecord(flows.FlowEvent{Flow: flows.FlowPurge{}}, flows.FinishPurged, now)
+	assert.Equal(t, false, ok)
+}
+
+func TestWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "flows.jsonl")
+	clock := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	w, err := New(
+		path,
+		WithMaxSize(1500),
+		WithMaxAge(time.Hour),
+		WithRetention(2),
+		WithCompression(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = func() time.Time { return clock }
+
+	write := func(digest string) {
+		t.Helper()
+		record, _ := NewRecord(finishedFlow(digest), flows.FinishExpired, clock)
+		if err := w.write(record); err != nil {
+			t.Fatal(err)
+		}
+		clock = clock.Add(time.Second)
+	}
+
+	t.Run("rotates by size", func(t *testing.T) {
+		// Each line is about 660 bytes: the third one starts a new file.
+		for _, digest := range []string{"f-001", "f-002", "f-003"} {
+			write(digest)
+		}
+		w.flush()
+
+		rotated, _ := filepath.Glob(filepath.Join(dir, "flows-*.jsonl.gz"))
+		assert.Equal(t, 1, len(rotated))
+		records := readLines(t, rotated[0])
+		assert.Equal(t, 2, len(records))
+		assert.Equal(t, "f-001", records[0].Digest)
+		assert.Equal(t, "f-003", readLines(t, path)[0].Digest)
+	})
+
+	t.Run("rotates by age", func(t *testing.T) {
+		if err := w.rotateIfExpired(); err != nil {
+			t.Fatal(err)
+		}
+		rotated, _ := filepath.Glob(filepath.Join(dir, "flows-*.jsonl.gz"))
+		assert.Equal(t, 1, len(rotated))
+
+		clock = clock.Add(time.Hour)
+		if err := w.rotateIfExpired(); err != nil {
+			t.Fatal(err)
+		}
+		rotated, _ = filepath.Glob(filepath.Join(dir, "flows-*.jsonl.gz"))
+		assert.Equal(t, 2, len(rotated))
+		assert.Equal(t, 0, len(readLines(t, path)))
+
+		// An empty file is never rotated.
+		clock = clock.Add(time.Hour)
+		if err := w.rotateIfExpired(); err != nil {
+			t.Fatal(err)
+		}
+		rotated, _ = filepath.Glob(filepath.Join(dir, "flows-*.jsonl.gz"))
+		assert.Equal(t, 2, len(rotated))
+	})
+
+	t.Run("keeps the most recent files", func(t *testing.T) {
+		for _, digest := range []string{"f-004", "f-005", "f-006"} {
+			write(digest)
+		}
+		w.flush()
+
+		rotated, _ := filepath.Glob(filepath.Join(dir, "flows-*.jsonl.gz"))
+		sort.Strings(rotated)
+		assert.Equal(t, 2, len(rotated))
+		assert.Equal(t, "f-003", readLines(t, rotated[0])[0].Digest)
+		assert.Equal(t, "f-004", readLines(t, rotated[1])[0].Digest)
+	})
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriterRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flows.jsonl")
+	if err := os.WriteFile(path, []byte(`{"schema_version":1,"digest":"old"}`+"\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	w.FlowFinished(finishedFlow("f-001"), flows.FinishPurged)
+	w.FlowFinished(flows.FlowEvent{Flow: flows.FlowStats{}}, flows.FinishExpired)
+	cancel()
+	<-done
+
+	records := readLines(t, path)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "old", records[0].Digest)
+	assert.Equal(t, "f-001", records[1].Digest)
+}
+
+func TestWriterPruneIgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "flows.jsonl")
+	names := []string{
+		"flows-20261018T100000.000.jsonl.gz",
+		"flows-20261018T110000.000.jsonl",
+		"flows-20261018T110000.000-1.jsonl.gz",
+		"flows-20261018T120000.000.jsonl.gz",
+		// Not rotated by the writer.
+		"flows-backup.jsonl",
+		"flows-20261018T090000.000.jsonl.bak",
+		"flows-archive-2025.jsonl.gz",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := New(path, WithRetention(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close() //nolint:errcheck
+
+	w.prune()
+	var left []string
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	assert.Equal(t, []string{
+		"flows-20261018T090000.000.jsonl.bak",
+		"flows-20261018T110000.000.jsonl",
+		"flows-20261018T120000.000.jsonl.gz",
+		"flows-archive-2025.jsonl.gz",
+		"flows-backup.jsonl",
+		"flows.jsonl",
+	}, left)
+}
+
+func TestWriterKeepsAgeOnReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flows.jsonl")
+	started := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	record, _ := NewRecord(finishedFlow("f-001"), flows.FinishExpired, started)
+	line, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(line, '\n'), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := New(path, WithMaxAge(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close() //nolint:errcheck
+	assert.Equal(t, started, w.openedAt.UTC())
+
+	w.now = func() time.Time { return started.Add(time.Hour) }
+	if err := w.rotateIfExpired(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "flows-*.jsonl"))
+	assert.Equal(t, 1, len(rotated))
+}
@@ -0,0 +1,98 @@
+package flowlog
+
+import (
+	"time"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// SchemaVersion is written in every record. It changes only when a field is
+// renamed, removed or changes meaning; new fields may be added within a
+// version.
+const SchemaVersion = 1
+
+// Record is one line of the flow log.
+type Record struct {
+	SchemaVersion  int       `json:"schema_version"`
+	LoggedAt       time.Time `json:"logged_at"`
+	Digest         string    `json:"digest"`
+	FinishReason   string    `json:"finish_reason"`
+	PurgeReason    string    `json:"purge_reason,omitempty"`
+	Interface      string    `json:"interface,omitempty"`
+	Internal       bool      `json:"internal"`
+	FirstSeenAt    time.Time `json:"first_seen_at"`
+	LastSeenAt     time.Time `json:"last_seen_at"`
+	DurationMs     int64     `json:"duration_ms"`
+	IpVersion      int       `json:"ip_version"`
+	IpProtocol     int       `json:"ip_protocol"`
+	VlanId         int       `json:"vlan_id"`
+	LocalIp        string    `json:"local_ip"`
+	LocalMac       string    `json:"local_mac"`
+	LocalPort      int       `json:"local_port"`
+	LocalOrigin    bool      `json:"local_origin"`
+	OtherIp        string    `json:"other_ip"`
+	OtherMac       string    `json:"other_mac"`
+	OtherPort      int       `json:"other_port"`
+	OtherType      string    `json:"other_type"`
+	ApplicationId  int       `json:"application_id"`
+	Application    string    `json:"application"`
+	ProtocolId     int       `json:"protocol_id"`
+	Protocol       string    `json:"protocol"`
+	HostServerName string    `json:"host_server_name,omitempty"`
+	DnsHostName    string    `json:"dns_host_name,omitempty"`
+	ClientSni      string    `json:"client_sni,omitempty"`
+	LocalBytes     int64     `json:"local_bytes"`
+	OtherBytes     int64     `json:"other_bytes"`
+	TotalBytes     int64     `json:"total_bytes"`
+	TotalPackets   int       `json:"total_packets"`
+	RiskScore      int       `json:"risk_score"`
+	Risks          []int     `json:"risks,omitempty"`
+}
+
+// NewRecord converts a finished flow. It returns false for events that do not
+// carry a completed flow.
+func NewRecord(event flows.FlowEvent, reason string, now time.Time) (Record, bool) {
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok {
+		return Record{}, false
+	}
+	record := Record{
+		SchemaVersion:  SchemaVersion,
+		LoggedAt:       now.UTC(),
+		Digest:         flow.Digest,
+		FinishReason:   reason,
+		PurgeReason:    event.Reason,
+		Interface:      event.Interface,
+		Internal:       event.Internal,
+		FirstSeenAt:    time.UnixMilli(flow.FirstSeenAt).UTC(),
+		LastSeenAt:     time.UnixMilli(flow.LastSeenAt).UTC(),
+		DurationMs:     max(flow.LastSeenAt-flow.FirstSeenAt, 0),
+		IpVersion:      flow.IpVersion,
+		IpProtocol:     flow.IpProtocol,
+		VlanId:         flow.VlanId,
+		LocalIp:        flow.LocalIp,
+		LocalMac:       flow.LocalMac,
+		LocalPort:      flow.LocalPort,
+		LocalOrigin:    flow.LocalOrigin,
+		OtherIp:        flow.OtherIp,
+		OtherMac:       flow.OtherMac,
+		OtherPort:      flow.OtherPort,
+		OtherType:      flow.OtherType,
+		ApplicationId:  flow.DetectedApplication,
+		Application:    flow.DetectedApplicationName,
+		ProtocolId:     flow.DetectedProtocol,
+		Protocol:       flow.DetectedProtocolName,
+		HostServerName: flow.HostServerName,
+		DnsHostName:    flow.DnsHostName,
+		LocalBytes:     flow.LocalBytes,
+		OtherBytes:     flow.OtherBytes,
+		TotalBytes:     flow.TotalBytes,
+		TotalPackets:   flow.TotalPackets,
+		RiskScore:      flow.Risks.NdpiRiskScore,
+		Risks:          flow.Risks.Risks,
+	}
+	if flow.Ssl != nil {
+		record.ClientSni = flow.Ssl.ClientSni
+	}
+	return record, true
+}
@@ -0,0 +1,102 @@
+package flows
+
+// Enrichment holds data attached to a flow by analyzers when it is ingested,
+// and by local lookups when it is served through the API. It is never sent by
+// netifyd.
+type Enrichment struct {
+	LocalHost *HostInfo     `json:"local_host,omitempty"`
+	OtherHost *HostInfo     `json:"other_host,omitempty"`
+	OtherGeo  *GeoInfo      `json:"other_geo,omitempty"`
+	OtherAsn  *AsnInfo      `json:"other_asn,omitempty"`
+	Ja4       *Ja4Info      `json:"ja4,omitempty"`
+	Threats   []ThreatMatch `json:"threats,omitempty"`
+	// Application and Protocol are the catalogue names of the detected
+	// application and protocol IDs.
+	Application string        `json:"application,omitempty"`
+	Protocol    string        `json:"protocol,omitempty"`
+	Categories  *CategoryInfo `json:"categories,omitempty"`
+	// HostnameMismatch is set when the names of the flow belong to different
+	// domains.
+	HostnameMismatch *HostnameMismatch `json:"hostname_mismatch,omitempty"`
+}
+
+// HostInfo describes a local endpoint known to the DHCP server.
+type HostInfo struct {
+	Hostname string `json:"hostname,omitempty"`
+	Mac      string `json:"mac,omitempty"`
+}
+
+// GeoInfo is the location of a remote endpoint according to the GeoIP
+// database.
+type GeoInfo struct {
+	Country     string `json:"country,omitempty"`
+	CountryName string `json:"country_name,omitempty"`
+	City        string `json:"city,omitempty"`
+}
+
+// AsnInfo is the autonomous system announcing a remote endpoint.
+type AsnInfo struct {
+	Number       uint32 `json:"number"`
+	Organization string `json:"organization,omitempty"`
+}
+
+// Ja4Info is the client identity matching the JA4 fingerprint of a TLS flow.
+// Verdict is "known", "unknown" (missing from the database) or "malicious".
+type Ja4Info struct {
+	Fingerprint string `json:"fingerprint"`
+	Client      string `json:"client,omitempty"`
+	Category    string `json:"category,omitempty"`
+	Verdict     string `json:"verdict"`
+}
+
+// CategoryInfo names the Netify categories of a flow, such as
+// "streaming-media" or "social-networking".
+type CategoryInfo struct {
+	Application  string `json:"application,omitempty"`
+	Protocol     string `json:"protocol,omitempty"`
+	Domain       string `json:"domain,omitempty"`
+	LocalNetwork string `json:"local_network,omitempty"`
+	OtherNetwork string `json:"other_network,omitempty"`
+	Overlay      string `json:"overlay,omitempty"`
+}
+
+// Has reports whether name is one of the categories.
+func (c *CategoryInfo) Has(name string) bool {
+	return c != nil && name != "" && (c.Application == name || c.Protocol == name ||
+		c.Domain == name || c.LocalNetwork == name || c.OtherNetwork == name ||
+		c.Overlay == name)
+}
+
+// ThreatMatch records a flow field found in a threat-intelligence feed.
+type ThreatMatch struct {
+	Feed      string `json:"feed"`
+	Field     string `json:"field"`
+	Indicator string `json:"indicator"`
+}
+
+// HostnameMismatch lists the names of a flow that were compared, such as the
+// TLS SNI and the DNS name, with the registered domain of each.
+type HostnameMismatch struct {
+	Names []HostnameField `json:"names"`
+}
+
+// HostnameField is a name found in a flow field.
+type HostnameField struct {
+	Field  string `json:"field"`
+	Name   string `json:"name"`
+	Domain string `json:"domain"`
+}
+
+// Enricher adds information to a flow event before it is returned to clients.
+// Implementations must only touch the event's Enrichment.
+type Enricher interface {
+	Enrich(event *FlowEvent)
+}
+
+// EnsureEnrichment returns the event's Enrichment, allocating it if needed.
+func (f *FlowEvent) EnsureEnrichment() *Enrichment {
+	if f.Enrichment == nil {
+		f.Enrichment = &Enrichment{}
+	}
+	return f.Enrichment
+}
@@ -15,11 +15,12 @@ const (
 var ErrUnsupportedFlowType = errors.New("unsupported flow type")
 
 type FlowEvent struct {
-	Type      string `json:"type"`
-	Interface string `json:"interface,omitempty"`
-	Internal  bool   `json:"internal,omitempty"`
-	Reason    string `json:"reason,omitempty"`
-	Flow      any    `json:"flow"`
+	Type       string      `json:"type"`
+	Interface  string      `json:"interface,omitempty"`
+	Internal   bool        `json:"internal,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+	Flow       any         `json:"flow"`
+	Enrichment *Enrichment `json:"enrichment,omitempty"`
 }
 
 type Conntrack struct {
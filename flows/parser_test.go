@@ -457,3 +457,15 @@ func TestParsingFlowErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestParsingFlowIgnoresEnrichment(t *testing.T) {
+	input := `{"type": "flow_purge", "flow": {"digest": "abc"},
+		"enrichment": {"local_host": {"hostname": "spoofed"}}}`
+	var event FlowEvent
+	if err := json.Unmarshal([]byte(input), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Enrichment != nil {
+		t.Fatalf("expected enrichment from the wire to be ignored, got %+v", event.Enrichment)
+	}
+}
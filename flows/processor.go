@@ -6,9 +6,41 @@ import (
 	"time"
 )
 
+// Reasons passed to FlowSink when a flow finishes.
+const (
+	// FinishPurged means netifyd sent a flow_purge event for the flow.
+	FinishPurged = "purged"
+	// FinishExpired means the flow stopped receiving updates and was dropped
+	// by PurgeFlowsOlderThan without a flow_purge event.
+	FinishExpired = "expired"
+)
+
+// FlowSink receives the final state of every flow once it finishes. Sinks are
+// called synchronously outside the processor lock and must not block.
+type FlowSink interface {
+	FlowFinished(event FlowEvent, reason string)
+}
+
+// FlowAnalyzer inspects every completed flow once, when it is ingested.
+// Results attached to the event's Enrichment are stored with the flow.
+// Analyzers are called synchronously outside the processor lock and must not
+// block.
+type FlowAnalyzer interface {
+	Analyze(event *FlowEvent)
+}
+
 type FlowProcessor struct {
 	eventMap map[string]FlowEvent
-	mu       sync.RWMutex
+	// purged holds the digests already reported to sinks by a flow_purge
+	// event, so that their later expiry is not reported again.
+	purged    map[string]struct{}
+	sinks     []FlowSink
+	analyzers []FlowAnalyzer
+	// ifaceBytes and vlanBytes accumulate flow_stats deltas since started.
+	ifaceBytes map[string]*byteCounter
+	vlanBytes  map[int]*byteCounter
+	started    time.Time
+	mu         sync.RWMutex
 }
 
 type FlowAccessor interface {
@@ -19,39 +51,85 @@ type FlowIngestor interface {
 	Process(event FlowEvent)
 }
 
-func NewFlowProcessor() *FlowProcessor {
-	return &FlowProcessor{
-		eventMap: make(map[string]FlowEvent),
+// ProcessorOption configures optional FlowProcessor features.
+type ProcessorOption func(*FlowProcessor)
+
+// WithSinks reports finished flows to sinks.
+func WithSinks(sinks ...FlowSink) ProcessorOption {
+	return func(fp *FlowProcessor) {
+		fp.sinks = append(fp.sinks, sinks...)
+	}
+}
+
+// WithAnalyzers runs analyzers on every completed flow as it is ingested.
+func WithAnalyzers(analyzers ...FlowAnalyzer) ProcessorOption {
+	return func(fp *FlowProcessor) {
+		fp.analyzers = append(fp.analyzers, analyzers...)
+	}
+}
+
+func NewFlowProcessor(opts ...ProcessorOption) *FlowProcessor {
+	fp := &FlowProcessor{
+		eventMap:   make(map[string]FlowEvent),
+		purged:     make(map[string]struct{}),
+		ifaceBytes: make(map[string]*byteCounter),
+		vlanBytes:  make(map[int]*byteCounter),
+		started:    time.Now(),
+	}
+	for _, opt := range opts {
+		opt(fp)
 	}
+	return fp
 }
 
 func (fp *FlowProcessor) Process(event FlowEvent) {
+	if _, ok := event.Flow.(FlowComplete); ok {
+		for _, analyzer := range fp.analyzers {
+			analyzer.Analyze(&event)
+		}
+	}
+	if finished, ok := fp.process(event); ok {
+		fp.notify(FinishPurged, finished)
+	}
+}
+
+// process applies event to the store. It returns the final state of the flow
+// when event is a flow_purge for a known flow.
+func (fp *FlowProcessor) process(event FlowEvent) (FlowEvent, bool) {
 	fp.mu.Lock()
 	defer fp.mu.Unlock()
 	switch f := event.Flow.(type) {
 	case FlowComplete:
 		slog.Debug("Flow complete", "digest", f.Digest)
 		fp.eventMap[f.Digest] = event
+		delete(fp.purged, f.Digest)
 	case FlowPurge:
 		slog.Debug("Flow purge", "digest", f.Digest)
 		flow, ok := fp.eventMap[f.Digest]
 		if !ok {
 			slog.Debug("Flow purge received for unknown flow", "digest", f.Digest)
-			return
+			return FlowEvent{}, false
 		}
 		switch toUpdateFlow := flow.Flow.(type) {
 		case FlowComplete:
 			toUpdateFlow.TotalBytes = f.TotalBytes
 			toUpdateFlow.TotalPackets = f.TotalPackets
+			toUpdateFlow.Tcp = toUpdateFlow.Tcp.merge(f.Tcp)
 			flow.Flow = toUpdateFlow
 			fp.eventMap[f.Digest] = flow
+			if _, done := fp.purged[f.Digest]; done {
+				return FlowEvent{}, false
+			}
+			fp.purged[f.Digest] = struct{}{}
+			flow.Reason = event.Reason
+			return flow, true
 		}
 	case FlowStats:
 		slog.Debug("Flow stats received", "type", event.Type, "digest", f.Digest)
 		flow, ok := fp.eventMap[f.Digest]
 		if !ok {
 			slog.Debug("Flow stats received for unknown flow", "digest", f.Digest)
-			return
+			return FlowEvent{}, false
 		}
 		switch toUpdateFlow := flow.Flow.(type) {
 		case FlowComplete:
@@ -64,12 +142,24 @@ func (fp *FlowProcessor) Process(event FlowEvent) {
 			toUpdateFlow.OtherRate = f.OtherRate
 			toUpdateFlow.TotalPackets = f.TotalPackets
 			toUpdateFlow.TotalBytes = f.TotalBytes
+			toUpdateFlow.Tcp = toUpdateFlow.Tcp.merge(f.Tcp)
 			flow.Flow = toUpdateFlow
 			fp.eventMap[f.Digest] = flow
+			fp.countTraffic(flow, toUpdateFlow, f.Stats)
 		}
 	default:
 		slog.Debug("Unknown flow event type", "type", event.Type)
 	}
+	return FlowEvent{}, false
+}
+
+// notify reports finished flows to all sinks.
+func (fp *FlowProcessor) notify(reason string, events ...FlowEvent) {
+	for _, event := range events {
+		for _, sink := range fp.sinks {
+			sink.FlowFinished(event, reason)
+		}
+	}
 }
 
 func (fp *FlowProcessor) GetEvents() map[string]FlowEvent {
@@ -83,6 +173,12 @@ func (fp *FlowProcessor) GetEvents() map[string]FlowEvent {
 }
 
 func (fp *FlowProcessor) PurgeFlowsOlderThan(olderThan time.Duration) {
+	fp.notify(FinishExpired, fp.purgeOlderThan(olderThan)...)
+}
+
+// purgeOlderThan drops stale flows and returns those not yet reported to the
+// sinks.
+func (fp *FlowProcessor) purgeOlderThan(olderThan time.Duration) []FlowEvent {
 	fp.mu.Lock()
 	defer fp.mu.Unlock()
 
@@ -97,7 +193,13 @@ func (fp *FlowProcessor) PurgeFlowsOlderThan(olderThan time.Duration) {
 		}
 	}
 	slog.Debug("Purging flows", "count", len(digests))
+	var expired []FlowEvent
 	for _, d := range digests {
+		if _, done := fp.purged[d]; !done && len(fp.sinks) > 0 {
+			expired = append(expired, fp.eventMap[d])
+		}
 		delete(fp.eventMap, d)
+		delete(fp.purged, d)
 	}
+	return expired
 }
@@ -6,6 +6,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/go-playground/assert/v2"
 )
 
 func randomDigest(t *testing.T) string {
@@ -368,3 +370,58 @@ func TestFlowsProcessor(t *testing.T) {
 		wg.Wait()
 	})
 }
+
+type finishedFlow struct {
+	digest string
+	reason string
+	bytes  int64
+	purge  string
+}
+
+type recordingSink struct {
+	finished []finishedFlow
+}
+
+func (r *recordingSink) FlowFinished(event FlowEvent, reason string) {
+	flow := event.Flow.(FlowComplete)
+	r.finished = append(r.finished, finishedFlow{
+		digest: flow.Digest,
+		reason: reason,
+		bytes:  flow.TotalBytes,
+		purge:  event.Reason,
+	})
+}
+
+func TestFlowsProcessorSinks(t *testing.T) {
+	sink := &recordingSink{}
+	processor := NewFlowProcessor(WithSinks(sink))
+	old := time.Now().Add(-time.Hour).UnixMilli()
+
+	for _, digest := range []string{"closed", "idle"} {
+		processor.Process(FlowEvent{
+			Type: FlowTypeDpiComplete,
+			Flow: FlowComplete{FlowBase: FlowBase{Digest: digest}, LastSeenAt: old},
+		})
+	}
+	purge := FlowEvent{
+		Type:   FlowTypePurge,
+		Reason: "closed",
+		Flow:   FlowPurge{FlowBase: FlowBase{Digest: "closed"}, Stats: Stats{TotalBytes: 4096}},
+	}
+	processor.Process(purge)
+	// A repeated purge must not report the flow twice.
+	processor.Process(purge)
+	// Unknown flows are ignored.
+	processor.Process(FlowEvent{Type: FlowTypePurge, Flow: FlowPurge{FlowBase: FlowBase{Digest: "x"}}})
+
+	assert.Equal(t, []finishedFlow{
+		{digest: "closed", reason: FinishPurged, bytes: 4096, purge: "closed"},
+	}, sink.finished)
+
+	processor.PurgeFlowsOlderThan(time.Minute)
+	assert.Equal(t, []finishedFlow{
+		{digest: "closed", reason: FinishPurged, bytes: 4096, purge: "closed"},
+		{digest: "idle", reason: FinishExpired},
+	}, sink.finished)
+	assert.Equal(t, 0, len(processor.GetEvents()))
+}
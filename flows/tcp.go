@@ -0,0 +1,19 @@
+package flows
+
+// merge returns the counters of t updated with those of a later event.
+// netifyd reports TCP counters cumulatively over the flow lifetime, so the
+// highest value of each counter is kept; a nil update leaves t unchanged. The
+// result is a new value because stored flows are shared with GetEvents
+// callers.
+func (t *Tcp) merge(update *Tcp) *Tcp {
+	if update == nil {
+		return t
+	}
+	merged := *update
+	if t != nil {
+		merged.Resets = max(t.Resets, update.Resets)
+		merged.Retrans = max(t.Retrans, update.Retrans)
+		merged.SeqErrors = max(t.SeqErrors, update.SeqErrors)
+	}
+	return &merged
+}
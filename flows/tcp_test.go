@@ -0,0 +1,53 @@
+package flows
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestFlowsProcessorTcp(t *testing.T) {
+	processor := NewFlowProcessor()
+	processor.Process(FlowEvent{
+		Type: FlowTypeDpiComplete,
+		Flow: FlowComplete{
+			FlowBase: FlowBase{Digest: "tcp"},
+			Tcp:      &Tcp{Retrans: 1},
+			Stats:    Stats{TotalPackets: 10},
+		},
+	})
+	stored := processor.GetEvents()["tcp"].Flow.(FlowComplete)
+
+	updates := []FlowEvent{
+		{
+			Type: FlowTypeStats,
+			Flow: FlowStats{
+				FlowBase: FlowBase{Digest: "tcp"},
+				Tcp:      &Tcp{Resets: 1, Retrans: 5, SeqErrors: 2},
+				Stats:    Stats{TotalPackets: 100},
+			},
+		},
+		// Events without a tcp block keep the counters.
+		{
+			Type: FlowTypeStats,
+			Flow: FlowStats{FlowBase: FlowBase{Digest: "tcp"}, Stats: Stats{TotalPackets: 150}},
+		},
+		// Counters never move backwards.
+		{
+			Type: FlowTypePurge,
+			Flow: FlowPurge{
+				FlowBase: FlowBase{Digest: "tcp"},
+				Tcp:      &Tcp{Resets: 2, Retrans: 4, SeqErrors: 2},
+				Stats:    Stats{TotalPackets: 200},
+			},
+		},
+	}
+	for _, update := range updates {
+		processor.Process(update)
+	}
+
+	flow := processor.GetEvents()["tcp"].Flow.(FlowComplete)
+	assert.Equal(t, &Tcp{Resets: 2, Retrans: 5, SeqErrors: 2}, flow.Tcp)
+	// Copies returned earlier are not modified.
+	assert.Equal(t, &Tcp{Retrans: 1}, stored.Tcp)
+}
@@ -0,0 +1,156 @@
+package flows
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// TrafficAccessor reports live traffic totals.
+type TrafficAccessor interface {
+	Traffic() Traffic
+}
+
+// Traffic holds the live totals of every interface and VLAN seen since the
+// processor started.
+type Traffic struct {
+	Since      time.Time          `json:"since"`
+	Interfaces []InterfaceTraffic `json:"interfaces"`
+	Vlans      []VlanTraffic      `json:"vlans"`
+}
+
+// TrafficTotals describes the load of an interface or VLAN. Rates are the sum
+// of the latest rates of the active flows, in bytes per second; bytes are the
+// flow_stats deltas accumulated since the processor started. Upload is
+// traffic sent by the local endpoint.
+type TrafficTotals struct {
+	ActiveFlows   int     `json:"active_flows"`
+	UploadRate    float64 `json:"upload_rate"`
+	DownloadRate  float64 `json:"download_rate"`
+	UploadBytes   int64   `json:"upload_bytes"`
+	DownloadBytes int64   `json:"download_bytes"`
+}
+
+type InterfaceTraffic struct {
+	Interface string `json:"interface"`
+	TrafficTotals
+}
+
+// VlanTraffic holds the totals of a VLAN; VlanId 0 is untagged traffic.
+type VlanTraffic struct {
+	VlanId int `json:"vlan_id"`
+	TrafficTotals
+}
+
+// byteCounter accumulates flow_stats deltas.
+type byteCounter struct {
+	upload   int64
+	download int64
+}
+
+// trafficInterfaces returns the interfaces a flow crosses: the input and
+// output interfaces when netifyd runs on a netfilter queue, otherwise the
+// capture interface.
+func trafficInterfaces(event FlowEvent, flow FlowComplete) []string {
+	var ifaces []string
+	if flow.Nfq != nil {
+		for _, iface := range []string{flow.Nfq.SrcIface, flow.Nfq.DstIface} {
+			if iface != "" && !slices.Contains(ifaces, iface) {
+				ifaces = append(ifaces, iface)
+			}
+		}
+	}
+	if len(ifaces) == 0 && event.Interface != "" {
+		ifaces = append(ifaces, event.Interface)
+	}
+	return ifaces
+}
+
+// countTraffic adds the deltas of a flow_stats event to the counters of the
+// interfaces and VLAN of flow. It must be called with the lock held.
+func (fp *FlowProcessor) countTraffic(event FlowEvent, flow FlowComplete, stats Stats) {
+	for _, iface := range trafficInterfaces(event, flow) {
+		counter, ok := fp.ifaceBytes[iface]
+		if !ok {
+			counter = &byteCounter{}
+			fp.ifaceBytes[iface] = counter
+		}
+		counter.upload += stats.LocalBytes
+		counter.download += stats.OtherBytes
+	}
+	counter, ok := fp.vlanBytes[flow.VlanId]
+	if !ok {
+		counter = &byteCounter{}
+		fp.vlanBytes[flow.VlanId] = counter
+	}
+	counter.upload += stats.LocalBytes
+	counter.download += stats.OtherBytes
+}
+
+// Traffic returns the totals of every interface and VLAN, sorted by name and
+// ID. Flows already purged by netifyd are not counted as active.
+func (fp *FlowProcessor) Traffic() Traffic {
+	fp.mu.RLock()
+	defer fp.mu.RUnlock()
+
+	ifaces := make(map[string]*TrafficTotals)
+	vlans := make(map[int]*TrafficTotals)
+	for iface, counter := range fp.ifaceBytes {
+		ifaces[iface] = &TrafficTotals{UploadBytes: counter.upload, DownloadBytes: counter.download}
+	}
+	for vlan, counter := range fp.vlanBytes {
+		vlans[vlan] = &TrafficTotals{UploadBytes: counter.upload, DownloadBytes: counter.download}
+	}
+
+	for digest, event := range fp.eventMap {
+		flow, ok := event.Flow.(FlowComplete)
+		if !ok {
+			continue
+		}
+		if _, done := fp.purged[digest]; done {
+			continue
+		}
+		for _, iface := range trafficInterfaces(event, flow) {
+			totals, ok := ifaces[iface]
+			if !ok {
+				totals = &TrafficTotals{}
+				ifaces[iface] = totals
+			}
+			totals.addFlow(flow)
+		}
+		totals, ok := vlans[flow.VlanId]
+		if !ok {
+			totals = &TrafficTotals{}
+			vlans[flow.VlanId] = totals
+		}
+		totals.addFlow(flow)
+	}
+
+	traffic := Traffic{
+		Since:      fp.started,
+		Interfaces: make([]InterfaceTraffic, 0, len(ifaces)),
+		Vlans:      make([]VlanTraffic, 0, len(vlans)),
+	}
+	for iface, totals := range ifaces {
+		traffic.Interfaces = append(
+			traffic.Interfaces,
+			InterfaceTraffic{Interface: iface, TrafficTotals: *totals},
+		)
+	}
+	for vlan, totals := range vlans {
+		traffic.Vlans = append(traffic.Vlans, VlanTraffic{VlanId: vlan, TrafficTotals: *totals})
+	}
+	slices.SortFunc(traffic.Interfaces, func(a, b InterfaceTraffic) int {
+		return strings.Compare(a.Interface, b.Interface)
+	})
+	slices.SortFunc(traffic.Vlans, func(a, b VlanTraffic) int {
+		return a.VlanId - b.VlanId
+	})
+	return traffic
+}
+
+func (t *TrafficTotals) addFlow(flow FlowComplete) {
+	t.ActiveFlows++
+	t.UploadRate += flow.LocalRate
+	t.DownloadRate += flow.OtherRate
+}
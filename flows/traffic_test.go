@@ -0,0 +1,99 @@
+package flows
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestFlowsProcessorTraffic(t *testing.T) {
+	processor := NewFlowProcessor()
+
+	processor.Process(FlowEvent{
+		Type:      FlowTypeDpiComplete,
+		Interface: "br-lan",
+		Flow:      FlowComplete{FlowBase: FlowBase{Digest: "lan"}},
+	})
+	processor.Process(FlowEvent{
+		Type: FlowTypeDpiComplete,
+		Flow: FlowComplete{
+			FlowBase: FlowBase{Digest: "guest"},
+			Nfq:      &Nfq{SrcIface: "br-guest", DstIface: "eth1"},
+			VlanId:   20,
+		},
+	})
+	stats := func(digest string, upload, download int64, rate float64) {
+		processor.Process(FlowEvent{
+			Type: FlowTypeStats,
+			Flow: FlowStats{
+				FlowBase: FlowBase{Digest: digest},
+				Stats: Stats{
+					LocalBytes: upload,
+					OtherBytes: download,
+					LocalRate:  rate,
+					OtherRate:  2 * rate,
+				},
+			},
+		})
+	}
+	stats("lan", 100, 1000, 10)
+	stats("lan", 50, 500, 5)
+	stats("guest", 200, 2000, 20)
+	// Stats of unknown flows are not counted.
+	stats("unknown", 1, 1, 1)
+
+	assert.Equal(t, []InterfaceTraffic{
+		{
+			Interface: "br-guest",
+			TrafficTotals: TrafficTotals{
+				ActiveFlows:   1,
+				UploadRate:    20,
+				DownloadRate:  40,
+				UploadBytes:   200,
+				DownloadBytes: 2000,
+			},
+		},
+		{
+			Interface: "br-lan",
+			TrafficTotals: TrafficTotals{
+				ActiveFlows:   1,
+				UploadRate:    5,
+				DownloadRate:  10,
+				UploadBytes:   150,
+				DownloadBytes: 1500,
+			},
+		},
+		{
+			Interface: "eth1",
+			TrafficTotals: TrafficTotals{
+				ActiveFlows:   1,
+				UploadRate:    20,
+				DownloadRate:  40,
+				UploadBytes:   200,
+				DownloadBytes: 2000,
+			},
+		},
+	}, processor.Traffic().Interfaces)
+
+	// Purged flows are no longer active but their bytes are kept.
+	processor.Process(FlowEvent{
+		Type: FlowTypePurge,
+		Flow: FlowPurge{FlowBase: FlowBase{Digest: "guest"}},
+	})
+	assert.Equal(t, []VlanTraffic{
+		{
+			VlanId: 0,
+			TrafficTotals: TrafficTotals{
+				ActiveFlows:   1,
+				UploadRate:    5,
+				DownloadRate:  10,
+				UploadBytes:   150,
+				DownloadBytes: 1500,
+			},
+		},
+		{
+			VlanId:        20,
+			TrafficTotals: TrafficTotals{UploadBytes: 200, DownloadBytes: 2000},
+		},
+	}, processor.Traffic().Vlans)
+}
@@ -0,0 +1,109 @@
+// Package geoip annotates remote IP addresses with their country and city
+// using a MaxMind-format (MMDB) database such as GeoLite2-City or
+// DB-IP City Lite.
+package geoip
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/internal/mmdb"
+)
+
+// Location is the geographical information known for an IP address.
+type Location struct {
+	Country     string
+	CountryName string
+	City        string
+}
+
+// DB is a reloadable GeoIP database. The file can be replaced at runtime:
+// Reload swaps the in-memory copy only once the new file parsed correctly.
+type DB struct {
+	path   string
+	reader atomic.Pointer[mmdb.Reader]
+}
+
+// New creates a DB reading from path. Call Reload to load the file.
+func New(path string) *DB {
+	return &DB{path: path}
+}
+
+// Path returns the database file path, for change detection.
+func (d *DB) Path() string {
+	return d.path
+}
+
+// Reload reads the database file. On failure the previously loaded database,
+// if any, stays in use.
+func (d *DB) Reload() error {
+	reader, err := mmdb.Open(d.path)
+	if err != nil {
+		return fmt.Errorf("load geoip database %s: %w", d.path, err)
+	}
+	d.reader.Store(reader)
+	slog.Debug("Loaded GeoIP database", "path", d.path, "type", reader.Metadata().DatabaseType)
+	return nil
+}
+
+// Lookup returns the location of ip.
+func (d *DB) Lookup(ip string) (Location, bool) {
+	reader := d.reader.Load()
+	if reader == nil {
+		return Location{}, false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Location{}, false
+	}
+	record, ok, err := reader.Lookup(addr)
+	if err != nil {
+		slog.Debug("GeoIP lookup failed", "ip", ip, "error", err)
+		return Location{}, false
+	}
+	if !ok {
+		return Location{}, false
+	}
+
+	var location Location
+	// City databases carry the registered country when the physical
+	// location is unknown, e.g. for anycast networks.
+	for _, key := range []string{"country", "registered_country"} {
+		if code, ok := mmdb.Path(record, key, "iso_code").(string); ok {
+			location.Country = code
+			location.CountryName, _ = mmdb.Path(record, key, "names", "en").(string)
+			break
+		}
+	}
+	location.City, _ = mmdb.Path(record, "city", "names", "en").(string)
+	if location == (Location{}) {
+		return Location{}, false
+	}
+	return location, true
+}
+
+// Locate returns the ISO country code and city name of ip.
+func (d *DB) Locate(ip string) (string, string, bool) {
+	location, ok := d.Lookup(ip)
+	return location.Country, location.City, ok
+}
+
+// Enrich attaches the location of the remote endpoint to a flow.
+func (d *DB) Enrich(event *flows.FlowEvent) {
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok {
+		return
+	}
+	location, ok := d.Lookup(flow.OtherIp)
+	if !ok {
+		return
+	}
+	event.EnsureEnrichment().OtherGeo = &flows.GeoInfo{
+		Country:     location.Country,
+		CountryName: location.CountryName,
+		City:        location.City,
+	}
+}
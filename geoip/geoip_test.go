@@ -0,0 +1,147 @@
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/internal/mmdb/mmdbtest"
+)
+
+func writeDatabase(t *testing.T, path string, networks map[string]map[string]any) {
+	t.Helper()
+	w := mmdbtest.NewWriter("GeoLite2-City")
+	for prefix, record := range networks {
+		if err := w.Insert(netip.MustParsePrefix(prefix), record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := w.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Replace the file atomically, as database updaters do.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cityRecord(iso, country, city string) map[string]any {
+	record := map[string]any{
+		"country": map[string]any{"iso_code": iso, "names": map[string]any{"en": country}},
+	}
+	if city != "" {
+		record["city"] = map[string]any{"names": map[string]any{"en": city}}
+	}
+	return record
+}
+
+func TestDB(t *testing.T) {
+	t.Run("looks up country and city", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "city.mmdb")
+		writeDatabase(t, path, map[string]map[string]any{
+			"8.8.8.0/24":  cityRecord("US", "United States", "Mountain View"),
+			"2a00::/16":   cityRecord("IT", "Italy", ""),
+			"1.1.1.0/24":  {"registered_country": map[string]any{"iso_code": "AU"}},
+			"9.9.9.0/24":  {"continent": map[string]any{"code": "EU"}},
+			"10.0.0.0/30": cityRecord("DE", "Germany", "Berlin"),
+		})
+		db := New(path)
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+
+		location, ok := db.Lookup("8.8.8.8")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, Location{Country: "US", CountryName: "United States", City: "Mountain View"}, location)
+
+		country, city, ok := db.Locate("2a00:1450::1")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "IT", country)
+		assert.Equal(t, "", city)
+
+		country, _, ok = db.Locate("1.1.1.1")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "AU", country)
+
+		for _, ip := range []string{"9.9.9.9", "192.168.1.1", "not-an-ip"} {
+			if _, ok := db.Lookup(ip); ok {
+				t.Errorf("expected no location for %s", ip)
+			}
+		}
+	})
+
+	t.Run("keeps the previous database when the new one is invalid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "city.mmdb")
+		db := New(path)
+		if err := db.Reload(); err == nil {
+			t.Fatal("expected error for missing database")
+		}
+		if _, ok := db.Lookup("8.8.8.8"); ok {
+			t.Fatal("expected no location without a database")
+		}
+
+		writeDatabase(t, path, map[string]map[string]any{
+			"8.8.8.0/24": cityRecord("US", "United States", ""),
+		})
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte("garbage"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Reload(); err == nil {
+			t.Fatal("expected error for invalid database")
+		}
+		country, _, _ := db.Locate("8.8.8.8")
+		assert.Equal(t, "US", country)
+
+		writeDatabase(t, path, map[string]map[string]any{
+			"8.8.8.0/24": cityRecord("CA", "Canada", ""),
+		})
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		country, _, _ = db.Locate("8.8.8.8")
+		assert.Equal(t, "CA", country)
+	})
+
+	t.Run("enriches the remote endpoint", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "city.mmdb")
+		writeDatabase(t, path, map[string]map[string]any{
+			"8.8.8.0/24": cityRecord("US", "United States", "Mountain View"),
+		})
+		db := New(path)
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+
+		event := flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{LocalIp: "192.168.1.10", OtherIp: "8.8.8.8"},
+		}
+		db.Enrich(&event)
+		assert.Equal(t, &flows.GeoInfo{
+			Country:     "US",
+			CountryName: "United States",
+			City:        "Mountain View",
+		}, event.Enrichment.OtherGeo)
+
+		unknown := flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{LocalIp: "192.168.1.10", OtherIp: "192.168.1.1"},
+		}
+		db.Enrich(&unknown)
+		if unknown.Enrichment != nil {
+			t.Fatalf("expected no enrichment, got %+v", unknown.Enrichment)
+		}
+	})
+}
@@ -0,0 +1,306 @@
+// Package hostcheck flags flows whose names disagree: the TLS SNI, the HTTP
+// URL host, the DNS name netifyd associated with the server address and the
+// host_server_name it settled on should all belong to the same registered
+// domain. A mismatch is a sign of domain fronting or of malware hiding its
+// real destination.
+//
+// Content delivery networks legitimately serve many domains from the same
+// addresses, so the DNS name of a flow is often a CDN host name. Names under
+// an allowlisted domain are left out of the comparison. The allowlist file
+// holds one domain per line, which also covers its subdomains.
+//
+// Flows are checked once, when they are ingested, and flagged flows are
+// counted per local host for as long as the checker runs.
+package hostcheck
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Compared flow fields.
+const (
+	FieldClientSni      = "ssl.client_sni"
+	FieldHostServerName = "host_server_name"
+	FieldHttpUrl        = "http.url"
+	FieldDnsHostName    = "dns_host_name"
+)
+
+// DefaultAllowlist holds the CDN and cloud domains always ignored.
+var DefaultAllowlist = []string{
+	"akadns.net",
+	"akamai.net",
+	"akamaiedge.net",
+	"akamaihd.net",
+	"akamaitechnologies.com",
+	"azureedge.net",
+	"cdn77.org",
+	"cloudflare.net",
+	"cloudfront.net",
+	"edgecastcdn.net",
+	"edgekey.net",
+	"edgesuite.net",
+	"fastly.net",
+	"fastlylb.net",
+	"googleusercontent.com",
+	"llnwd.net",
+	"trafficmanager.net",
+}
+
+// maxDomains bounds the domains remembered per host.
+const maxDomains = 64
+
+// Checker compares the names of a flow. Reload swaps the in-memory allowlist
+// only once the new file has been read.
+type Checker struct {
+	path      string
+	allowlist atomic.Pointer[map[string]struct{}]
+
+	mu sync.Mutex
+	// flagged holds the digests of the active flows already counted.
+	flagged map[string]struct{}
+	hosts   map[string]*HostSummary
+}
+
+// HostSummary counts the flagged flows of a local host, finished ones
+// included.
+type HostSummary struct {
+	LocalIp  string
+	LocalMac string
+	Flows    int
+	// Domains lists the registered domains of the compared names, up to 64.
+	Domains []string
+}
+
+// New creates a Checker that ignores DefaultAllowlist plus the domains listed
+// in path, if not empty. Call Reload to load the file.
+func New(path string) *Checker {
+	c := &Checker{
+		path:    path,
+		flagged: make(map[string]struct{}),
+		hosts:   make(map[string]*HostSummary),
+	}
+	allowlist := defaultAllowlist()
+	c.allowlist.Store(&allowlist)
+	return c
+}
+
+// Path returns the allowlist file path, for change detection.
+func (c *Checker) Path() string {
+	return c.path
+}
+
+// Reload reads the allowlist file. On failure the previously loaded
+// allowlist stays in use.
+func (c *Checker) Reload() error {
+	allowlist := defaultAllowlist()
+	if c.path != "" {
+		file, err := os.Open(c.path)
+		if err != nil {
+			return fmt.Errorf("load hostname allowlist %s: %w", c.path, err)
+		}
+		defer file.Close() //nolint:errcheck
+		if err := parse(file, allowlist); err != nil {
+			return fmt.Errorf("load hostname allowlist %s: %w", c.path, err)
+		}
+	}
+	c.allowlist.Store(&allowlist)
+	slog.Debug("Loaded hostname allowlist", "domains", len(allowlist))
+	return nil
+}
+
+func defaultAllowlist() map[string]struct{} {
+	allowlist := make(map[string]struct{}, len(DefaultAllowlist))
+	for _, domain := range DefaultAllowlist {
+		allowlist[domain] = struct{}{}
+	}
+	return allowlist
+}
+
+// parse adds the domains read from r to allowlist.
+func parse(r io.Reader, allowlist map[string]struct{}) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if domain := normalize(line); domain != "" {
+			allowlist[domain] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// Analyze flags a flow being ingested when its names do not share a
+// registered domain, and counts it for its local host. It implements
+// flows.FlowAnalyzer.
+func (c *Checker) Analyze(event *flows.FlowEvent) {
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok {
+		return
+	}
+	mismatch := c.Check(flow)
+	if mismatch == nil {
+		return
+	}
+	event.EnsureEnrichment().HostnameMismatch = mismatch
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// netifyd may report a flow again before it finishes.
+	if _, counted := c.flagged[flow.Digest]; counted {
+		return
+	}
+	c.flagged[flow.Digest] = struct{}{}
+	host, ok := c.hosts[flow.LocalIp]
+	if !ok {
+		host = &HostSummary{LocalIp: flow.LocalIp}
+		c.hosts[flow.LocalIp] = host
+	}
+	host.LocalMac = flow.LocalMac
+	host.Flows++
+	for _, name := range mismatch.Names {
+		if len(host.Domains) < maxDomains && !slices.Contains(host.Domains, name.Domain) {
+			host.Domains = append(host.Domains, name.Domain)
+		}
+	}
+}
+
+// FlowFinished forgets a finished flow, which stays counted for its host. It
+// implements flows.FlowSink.
+func (c *Checker) FlowFinished(event flows.FlowEvent, _ string) {
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	delete(c.flagged, flow.Digest)
+	c.mu.Unlock()
+}
+
+// Hosts returns the hosts with flagged flows, most flows first.
+func (c *Checker) Hosts() []HostSummary {
+	c.mu.Lock()
+	hosts := make([]HostSummary, 0, len(c.hosts))
+	for _, host := range c.hosts {
+		summary := *host
+		summary.Domains = slices.Sorted(slices.Values(host.Domains))
+		hosts = append(hosts, summary)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Flows != hosts[j].Flows {
+			return hosts[i].Flows > hosts[j].Flows
+		}
+		return hosts[i].LocalIp < hosts[j].LocalIp
+	})
+	return hosts
+}
+
+// Check returns the compared names of flow when they disagree, or nil.
+func (c *Checker) Check(flow flows.FlowComplete) *flows.HostnameMismatch {
+	var candidates []flows.HostnameField
+	if flow.Ssl != nil {
+		candidates = append(
+			candidates,
+			flows.HostnameField{Field: FieldClientSni, Name: flow.Ssl.ClientSni},
+		)
+	}
+	candidates = append(
+		candidates,
+		flows.HostnameField{Field: FieldHostServerName, Name: flow.HostServerName},
+	)
+	if flow.Http != nil {
+		candidates = append(
+			candidates,
+			flows.HostnameField{Field: FieldHttpUrl, Name: urlHost(flow.Http.Url)},
+		)
+	}
+	candidates = append(
+		candidates,
+		flows.HostnameField{Field: FieldDnsHostName, Name: flow.DnsHostName},
+	)
+	allowlist := *c.allowlist.Load()
+
+	var compared []flows.HostnameField
+	consistent := true
+	for _, candidate := range candidates {
+		name := normalize(candidate.Name)
+		if name == "" || allowed(allowlist, name) {
+			continue
+		}
+		domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+		if err != nil {
+			// Single labels and bare public suffixes cannot be compared.
+			continue
+		}
+		if len(compared) > 0 && compared[0].Domain != domain {
+			consistent = false
+		}
+		candidate.Name = name
+		candidate.Domain = domain
+		compared = append(compared, candidate)
+	}
+	if consistent {
+		return nil
+	}
+	return &flows.HostnameMismatch{Names: compared}
+}
+
+// normalize lowercases a host name and strips the port and trailing dot. It
+// returns "" for IP addresses and empty names.
+func normalize(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+	name = strings.TrimSuffix(name, ".")
+	if _, err := netip.ParseAddr(strings.Trim(name, "[]")); err == nil {
+		return ""
+	}
+	return name
+}
+
+// urlHost extracts the host of an HTTP URL, which netifyd reports either
+// absolute or as host and path. Bare paths have no host.
+func urlHost(raw string) string {
+	if raw == "" || strings.HasPrefix(raw, "/") {
+		return ""
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+// allowed reports whether name is an allowlisted domain or one of its
+// subdomains.
+func allowed(allowlist map[string]struct{}, name string) bool {
+	for {
+		if _, ok := allowlist[name]; ok {
+			return true
+		}
+		_, parent, found := strings.Cut(name, ".")
+		if !found {
+			return false
+		}
+		name = parent
+	}
+}
@@ -0,0 +1,144 @@
+package hostcheck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+func TestCheck(t *testing.T) {
+	checker := New("")
+	tests := []struct {
+		name     string
+		flow     flows.FlowComplete
+		mismatch bool
+	}{
+		{
+			name: "same registered domain",
+			flow: flows.FlowComplete{
+				Ssl:            &flows.Ssl{ClientSni: "www.example.com"},
+				HostServerName: "www.example.com",
+				DnsHostName:    "static.example.com.",
+			},
+		},
+		{
+			name: "public suffix with two labels",
+			flow: flows.FlowComplete{
+				Ssl:         &flows.Ssl{ClientSni: "shop.example.co.uk"},
+				DnsHostName: "example.co.uk",
+			},
+		},
+		{
+			name: "domain fronting",
+			flow: flows.FlowComplete{
+				Ssl:            &flows.Ssl{ClientSni: "www.example.com"},
+				HostServerName: "www.example.com",
+				Http:           &flows.Http{Url: "c2.example.org/beacon"},
+			},
+			mismatch: true,
+		},
+		{
+			name: "CDN name in DNS cache",
+			flow: flows.FlowComplete{
+				Ssl:         &flows.Ssl{ClientSni: "www.example.com"},
+				DnsHostName: "d111111abcdef8.cloudfront.net",
+			},
+		},
+		{
+			name: "IP literals and paths are ignored",
+			flow: flows.FlowComplete{
+				HostServerName: "www.example.com",
+				Http:           &flows.Http{Url: "/index.html"},
+				DnsHostName:    "203.0.113.5",
+			},
+		},
+		{
+			name: "absolute URL with port",
+			flow: flows.FlowComplete{
+				HostServerName: "www.example.com",
+				Http:           &flows.Http{Url: "http://Evil.Example.net:8080/x"},
+			},
+			mismatch: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mismatch := checker.Check(tt.flow)
+			assert.Equal(t, tt.mismatch, mismatch != nil)
+		})
+	}
+
+	mismatch := checker.Check(tests[2].flow)
+	assert.Equal(t, []flows.HostnameField{
+		{Field: FieldClientSni, Name: "www.example.com", Domain: "example.com"},
+		{Field: FieldHostServerName, Name: "www.example.com", Domain: "example.com"},
+		{Field: FieldHttpUrl, Name: "c2.example.org", Domain: "example.org"},
+	}, mismatch.Names)
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist")
+	if err := os.WriteFile(path, []byte("# shared hosting\nHosting.Example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	checker := New(path)
+	flow := flows.FlowComplete{
+		Ssl:         &flows.Ssl{ClientSni: "www.example.com"},
+		DnsHostName: "srv1.hosting.example",
+	}
+	assert.NotEqual(t, nil, checker.Check(flow))
+
+	if err := checker.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, (*flows.HostnameMismatch)(nil), checker.Check(flow))
+
+	// A failed reload keeps the loaded allowlist.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, nil, checker.Reload())
+	assert.Equal(t, (*flows.HostnameMismatch)(nil), checker.Check(flow))
+}
+
+func TestAnalyze(t *testing.T) {
+	tlsFlow := func(digest, localIp, sni, hostServerName string) flows.FlowEvent {
+		return flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{
+				FlowBase:       flows.FlowBase{Digest: digest},
+				LocalIp:        localIp,
+				Ssl:            &flows.Ssl{ClientSni: sni},
+				HostServerName: hostServerName,
+			},
+		}
+	}
+	checker := New("")
+
+	event := tlsFlow("f-001", "192.168.1.10", "www.example.com", "cdn.example.net")
+	checker.Analyze(&event)
+	assert.Equal(t, 2, len(event.Enrichment.HostnameMismatch.Names))
+	consistent := tlsFlow("f-002", "192.168.1.10", "www.example.com", "www.example.com")
+	checker.Analyze(&consistent)
+	assert.Equal(t, (*flows.Enrichment)(nil), consistent.Enrichment)
+
+	// Flows reported again are counted once, finished flows stay counted.
+	checker.Analyze(&event)
+	checker.FlowFinished(event, flows.FinishPurged)
+	other := tlsFlow("f-003", "192.168.1.20", "api.example.com", "tracker.example.org")
+	checker.Analyze(&other)
+	other = tlsFlow("f-004", "192.168.1.20", "api.example.com", "c2.example.net")
+	checker.Analyze(&other)
+
+	assert.Equal(t, []HostSummary{
+		{
+			LocalIp: "192.168.1.20",
+			Flows:   2,
+			Domains: []string{"example.com", "example.net", "example.org"},
+		},
+		{LocalIp: "192.168.1.10", Flows: 1, Domains: []string{"example.com", "example.net"}},
+	}, checker.Hosts())
+}
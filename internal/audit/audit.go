@@ -0,0 +1,83 @@
+// Package audit records administrative actions performed through the APIs.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Record describes a single action.
+type Record struct {
+	Time       time.Time      `json:"time"`
+	Action     string         `json:"action"`
+	Target     string         `json:"target"`
+	RemoteAddr string         `json:"remote_addr,omitempty"`
+	Result     string         `json:"result"`
+	Error      string         `json:"error,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+}
+
+// Recorder persists audit records.
+type Recorder interface {
+	Record(record Record) error
+}
+
+// FileRecorder appends one JSON object per line to a file.
+type FileRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFile opens (or creates) the audit log at path in append mode.
+func OpenFile(path string) (*FileRecorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	return &FileRecorder{file: file}, nil
+}
+
+func (f *FileRecorder) Record(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal audit record: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write audit record: %w", err)
+	}
+	return f.file.Sync()
+}
+
+// Close closes the underlying file.
+func (f *FileRecorder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// LogRecorder writes audit records to the default slog logger.
+type LogRecorder struct{}
+
+func (LogRecorder) Record(record Record) error {
+	slog.Info(
+		"Audit",
+		"action", record.Action,
+		"target", record.Target,
+		"remote_addr", record.RemoteAddr,
+		"result", record.Result,
+		"error", record.Error,
+	)
+	return nil
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestFileRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	recorder, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := Record{
+		Time:   time.Unix(1760000000, 0).UTC(),
+		Action: "terminate_flow",
+		Target: "abc",
+		Result: ResultSuccess,
+	}
+	if err := recorder.Record(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening appends instead of truncating.
+	recorder, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := Record{
+		Time:   time.Unix(1760000060, 0).UTC(),
+		Action: "terminate_flow",
+		Target: "def",
+		Result: ResultFailure,
+		Error:  "conntrack entry not found",
+	}
+	if err := recorder.Record(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	assert.Equal(t, []Record{first, second}, records)
+}
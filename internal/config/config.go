@@ -0,0 +1,236 @@
+// Package config resolves daemon settings from a UCI file and command line
+// flags.
+//
+// Every flag of a daemon can be set in its section of the file, with
+// underscores in place of dashes: "option expired_persistence '2m'" sets
+// --expired-persistence, and list options are joined with commas. Flags given
+// on the command line take precedence over the file, which takes precedence
+// over the flag defaults.
+package config
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nethserver/nethsecurity-monitoring/internal/uci"
+)
+
+// DefaultPath is the UCI file shared by the monitoring daemons.
+const DefaultPath = "/etc/config/ns-monitoring"
+
+// Section types read by each daemon.
+const (
+	SectionFlows = "flows"
+	SectionStats = "stats"
+)
+
+// Loader reads the settings of a flag set from a UCI file.
+type Loader struct {
+	flags    *flag.FlagSet
+	path     string
+	section  string
+	required bool
+	explicit map[string]bool
+}
+
+// NewLoader creates a loader for the sections of type section in path. It
+// must be called after flags has been parsed, to record which flags were set
+// on the command line. A missing file is an error only when required.
+func NewLoader(flags *flag.FlagSet, path, section string, required bool) *Loader {
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	return &Loader{
+		flags:    flags,
+		path:     path,
+		section:  section,
+		required: required,
+		explicit: explicit,
+	}
+}
+
+// Path returns the configuration file path.
+func (l *Loader) Path() string {
+	return l.path
+}
+
+// Load reads the file and resolves the value of every flag. The flags
+// themselves are left untouched; see Apply.
+func (l *Loader) Load() (Settings, error) {
+	options, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(Settings)
+	var loadErr error
+	l.flags.VisitAll(func(f *flag.Flag) {
+		raw := f.DefValue
+		if l.explicit[f.Name] {
+			raw = f.Value.String()
+		} else if option, ok := options[f.Name]; ok {
+			raw = option
+		}
+		value, err := newValue(f, raw)
+		if err != nil {
+			loadErr = errors.Join(loadErr, fmt.Errorf(
+				"%s: option %s: %w", l.path, optionName(f.Name), err,
+			))
+			return
+		}
+		settings[f.Name] = value
+	})
+	if loadErr != nil {
+		return nil, loadErr
+	}
+	return settings, nil
+}
+
+// Apply sets the flags not given on the command line to settings. Call it
+// once at startup, before the flag values are used.
+func (l *Loader) Apply(settings Settings) error {
+	for name, value := range settings {
+		if l.explicit[name] {
+			continue
+		}
+		if err := l.flags.Set(name, value.String()); err != nil {
+			return fmt.Errorf("set --%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Run reloads the file on SIGHUP until ctx is cancelled. When one of the
+// reloadable flags changed, apply is called with the new settings; it may
+// reject them by returning an error, in which case the current settings stay
+// in effect. Changes to other flags are logged as requiring a restart.
+func (l *Loader) Run(
+	ctx context.Context,
+	current Settings,
+	reloadable []string,
+	apply func(Settings) error,
+) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+		case <-ctx.Done():
+			return
+		}
+
+		settings, err := l.Load()
+		if err != nil {
+			slog.Error("Failed to reload configuration", "error", err)
+			continue
+		}
+		var applied, restart []string
+		for _, name := range settings.Changed(current) {
+			if slices.Contains(reloadable, name) {
+				applied = append(applied, name)
+			} else {
+				restart = append(restart, name)
+			}
+		}
+		if len(restart) > 0 {
+			slog.Warn("Configuration changes require a restart", "flags", restart)
+		}
+		if len(applied) > 0 {
+			if err := apply(settings); err != nil {
+				slog.Error("Failed to apply configuration", "error", err)
+				continue
+			}
+		}
+		slog.Info("Reloaded configuration", "path", l.path, "applied", applied)
+		current = settings
+	}
+}
+
+// read returns the options of the daemon sections, keyed by flag name.
+func (l *Loader) read() (map[string]string, error) {
+	sections, err := uci.ParseFile(l.path)
+	if errors.Is(err, fs.ErrNotExist) && !l.required {
+		slog.Debug("No configuration file, using flags only", "path", l.path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load configuration: %w", err)
+	}
+
+	options := make(map[string]string)
+	for _, section := range sections {
+		if section.Type != l.section {
+			continue
+		}
+		for option, values := range section.Options {
+			name := strings.ReplaceAll(option, "_", "-")
+			if l.flags.Lookup(name) == nil || name == "config" {
+				slog.Warn("Ignoring unknown configuration option", "path", l.path, "option", option)
+				continue
+			}
+			options[name] = strings.Join(values, ",")
+		}
+	}
+	return options, nil
+}
+
+// newValue parses raw into a fresh value of the same type as the flag.
+func newValue(f *flag.Flag, raw string) (flag.Getter, error) {
+	value, ok := reflect.New(reflect.TypeOf(f.Value).Elem()).Interface().(flag.Getter)
+	if !ok {
+		return nil, fmt.Errorf("unsupported flag type %T", f.Value)
+	}
+	if err := value.Set(raw); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func optionName(flagName string) string {
+	return strings.ReplaceAll(flagName, "-", "_")
+}
+
+// Settings holds the resolved value of every flag, keyed by flag name.
+type Settings map[string]flag.Getter
+
+// String returns the value of a string flag.
+func (s Settings) String(name string) string {
+	return s[name].Get().(string)
+}
+
+// Int returns the value of an int flag.
+func (s Settings) Int(name string) int {
+	return s[name].Get().(int)
+}
+
+// Duration returns the value of a duration flag.
+func (s Settings) Duration(name string) time.Duration {
+	return s[name].Get().(time.Duration)
+}
+
+// Changed returns the sorted names of the flags whose value differs from
+// previous.
+func (s Settings) Changed(previous Settings) []string {
+	var changed []string
+	for name, value := range s {
+		if old, ok := previous[name]; !ok || old.String() != value.String() {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
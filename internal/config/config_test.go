@@ -0,0 +1,110 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+const configFile = `
+config flows 'flows'
+	option api_port '9090'
+
+config stats 'stats'
+	option retention '6h'
+	option export_window '4'
+	option log_level 'debug'
+	list threat_feeds '/etc/feeds/a.netset'
+	list threat_feeds '/etc/feeds/b.domains'
+	option unknown_option 'x'
+`
+
+type testFlags struct {
+	set          *flag.FlagSet
+	retention    time.Duration
+	exportWindow int
+	logLevel     string
+	threatFeeds  string
+}
+
+func newTestFlags(t *testing.T, args ...string) *testFlags {
+	t.Helper()
+	f := &testFlags{set: flag.NewFlagSet("test", flag.ContinueOnError)}
+	f.set.DurationVar(&f.retention, "retention", 3*time.Hour, "")
+	f.set.IntVar(&f.exportWindow, "export-window", 2, "")
+	f.set.StringVar(&f.logLevel, "log-level", "info", "")
+	f.set.StringVar(&f.threatFeeds, "threat-feeds", "", "")
+	if err := f.set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ns-monitoring")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoader(t *testing.T) {
+	path := writeConfig(t, configFile)
+	flags := newTestFlags(t, "--export-window", "8")
+	loader := NewLoader(flags.set, path, SectionStats, true)
+
+	settings, err := loader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file overrides defaults, the command line overrides the file.
+	assert.Equal(t, 6*time.Hour, settings.Duration("retention"))
+	assert.Equal(t, 8, settings.Int("export-window"))
+	assert.Equal(t, "debug", settings.String("log-level"))
+	assert.Equal(t, "/etc/feeds/a.netset,/etc/feeds/b.domains", settings.String("threat-feeds"))
+	// Loading does not touch the flags.
+	assert.Equal(t, 3*time.Hour, flags.retention)
+
+	if err := loader.Apply(settings); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 6*time.Hour, flags.retention)
+	assert.Equal(t, 8, flags.exportWindow)
+	assert.Equal(t, "debug", flags.logLevel)
+
+	// Options removed from the file go back to their defaults.
+	updated := "config stats\n\toption export_window '1'\n"
+	if err := os.WriteFile(path, []byte(updated), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := loader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3*time.Hour, reloaded.Duration("retention"))
+	assert.Equal(t, 8, reloaded.Int("export-window"))
+	assert.Equal(t, []string{"log-level", "retention", "threat-feeds"}, reloaded.Changed(settings))
+}
+
+func TestLoaderErrors(t *testing.T) {
+	t.Run("rejects invalid values", func(t *testing.T) {
+		path := writeConfig(t, "config stats\n\toption retention 'forever'\n")
+		_, err := NewLoader(newTestFlags(t).set, path, SectionStats, true).Load()
+		assert.NotEqual(t, nil, err)
+	})
+
+	t.Run("ignores a missing optional file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "missing")
+		settings, err := NewLoader(newTestFlags(t).set, path, SectionStats, false).Load()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 3*time.Hour, settings.Duration("retention"))
+
+		_, err = NewLoader(newTestFlags(t).set, path, SectionStats, true).Load()
+		assert.NotEqual(t, nil, err)
+	})
+}
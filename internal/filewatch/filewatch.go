@@ -0,0 +1,72 @@
+// Package filewatch detects changes to files on disk by polling their size and
+// modification time. It is meant for small configuration and database files
+// that are replaced in place (or atomically renamed) by other daemons.
+package filewatch
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+type stamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// Watcher tracks the state of a fixed set of paths.
+type Watcher struct {
+	mu     sync.Mutex
+	paths  []string
+	stamps map[string]stamp
+}
+
+// New creates a Watcher for the given paths. Missing files are allowed and are
+// reported as changed once they appear.
+func New(paths ...string) *Watcher {
+	w := &Watcher{
+		paths:  paths,
+		stamps: make(map[string]stamp, len(paths)),
+	}
+	w.Changed()
+	return w
+}
+
+// Changed reports whether any path changed since the previous call.
+func (w *Watcher) Changed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changed := false
+	for _, path := range w.paths {
+		current := stamp{}
+		if info, err := os.Stat(path); err == nil {
+			current = stamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+		}
+		if previous, ok := w.stamps[path]; !ok || previous != current {
+			changed = true
+		}
+		w.stamps[path] = current
+	}
+	return changed
+}
+
+// Run calls reload every time a change is detected, checking every interval
+// until ctx is cancelled.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration, reload func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if w.Changed() {
+				reload()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
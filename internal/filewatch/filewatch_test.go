@@ -0,0 +1,51 @@
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	t.Run("detects creation, modification and removal", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "watched")
+		w := New(path)
+
+		if w.Changed() {
+			t.Fatal("expected no change for a missing file")
+		}
+
+		if err := os.WriteFile(path, []byte("one"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if !w.Changed() {
+			t.Fatal("expected change after creation")
+		}
+		if w.Changed() {
+			t.Fatal("expected no change without modification")
+		}
+
+		if err := os.WriteFile(path, []byte("two two"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if !w.Changed() {
+			t.Fatal("expected change after modification")
+		}
+
+		future := time.Now().Add(time.Hour)
+		if err := os.Chtimes(path, future, future); err != nil {
+			t.Fatal(err)
+		}
+		if !w.Changed() {
+			t.Fatal("expected change after touching the file")
+		}
+
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		if !w.Changed() {
+			t.Fatal("expected change after removal")
+		}
+	})
+}
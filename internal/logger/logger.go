@@ -1,38 +1,360 @@
+// Package logger provides the slog handler of the daemons. Records are
+// written one per line, either as "LEVEL message key=value ..." text or as
+// JSON objects, to a stream such as stderr or to the local syslog daemon
+// (logd on OpenWrt). Attributes added with With and groups opened with
+// WithGroup are kept: groups nest JSON objects and prefix text keys with
+// "group.".
 package logger
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode"
 )
 
+// Output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Outputs.
+const (
+	OutputStderr = "stderr"
+	OutputSyslog = "syslog"
+)
+
+// writer receives one formatted record at a time, without the trailing
+// newline.
+type writer interface {
+	write(level slog.Level, line []byte) error
+}
+
+// streamWriter writes records to an io.Writer.
+type streamWriter struct {
+	out io.Writer
+}
+
+func (w streamWriter) write(_ slog.Level, line []byte) error {
+	_, err := w.out.Write(append(line, '\n'))
+	return err
+}
+
+// Handler formats records as text or JSON. Handlers derived with WithAttrs
+// and WithGroup share the output of their parent.
 type Handler struct {
-	out   io.Writer
-	level slog.Level
+	mu     *sync.Mutex
+	out    writer
+	level  slog.Leveler
+	json   bool
+	syslog bool
+	attrs  []groupedAttr
+	groups []string
+}
+
+// groupedAttr is an attribute added with WithAttrs, inside the groups open at
+// the time.
+type groupedAttr struct {
+	groups []string
+	attr   slog.Attr
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithFormat selects FormatText (the default) or FormatJSON.
+func WithFormat(format string) Option {
+	return func(h *Handler) {
+		h.json = format == FormatJSON
+	}
+}
+
+// New creates a handler writing records at or above level to out. level may
+// be a *slog.LevelVar to change it at runtime.
+func New(out io.Writer, level slog.Leveler, opts ...Option) slog.Handler {
+	return newHandler(streamWriter{out: out}, level, opts...)
+}
+
+func newHandler(out writer, level slog.Leveler, opts ...Option) *Handler {
+	h := &Handler{mu: &sync.Mutex{}, out: out, level: level}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Setup installs the handler selected by the --log-output and --log-format
+// flags as the default logger. Syslog messages are tagged with tag.
+func Setup(output, format, tag string, level slog.Leveler) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	var handler slog.Handler
+	switch output {
+	case OutputStderr:
+		handler = New(os.Stderr, level, WithFormat(format))
+	case OutputSyslog:
+		var err error
+		handler, err = NewSyslog(tag, level, WithFormat(format))
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid log output: %s", output)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
 }
 
-func New(out io.Writer, level slog.Level) slog.Handler {
-	return &Handler{out: out, level: level}
+// ParseLevel parses a --log-level value.
+func ParseLevel(name string) (slog.Level, error) {
+	switch name {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level: %s", name)
+}
+
+// ValidateFormat checks a --log-format value.
+func ValidateFormat(format string) error {
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("invalid log format: %s", format)
+	}
+	return nil
 }
 
 func (h *Handler) Enabled(_ context.Context, l slog.Level) bool {
-	return l >= h.level
+	return l >= h.level.Level()
 }
 
 func (h *Handler) Handle(_ context.Context, r slog.Record) error {
-	// Format: LEVEL Message
-	if _, err := fmt.Fprintf(h.out, "%s %s", r.Level, r.Message); err != nil {
-		return err
+	root := &object{}
+	for _, a := range h.attrs {
+		root.add(a.groups, a.attr)
 	}
-	// Append attributes as key=value
 	r.Attrs(func(a slog.Attr) bool {
-		fmt.Fprintf(h.out, " %s=%v", a.Key, a.Value) //nolint:errcheck
+		root.add(h.groups, a)
 		return true
 	})
-	fmt.Fprintln(h.out) //nolint:errcheck
-	return nil
+
+	var buf bytes.Buffer
+	if h.json {
+		buf.WriteByte('{')
+		// Syslog stamps messages itself.
+		if !h.syslog && !r.Time.IsZero() {
+			buf.WriteString(`"time":`)
+			writeJSONString(&buf, r.Time.Format(time.RFC3339Nano))
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`"level":`)
+		writeJSONString(&buf, r.Level.String())
+		buf.WriteString(`,"msg":`)
+		writeJSONString(&buf, r.Message)
+		if len(root.keys) > 0 {
+			buf.WriteByte(',')
+			root.writeJSONFields(&buf)
+		}
+		buf.WriteByte('}')
+	} else {
+		if !h.syslog {
+			buf.WriteString(r.Level.String())
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(r.Message)
+		root.writeText(&buf, "")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.out.write(r.Level, buf.Bytes())
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	clone := *h
+	clone.attrs = make([]groupedAttr, len(h.attrs), len(h.attrs)+len(attrs))
+	copy(clone.attrs, h.attrs)
+	for _, a := range attrs {
+		clone.attrs = append(clone.attrs, groupedAttr{groups: h.groups, attr: a})
+	}
+	return &clone
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+	return &clone
+}
+
+// object is an ordered set of attributes and nested groups.
+type object struct {
+	keys   []string
+	values map[string]any // slog.Value or *object
+}
+
+// add stores a inside the groups path, creating them as needed. Empty
+// attributes and groups are dropped, as slog requires.
+func (o *object) add(groups []string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		members := a.Value.Group()
+		if len(members) == 0 {
+			return
+		}
+		if a.Key != "" {
+			groups = append(groups[:len(groups):len(groups)], a.Key)
+		}
+		for _, member := range members {
+			o.add(groups, member)
+		}
+		return
+	}
+
+	target := o
+	for _, group := range groups {
+		target = target.group(group)
+	}
+	target.set(a.Key, a.Value)
+}
+
+func (o *object) group(name string) *object {
+	if child, ok := o.values[name].(*object); ok {
+		return child
+	}
+	child := &object{}
+	o.set(name, child)
+	return child
+}
+
+func (o *object) set(key string, value any) {
+	if o.values == nil {
+		o.values = make(map[string]any)
+	}
+	if _, exists := o.values[key]; !exists {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *object) writeText(buf *bytes.Buffer, prefix string) {
+	for _, key := range o.keys {
+		switch value := o.values[key].(type) {
+		case *object:
+			value.writeText(buf, prefix+key+".")
+		case slog.Value:
+			buf.WriteByte(' ')
+			buf.WriteString(prefix + key)
+			buf.WriteByte('=')
+			buf.WriteString(quoteText(textValue(value)))
+		}
+	}
 }
 
-func (h *Handler) WithAttrs(_ []slog.Attr) slog.Handler { return h }
-func (h *Handler) WithGroup(_ string) slog.Handler      { return h }
+func (o *object) writeJSONFields(buf *bytes.Buffer) {
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(buf, key)
+		buf.WriteByte(':')
+		switch value := o.values[key].(type) {
+		case *object:
+			buf.WriteByte('{')
+			value.writeJSONFields(buf)
+			buf.WriteByte('}')
+		case slog.Value:
+			writeJSONValue(buf, value)
+		}
+	}
+}
+
+func textValue(v slog.Value) string {
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+		if b, ok := v.Any().([]byte); ok {
+			return string(b)
+		}
+	}
+	return v.String()
+}
+
+// quoteText quotes values that would be ambiguous in key=value output.
+func quoteText(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+func writeJSONValue(buf *bytes.Buffer, v slog.Value) {
+	switch v.Kind() {
+	case slog.KindString:
+		writeJSONString(buf, v.String())
+	case slog.KindInt64:
+		buf.WriteString(strconv.FormatInt(v.Int64(), 10))
+	case slog.KindUint64:
+		buf.WriteString(strconv.FormatUint(v.Uint64(), 10))
+	case slog.KindFloat64:
+		if encoded, err := json.Marshal(v.Float64()); err == nil {
+			buf.Write(encoded)
+		} else {
+			// NaN and infinities have no JSON representation.
+			writeJSONString(buf, v.String())
+		}
+	case slog.KindBool:
+		buf.WriteString(strconv.FormatBool(v.Bool()))
+	case slog.KindDuration:
+		buf.WriteString(strconv.FormatInt(int64(v.Duration()), 10))
+	case slog.KindTime:
+		writeJSONString(buf, v.Time().Format(time.RFC3339Nano))
+	default:
+		if err, ok := v.Any().(error); ok {
+			writeJSONString(buf, err.Error())
+			return
+		}
+		encoded, err := json.Marshal(v.Any())
+		if err != nil {
+			writeJSONString(buf, fmt.Sprintf("!ERROR: %v", err))
+			return
+		}
+		buf.Write(encoded)
+	}
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	// Strings always marshal; HTML characters get escaped, which is harmless.
+	encoded, _ := json.Marshal(s)
+	buf.Write(encoded)
+}
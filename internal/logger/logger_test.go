@@ -0,0 +1,64 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestTextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(New(&buf, slog.LevelInfo))
+
+	log.Debug("hidden")
+	log.With("source", "GeoIP database").
+		WithGroup("cache").
+		Info("Reloaded file", "size", 10, "path", "/var/lib/geo ip.mmdb", slog.Group("stats"))
+	log.Error("Failed", "error", errors.New("boom"), slog.Group("req", "id", 7, "ok", true))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{
+		`INFO Reloaded file source="GeoIP database" cache.size=10 cache.path="/var/lib/geo ip.mmdb"`,
+		`ERROR Failed error=boom req.id=7 req.ok=true`,
+	}, lines)
+}
+
+func TestJSONHandler(t *testing.T) {
+	var buf bytes.Buffer
+	var level slog.LevelVar
+	log := slog.New(New(&buf, &level, WithFormat(FormatJSON)))
+
+	log.Debug("hidden")
+	level.Set(slog.LevelDebug)
+	log.With("a", 1).WithGroup("g").With("b", "x").WithGroup("empty").
+		Debug("hello", "d", 1500*time.Millisecond)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "DEBUG", record["level"])
+	assert.Equal(t, "hello", record["msg"])
+	assert.Equal(t, float64(1), record["a"])
+	assert.Equal(t, map[string]any{
+		"b":     "x",
+		"empty": map[string]any{"d": float64(1500 * time.Millisecond)},
+	}, record["g"])
+	_, hasTime := record["time"]
+	assert.Equal(t, true, hasTime)
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("warn")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, slog.LevelWarn, level)
+	_, err = ParseLevel("verbose")
+	assert.NotEqual(t, nil, err)
+}
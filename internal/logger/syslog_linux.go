@@ -0,0 +1,70 @@
+//go:build linux
+
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// syslogWriter sends records to the local syslog daemon, mapping the slog
+// level to the message severity.
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+func (s syslogWriter) write(level slog.Level, line []byte) error {
+	msg := string(line)
+	switch {
+	case level >= slog.LevelError:
+		return s.w.Err(msg)
+	case level >= slog.LevelWarn:
+		return s.w.Warning(msg)
+	case level >= slog.LevelInfo:
+		return s.w.Info(msg)
+	default:
+		return s.w.Debug(msg)
+	}
+}
+
+// NewSyslog creates a handler sending records at or above level to the local
+// syslog daemon through /dev/log, with the daemon facility and tag. The level
+// prefix and the timestamp are left to syslog.
+func NewSyslog(tag string, level slog.Leveler, opts ...Option) (slog.Handler, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog: %w", err)
+	}
+	h := newHandler(syslogWriter{w: w}, level, opts...)
+	h.syslog = true
+	return h, nil
+}
+
+// ToggleDebugOnSignal switches level to debug on SIGUSR1, and back to the
+// level it had before on the next SIGUSR1, until ctx is cancelled.
+func ToggleDebugOnSignal(ctx context.Context, level *slog.LevelVar) {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+
+	previous := slog.LevelInfo
+	for {
+		select {
+		case <-usr1:
+		case <-ctx.Done():
+			return
+		}
+		if current := level.Level(); current != slog.LevelDebug {
+			previous = current
+			level.Set(slog.LevelDebug)
+		} else {
+			level.Set(previous)
+		}
+		slog.Info("Changed log level", "level", level.Level())
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package logger
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// NewSyslog is only available on Linux.
+func NewSyslog(_ string, _ slog.Leveler, _ ...Option) (slog.Handler, error) {
+	return nil, errors.New("syslog output is only supported on linux")
+}
+
+// ToggleDebugOnSignal is only available on Linux; it returns when ctx is
+// cancelled.
+func ToggleDebugOnSignal(ctx context.Context, _ *slog.LevelVar) {
+	<-ctx.Done()
+}
@@ -0,0 +1,143 @@
+package mmdb_test
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/internal/mmdb"
+	"github.com/nethserver/nethsecurity-monitoring/internal/mmdb/mmdbtest"
+)
+
+func buildDatabase(t *testing.T) []byte {
+	t.Helper()
+	w := mmdbtest.NewWriter("Test-City")
+	networks := []struct {
+		prefix string
+		value  any
+	}{
+		{"8.8.0.0/16", map[string]any{
+			"country": map[string]any{"iso_code": "US", "names": map[string]any{"en": "United States"}},
+		}},
+		{"8.8.8.0/24", map[string]any{
+			"country": map[string]any{"iso_code": "US"},
+			"city":    map[string]any{"names": map[string]any{"en": "Mountain View"}},
+			"location": map[string]any{
+				"latitude":  37.386,
+				"longitude": -122.0838,
+			},
+		}},
+		{"2001:db8::/32", map[string]any{
+			"flags": []any{true, false, uint16(7), uint64(1) << 40, strings.Repeat("x", 300)},
+		}},
+	}
+	for _, network := range networks {
+		if err := w.Insert(netip.MustParsePrefix(network.prefix), network.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := w.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReader(t *testing.T) {
+	reader, err := mmdb.FromBytes(buildDatabase(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("reads metadata", func(t *testing.T) {
+		metadata := reader.Metadata()
+		assert.Equal(t, "Test-City", metadata.DatabaseType)
+		assert.Equal(t, uint16(6), metadata.IPVersion)
+		assert.Equal(t, uint16(24), metadata.RecordSize)
+	})
+
+	t.Run("finds the most specific network", func(t *testing.T) {
+		record, ok, err := reader.Lookup(netip.MustParseAddr("8.8.8.8"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "Mountain View", mmdb.Path(record, "city", "names", "en"))
+		assert.Equal(t, 37.386, mmdb.Path(record, "location", "latitude"))
+
+		record, ok, err = reader.Lookup(netip.MustParseAddr("8.8.4.4"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "United States", mmdb.Path(record, "country", "names", "en"))
+		assert.Equal(t, nil, mmdb.Path(record, "city", "names", "en"))
+	})
+
+	t.Run("handles IPv4-mapped and IPv6 addresses", func(t *testing.T) {
+		record, ok, err := reader.Lookup(netip.MustParseAddr("::ffff:8.8.8.8"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "US", mmdb.Path(record, "country", "iso_code"))
+
+		record, ok, err = reader.Lookup(netip.MustParseAddr("2001:db8::1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, true, ok)
+		assert.Equal(t, true, mmdb.Path(record, "flags", 0))
+		assert.Equal(t, false, mmdb.Path(record, "flags", 1))
+		assert.Equal(t, uint16(7), mmdb.Path(record, "flags", 2))
+		assert.Equal(t, uint64(1)<<40, mmdb.Path(record, "flags", 3))
+		assert.Equal(t, 300, len(mmdb.Path(record, "flags", 4).(string)))
+		assert.Equal(t, nil, mmdb.Path(record, "flags", 5))
+	})
+
+	t.Run("reports missing networks", func(t *testing.T) {
+		for _, ip := range []string{"1.1.1.1", "192.168.1.1", "2001:db9::1"} {
+			_, ok, err := reader.Lookup(netip.MustParseAddr(ip))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				t.Errorf("expected no record for %s", ip)
+			}
+		}
+	})
+}
+
+func TestOpen(t *testing.T) {
+	t.Run("reads a database file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.mmdb")
+		if err := os.WriteFile(path, buildDatabase(t), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		reader, err := mmdb.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok, err := reader.Lookup(netip.MustParseAddr("8.8.8.8"))
+		if err != nil || !ok {
+			t.Fatalf("expected record, got ok=%v err=%v", ok, err)
+		}
+	})
+
+	t.Run("rejects invalid files", func(t *testing.T) {
+		_, err := mmdb.FromBytes([]byte("not a database"))
+		if !errors.Is(err, mmdb.ErrInvalidDatabase) {
+			t.Fatalf("expected mmdb.ErrInvalidDatabase, got %v", err)
+		}
+
+		data := buildDatabase(t)
+		_, err = mmdb.FromBytes(data[len(data)/2:])
+		if !errors.Is(err, mmdb.ErrInvalidDatabase) {
+			t.Fatalf("expected mmdb.ErrInvalidDatabase for truncated file, got %v", err)
+		}
+	})
+}
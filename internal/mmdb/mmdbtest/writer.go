@@ -0,0 +1,276 @@
+// Package mmdbtest builds MaxMind DB files for the tests of the packages
+// reading them.
+package mmdbtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+)
+
+// metadataMarker precedes the metadata map at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Data section types used by encode.
+const (
+	typeString = 2
+	typeDouble = 3
+	typeBytes  = 4
+	typeUint16 = 5
+	typeUint32 = 6
+	typeMap    = 7
+	typeUint64 = 9
+	typeArray  = 11
+	typeBool   = 14
+)
+
+// Writer builds small IPv6 databases with 24 bit records for test fixtures:
+// every record is stored once per network and networks are inserted into a
+// plain binary tree.
+type Writer struct {
+	databaseType string
+	root         *writerNode
+	values       []any
+}
+
+type writerNode struct {
+	children [2]*writerNode
+	// data is the 1-based index into values of the record for this network,
+	// 0 when the node is an intermediate one.
+	data int
+}
+
+// NewWriter creates an empty database of the given type.
+func NewWriter(databaseType string) *Writer {
+	return &Writer{databaseType: databaseType, root: &writerNode{}}
+}
+
+// Insert associates value with prefix. More specific prefixes must be inserted
+// after the networks containing them. IPv4 prefixes are stored in the
+// IPv4-mapped ::/96 subtree.
+func (w *Writer) Insert(prefix netip.Prefix, value any) error {
+	if !prefix.IsValid() {
+		return errors.New("invalid prefix")
+	}
+	prefix = prefix.Masked()
+	bits := prefix.Bits()
+	addr := prefix.Addr()
+	if addr.Is4() {
+		addr = netip.AddrFrom16(addr.As16())
+		// As16 returns the ::ffff:0:0/96 form, MaxMind uses ::/96.
+		raw := addr.As16()
+		raw[10], raw[11] = 0, 0
+		addr = netip.AddrFrom16(raw)
+		bits += 96
+	}
+	if bits == 0 {
+		return errors.New("cannot insert the default route")
+	}
+
+	w.values = append(w.values, value)
+	index := len(w.values)
+
+	raw := addr.As16()
+	node := w.root
+	for i := range bits {
+		bit := (raw[i/8] >> (7 - uint(i%8))) & 1
+		child := node.children[bit]
+		if child == nil || child.data != 0 {
+			inherited := 0
+			if child != nil {
+				inherited = child.data
+			}
+			child = &writerNode{}
+			if i < bits-1 && inherited != 0 {
+				// Split an existing network: both halves keep its record
+				// until the more specific one overrides part of it.
+				child.children[0] = &writerNode{data: inherited}
+				child.children[1] = &writerNode{data: inherited}
+			}
+			node.children[bit] = child
+		}
+		node = child
+	}
+	node.children = [2]*writerNode{}
+	node.data = index
+	return nil
+}
+
+// Bytes serializes the database.
+func (w *Writer) Bytes() ([]byte, error) {
+	// Number the internal nodes breadth first; leaves become data pointers.
+	var nodes []*writerNode
+	ids := make(map[*writerNode]uint32)
+	queue := []*writerNode{w.root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node.data != 0 {
+			continue
+		}
+		ids[node] = uint32(len(nodes))
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			if child != nil {
+				queue = append(queue, child)
+			}
+		}
+	}
+	nodeCount := uint32(len(nodes))
+
+	var data bytes.Buffer
+	offsets := make([]int, len(w.values))
+	for i, value := range w.values {
+		offsets[i] = data.Len()
+		if err := encode(&data, value); err != nil {
+			return nil, fmt.Errorf("encode record %d: %w", i, err)
+		}
+	}
+
+	record := func(child *writerNode) (uint32, error) {
+		var value uint32
+		switch {
+		case child == nil:
+			value = nodeCount
+		case child.data != 0:
+			value = nodeCount + 16 + uint32(offsets[child.data-1])
+		default:
+			value = ids[child]
+		}
+		if value >= 1<<24 {
+			return 0, errors.New("database too large for 24 bit records")
+		}
+		return value, nil
+	}
+
+	var out bytes.Buffer
+	for _, node := range nodes {
+		for _, child := range node.children {
+			value, err := record(child)
+			if err != nil {
+				return nil, err
+			}
+			out.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.Write(metadataMarker)
+
+	metadata := map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"database_type":               w.databaseType,
+		"ip_version":                  uint16(6),
+		"node_count":                  nodeCount,
+		"record_size":                 uint16(24),
+	}
+	if err := encode(&out, metadata); err != nil {
+		return nil, fmt.Errorf("encode metadata: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case string:
+		writeControl(buf, typeString, len(v))
+		buf.WriteString(v)
+	case []byte:
+		writeControl(buf, typeBytes, len(v))
+		buf.Write(v)
+	case float64:
+		writeControl(buf, typeDouble, 8)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		writeControl(buf, typeBool, size)
+	case uint16:
+		writeUint(buf, typeUint16, uint64(v))
+	case uint32:
+		writeUint(buf, typeUint32, uint64(v))
+	case uint64:
+		writeUint(buf, typeUint64, v)
+	case int:
+		if v < 0 || v > math.MaxUint32 {
+			return fmt.Errorf("int %d out of range", v)
+		}
+		writeUint(buf, typeUint32, uint64(v))
+	case []any:
+		writeControl(buf, typeArray, len(v))
+		for _, item := range v {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		writeControl(buf, typeMap, len(v))
+		for _, key := range keys {
+			if err := encode(buf, key); err != nil {
+				return err
+			}
+			if err := encode(buf, v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported value type %T", value)
+	}
+	return nil
+}
+
+func writeUint(buf *bytes.Buffer, kind int, value uint64) {
+	var raw [8]byte
+	binary.BigEndian.PutUint64(raw[:], value)
+	trimmed := bytes.TrimLeft(raw[:], "\x00")
+	writeControl(buf, kind, len(trimmed))
+	buf.Write(trimmed)
+}
+
+func writeControl(buf *bytes.Buffer, kind, size int) {
+	var ctrl byte
+	extended := kind > 7
+	if !extended {
+		ctrl = byte(kind) << 5
+	}
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+		buf.WriteByte(ctrl)
+		if extended {
+			buf.WriteByte(byte(kind - 7))
+		}
+	case size < 285:
+		buf.WriteByte(ctrl | 29)
+		if extended {
+			buf.WriteByte(byte(kind - 7))
+		}
+		buf.WriteByte(byte(size - 29))
+	case size < 65821:
+		buf.WriteByte(ctrl | 30)
+		if extended {
+			buf.WriteByte(byte(kind - 7))
+		}
+		size -= 285
+		buf.Write([]byte{byte(size >> 8), byte(size)})
+	default:
+		buf.WriteByte(ctrl | 31)
+		if extended {
+			buf.WriteByte(byte(kind - 7))
+		}
+		size -= 65821
+		buf.Write([]byte{byte(size >> 16), byte(size >> 8), byte(size)})
+	}
+}
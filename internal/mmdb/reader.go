@@ -0,0 +1,404 @@
+// Package mmdb reads databases in the MaxMind DB format, as used by GeoLite2,
+// DB-IP and IPinfo downloads.
+//
+// Records are decoded into generic Go values: map[string]any, []any, string,
+// []byte, float64, float32, bool, int32, uint16, uint32, uint64 and
+// *big.Int for 128 bit integers.
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"os"
+)
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// ErrInvalidDatabase is returned when the file is not a valid MaxMind DB.
+var ErrInvalidDatabase = errors.New("invalid mmdb database")
+
+// Metadata describes the database layout and content.
+type Metadata struct {
+	NodeCount    uint32
+	RecordSize   uint16
+	IPVersion    uint16
+	DatabaseType string
+	BuildEpoch   uint64
+}
+
+// Reader looks up IP addresses in an in-memory copy of a database.
+// It is safe for concurrent use.
+type Reader struct {
+	buffer    []byte
+	data      []byte
+	metadata  Metadata
+	nodeSize  int
+	ipv4Start uint32
+}
+
+// Open reads the database at path into memory.
+func Open(path string) (*Reader, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read mmdb file: %w", err)
+	}
+	return FromBytes(buffer)
+}
+
+// FromBytes parses a database held in memory. The buffer must not be modified
+// afterwards.
+func FromBytes(buffer []byte) (*Reader, error) {
+	markerAt := bytes.LastIndex(buffer, metadataMarker)
+	if markerAt < 0 {
+		return nil, fmt.Errorf("%w: metadata marker not found", ErrInvalidDatabase)
+	}
+	metaStart := markerAt + len(metadataMarker)
+	raw, _, err := (&decoder{data: buffer[metaStart:]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidDatabase, err)
+	}
+	fields, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	metadata := Metadata{
+		NodeCount:  uint32(toUint64(fields["node_count"])),
+		RecordSize: uint16(toUint64(fields["record_size"])),
+		IPVersion:  uint16(toUint64(fields["ip_version"])),
+		BuildEpoch: toUint64(fields["build_epoch"]),
+	}
+	metadata.DatabaseType, _ = fields["database_type"].(string)
+
+	switch metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf(
+			"%w: unsupported record size %d",
+			ErrInvalidDatabase,
+			metadata.RecordSize,
+		)
+	}
+
+	nodeSize := int(metadata.RecordSize) / 4
+	treeSize := int(metadata.NodeCount) * nodeSize
+	// The search tree is followed by 16 zero bytes, then the data section.
+	if treeSize+16 > markerAt {
+		return nil, fmt.Errorf("%w: search tree exceeds file size", ErrInvalidDatabase)
+	}
+
+	r := &Reader{
+		buffer:   buffer,
+		data:     buffer[treeSize+16 : markerAt],
+		metadata: metadata,
+		nodeSize: nodeSize,
+	}
+	if metadata.IPVersion == 6 {
+		r.ipv4Start = r.ipv4StartNode()
+	}
+	return r, nil
+}
+
+// Metadata returns the database metadata.
+func (r *Reader) Metadata() Metadata {
+	return r.metadata
+}
+
+// Lookup returns the record for addr. The boolean is false when the address is
+// not covered by the database.
+func (r *Reader) Lookup(addr netip.Addr) (any, bool, error) {
+	addr = addr.Unmap()
+	node := uint32(0)
+	bits := addr.AsSlice()
+	if addr.Is4() && r.metadata.IPVersion == 6 {
+		node = r.ipv4Start
+	} else if addr.Is6() && r.metadata.IPVersion == 4 {
+		return nil, false, nil
+	}
+
+	nodeCount := r.metadata.NodeCount
+	for i := 0; i < len(bits)*8 && node < nodeCount; i++ {
+		bit := (bits[i/8] >> (7 - uint(i%8))) & 1
+		node = r.readNode(node, bit)
+	}
+
+	switch {
+	case node == nodeCount:
+		return nil, false, nil
+	case node < nodeCount:
+		return nil, false, fmt.Errorf("%w: search tree too deep", ErrInvalidDatabase)
+	}
+
+	offset := int(node-nodeCount) - 16
+	if offset < 0 || offset >= len(r.data) {
+		return nil, false, fmt.Errorf("%w: data pointer out of range", ErrInvalidDatabase)
+	}
+	value, _, err := (&decoder{data: r.data}).decode(offset, 0)
+	if err != nil {
+		return nil, false, fmt.Errorf("decode record: %w", err)
+	}
+	return value, true, nil
+}
+
+// ipv4StartNode returns the node reached after 96 zero bits, where IPv4
+// addresses live in an IPv6 tree.
+func (r *Reader) ipv4StartNode() uint32 {
+	node := uint32(0)
+	for i := 0; i < 96 && node < r.metadata.NodeCount; i++ {
+		node = r.readNode(node, 0)
+	}
+	return node
+}
+
+func (r *Reader) readNode(node uint32, bit byte) uint32 {
+	b := r.buffer[int(node)*r.nodeSize:]
+	switch r.metadata.RecordSize {
+	case 24:
+		if bit == 0 {
+			return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3])<<16 | uint32(b[4])<<8 | uint32(b[5])
+	case 28:
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		if bit == 0 {
+			return binary.BigEndian.Uint32(b[0:4])
+		}
+		return binary.BigEndian.Uint32(b[4:8])
+	}
+}
+
+// Data section types.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth bounds nesting to protect against malicious files.
+const maxDepth = 64
+
+type decoder struct {
+	data []byte
+}
+
+func (d *decoder) decode(offset, depth int) (any, int, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("maximum nesting depth exceeded")
+	}
+	if offset >= len(d.data) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+
+	ctrl := d.data[offset]
+	offset++
+	kind := int(ctrl >> 5)
+
+	if kind == typePointer {
+		pointer, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer, depth+1)
+		return value, next, err
+	}
+
+	if kind == typeExtended {
+		if offset >= len(d.data) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		kind = 7 + int(d.data[offset])
+		offset++
+	}
+
+	size, offset, err := d.size(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Every element takes at least one byte and every map entry two: larger
+	// sizes come from corrupt files and must not size the allocations.
+	switch kind {
+	case typeMap:
+		if size > (len(d.data)-offset)/2 {
+			return nil, 0, errors.New("map exceeds data section")
+		}
+		result := make(map[string]any, size)
+		for range size {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			result[name] = value
+			offset = next
+		}
+		return result, offset, nil
+	case typeArray:
+		if size > len(d.data)-offset {
+			return nil, 0, errors.New("array exceeds data section")
+		}
+		result := make([]any, 0, size)
+		for range size {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			result = append(result, value)
+			offset = next
+		}
+		return result, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(d.data) {
+		return nil, 0, errors.New("value exceeds data section")
+	}
+	payload := d.data[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case typeString:
+		return string(payload), offset, nil
+	case typeBytes:
+		return bytes.Clone(payload), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(payload)), offset, nil
+	case typeUint16:
+		return uint16(uintFromBytes(payload)), offset, nil
+	case typeUint32:
+		return uint32(uintFromBytes(payload)), offset, nil
+	case typeInt32:
+		return int32(uint32(uintFromBytes(payload))), offset, nil
+	case typeUint64:
+		return uintFromBytes(payload), offset, nil
+	case typeUint128:
+		return new(big.Int).SetBytes(payload), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", kind)
+}
+
+func (d *decoder) size(ctrl byte, offset int) (int, int, error) {
+	size := int(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+	extra := size - 28
+	if offset+extra > len(d.data) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	value := int(uintFromBytes(d.data[offset : offset+extra]))
+	switch size {
+	case 29:
+		size = 29 + value
+	case 30:
+		size = 285 + value
+	default:
+		size = 65821 + value
+	}
+	return size, offset + extra, nil
+}
+
+func (d *decoder) pointer(ctrl byte, offset int) (int, int, error) {
+	length := int((ctrl>>3)&0x3) + 1
+	if offset+length > len(d.data) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	b := d.data[offset : offset+length]
+	var pointer int
+	switch length {
+	case 1:
+		pointer = int(ctrl&0x7)<<8 | int(b[0])
+	case 2:
+		pointer = (int(ctrl&0x7)<<16 | int(b[0])<<8 | int(b[1])) + 2048
+	case 3:
+		pointer = (int(ctrl&0x7)<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])) + 526336
+	default:
+		pointer = int(binary.BigEndian.Uint32(b))
+	}
+	return pointer, offset + length, nil
+}
+
+func uintFromBytes(b []byte) uint64 {
+	var value uint64
+	for _, c := range b {
+		value = value<<8 | uint64(c)
+	}
+	return value
+}
+
+func toUint64(value any) uint64 {
+	switch v := value.(type) {
+	case uint16:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	case uint64:
+		return v
+	case int32:
+		return uint64(v)
+	}
+	return 0
+}
+
+// Path walks nested maps and arrays of a decoded record, returning nil when any
+// step is missing. String keys index maps, int keys index arrays.
+func Path(record any, keys ...any) any {
+	current := record
+	for _, key := range keys {
+		switch k := key.(type) {
+		case string:
+			m, ok := current.(map[string]any)
+			if !ok {
+				return nil
+			}
+			current = m[k]
+		case int:
+			a, ok := current.([]any)
+			if !ok || k < 0 || k >= len(a) {
+				return nil
+			}
+			current = a[k]
+		default:
+			return nil
+		}
+	}
+	return current
+}
@@ -0,0 +1,20 @@
+package mmdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeRejectsOversizedContainers(t *testing.T) {
+	// The largest sizes the format can express, followed by nothing.
+	for name, data := range map[string][]byte{
+		"map":   {typeMap<<5 | 31, 0xff, 0xff, 0xff},
+		"array": {31, typeArray - 7, 0xff, 0xff, 0xff},
+	} {
+		d := decoder{data: data}
+		_, _, err := d.decode(0, 0)
+		if err == nil || !strings.Contains(err.Error(), "exceeds data section") {
+			t.Errorf("%s: expected a size error, got %v", name, err)
+		}
+	}
+}
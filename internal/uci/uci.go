@@ -0,0 +1,142 @@
+// Package uci reads OpenWrt UCI configuration files.
+//
+// Only the subset of the format written by the uci command line tool is
+// supported: "config", "option" and "list" statements, single or double
+// quoted values and "#" comments. Imports and packages are not supported.
+package uci
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Section is a single "config" block of a UCI file.
+type Section struct {
+	Type    string
+	Name    string
+	Options map[string][]string
+}
+
+// Get returns the last value of the named option, or "" if it is not set.
+func (s Section) Get(name string) string {
+	values := s.Options[name]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// Parse reads all sections from a UCI file.
+func Parse(r io.Reader) ([]Section, error) {
+	var sections []Section
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields, err := splitLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "config":
+			if len(fields) < 2 || len(fields) > 3 {
+				return nil, fmt.Errorf("line %d: malformed config statement", lineNo)
+			}
+			section := Section{Type: fields[1], Options: make(map[string][]string)}
+			if len(fields) == 3 {
+				section.Name = fields[2]
+			}
+			sections = append(sections, section)
+		case "option", "list":
+			if len(sections) == 0 {
+				return nil, fmt.Errorf("line %d: %s outside of a config section", lineNo, fields[0])
+			}
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: malformed %s statement", lineNo, fields[0])
+			}
+			current := &sections[len(sections)-1]
+			if fields[0] == "option" {
+				current.Options[fields[1]] = []string{fields[2]}
+			} else {
+				current.Options[fields[1]] = append(current.Options[fields[1]], fields[2])
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown statement %q", lineNo, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read uci file: %w", err)
+	}
+	return sections, nil
+}
+
+// ParseFile reads all sections from the UCI file at path.
+func ParseFile(path string) ([]Section, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	sections, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return sections, nil
+}
+
+// splitLine tokenizes a UCI line, honouring quotes and stripping comments.
+func splitLine(line string) ([]string, error) {
+	var (
+		fields  []string
+		current strings.Builder
+		quote   rune
+		inField bool
+	)
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+				continue
+			}
+			current.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inField = true
+		case c == '#':
+			if inField {
+				current.WriteRune(c)
+				continue
+			}
+			return finish(fields, &current, inField), nil
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+		default:
+			current.WriteRune(c)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	return finish(fields, &current, inField), nil
+}
+
+func finish(fields []string, current *strings.Builder, inField bool) []string {
+	if inField {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
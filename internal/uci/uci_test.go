@@ -0,0 +1,61 @@
+package uci
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("reads named and anonymous sections", func(t *testing.T) {
+		input := `
+# static leases
+config host 'laptop'
+	option name 'laptop-anna'
+	option mac 'AA:BB:CC:DD:EE:FF'
+	option ip "192.168.1.34"
+
+config dnsmasq
+	option domain lan # trailing comment
+	list server '1.1.1.1'
+	list server '8.8.8.8'
+`
+		sections, err := Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 2, len(sections))
+		assert.Equal(t, "host", sections[0].Type)
+		assert.Equal(t, "laptop", sections[0].Name)
+		assert.Equal(t, "laptop-anna", sections[0].Get("name"))
+		assert.Equal(t, "192.168.1.34", sections[0].Get("ip"))
+		assert.Equal(t, "dnsmasq", sections[1].Type)
+		assert.Equal(t, "", sections[1].Name)
+		assert.Equal(t, "lan", sections[1].Get("domain"))
+		assert.Equal(t, []string{"1.1.1.1", "8.8.8.8"}, sections[1].Options["server"])
+		assert.Equal(t, "", sections[1].Get("missing"))
+	})
+
+	t.Run("keeps hash characters inside values", func(t *testing.T) {
+		sections, err := Parse(strings.NewReader("config x\n\toption key 'a#b'\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "a#b", sections[0].Get("key"))
+	})
+
+	t.Run("rejects malformed input", func(t *testing.T) {
+		inputs := []string{
+			"option name value\n",
+			"config host\n\toption name 'unterminated\n",
+			"config host\n\tfoo bar baz\n",
+			"config host\n\toption name\n",
+		}
+		for _, input := range inputs {
+			if _, err := Parse(strings.NewReader(input)); err == nil {
+				t.Errorf("expected error for %q", input)
+			}
+		}
+	})
+}
@@ -0,0 +1,381 @@
+// Package inventory keeps a persistent record of every local device seen in
+// flows or aggregator stats, keyed by MAC address, and raises an alert the
+// first time a MAC address shows up. Several processes may share the same
+// database file.
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/nethserver/nethsecurity-monitoring/alert"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS devices (
+    mac TEXT PRIMARY KEY,
+    first_seen INTEGER NOT NULL,
+    last_seen INTEGER NOT NULL,
+    interface TEXT NOT NULL DEFAULT '',
+    known BOOLEAN NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS device_ips (
+    mac TEXT NOT NULL REFERENCES devices(mac) ON DELETE CASCADE,
+    ip TEXT NOT NULL,
+    first_seen INTEGER NOT NULL,
+    last_seen INTEGER NOT NULL,
+    PRIMARY KEY (mac, ip)
+);
+`
+
+// Device is an inventory entry. Times are Unix seconds.
+type Device struct {
+	Mac       string     `json:"mac"`
+	FirstSeen int64      `json:"first_seen"`
+	LastSeen  int64      `json:"last_seen"`
+	Interface string     `json:"interface,omitempty"`
+	Known     bool       `json:"known"`
+	Ips       []DeviceIp `json:"ips"`
+}
+
+// DeviceIp is an address used by a device.
+type DeviceIp struct {
+	Ip        string `json:"ip"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+}
+
+// Sighting records a device seen at a point in time.
+type Sighting struct {
+	Mac       string
+	Ip        string
+	Interface string
+	Time      time.Time
+}
+
+// sightingKey deduplicates pending sightings.
+type sightingKey struct {
+	mac string
+	ip  string
+}
+
+// Inventory stores devices in SQLite. Sightings are buffered in memory and
+// written by Flush, which Run calls periodically.
+type Inventory struct {
+	db   *sql.DB
+	sink alert.Sink
+
+	mu      sync.Mutex
+	pending map[sightingKey]Sighting
+}
+
+// Open opens or creates the inventory database at path. New devices are
+// published to sink, which may be nil.
+func Open(ctx context.Context, path string, sink alert.Sink) (*Inventory, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open device inventory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = db.Close()
+		}
+	}()
+
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+
+	settings := []string{
+		"PRAGMA foreign_keys = ON;",
+		"PRAGMA busy_timeout = 5000;",
+		"PRAGMA synchronous = NORMAL;",
+	}
+	if path != ":memory:" {
+		settings = append(settings, "PRAGMA journal_mode = WAL;")
+	}
+	for _, pragma := range settings {
+		if _, err = db.ExecContext(ctx, pragma); err != nil {
+			return nil, fmt.Errorf("apply sqlite pragma %q: %w", pragma, err)
+		}
+	}
+	if _, err = db.ExecContext(ctx, schema); err != nil {
+		return nil, fmt.Errorf("initialize device inventory schema: %w", err)
+	}
+
+	return &Inventory{db: db, sink: sink, pending: make(map[sightingKey]Sighting)}, nil
+}
+
+// Close closes the database. Call Flush first to persist pending sightings.
+func (i *Inventory) Close() error {
+	return i.db.Close()
+}
+
+// normalizeMac returns mac in lower case, or false when it is not the unicast
+// address of a device.
+func normalizeMac(mac string) (string, bool) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 || hw[0]&1 == 1 {
+		return "", false
+	}
+	if hw.String() == "00:00:00:00:00:00" {
+		return "", false
+	}
+	return hw.String(), true
+}
+
+// Observe buffers a sighting. Invalid, multicast and all-zero MAC addresses
+// are ignored.
+func (i *Inventory) Observe(sighting Sighting) {
+	mac, ok := normalizeMac(sighting.Mac)
+	if !ok {
+		return
+	}
+	sighting.Mac = mac
+	sighting.Ip = strings.TrimSpace(sighting.Ip)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	key := sightingKey{mac: mac, ip: sighting.Ip}
+	if previous, ok := i.pending[key]; ok && previous.Time.After(sighting.Time) {
+		return
+	}
+	i.pending[key] = sighting
+}
+
+// ObserveFlows buffers the local endpoint of every complete flow in events.
+func (i *Inventory) ObserveFlows(events map[string]flows.FlowEvent) {
+	for _, event := range events {
+		flow, ok := event.Flow.(flows.FlowComplete)
+		if !ok {
+			continue
+		}
+		i.Observe(Sighting{
+			Mac:       flow.LocalMac,
+			Ip:        flow.LocalIp,
+			Interface: event.Interface,
+			Time:      time.UnixMilli(flow.LastSeenAt),
+		})
+	}
+}
+
+// Run flushes pending sightings every interval until ctx is cancelled, then
+// flushes once more.
+func (i *Inventory) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := i.Flush(ctx); err != nil {
+				slog.Error("Failed to update device inventory", "error", err)
+			}
+		case <-ctx.Done():
+			if err := i.Flush(context.Background()); err != nil {
+				slog.Error("Failed to update device inventory", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// Flush writes pending sightings and publishes a new_device alert for every
+// MAC address added to the inventory.
+func (i *Inventory) Flush(ctx context.Context) error {
+	i.mu.Lock()
+	pending := i.pending
+	i.pending = make(map[sightingKey]Sighting)
+	i.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	added, err := i.write(ctx, pending)
+	if err != nil {
+		// Keep the sightings for the next attempt, unless newer ones arrived.
+		i.mu.Lock()
+		for key, sighting := range pending {
+			if _, ok := i.pending[key]; !ok {
+				i.pending[key] = sighting
+			}
+		}
+		i.mu.Unlock()
+		return err
+	}
+
+	if i.sink != nil {
+		for _, sighting := range added {
+			i.sink.Publish(alert.Alert{
+				Time:    sighting.Time,
+				Type:    alert.TypeNewDevice,
+				Message: fmt.Sprintf("New device %s seen with address %s", sighting.Mac, sighting.Ip),
+				Details: NewDevice{
+					Mac:       sighting.Mac,
+					Ip:        sighting.Ip,
+					Interface: sighting.Interface,
+					FirstSeen: sighting.Time.Unix(),
+				},
+			})
+		}
+	}
+	return nil
+}
+
+// NewDevice is the evidence attached to new_device alerts.
+type NewDevice struct {
+	Mac       string `json:"mac"`
+	Ip        string `json:"ip,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	FirstSeen int64  `json:"first_seen"`
+}
+
+// write stores sightings in one transaction and returns those that added a
+// device.
+func (i *Inventory) write(
+	ctx context.Context,
+	sightings map[sightingKey]Sighting,
+) ([]Sighting, error) {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin inventory transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var added []Sighting
+	for _, sighting := range sightings {
+		seen := sighting.Time.Unix()
+		var result sql.Result
+		result, err = tx.ExecContext(ctx, `
+INSERT INTO devices (mac, first_seen, last_seen, interface)
+VALUES (?1, ?2, ?2, ?3)
+ON CONFLICT (mac) DO NOTHING
+`, sighting.Mac, seen, sighting.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("insert device %s: %w", sighting.Mac, err)
+		}
+		var rows int64
+		if rows, err = result.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("insert device %s: %w", sighting.Mac, err)
+		}
+		if rows == 1 {
+			added = append(added, sighting)
+		} else if _, err = tx.ExecContext(ctx, `
+UPDATE devices
+SET
+    first_seen = MIN(first_seen, ?2),
+    interface = CASE WHEN ?2 >= last_seen AND ?3 != '' THEN ?3 ELSE interface END,
+    last_seen = MAX(last_seen, ?2)
+WHERE mac = ?1
+`, sighting.Mac, seen, sighting.Interface); err != nil {
+			return nil, fmt.Errorf("update device %s: %w", sighting.Mac, err)
+		}
+
+		if sighting.Ip == "" {
+			continue
+		}
+		if _, err = tx.ExecContext(ctx, `
+INSERT INTO device_ips (mac, ip, first_seen, last_seen)
+VALUES (?1, ?2, ?3, ?3)
+ON CONFLICT (mac, ip) DO UPDATE SET
+    first_seen = MIN(first_seen, excluded.first_seen),
+    last_seen = MAX(last_seen, excluded.last_seen)
+`, sighting.Mac, sighting.Ip, seen); err != nil {
+			return nil, fmt.Errorf("record address of device %s: %w", sighting.Mac, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit inventory transaction: %w", err)
+	}
+	return added, nil
+}
+
+// Devices lists the inventory sorted by MAC address. With onlyUnknown, devices
+// marked as known are left out.
+func (i *Inventory) Devices(ctx context.Context, onlyUnknown bool) ([]Device, error) {
+	rows, err := i.db.QueryContext(ctx, `
+SELECT d.mac, d.first_seen, d.last_seen, d.interface, d.known,
+    COALESCE(a.ip, ''), COALESCE(a.first_seen, 0), COALESCE(a.last_seen, 0)
+FROM devices d
+LEFT JOIN device_ips a ON a.mac = d.mac
+WHERE NOT (?1 AND d.known)
+ORDER BY d.mac, a.last_seen DESC, a.ip
+`, onlyUnknown)
+	if err != nil {
+		return nil, fmt.Errorf("query devices: %w", err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	devices := make([]Device, 0)
+	for rows.Next() {
+		var device Device
+		var ip DeviceIp
+		if err := rows.Scan(
+			&device.Mac,
+			&device.FirstSeen,
+			&device.LastSeen,
+			&device.Interface,
+			&device.Known,
+			&ip.Ip,
+			&ip.FirstSeen,
+			&ip.LastSeen,
+		); err != nil {
+			return nil, fmt.Errorf("scan device: %w", err)
+		}
+		if n := len(devices); n == 0 || devices[n-1].Mac != device.Mac {
+			device.Ips = make([]DeviceIp, 0, 1)
+			devices = append(devices, device)
+		}
+		if ip.Ip != "" {
+			last := &devices[len(devices)-1]
+			last.Ips = append(last.Ips, ip)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate devices: %w", err)
+	}
+	return devices, nil
+}
+
+// ErrUnknownDevice is returned by SetKnown for MAC addresses missing from the
+// inventory.
+var ErrUnknownDevice = errors.New("device not in inventory")
+
+// SetKnown marks a device as known or unknown.
+func (i *Inventory) SetKnown(ctx context.Context, mac string, known bool) error {
+	normalized, ok := normalizeMac(mac)
+	if !ok {
+		return ErrUnknownDevice
+	}
+	result, err := i.db.ExecContext(
+		ctx,
+		`UPDATE devices SET known = ? WHERE mac = ?`,
+		known,
+		normalized,
+	)
+	if err != nil {
+		return fmt.Errorf("update device %s: %w", normalized, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update device %s: %w", normalized, err)
+	}
+	if rows == 0 {
+		return ErrUnknownDevice
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/alert"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+type memorySink struct {
+	alerts []alert.Alert
+}
+
+func (m *memorySink) Publish(a alert.Alert) {
+	m.alerts = append(m.alerts, a)
+}
+
+func TestInventory(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "devices.db")
+	sink := &memorySink{}
+	inv, err := Open(ctx, path, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	inv.ObserveFlows(map[string]flows.FlowEvent{
+		"f-001": {
+			Type:      flows.FlowTypeDpiComplete,
+			Interface: "br-lan",
+			Flow: flows.FlowComplete{
+				LocalMac:   "AA:BB:CC:DD:EE:01",
+				LocalIp:    "192.168.1.10",
+				LastSeenAt: start.UnixMilli(),
+			},
+		},
+		// Multicast and empty MAC addresses are not devices.
+		"f-002": {
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{LocalMac: "01:00:5e:00:00:fb", LocalIp: "224.0.0.251"},
+		},
+		"f-003": {Type: flows.FlowTypeDpiComplete, Flow: flows.FlowComplete{LocalIp: "10.0.0.1"}},
+	})
+	inv.Observe(Sighting{
+		Mac:       "aa:bb:cc:dd:ee:02",
+		Ip:        "192.168.2.20",
+		Interface: "br-guest",
+		Time:      start.Add(time.Minute),
+	})
+	if err := inv.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(sink.alerts))
+	assert.Equal(t, alert.TypeNewDevice, sink.alerts[0].Type)
+
+	// Seen again with a new address: no alert, last seen and addresses updated.
+	inv.Observe(Sighting{
+		Mac:       "aa:bb:cc:dd:ee:01",
+		Ip:        "192.168.1.11",
+		Interface: "br-lan",
+		Time:      start.Add(time.Hour),
+	})
+	if err := inv.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(sink.alerts))
+	if err := inv.SetKnown(ctx, "AA-BB-CC-DD-EE-02", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := inv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The inventory survives a restart, including for other processes.
+	inv, err = Open(ctx, path, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inv.Close() //nolint:errcheck
+
+	later := start.Add(time.Hour)
+	guest := start.Add(time.Minute)
+	devices, err := inv.Devices(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Device{
+		{
+			Mac:       "aa:bb:cc:dd:ee:01",
+			FirstSeen: start.Unix(),
+			LastSeen:  later.Unix(),
+			Interface: "br-lan",
+			Ips: []DeviceIp{
+				{Ip: "192.168.1.11", FirstSeen: later.Unix(), LastSeen: later.Unix()},
+				{Ip: "192.168.1.10", FirstSeen: start.Unix(), LastSeen: start.Unix()},
+			},
+		},
+		{
+			Mac:       "aa:bb:cc:dd:ee:02",
+			FirstSeen: guest.Unix(),
+			LastSeen:  guest.Unix(),
+			Interface: "br-guest",
+			Known:     true,
+			Ips: []DeviceIp{
+				{Ip: "192.168.2.20", FirstSeen: guest.Unix(), LastSeen: guest.Unix()},
+			},
+		},
+	}, devices)
+
+	unknown, err := inv.Devices(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(unknown))
+	assert.Equal(t, "aa:bb:cc:dd:ee:01", unknown[0].Mac)
+
+	if err := inv.SetKnown(ctx, "aa:bb:cc:dd:ee:99", true); !errors.Is(err, ErrUnknownDevice) {
+		t.Fatalf("expected ErrUnknownDevice, got %v", err)
+	}
+}
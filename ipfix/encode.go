@@ -0,0 +1,206 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// Protocol constants from RFC 7011.
+const (
+	version          = 10
+	headerLen        = 16
+	setHeaderLen     = 4
+	templateSetID    = 2
+	templateIPv4     = 256
+	templateIPv6     = 257
+	variableLength   = 0xffff
+	enterpriseBit    = 0x8000
+	maxUDPMessageLen = 1400
+	maxTCPMessageLen = 65535
+)
+
+// Information elements from the IANA IPFIX registry.
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieSourceMacAddress         = 56
+	ieVlanId                   = 58
+	ieFlowEndReason            = 136
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+	ieInitiatorOctets          = 231
+	ieResponderOctets          = 232
+)
+
+// Enterprise-specific elements, scoped by the configured private enterprise
+// number. They are left out of the templates when none is configured.
+const (
+	ieNdpiApplicationId   = 1
+	ieNdpiApplicationName = 2
+	ieNdpiProtocolId      = 3
+	ieNdpiProtocolName    = 4
+	ieNdpiRiskScore       = 5
+)
+
+// flowEndReason values.
+const (
+	endIdleTimeout    = 1
+	endOfFlowDetected = 3
+)
+
+type field struct {
+	id         uint16
+	length     uint16
+	enterprise bool
+}
+
+// templateFields lists the fields of a template, in record order.
+func templateFields(ipv6, enterprise bool) []field {
+	src, dst, addrLen := uint16(ieSourceIPv4Address), uint16(ieDestinationIPv4Address), uint16(4)
+	if ipv6 {
+		src, dst, addrLen = ieSourceIPv6Address, ieDestinationIPv6Address, 16
+	}
+	fields := []field{
+		{id: ieFlowStartMilliseconds, length: 8},
+		{id: ieFlowEndMilliseconds, length: 8},
+		{id: src, length: addrLen},
+		{id: dst, length: addrLen},
+		{id: ieSourceTransportPort, length: 2},
+		{id: ieDestinationTransportPort, length: 2},
+		{id: ieProtocolIdentifier, length: 1},
+		{id: ieOctetDeltaCount, length: 8},
+		{id: iePacketDeltaCount, length: 8},
+		{id: ieInitiatorOctets, length: 8},
+		{id: ieResponderOctets, length: 8},
+		{id: ieFlowEndReason, length: 1},
+		{id: ieVlanId, length: 2},
+		{id: ieSourceMacAddress, length: 6},
+	}
+	if !enterprise {
+		return fields
+	}
+	return append(fields,
+		field{id: ieNdpiApplicationId, length: 4, enterprise: true},
+		field{id: ieNdpiApplicationName, length: variableLength, enterprise: true},
+		field{id: ieNdpiProtocolId, length: 4, enterprise: true},
+		field{id: ieNdpiProtocolName, length: variableLength, enterprise: true},
+		field{id: ieNdpiRiskScore, length: 4, enterprise: true},
+	)
+}
+
+// appendTemplateSet encodes a template set defining both templates. The
+// enterprise fields are included only when pen is not zero.
+func appendTemplateSet(buf []byte, pen uint32) []byte {
+	start := len(buf)
+	buf = binary.BigEndian.AppendUint16(buf, templateSetID)
+	buf = binary.BigEndian.AppendUint16(buf, 0)
+	for _, template := range []struct {
+		id   uint16
+		ipv6 bool
+	}{{templateIPv4, false}, {templateIPv6, true}} {
+		fields := templateFields(template.ipv6, pen != 0)
+		buf = binary.BigEndian.AppendUint16(buf, template.id)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(fields)))
+		for _, f := range fields {
+			if f.enterprise {
+				buf = binary.BigEndian.AppendUint16(buf, f.id|enterpriseBit)
+				buf = binary.BigEndian.AppendUint16(buf, f.length)
+				buf = binary.BigEndian.AppendUint32(buf, pen)
+				continue
+			}
+			buf = binary.BigEndian.AppendUint16(buf, f.id)
+			buf = binary.BigEndian.AppendUint16(buf, f.length)
+		}
+	}
+	binary.BigEndian.PutUint16(buf[start+2:], uint16(len(buf)-start))
+	return buf
+}
+
+// record is the exported view of a finished flow.
+type record struct {
+	start, end      int64
+	src, dst        netip.Addr
+	srcPort         uint16
+	dstPort         uint16
+	protocol        uint8
+	octets          uint64
+	packets         uint64
+	initiatorOctets uint64
+	responderOctets uint64
+	endReason       uint8
+	vlan            uint16
+	srcMac          [6]byte
+	applicationId   uint32
+	applicationName string
+	protocolId      uint32
+	protocolName    string
+	riskScore       uint32
+}
+
+func (r record) templateID() uint16 {
+	if r.src.Is6() {
+		return templateIPv6
+	}
+	return templateIPv4
+}
+
+// appendRecord encodes r following the fields of its template.
+func appendRecord(buf []byte, r record, enterprise bool) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, uint64(r.start))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(r.end))
+	buf = append(buf, r.src.AsSlice()...)
+	buf = append(buf, r.dst.AsSlice()...)
+	buf = binary.BigEndian.AppendUint16(buf, r.srcPort)
+	buf = binary.BigEndian.AppendUint16(buf, r.dstPort)
+	buf = append(buf, r.protocol)
+	buf = binary.BigEndian.AppendUint64(buf, r.octets)
+	buf = binary.BigEndian.AppendUint64(buf, r.packets)
+	buf = binary.BigEndian.AppendUint64(buf, r.initiatorOctets)
+	buf = binary.BigEndian.AppendUint64(buf, r.responderOctets)
+	buf = append(buf, r.endReason)
+	buf = binary.BigEndian.AppendUint16(buf, r.vlan)
+	buf = append(buf, r.srcMac[:]...)
+	if !enterprise {
+		return buf
+	}
+	buf = binary.BigEndian.AppendUint32(buf, r.applicationId)
+	buf = appendVariable(buf, r.applicationName)
+	buf = binary.BigEndian.AppendUint32(buf, r.protocolId)
+	buf = appendVariable(buf, r.protocolName)
+	return binary.BigEndian.AppendUint32(buf, r.riskScore)
+}
+
+// appendVariable encodes a variable-length string (RFC 7011, section 7).
+func appendVariable(buf []byte, value string) []byte {
+	if len(value) > 0xfffe {
+		value = value[:0xfffe]
+	}
+	if len(value) < 255 {
+		buf = append(buf, byte(len(value)))
+	} else {
+		buf = append(buf, 255)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	}
+	return append(buf, value...)
+}
+
+// appendHeader encodes a message header; the length is set by finishMessage.
+func appendHeader(buf []byte, exportTime uint32, sequence, domainID uint32) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, version)
+	buf = binary.BigEndian.AppendUint16(buf, 0)
+	buf = binary.BigEndian.AppendUint32(buf, exportTime)
+	buf = binary.BigEndian.AppendUint32(buf, sequence)
+	return binary.BigEndian.AppendUint32(buf, domainID)
+}
+
+func finishMessage(buf []byte) []byte {
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)))
+	return buf
+}
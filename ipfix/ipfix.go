@@ -0,0 +1,313 @@
+// Package ipfix exports finished flows to an IPFIX collector (RFC 7011) over
+// UDP or TCP. Besides the standard flow keys and counters, records carry the
+// nDPI application, protocol and risk score as enterprise-specific fields
+// when a private enterprise number is configured.
+package ipfix
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// Exporter queues finished flows and sends them in batches. It implements
+// flows.FlowSink.
+type Exporter struct {
+	network          string
+	addr             string
+	pen              uint32
+	domainID         uint32
+	templateInterval time.Duration
+	flushInterval    time.Duration
+
+	queue   chan record
+	dropped atomic.Uint64
+
+	conn          net.Conn
+	retryAt       time.Time
+	sequence      uint32
+	templatesSent time.Time
+}
+
+// Option configures optional Exporter features.
+type Option func(*Exporter)
+
+// WithEnterpriseNumber sets the private enterprise number scoping the nDPI
+// fields. Without it, or with zero, the nDPI fields are not exported.
+func WithEnterpriseNumber(pen uint32) Option {
+	return func(e *Exporter) {
+		e.pen = pen
+	}
+}
+
+// WithObservationDomain sets the observation domain ID of the messages.
+func WithObservationDomain(id uint32) Option {
+	return func(e *Exporter) {
+		e.domainID = id
+	}
+}
+
+// WithTemplateInterval sets how often templates are sent again. Collectors
+// listening on UDP forget templates, so they must be refreshed.
+func WithTemplateInterval(interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.templateInterval = interval
+	}
+}
+
+// WithFlushInterval sets how long records wait to be batched.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.flushInterval = interval
+	}
+}
+
+// New creates an exporter sending to collector, given as "udp://host:port"
+// or "tcp://host:port". Call Run to start sending.
+func New(collector string, opts ...Option) (*Exporter, error) {
+	parsed, err := url.Parse(collector)
+	if err != nil {
+		return nil, fmt.Errorf("parse ipfix collector: %w", err)
+	}
+	if parsed.Scheme != "udp" && parsed.Scheme != "tcp" {
+		return nil, fmt.Errorf("ipfix collector %q: scheme must be udp or tcp", collector)
+	}
+	if parsed.Port() == "" {
+		return nil, fmt.Errorf("ipfix collector %q: missing port", collector)
+	}
+
+	e := &Exporter{
+		network:          parsed.Scheme,
+		addr:             parsed.Host,
+		templateInterval: time.Minute,
+		flushInterval:    time.Second,
+		queue:            make(chan record, 4096),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e, nil
+}
+
+// FlowFinished queues a flow for export. Flows are dropped when the queue is
+// full, for example while the collector is unreachable.
+func (e *Exporter) FlowFinished(event flows.FlowEvent, reason string) {
+	rec, ok := newRecord(event, reason)
+	if !ok {
+		return
+	}
+	select {
+	case e.queue <- rec:
+	default:
+		if e.dropped.Add(1)%1000 == 1 {
+			slog.Warn("IPFIX queue full, dropping flows", "dropped", e.dropped.Load())
+		}
+	}
+}
+
+// Run sends queued records until ctx is cancelled, then flushes what is left.
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+	defer e.close()
+
+	var pending []record
+	for {
+		select {
+		case rec := <-e.queue:
+			pending = append(pending, rec)
+			if len(pending) >= 512 {
+				pending = e.send(pending)
+			}
+		case <-ticker.C:
+			pending = e.send(pending)
+		case <-ctx.Done():
+			for {
+				select {
+				case rec := <-e.queue:
+					pending = append(pending, rec)
+				default:
+					e.send(pending)
+					return
+				}
+			}
+		}
+	}
+}
+
+// send writes records, preceded by the templates when they are due. It
+// returns the records that could not be sent, which are retried on the next
+// call unless too many are waiting.
+func (e *Exporter) send(records []record) []record {
+	templatesDue := e.conn == nil || time.Since(e.templatesSent) >= e.templateInterval
+	if len(records) == 0 && !templatesDue {
+		return records
+	}
+	if e.conn == nil {
+		if time.Now().Before(e.retryAt) {
+			return e.keep(records)
+		}
+		conn, err := net.DialTimeout(e.network, e.addr, 5*time.Second)
+		if err != nil {
+			slog.Error("Failed to connect to IPFIX collector", "addr", e.addr, "error", err)
+			e.retryAt = time.Now().Add(10 * time.Second)
+			return e.keep(records)
+		}
+		e.conn = conn
+	}
+
+	for _, msg := range e.messages(records, templatesDue) {
+		if _, err := e.conn.Write(msg.data); err != nil {
+			slog.Error("Failed to send IPFIX message", "addr", e.addr, "error", err)
+			e.close()
+			return e.keep(records)
+		}
+		// Records of the messages already sent are not retried.
+		records = records[msg.records:]
+		e.sequence += uint32(msg.records)
+	}
+	if templatesDue {
+		e.templatesSent = time.Now()
+	}
+	return records
+}
+
+// keep bounds the records retained while the collector is unreachable.
+func (e *Exporter) keep(records []record) []record {
+	const limit = 4096
+	if len(records) > limit {
+		e.dropped.Add(uint64(len(records) - limit))
+		return records[len(records)-limit:]
+	}
+	return records
+}
+
+func (e *Exporter) close() {
+	if e.conn == nil {
+		return
+	}
+	if err := e.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Debug("Failed to close IPFIX connection", "error", err)
+	}
+	e.conn = nil
+}
+
+// message is an encoded message and the number of records it carries.
+type message struct {
+	data    []byte
+	records int
+}
+
+// messages splits records into messages fitting the transport.
+func (e *Exporter) messages(records []record, withTemplates bool) []message {
+	maxLen := maxUDPMessageLen
+	if e.network == "tcp" {
+		maxLen = maxTCPMessageLen
+	}
+	exportTime := uint32(time.Now().Unix())
+	sequence := e.sequence
+
+	var messages []message
+	count := 0
+	msg := appendHeader(nil, exportTime, sequence, e.domainID)
+	if withTemplates {
+		msg = appendTemplateSet(msg, e.pen)
+	}
+	setStart, setID := -1, uint16(0)
+	closeSet := func() {
+		if setStart >= 0 {
+			binary.BigEndian.PutUint16(msg[setStart+2:], uint16(len(msg)-setStart))
+			setStart = -1
+		}
+	}
+	for _, rec := range records {
+		encoded := appendRecord(nil, rec, e.pen != 0)
+		needed := len(encoded)
+		if setStart < 0 || setID != rec.templateID() {
+			needed += setHeaderLen
+		}
+		if len(msg)+needed > maxLen && len(msg) > headerLen {
+			closeSet()
+			messages = append(messages, message{data: finishMessage(msg), records: count})
+			msg, count = appendHeader(nil, exportTime, sequence, e.domainID), 0
+		}
+		if setStart < 0 || setID != rec.templateID() {
+			closeSet()
+			setStart, setID = len(msg), rec.templateID()
+			msg = binary.BigEndian.AppendUint16(msg, setID)
+			msg = binary.BigEndian.AppendUint16(msg, 0)
+		}
+		msg = append(msg, encoded...)
+		sequence++
+		count++
+	}
+	closeSet()
+	if len(msg) > headerLen {
+		messages = append(messages, message{data: finishMessage(msg), records: count})
+	}
+	return messages
+}
+
+// newRecord converts a finished flow. The flow initiator is the source.
+func newRecord(event flows.FlowEvent, reason string) (record, bool) {
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok {
+		return record{}, false
+	}
+	local, err := netip.ParseAddr(flow.LocalIp)
+	if err != nil {
+		return record{}, false
+	}
+	other, err := netip.ParseAddr(flow.OtherIp)
+	if err != nil {
+		return record{}, false
+	}
+	local, other = local.Unmap(), other.Unmap()
+	if local.Is4() != other.Is4() {
+		return record{}, false
+	}
+
+	rec := record{
+		start:           flow.FirstSeenAt,
+		end:             flow.LastSeenAt,
+		src:             local,
+		dst:             other,
+		srcPort:         uint16(flow.LocalPort),
+		dstPort:         uint16(flow.OtherPort),
+		protocol:        uint8(flow.IpProtocol),
+		octets:          uint64(max(flow.TotalBytes, 0)),
+		packets:         uint64(max(flow.TotalPackets, 0)),
+		initiatorOctets: uint64(max(flow.LocalBytes, 0)),
+		responderOctets: uint64(max(flow.OtherBytes, 0)),
+		endReason:       endIdleTimeout,
+		vlan:            uint16(flow.VlanId),
+		applicationId:   uint32(flow.DetectedApplication),
+		applicationName: flow.DetectedApplicationName,
+		protocolId:      uint32(flow.DetectedProtocol),
+		protocolName:    flow.DetectedProtocolName,
+		riskScore:       uint32(max(flow.Risks.NdpiRiskScore, 0)),
+	}
+	mac := flow.LocalMac
+	if !flow.LocalOrigin {
+		rec.src, rec.dst = other, local
+		rec.srcPort, rec.dstPort = rec.dstPort, rec.srcPort
+		rec.initiatorOctets, rec.responderOctets = rec.responderOctets, rec.initiatorOctets
+		mac = flow.OtherMac
+	}
+	if hw, err := net.ParseMAC(mac); err == nil && len(hw) == 6 {
+		copy(rec.srcMac[:], hw)
+	}
+	if reason == flows.FinishPurged && event.Reason == "closed" {
+		rec.endReason = endOfFlowDetected
+	}
+	return rec, true
+}
@@ -0,0 +1,365 @@
+package ipfix
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+type fieldKey struct {
+	id  uint16
+	pen uint32
+}
+
+func std(id uint16) fieldKey { return fieldKey{id: id} }
+
+// testPen is the private enterprise number reserved for documentation.
+const testPen = 32473
+
+func ent(id uint16) fieldKey { return fieldKey{id: id, pen: testPen} }
+
+type decodedMessage struct {
+	sequence  uint32
+	domainID  uint32
+	templates map[uint16][]fieldKey
+	records   []map[fieldKey][]byte
+}
+
+// decoder is a minimal collector keeping templates across messages.
+type decoder struct {
+	templates map[uint16][]field
+	pens      map[uint16][]uint32
+}
+
+func (d *decoder) decode(t *testing.T, msg []byte) decodedMessage {
+	t.Helper()
+	if len(msg) < headerLen || binary.BigEndian.Uint16(msg) != version {
+		t.Fatalf("invalid message header")
+	}
+	if int(binary.BigEndian.Uint16(msg[2:])) != len(msg) {
+		t.Fatalf("message length %d does not match %d", binary.BigEndian.Uint16(msg[2:]), len(msg))
+	}
+	out := decodedMessage{
+		sequence:  binary.BigEndian.Uint32(msg[8:]),
+		domainID:  binary.BigEndian.Uint32(msg[12:]),
+		templates: make(map[uint16][]fieldKey),
+	}
+	for rest := msg[headerLen:]; len(rest) > 0; {
+		setID := binary.BigEndian.Uint16(rest)
+		setLen := int(binary.BigEndian.Uint16(rest[2:]))
+		body := rest[setHeaderLen:setLen]
+		rest = rest[setLen:]
+
+		if setID == templateSetID {
+			for len(body) > 0 {
+				id := binary.BigEndian.Uint16(body)
+				count := int(binary.BigEndian.Uint16(body[2:]))
+				body = body[4:]
+				var fields []field
+				var pens []uint32
+				for range count {
+					f := field{id: binary.BigEndian.Uint16(body), length: binary.BigEndian.Uint16(body[2:])}
+					body = body[4:]
+					var pen uint32
+					if f.id&enterpriseBit != 0 {
+						f.id &^= enterpriseBit
+						f.enterprise = true
+						pen = binary.BigEndian.Uint32(body)
+						body = body[4:]
+					}
+					fields = append(fields, f)
+					pens = append(pens, pen)
+					out.templates[id] = append(out.templates[id], fieldKey{f.id, pen})
+				}
+				d.templates[id], d.pens[id] = fields, pens
+			}
+			continue
+		}
+
+		fields, ok := d.templates[setID]
+		if !ok {
+			t.Fatalf("data set %d received before its template", setID)
+		}
+		for len(body) > 0 {
+			values := make(map[fieldKey][]byte)
+			for i, f := range fields {
+				length := int(f.length)
+				if f.length == variableLength {
+					length, body = int(body[0]), body[1:]
+					if length == 255 {
+						length, body = int(binary.BigEndian.Uint16(body)), body[2:]
+					}
+				}
+				values[fieldKey{f.id, d.pens[setID][i]}] = body[:length]
+				body = body[length:]
+			}
+			out.records = append(out.records, values)
+		}
+	}
+	return out
+}
+
+func listen(t *testing.T) (*net.UDPConn, *decoder) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+	return conn, &decoder{templates: make(map[uint16][]field), pens: make(map[uint16][]uint32)}
+}
+
+func receive(t *testing.T, conn *net.UDPConn, d *decoder) decodedMessage {
+	t.Helper()
+	buf := make([]byte, 65535)
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.decode(t, buf[:n])
+}
+
+func completedFlow(digest, localIp, otherIp string, localOrigin bool) flows.FlowEvent {
+	flow := flows.FlowComplete{
+		FlowBase:                flows.FlowBase{Digest: digest},
+		DetectedApplication:     119,
+		DetectedApplicationName: "netify.youtube",
+		DetectedProtocol:        188,
+		DetectedProtocolName:    "QUIC",
+		FirstSeenAt:             1760000000000,
+		LastSeenAt:              1760000060000,
+		IpProtocol:              17,
+		LocalIp:                 localIp,
+		LocalMac:                "aa:bb:cc:dd:ee:ff",
+		LocalOrigin:             localOrigin,
+		LocalPort:               51000,
+		OtherIp:                 otherIp,
+		OtherMac:                "11:22:33:44:55:66",
+		OtherPort:               443,
+		Stats: flows.Stats{
+			LocalBytes:   1000,
+			OtherBytes:   9000,
+			TotalBytes:   10000,
+			TotalPackets: 20,
+		},
+		VlanId: 10,
+	}
+	flow.Risks.NdpiRiskScore = 60
+	return flows.FlowEvent{Type: flows.FlowTypeDpiComplete, Flow: flow}
+}
+
+func TestExporter(t *testing.T) {
+	conn, d := listen(t)
+	exporter, err := New(
+		"udp://"+conn.LocalAddr().String(),
+		WithEnterpriseNumber(testPen),
+		WithObservationDomain(7),
+		WithTemplateInterval(time.Hour),
+		WithFlushInterval(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purged := completedFlow("f-001", "192.168.1.10", "142.250.80.46", true)
+	purged.Reason = "closed"
+	exporter.FlowFinished(purged, flows.FinishPurged)
+	remote := completedFlow("f-002", "fd00::10", "2001:db8::1", false)
+	exporter.FlowFinished(remote, flows.FinishExpired)
+	// Only completed flows are exported.
+	stats := flows.FlowEvent{Type: flows.FlowTypeStats, Flow: flows.FlowStats{}}
+	exporter.FlowFinished(stats, flows.FinishExpired)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exporter.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	msg := receive(t, conn, d)
+	assert.Equal(t, uint32(0), msg.sequence)
+	assert.Equal(t, uint32(7), msg.domainID)
+	assert.Equal(t, 2, len(msg.templates))
+	assert.Equal(t, ent(ieNdpiApplicationName), msg.templates[templateIPv4][15])
+	assert.Equal(t, 2, len(msg.records))
+
+	v4 := msg.records[0]
+	ip := func(s string) []byte { return netip.MustParseAddr(s).AsSlice() }
+	assert.Equal(t, ip("192.168.1.10"), v4[std(ieSourceIPv4Address)])
+	assert.Equal(t, ip("142.250.80.46"), v4[std(ieDestinationIPv4Address)])
+	assert.Equal(t, uint16(51000), binary.BigEndian.Uint16(v4[std(ieSourceTransportPort)]))
+	assert.Equal(t, uint16(443), binary.BigEndian.Uint16(v4[std(ieDestinationTransportPort)]))
+	assert.Equal(t, []byte{17}, v4[std(ieProtocolIdentifier)])
+	assert.Equal(t, uint64(10000), binary.BigEndian.Uint64(v4[std(ieOctetDeltaCount)]))
+	assert.Equal(t, uint64(20), binary.BigEndian.Uint64(v4[std(iePacketDeltaCount)]))
+	assert.Equal(t, uint64(1000), binary.BigEndian.Uint64(v4[std(ieInitiatorOctets)]))
+	end := binary.BigEndian.Uint64(v4[std(ieFlowEndMilliseconds)])
+	assert.Equal(t, uint64(1760000060000), end)
+	assert.Equal(t, []byte{endOfFlowDetected}, v4[std(ieFlowEndReason)])
+	assert.Equal(t, []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}, v4[std(ieSourceMacAddress)])
+	assert.Equal(t, uint16(10), binary.BigEndian.Uint16(v4[std(ieVlanId)]))
+	assert.Equal(t, uint32(119), binary.BigEndian.Uint32(v4[ent(ieNdpiApplicationId)]))
+	assert.Equal(t, "netify.youtube", string(v4[ent(ieNdpiApplicationName)]))
+	assert.Equal(t, "QUIC", string(v4[ent(ieNdpiProtocolName)]))
+	assert.Equal(t, uint32(60), binary.BigEndian.Uint32(v4[ent(ieNdpiRiskScore)]))
+
+	// Remote-initiated flows use the remote endpoint as source.
+	v6 := msg.records[1]
+	assert.Equal(t, ip("2001:db8::1"), v6[std(ieSourceIPv6Address)])
+	assert.Equal(t, uint16(443), binary.BigEndian.Uint16(v6[std(ieSourceTransportPort)]))
+	assert.Equal(t, uint64(9000), binary.BigEndian.Uint64(v6[std(ieInitiatorOctets)]))
+	assert.Equal(t, []byte{endIdleTimeout}, v6[std(ieFlowEndReason)])
+
+	// Later messages rely on the templates already sent.
+	exporter.FlowFinished(completedFlow("f-003", "192.168.1.11", "1.1.1.1", true), flows.FinishExpired)
+	msg = receive(t, conn, d)
+	assert.Equal(t, uint32(2), msg.sequence)
+	assert.Equal(t, 0, len(msg.templates))
+	assert.Equal(t, 1, len(msg.records))
+}
+
+func TestExporterResendsTemplates(t *testing.T) {
+	conn, d := listen(t)
+	exporter, err := New(
+		"udp://"+conn.LocalAddr().String(),
+		WithTemplateInterval(50*time.Millisecond),
+		WithFlushInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exporter.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for range 2 {
+		msg := receive(t, conn, d)
+		assert.Equal(t, 2, len(msg.templates))
+		assert.Equal(t, 0, len(msg.records))
+	}
+}
+
+func TestExporterWithoutEnterpriseNumber(t *testing.T) {
+	exporter, err := New("udp://127.0.0.1:4739")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := newRecord(completedFlow("f", "192.168.1.10", "1.1.1.1", true), flows.FinishExpired)
+	if !ok {
+		t.Fatal("expected record")
+	}
+
+	d := &decoder{templates: make(map[uint16][]field), pens: make(map[uint16][]uint32)}
+	messages := exporter.messages([]record{rec}, true)
+	assert.Equal(t, 1, len(messages))
+	msg := d.decode(t, messages[0].data)
+	assert.Equal(t, 14, len(msg.templates[templateIPv4]))
+	assert.Equal(t, 1, len(msg.records))
+	for key := range msg.records[0] {
+		if key.pen != 0 {
+			t.Fatalf("unexpected enterprise field %d", key.id)
+		}
+	}
+}
+
+// failingConn accepts a number of writes, then fails.
+type failingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	if c.writes == 0 {
+		return 0, net.ErrClosed
+	}
+	c.writes--
+	return len(b), nil
+}
+
+func (c *failingConn) Close() error { return nil }
+
+func TestSendKeepsUnsentRecords(t *testing.T) {
+	exporter, err := New("udp://127.0.0.1:4739", WithTemplateInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []record
+	for range 40 {
+		rec, ok := newRecord(completedFlow("f", "192.168.1.10", "1.1.1.1", true), flows.FinishExpired)
+		if !ok {
+			t.Fatal("expected record")
+		}
+		records = append(records, rec)
+	}
+	messages := exporter.messages(records, true)
+	if len(messages) < 2 {
+		t.Fatalf("expected records split across messages, got %d", len(messages))
+	}
+
+	exporter.conn = &failingConn{writes: 1}
+	pending := exporter.send(records)
+	assert.Equal(t, 40-messages[0].records, len(pending))
+	assert.Equal(t, uint32(messages[0].records), exporter.sequence)
+	assert.Equal(t, nil, exporter.conn)
+}
+
+func TestMessagesSplit(t *testing.T) {
+	exporter, err := New("udp://127.0.0.1:4739", WithEnterpriseNumber(testPen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []record
+	for range 40 {
+		rec, ok := newRecord(completedFlow("f", "192.168.1.10", "1.1.1.1", true), flows.FinishExpired)
+		if !ok {
+			t.Fatal("expected record")
+		}
+		records = append(records, rec)
+	}
+
+	d := &decoder{templates: make(map[uint16][]field), pens: make(map[uint16][]uint32)}
+	messages := exporter.messages(records, true)
+	if len(messages) < 2 {
+		t.Fatalf("expected records split across messages, got %d", len(messages))
+	}
+	total := 0
+	for _, msg := range messages {
+		if len(msg.data) > maxUDPMessageLen {
+			t.Fatalf("message of %d bytes exceeds the UDP limit", len(msg.data))
+		}
+		decoded := d.decode(t, msg.data)
+		assert.Equal(t, uint32(total), decoded.sequence)
+		assert.Equal(t, len(decoded.records), msg.records)
+		total += len(decoded.records)
+	}
+	assert.Equal(t, 40, total)
+}
+
+func TestNew(t *testing.T) {
+	for _, collector := range []string{"http://host:4739", "udp://host", "::"} {
+		if _, err := New(collector); err == nil {
+			t.Errorf("expected error for %q", collector)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Package ja4 labels TLS flows with the client identity matching their JA4
+// fingerprint. Signatures come from a local file, either tab-separated
+// "fingerprint, category, name" lines or the JSON export of ja4db.com.
+package ja4
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// Signature categories.
+const (
+	CategoryClient  = "client"
+	CategoryMalware = "malware"
+	CategoryTool    = "tool"
+)
+
+// Verdicts attached to flows.
+const (
+	VerdictKnown     = "known"
+	VerdictUnknown   = "unknown"
+	VerdictMalicious = "malicious"
+)
+
+// Signature identifies the client behind a fingerprint.
+type Signature struct {
+	Name     string
+	Category string
+}
+
+// Verdict classifies a matched signature.
+func (s Signature) Verdict() string {
+	if s.Category == CategoryMalware {
+		return VerdictMalicious
+	}
+	return VerdictKnown
+}
+
+// DB is a reloadable fingerprint database. Reload swaps the in-memory copy
+// only once the new file parsed correctly.
+type DB struct {
+	path       string
+	signatures atomic.Pointer[map[string]Signature]
+}
+
+// New creates a DB reading from path. Call Reload to load the file.
+func New(path string) *DB {
+	return &DB{path: path}
+}
+
+// Path returns the database file path, for change detection.
+func (d *DB) Path() string {
+	return d.path
+}
+
+// Reload reads the database file. On failure the previously loaded
+// signatures, if any, stay in use.
+func (d *DB) Reload() error {
+	content, err := os.ReadFile(d.path)
+	if err != nil {
+		return fmt.Errorf("load ja4 database %s: %w", d.path, err)
+	}
+
+	var signatures map[string]Signature
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '[' {
+		signatures, err = parseJSON(trimmed)
+	} else {
+		signatures, err = parseTSV(bytes.NewReader(content))
+	}
+	if err != nil {
+		return fmt.Errorf("load ja4 database %s: %w", d.path, err)
+	}
+
+	d.signatures.Store(&signatures)
+	slog.Debug("Loaded JA4 database", "path", d.path, "signatures", len(signatures))
+	return nil
+}
+
+// Lookup returns the signature matching fingerprint.
+func (d *DB) Lookup(fingerprint string) (Signature, bool) {
+	signatures := d.signatures.Load()
+	if signatures == nil {
+		return Signature{}, false
+	}
+	signature, ok := (*signatures)[strings.ToLower(fingerprint)]
+	return signature, ok
+}
+
+// Enrich labels a TLS flow with the client matching its JA4 fingerprint.
+// Fingerprints missing from the database are flagged as unknown; nothing is
+// attached until a database has been loaded.
+func (d *DB) Enrich(event *flows.FlowEvent) {
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok || flow.Ssl == nil || flow.Ssl.ClientJa4 == "" || d.signatures.Load() == nil {
+		return
+	}
+	info := &flows.Ja4Info{Fingerprint: flow.Ssl.ClientJa4, Verdict: VerdictUnknown}
+	if signature, ok := d.Lookup(flow.Ssl.ClientJa4); ok {
+		info.Client = signature.Name
+		info.Category = signature.Category
+		info.Verdict = signature.Verdict()
+	}
+	event.EnsureEnrichment().Ja4 = info
+}
+
+// validFingerprint reports whether s looks like a JA4 fingerprint
+// ("t13d1516h2_8daaf6152771_02713d6af862").
+func validFingerprint(s string) bool {
+	parts := strings.Split(s, "_")
+	return len(parts) == 3 && len(parts[0]) == 10 && parts[1] != "" && parts[2] != ""
+}
+
+// parseTSV reads "fingerprint<TAB>category<TAB>name" lines. Blank lines and
+// lines starting with # are ignored.
+func parseTSV(r io.Reader) (map[string]Signature, error) {
+	signatures := make(map[string]Signature)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected 3 tab-separated fields", line)
+		}
+		fingerprint := strings.ToLower(strings.TrimSpace(fields[0]))
+		if !validFingerprint(fingerprint) {
+			return nil, fmt.Errorf("line %d: invalid fingerprint %q", line, fields[0])
+		}
+		category := strings.ToLower(strings.TrimSpace(fields[1]))
+		switch category {
+		case CategoryClient, CategoryMalware, CategoryTool:
+		default:
+			return nil, fmt.Errorf("line %d: unknown category %q", line, fields[1])
+		}
+		signatures[fingerprint] = Signature{Name: strings.TrimSpace(fields[2]), Category: category}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return signatures, nil
+}
+
+// ja4dbEntry is the subset of a ja4db.com record used to name a client.
+type ja4dbEntry struct {
+	Application string `json:"application"`
+	Library     string `json:"library"`
+	Device      string `json:"device"`
+	Os          string `json:"os"`
+	Fingerprint string `json:"ja4_fingerprint"`
+}
+
+// parseJSON reads a ja4db.com export. Records without a JA4 client
+// fingerprint (JA4S, JA4H, ... only) are skipped and every match is
+// categorised as a client.
+func parseJSON(content []byte) (map[string]Signature, error) {
+	var entries []ja4dbEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+	signatures := make(map[string]Signature)
+	for _, entry := range entries {
+		fingerprint := strings.ToLower(strings.TrimSpace(entry.Fingerprint))
+		if !validFingerprint(fingerprint) {
+			continue
+		}
+		name := entry.Application
+		for _, fallback := range []string{entry.Library, entry.Os, entry.Device} {
+			if name == "" {
+				name = fallback
+			}
+		}
+		// Several records can share a fingerprint; keep the first named one.
+		if existing, ok := signatures[fingerprint]; ok && existing.Name != "" {
+			continue
+		}
+		signatures[fingerprint] = Signature{Name: name, Category: CategoryClient}
+	}
+	return signatures, nil
+}
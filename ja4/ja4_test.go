@@ -0,0 +1,142 @@
+package ja4
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+const (
+	chrome  = "t13d1516h2_8daaf6152771_02713d6af862"
+	sliver  = "t13d190900_9dc949149365_97f8aa674fd9"
+	curl    = "t13d3112h2_e8f1e7e78f70_6bebaf5329ac"
+	unknown = "t12d0909h1_1d5e2c6a5a4b_ffffffffffff"
+)
+
+const tsvSignatures = "# fingerprint\tcategory\tname\n" +
+	"\n" +
+	chrome + "\tclient\tChrome\n" +
+	"T13D190900_9DC949149365_97F8AA674FD9\tMalware\tSliver C2\n" +
+	curl + "\ttool\tcurl\n"
+
+func TestParseTSV(t *testing.T) {
+	t.Run("reads signatures", func(t *testing.T) {
+		signatures, err := parseTSV(strings.NewReader(tsvSignatures))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, map[string]Signature{
+			chrome: {Name: "Chrome", Category: CategoryClient},
+			sliver: {Name: "Sliver C2", Category: CategoryMalware},
+			curl:   {Name: "curl", Category: CategoryTool},
+		}, signatures)
+	})
+
+	t.Run("rejects malformed lines", func(t *testing.T) {
+		inputs := []string{
+			chrome + "\tclient\n",
+			"not-a-fingerprint\tclient\tChrome\n",
+			chrome + "\tbrowser\tChrome\n",
+		}
+		for _, input := range inputs {
+			if _, err := parseTSV(strings.NewReader(input)); err == nil {
+				t.Errorf("expected error for %q", input)
+			}
+		}
+	})
+}
+
+func TestParseJSON(t *testing.T) {
+	content := `[
+		{"application": "Chrome", "ja4_fingerprint": "` + chrome + `"},
+		{"application": null, "library": "OpenSSL", "ja4_fingerprint": "` + curl + `"},
+		{"application": "Nginx", "ja4_fingerprint": null, "ja4s_fingerprint": "t130200_1301_a56c5b993250"}
+	]`
+	signatures, err := parseJSON([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]Signature{
+		chrome: {Name: "Chrome", Category: CategoryClient},
+		curl:   {Name: "OpenSSL", Category: CategoryClient},
+	}, signatures)
+}
+
+func tlsEvent(fingerprint string) flows.FlowEvent {
+	return flows.FlowEvent{
+		Type: flows.FlowTypeDpiComplete,
+		Flow: flows.FlowComplete{Ssl: &flows.Ssl{ClientJa4: fingerprint}},
+	}
+}
+
+func TestDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ja4.tsv")
+	db := New(path)
+
+	t.Run("enriches nothing before loading", func(t *testing.T) {
+		event := tlsEvent(chrome)
+		db.Enrich(&event)
+		assert.Equal(t, (*flows.Enrichment)(nil), event.Enrichment)
+	})
+
+	if err := os.WriteFile(path, []byte(tsvSignatures), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("labels flows", func(t *testing.T) {
+		tests := []struct {
+			fingerprint string
+			expected    *flows.Ja4Info
+		}{
+			{chrome, &flows.Ja4Info{
+				Fingerprint: chrome,
+				Client:      "Chrome",
+				Category:    CategoryClient,
+				Verdict:     VerdictKnown,
+			}},
+			{sliver, &flows.Ja4Info{
+				Fingerprint: sliver,
+				Client:      "Sliver C2",
+				Category:    CategoryMalware,
+				Verdict:     VerdictMalicious,
+			}},
+			{unknown, &flows.Ja4Info{Fingerprint: unknown, Verdict: VerdictUnknown}},
+		}
+		for _, tt := range tests {
+			event := tlsEvent(tt.fingerprint)
+			db.Enrich(&event)
+			assert.Equal(t, tt.expected, event.Enrichment.Ja4)
+		}
+	})
+
+	t.Run("skips flows without fingerprint", func(t *testing.T) {
+		events := []flows.FlowEvent{
+			tlsEvent(""),
+			{Type: flows.FlowTypeDpiComplete, Flow: flows.FlowComplete{}},
+			{Type: flows.FlowTypeStats, Flow: flows.FlowStats{}},
+		}
+		for _, event := range events {
+			db.Enrich(&event)
+			assert.Equal(t, (*flows.Enrichment)(nil), event.Enrichment)
+		}
+	})
+
+	t.Run("keeps previous signatures on failure", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte("broken\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Reload(); err == nil {
+			t.Fatal("expected reload error")
+		}
+		signature, ok := db.Lookup(chrome)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "Chrome", signature.Name)
+	})
+}
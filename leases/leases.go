@@ -0,0 +1,320 @@
+// Package leases maps local IP addresses to the hostname and MAC address known
+// by the DHCP servers running on the firewall (dnsmasq and odhcpd).
+package leases
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+	"github.com/nethserver/nethsecurity-monitoring/internal/uci"
+)
+
+const (
+	DefaultDnsmasqPath = "/tmp/dhcp.leases"
+	DefaultOdhcpdPath  = "/tmp/hosts/odhcpd"
+	DefaultStaticPath  = "/etc/config/dhcp"
+)
+
+// Lease is a single address assignment.
+type Lease struct {
+	IP       string
+	Mac      string
+	Hostname string
+	// Expires is the zero time for static and infinite leases.
+	Expires time.Time
+	Static  bool
+}
+
+// ParseDnsmasq reads a dnsmasq lease file. Each line has the format:
+//
+//	<expiry> <mac> <ip> <hostname|*> <client-id|*>
+//
+// DHCPv6 leases, listed after the "duid" line of the server, have an IPv6
+// address and an IAID in place of the MAC; their MAC is taken from the client
+// DUID when it embeds one.
+func ParseDnsmasq(r io.Reader) ([]Lease, error) {
+	var leases []Lease
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// DUID lines of DHCPv6 leases have fewer fields and are skipped.
+		if len(fields) < 4 {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease expiry %q: %w", fields[0], err)
+		}
+		lease := Lease{IP: fields[2]}
+		if ip := net.ParseIP(fields[2]); ip != nil && ip.To4() == nil {
+			if len(fields) > 4 {
+				lease.Mac = macFromClientID(strings.ReplaceAll(fields[4], ":", ""), false)
+			}
+		} else {
+			lease.Mac = normalizeMac(fields[1])
+		}
+		if fields[3] != "*" {
+			lease.Hostname = fields[3]
+		}
+		if expiry > 0 {
+			lease.Expires = time.Unix(expiry, 0)
+		}
+		leases = append(leases, lease)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read dnsmasq leases: %w", err)
+	}
+	return leases, nil
+}
+
+// ParseOdhcpd reads the odhcpd state file. Lease lines have the format:
+//
+//	# <iface> <duid|mac> <iaid|ipv4> <hostname|-> <valid> <hex> <prefix> <addr/len>...
+//
+// Lines not starting with "# " are host entries and are skipped.
+func ParseOdhcpd(r io.Reader) ([]Lease, error) {
+	var leases []Lease
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "# ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 9 {
+			continue
+		}
+		hostname := fields[4]
+		if hostname == "-" || strings.HasPrefix(hostname, "broken") {
+			hostname = ""
+		}
+		var expires time.Time
+		if valid, err := strconv.ParseInt(fields[5], 10, 64); err == nil && valid > 0 {
+			expires = time.Unix(valid, 0)
+		}
+		mac := macFromClientID(fields[2], fields[3] == "ipv4")
+		for _, addr := range fields[8:] {
+			ip, _, _ := strings.Cut(addr, "/")
+			if net.ParseIP(ip) == nil {
+				continue
+			}
+			leases = append(leases, Lease{
+				IP:       ip,
+				Mac:      mac,
+				Hostname: hostname,
+				Expires:  expires,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read odhcpd leases: %w", err)
+	}
+	return leases, nil
+}
+
+// ParseStatic reads the "host" sections of the dhcp UCI configuration.
+func ParseStatic(r io.Reader) ([]Lease, error) {
+	sections, err := uci.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("read static leases: %w", err)
+	}
+
+	var leases []Lease
+	for _, section := range sections {
+		if section.Type != "host" {
+			continue
+		}
+		ip := section.Get("ip")
+		if ip == "" || ip == "ignore" {
+			continue
+		}
+		// A host may list several MAC addresses; the first one is reported.
+		var mac string
+		if macs := strings.Fields(strings.Join(section.Options["mac"], " ")); len(macs) > 0 {
+			mac = normalizeMac(macs[0])
+		}
+		leases = append(leases, Lease{
+			IP:       ip,
+			Mac:      mac,
+			Hostname: section.Get("name"),
+			Static:   true,
+		})
+	}
+	return leases, nil
+}
+
+// macFromClientID extracts a MAC address from an odhcpd client identifier.
+// IPv4 leases store the hardware address directly, IPv6 leases store a DUID
+// which embeds it for the link-layer based types.
+func macFromClientID(id string, ipv4 bool) string {
+	raw, err := hex.DecodeString(id)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case ipv4 && len(raw) == 6:
+		return net.HardwareAddr(raw).String()
+	case ipv4 && len(raw) == 7 && raw[0] == 1:
+		// RFC 2132 client identifier: hardware type followed by the address.
+		return net.HardwareAddr(raw[1:]).String()
+	case len(raw) == 14 && raw[0] == 0 && raw[1] == 1 && raw[2] == 0 && raw[3] == 1:
+		// DUID-LLT: type, hardware type, time, link-layer address.
+		return net.HardwareAddr(raw[8:]).String()
+	case len(raw) == 10 && raw[0] == 0 && raw[1] == 3 && raw[2] == 0 && raw[3] == 1:
+		// DUID-LL: type, hardware type, link-layer address.
+		return net.HardwareAddr(raw[4:]).String()
+	}
+	return ""
+}
+
+func normalizeMac(mac string) string {
+	if hw, err := net.ParseMAC(mac); err == nil {
+		return hw.String()
+	}
+	return strings.ToLower(mac)
+}
+
+// Table is a thread-safe, reloadable view of the leases of all sources.
+type Table struct {
+	mu          sync.RWMutex
+	dnsmasqPath string
+	odhcpdPath  string
+	staticPath  string
+	byIP        map[string]Lease
+}
+
+// NewTable creates a Table reading from the given files. Empty paths disable
+// the corresponding source. Call Reload to load the files.
+func NewTable(dnsmasqPath, odhcpdPath, staticPath string) *Table {
+	return &Table{
+		dnsmasqPath: dnsmasqPath,
+		odhcpdPath:  odhcpdPath,
+		staticPath:  staticPath,
+		byIP:        make(map[string]Lease),
+	}
+}
+
+// Paths returns the files the table reads from, for change detection.
+func (t *Table) Paths() []string {
+	var paths []string
+	for _, path := range []string{t.dnsmasqPath, t.odhcpdPath, t.staticPath} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Reload reads all sources and replaces the table content. Missing files are
+// treated as empty. Static leases take precedence: dynamic leases for the same
+// address only fill in a missing hostname or MAC address.
+func (t *Table) Reload() error {
+	byIP := make(map[string]Lease)
+	sources := []struct {
+		path  string
+		parse func(io.Reader) ([]Lease, error)
+	}{
+		{t.staticPath, ParseStatic},
+		{t.odhcpdPath, ParseOdhcpd},
+		{t.dnsmasqPath, ParseDnsmasq},
+	}
+	for _, source := range sources {
+		if source.path == "" {
+			continue
+		}
+		leases, err := readLeases(source.path, source.parse)
+		if err != nil {
+			return err
+		}
+		for _, lease := range leases {
+			if existing, ok := byIP[lease.IP]; ok && existing.Static {
+				if existing.Hostname == "" {
+					existing.Hostname = lease.Hostname
+				}
+				if existing.Mac == "" {
+					existing.Mac = lease.Mac
+				}
+				byIP[lease.IP] = existing
+				continue
+			}
+			byIP[lease.IP] = lease
+		}
+	}
+
+	t.mu.Lock()
+	t.byIP = byIP
+	t.mu.Unlock()
+	slog.Debug("Reloaded DHCP leases", "count", len(byIP))
+	return nil
+}
+
+func readLeases(path string, parse func(io.Reader) ([]Lease, error)) ([]Lease, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	leases, err := parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return leases, nil
+}
+
+// Lookup returns the lease assigned to ip, if any. Expired dynamic leases are
+// still returned: the host keeps its address until it is reassigned.
+func (t *Table) Lookup(ip string) (Lease, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	lease, ok := t.byIP[ip]
+	return lease, ok
+}
+
+// LookupHost returns the hostname and MAC address assigned to ip.
+func (t *Table) LookupHost(ip string) (string, string, bool) {
+	lease, ok := t.Lookup(ip)
+	if !ok {
+		return "", "", false
+	}
+	return lease.Hostname, lease.Mac, true
+}
+
+// Enrich attaches lease information to the local endpoints of a flow.
+func (t *Table) Enrich(event *flows.FlowEvent) {
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok {
+		return
+	}
+	if info := t.hostInfo(flow.LocalIp); info != nil {
+		event.EnsureEnrichment().LocalHost = info
+	}
+	if flow.OtherType == "local" {
+		if info := t.hostInfo(flow.OtherIp); info != nil {
+			event.EnsureEnrichment().OtherHost = info
+		}
+	}
+}
+
+func (t *Table) hostInfo(ip string) *flows.HostInfo {
+	lease, ok := t.Lookup(ip)
+	if !ok {
+		return nil
+	}
+	return &flows.HostInfo{Hostname: lease.Hostname, Mac: lease.Mac}
+}
@@ -0,0 +1,205 @@
+package leases
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+const dnsmasqLeases = `1760000000 aa:bb:cc:dd:ee:01 192.168.1.34 laptop-anna 01:aa:bb:cc:dd:ee:01
+0 AA:BB:CC:DD:EE:02 192.168.1.35 * *
+duid 00:01:00:01:2a:00:00:00:aa:bb:cc:dd:ee:ff
+1760000300 1234567 fd00::50 tablet 00:03:00:01:aa:bb:cc:dd:ee:09
+1760000300 7654321 fd00::51 * 00:02:00:00:ab:11:01:02
+`
+
+const odhcpdLeases = `# br-lan 0001000129a2b3c4aabbccddee03 4d2 phone 1760000100 1a 128 fd00::1a/128 fd00::1b/128
+fd00::1a phone.lan phone
+# br-lan aabbccddee04 ipv4 printer 1760000200 22 32 192.168.1.40/32
+# br-lan 00030001aabbccddee05 1 - -1 23 128 fd00::23/128
+`
+
+const staticLeases = `
+config dnsmasq
+	option domain 'lan'
+
+config host
+	option name 'nas'
+	option mac 'aa:bb:cc:dd:ee:06 aa:bb:cc:dd:ee:07'
+	option ip '192.168.1.10'
+
+config host
+	option name 'ignored'
+	option mac 'aa:bb:cc:dd:ee:08'
+	option ip 'ignore'
+
+config host
+	option mac 'aa:bb:cc:dd:ee:02'
+	option ip '192.168.1.35'
+`
+
+func TestParseDnsmasq(t *testing.T) {
+	leases, err := ParseDnsmasq(strings.NewReader(dnsmasqLeases))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, len(leases))
+	assert.Equal(t, Lease{
+		IP:       "192.168.1.34",
+		Mac:      "aa:bb:cc:dd:ee:01",
+		Hostname: "laptop-anna",
+		Expires:  time.Unix(1760000000, 0),
+	}, leases[0])
+	assert.Equal(t, Lease{IP: "192.168.1.35", Mac: "aa:bb:cc:dd:ee:02"}, leases[1])
+	// The IAID of DHCPv6 leases is not a MAC: it comes from the DUID, if any.
+	assert.Equal(t, Lease{
+		IP:       "fd00::50",
+		Mac:      "aa:bb:cc:dd:ee:09",
+		Hostname: "tablet",
+		Expires:  time.Unix(1760000300, 0),
+	}, leases[2])
+	assert.Equal(t, Lease{IP: "fd00::51", Expires: time.Unix(1760000300, 0)}, leases[3])
+
+	invalid := "never aa:bb:cc:dd:ee:01 192.168.1.34 x *\n"
+	if _, err := ParseDnsmasq(strings.NewReader(invalid)); err == nil {
+		t.Fatal("expected error for invalid expiry")
+	}
+}
+
+func TestParseOdhcpd(t *testing.T) {
+	leases, err := ParseOdhcpd(strings.NewReader(odhcpdLeases))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, len(leases))
+
+	assert.Equal(t, "fd00::1a", leases[0].IP)
+	assert.Equal(t, "fd00::1b", leases[1].IP)
+	assert.Equal(t, "phone", leases[0].Hostname)
+	assert.Equal(t, "aa:bb:cc:dd:ee:03", leases[0].Mac)
+
+	assert.Equal(t, Lease{
+		IP:       "192.168.1.40",
+		Mac:      "aa:bb:cc:dd:ee:04",
+		Hostname: "printer",
+		Expires:  time.Unix(1760000200, 0),
+	}, leases[2])
+
+	assert.Equal(t, Lease{IP: "fd00::23", Mac: "aa:bb:cc:dd:ee:05"}, leases[3])
+}
+
+func TestParseStatic(t *testing.T) {
+	leases, err := ParseStatic(strings.NewReader(staticLeases))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Lease{
+		{IP: "192.168.1.10", Mac: "aa:bb:cc:dd:ee:06", Hostname: "nas", Static: true},
+		{IP: "192.168.1.35", Mac: "aa:bb:cc:dd:ee:02", Static: true},
+	}, leases)
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTable(t *testing.T) {
+	t.Run("merges all sources", func(t *testing.T) {
+		dir := t.TempDir()
+		table := NewTable(
+			writeFile(t, dir, "dhcp.leases", dnsmasqLeases),
+			writeFile(t, dir, "odhcpd", odhcpdLeases),
+			writeFile(t, dir, "dhcp", staticLeases),
+		)
+		if err := table.Reload(); err != nil {
+			t.Fatal(err)
+		}
+
+		hostname, mac, ok := table.LookupHost("192.168.1.34")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "laptop-anna", hostname)
+		assert.Equal(t, "aa:bb:cc:dd:ee:01", mac)
+
+		lease, ok := table.Lookup("192.168.1.10")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, true, lease.Static)
+
+		lease, ok = table.Lookup("192.168.1.40")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "printer", lease.Hostname)
+
+		// Static entry without a name keeps its MAC and stays static.
+		lease, ok = table.Lookup("192.168.1.35")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, true, lease.Static)
+		assert.Equal(t, "", lease.Hostname)
+
+		_, _, ok = table.LookupHost("192.168.1.99")
+		assert.Equal(t, false, ok)
+	})
+
+	t.Run("tolerates missing files and picks up changes", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "dhcp.leases")
+		table := NewTable(path, filepath.Join(dir, "missing"), "")
+		if err := table.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, []string{path, filepath.Join(dir, "missing")}, table.Paths())
+
+		writeFile(t, dir, "dhcp.leases", dnsmasqLeases)
+		if err := table.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := table.Lookup("192.168.1.34"); !ok {
+			t.Fatal("expected lease after reload")
+		}
+	})
+
+	t.Run("enriches local endpoints", func(t *testing.T) {
+		dir := t.TempDir()
+		table := NewTable(writeFile(t, dir, "dhcp.leases", dnsmasqLeases), "", "")
+		if err := table.Reload(); err != nil {
+			t.Fatal(err)
+		}
+
+		event := flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{
+				LocalIp:   "192.168.1.34",
+				OtherIp:   "192.168.1.35",
+				OtherType: "local",
+			},
+		}
+		table.Enrich(&event)
+		assert.Equal(
+			t,
+			&flows.HostInfo{Hostname: "laptop-anna", Mac: "aa:bb:cc:dd:ee:01"},
+			event.Enrichment.LocalHost,
+		)
+		assert.Equal(t, &flows.HostInfo{Mac: "aa:bb:cc:dd:ee:02"}, event.Enrichment.OtherHost)
+
+		remote := flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{
+				LocalIp:   "10.0.0.1",
+				OtherIp:   "192.168.1.34",
+				OtherType: "remote",
+			},
+		}
+		table.Enrich(&remote)
+		if remote.Enrichment != nil {
+			t.Fatalf("expected no enrichment, got %+v", remote.Enrichment)
+		}
+	})
+}
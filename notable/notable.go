@@ -0,0 +1,215 @@
+// Package notable flags active flows that exceed configurable limits on
+// duration, cumulative bytes or sustained rate, such as backups saturating
+// the WAN link or VPN tunnels open for a whole day.
+package notable
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nethserver/nethsecurity-monitoring/alert"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// Reasons a flow is notable.
+const (
+	ReasonDuration = "duration"
+	ReasonBytes    = "bytes"
+	ReasonRate     = "rate"
+)
+
+// Limits holds the thresholds; a zero value disables the check. A flow is
+// notable for its rate once the sum of its upload and download rates stays
+// above Rate for at least RateFor.
+type Limits struct {
+	Duration time.Duration
+	Bytes    int64
+	Rate     float64
+	RateFor  time.Duration
+}
+
+// Enabled reports whether any check is enabled.
+func (l Limits) Enabled() bool {
+	return l.Duration > 0 || l.Bytes > 0 || l.Rate > 0
+}
+
+// Flow describes a notable flow.
+type Flow struct {
+	Digest      string    `json:"digest"`
+	Interface   string    `json:"interface,omitempty"`
+	LocalIp     string    `json:"local_ip"`
+	LocalMac    string    `json:"local_mac,omitempty"`
+	OtherIp     string    `json:"other_ip"`
+	OtherPort   int       `json:"other_port"`
+	Application string    `json:"application,omitempty"`
+	Reasons     []string  `json:"reasons"`
+	DurationMs  int64     `json:"duration_ms"`
+	TotalBytes  int64     `json:"total_bytes"`
+	Rate        float64   `json:"rate"`
+	DetectedAt  time.Time `json:"detected_at"`
+}
+
+// Detector periodically checks the active flows against the limits. Alerts
+// are published once per flow and reason.
+type Detector struct {
+	limits Limits
+	sink   alert.Sink
+
+	mu sync.RWMutex
+	// rateSince records when each flow went above the rate limit.
+	rateSince map[string]time.Time
+	notable   map[string]*Flow
+}
+
+// New creates a detector publishing to sink, which may be nil.
+func New(limits Limits, sink alert.Sink) *Detector {
+	return &Detector{
+		limits:    limits,
+		sink:      sink,
+		rateSince: make(map[string]time.Time),
+		notable:   make(map[string]*Flow),
+	}
+}
+
+// Run checks the flows of accessor every interval until ctx is cancelled.
+func (d *Detector) Run(ctx context.Context, accessor flows.FlowAccessor, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			d.Check(now, accessor.GetEvents())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check evaluates events at now. Flows that disappeared from events are
+// forgotten.
+func (d *Detector) Check(now time.Time, events map[string]flows.FlowEvent) {
+	var alerts []alert.Alert
+
+	d.mu.Lock()
+	for digest := range d.notable {
+		if _, ok := events[digest]; !ok {
+			delete(d.notable, digest)
+		}
+	}
+	for digest := range d.rateSince {
+		if _, ok := events[digest]; !ok {
+			delete(d.rateSince, digest)
+		}
+	}
+
+	for digest, event := range events {
+		flow, ok := event.Flow.(flows.FlowComplete)
+		if !ok {
+			continue
+		}
+		reasons := d.reasons(now, digest, flow)
+		if len(reasons) == 0 {
+			continue
+		}
+
+		current, ok := d.notable[digest]
+		if !ok {
+			current = &Flow{
+				Digest:      digest,
+				Interface:   event.Interface,
+				LocalIp:     flow.LocalIp,
+				LocalMac:    flow.LocalMac,
+				OtherIp:     flow.OtherIp,
+				OtherPort:   flow.OtherPort,
+				Application: flow.DetectedApplicationName,
+				DetectedAt:  now,
+			}
+			d.notable[digest] = current
+		}
+		current.DurationMs = flow.LastSeenAt - flow.FirstSeenAt
+		current.TotalBytes = flow.TotalBytes
+		current.Rate = flow.LocalRate + flow.OtherRate
+
+		var added []string
+		for _, reason := range reasons {
+			if !slices.Contains(current.Reasons, reason) {
+				current.Reasons = append(current.Reasons, reason)
+				added = append(added, reason)
+			}
+		}
+		if len(added) > 0 {
+			evidence := *current
+			evidence.Reasons = slices.Clone(current.Reasons)
+			alerts = append(alerts, alert.Alert{
+				Time: now,
+				Type: alert.TypeNotableFlow,
+				Message: fmt.Sprintf(
+					"Flow %s:%d from %s exceeded %s limit",
+					flow.OtherIp,
+					flow.OtherPort,
+					flow.LocalIp,
+					strings.Join(added, " and "),
+				),
+				Details: evidence,
+			})
+		}
+	}
+	d.mu.Unlock()
+
+	if d.sink != nil {
+		for _, a := range alerts {
+			d.sink.Publish(a)
+		}
+	}
+}
+
+// reasons returns the limits flow exceeds at now. It must be called with the
+// lock held.
+func (d *Detector) reasons(now time.Time, digest string, flow flows.FlowComplete) []string {
+	var reasons []string
+	duration := time.Duration(flow.LastSeenAt-flow.FirstSeenAt) * time.Millisecond
+	if d.limits.Duration > 0 && duration >= d.limits.Duration {
+		reasons = append(reasons, ReasonDuration)
+	}
+	if d.limits.Bytes > 0 && flow.TotalBytes >= d.limits.Bytes {
+		reasons = append(reasons, ReasonBytes)
+	}
+	if d.limits.Rate > 0 {
+		if flow.LocalRate+flow.OtherRate >= d.limits.Rate {
+			since, ok := d.rateSince[digest]
+			if !ok {
+				since = now
+				d.rateSince[digest] = now
+			}
+			if now.Sub(since) >= d.limits.RateFor {
+				reasons = append(reasons, ReasonRate)
+			}
+		} else {
+			delete(d.rateSince, digest)
+		}
+	}
+	return reasons
+}
+
+// Notable returns the notable flows, sorted by detection time and digest.
+func (d *Detector) Notable() []Flow {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	result := make([]Flow, 0, len(d.notable))
+	for _, flow := range d.notable {
+		copied := *flow
+		copied.Reasons = slices.Clone(flow.Reasons)
+		result = append(result, copied)
+	}
+	slices.SortFunc(result, func(a, b Flow) int {
+		if c := a.DetectedAt.Compare(b.DetectedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Digest, b.Digest)
+	})
+	return result
+}
@@ -0,0 +1,91 @@
+package notable
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/alert"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+type memorySink struct {
+	alerts []alert.Alert
+}
+
+func (m *memorySink) Publish(a alert.Alert) {
+	m.alerts = append(m.alerts, a)
+}
+
+func flowEvent(digest string, duration time.Duration, bytes int64, rate float64) flows.FlowEvent {
+	return flows.FlowEvent{
+		Type: flows.FlowTypeDpiComplete,
+		Flow: flows.FlowComplete{
+			FlowBase:    flows.FlowBase{Digest: digest},
+			LocalIp:     "192.168.1.10",
+			OtherIp:     "203.0.113.5",
+			OtherPort:   443,
+			FirstSeenAt: 1_000_000,
+			LastSeenAt:  1_000_000 + duration.Milliseconds(),
+			Stats:       flows.Stats{TotalBytes: bytes, LocalRate: rate / 2, OtherRate: rate / 2},
+		},
+	}
+}
+
+func TestDetector(t *testing.T) {
+	sink := &memorySink{}
+	detector := New(Limits{
+		Duration: 20 * time.Hour,
+		Bytes:    1 << 30,
+		Rate:     10e6,
+		RateFor:  time.Minute,
+	}, sink)
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	events := map[string]flows.FlowEvent{
+		"vpn":    flowEvent("vpn", 21*time.Hour, 1<<20, 1000),
+		"backup": flowEvent("backup", time.Hour, 2<<30, 20e6),
+		"web":    flowEvent("web", time.Minute, 1<<20, 1000),
+	}
+	detector.Check(start, events)
+
+	notable := detector.Notable()
+	assert.Equal(t, 2, len(notable))
+	assert.Equal(t, "backup", notable[0].Digest)
+	assert.Equal(t, []string{ReasonBytes}, notable[0].Reasons)
+	assert.Equal(t, "vpn", notable[1].Digest)
+	assert.Equal(t, []string{ReasonDuration}, notable[1].Reasons)
+	assert.Equal(t, int64(21*time.Hour/time.Millisecond), notable[1].DurationMs)
+	assert.Equal(t, 2, len(sink.alerts))
+
+	// The rate must stay above the limit for RateFor.
+	detector.Check(start.Add(30*time.Second), events)
+	assert.Equal(t, 2, len(sink.alerts))
+	detector.Check(start.Add(time.Minute), events)
+	assert.Equal(t, 3, len(sink.alerts))
+	last := sink.alerts[2]
+	assert.Equal(t, alert.TypeNotableFlow, last.Type)
+	assert.Equal(t, "Flow 203.0.113.5:443 from 192.168.1.10 exceeded rate limit", last.Message)
+	evidence := last.Details.(Flow)
+	assert.Equal(t, []string{ReasonBytes, ReasonRate}, evidence.Reasons)
+	assert.Equal(t, start, evidence.DetectedAt)
+
+	// A dip below the rate limit restarts the window.
+	events["web"] = flowEvent("web", 2*time.Minute, 1<<20, 20e6)
+	detector.Check(start.Add(2*time.Minute), events)
+	events["web"] = flowEvent("web", 3*time.Minute, 1<<20, 1000)
+	detector.Check(start.Add(3*time.Minute), events)
+	events["web"] = flowEvent("web", 4*time.Minute, 1<<20, 20e6)
+	detector.Check(start.Add(4*time.Minute), events)
+	assert.Equal(t, 3, len(sink.alerts))
+
+	// Flows that are gone are forgotten.
+	delete(events, "vpn")
+	detector.Check(start.Add(4*time.Minute+30*time.Second), events)
+	assert.Equal(t, 1, len(detector.Notable()))
+}
+
+func TestLimitsEnabled(t *testing.T) {
+	assert.Equal(t, false, Limits{RateFor: time.Minute}.Enabled())
+	assert.Equal(t, true, Limits{Bytes: 1}.Enabled())
+}
@@ -0,0 +1,298 @@
+// Package scan detects local hosts that behave like scanners or compromised
+// machines: many flows to distinct destinations or ports, or many short flows
+// torn down with TCP resets, within a sliding window.
+package scan
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nethserver/nethsecurity-monitoring/alert"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// Reasons a host is suspect.
+const (
+	ReasonDestinations = "destinations"
+	ReasonPorts        = "ports"
+	ReasonResets       = "resets"
+)
+
+// maxObservations bounds the flows remembered per host.
+const maxObservations = 10000
+
+// sampleSize is the number of destinations and ports attached as evidence.
+const sampleSize = 20
+
+// Limits holds the thresholds, counted per local IP over Window; a zero limit
+// disables the check. A flow counts towards Resets when it saw a TCP reset
+// and lasted less than ShortFlow.
+type Limits struct {
+	Window       time.Duration
+	Destinations int
+	Ports        int
+	Resets       int
+	ShortFlow    time.Duration
+}
+
+// Enabled reports whether any check is enabled.
+func (l Limits) Enabled() bool {
+	return l.Window > 0 && (l.Destinations > 0 || l.Ports > 0 || l.Resets > 0)
+}
+
+// Suspect is a local host exceeding at least one limit, with the evidence
+// gathered in the current window.
+type Suspect struct {
+	LocalIp         string    `json:"local_ip"`
+	LocalMac        string    `json:"local_mac,omitempty"`
+	Reasons         []string  `json:"reasons"`
+	Flows           int       `json:"flows"`
+	Destinations    int       `json:"destinations"`
+	Ports           int       `json:"ports"`
+	ResetFlows      int       `json:"reset_flows"`
+	SampleIps       []string  `json:"sample_ips"`
+	SamplePorts     []int     `json:"sample_ports"`
+	WindowStart     time.Time `json:"window_start"`
+	FirstDetectedAt time.Time `json:"first_detected_at"`
+	LastDetectedAt  time.Time `json:"last_detected_at"`
+	WindowSeconds   int64     `json:"window_seconds"`
+}
+
+// observation is a flow opened by a local host, recorded when it is
+// ingested, or a short flow torn down by a TCP reset, recorded when it
+// finishes.
+type observation struct {
+	at        time.Time
+	otherIp   string
+	otherPort int
+	reset     bool
+}
+
+type host struct {
+	mac          string
+	observations []observation
+	suspect      *Suspect
+	published    []string
+}
+
+// Detector counts the flows opened by each local host as they are ingested,
+// and their short reset flows as they finish. It implements
+// flows.FlowAnalyzer and flows.FlowSink and publishes an alert whenever a
+// host exceeds a new limit.
+type Detector struct {
+	limits Limits
+	sink   alert.Sink
+	now    func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*host
+	// counted holds the digests of the unfinished flows already counted.
+	counted map[string]struct{}
+}
+
+// New creates a detector publishing to sink, which may be nil.
+func New(limits Limits, sink alert.Sink) *Detector {
+	return &Detector{
+		limits:  limits,
+		sink:    sink,
+		now:     time.Now,
+		hosts:   make(map[string]*host),
+		counted: make(map[string]struct{}),
+	}
+}
+
+// Analyze counts the remote IP and port of a flow opened by a local host
+// when netifyd reports it complete, placing it in the window at its arrival
+// so that flows lasting longer than the window are still counted.
+func (d *Detector) Analyze(event *flows.FlowEvent) {
+	if event.Type != flows.FlowTypeDpiComplete {
+		return
+	}
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok || !flow.LocalOrigin || flow.LocalIp == "" {
+		return
+	}
+
+	now := d.now()
+	d.mu.Lock()
+	// netifyd may report a flow again before it finishes.
+	if _, counted := d.counted[flow.Digest]; counted {
+		d.mu.Unlock()
+		return
+	}
+	d.counted[flow.Digest] = struct{}{}
+	published := d.observe(now, flow, observation{
+		at:        now,
+		otherIp:   flow.OtherIp,
+		otherPort: flow.OtherPort,
+	})
+	d.mu.Unlock()
+	d.publish(published)
+}
+
+// FlowFinished counts a flow opened by a local host towards Resets when it
+// saw a TCP reset and lasted less than ShortFlow.
+func (d *Detector) FlowFinished(event flows.FlowEvent, _ string) {
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok {
+		return
+	}
+	duration := time.Duration(flow.LastSeenAt-flow.FirstSeenAt) * time.Millisecond
+	reset := flow.Tcp != nil && flow.Tcp.Resets > 0 && duration < d.limits.ShortFlow
+
+	now := d.now()
+	d.mu.Lock()
+	delete(d.counted, flow.Digest)
+	if !reset || !flow.LocalOrigin || flow.LocalIp == "" || d.limits.Resets == 0 {
+		d.mu.Unlock()
+		return
+	}
+	published := d.observe(now, flow, observation{at: now, reset: true})
+	d.mu.Unlock()
+	d.publish(published)
+}
+
+// observe records obs for the local host of flow and returns the alert to
+// publish, if any. It must be called with the lock held.
+func (d *Detector) observe(now time.Time, flow flows.FlowComplete, obs observation) *alert.Alert {
+	h, ok := d.hosts[flow.LocalIp]
+	if !ok {
+		h = &host{}
+		d.hosts[flow.LocalIp] = h
+	}
+	if flow.LocalMac != "" {
+		h.mac = flow.LocalMac
+	}
+	h.observations = append(h.observations, obs)
+	if len(h.observations) > maxObservations {
+		h.observations = h.observations[len(h.observations)-maxObservations:]
+	}
+	return d.evaluate(now, flow.LocalIp, h)
+}
+
+func (d *Detector) publish(published *alert.Alert) {
+	if published != nil && d.sink != nil {
+		d.sink.Publish(*published)
+	}
+}
+
+// evaluate refreshes the state of h at now and returns the alert to publish,
+// if any. It must be called with the lock held.
+func (d *Detector) evaluate(now time.Time, localIp string, h *host) *alert.Alert {
+	cutoff := now.Add(-d.limits.Window)
+	h.observations = slices.DeleteFunc(h.observations, func(o observation) bool {
+		return o.at.Before(cutoff)
+	})
+	if len(h.observations) == 0 {
+		h.suspect = nil
+		delete(d.hosts, localIp)
+		return nil
+	}
+
+	ips := make(map[string]struct{})
+	ports := make(map[int]struct{})
+	opened, resets := 0, 0
+	for _, o := range h.observations {
+		if o.reset {
+			resets++
+			continue
+		}
+		opened++
+		ips[o.otherIp] = struct{}{}
+		ports[o.otherPort] = struct{}{}
+	}
+
+	var reasons []string
+	if d.limits.Destinations > 0 && len(ips) >= d.limits.Destinations {
+		reasons = append(reasons, ReasonDestinations)
+	}
+	if d.limits.Ports > 0 && len(ports) >= d.limits.Ports {
+		reasons = append(reasons, ReasonPorts)
+	}
+	if d.limits.Resets > 0 && resets >= d.limits.Resets {
+		reasons = append(reasons, ReasonResets)
+	}
+	if len(reasons) == 0 {
+		h.suspect = nil
+		h.published = nil
+		return nil
+	}
+
+	if h.suspect == nil {
+		h.suspect = &Suspect{LocalIp: localIp, FirstDetectedAt: now}
+	}
+	s := h.suspect
+	s.LocalMac = h.mac
+	s.Reasons = reasons
+	s.Flows = opened
+	s.Destinations = len(ips)
+	s.Ports = len(ports)
+	s.ResetFlows = resets
+	s.SampleIps = sample(ips, strings.Compare)
+	s.SamplePorts = sample(ports, func(a, b int) int { return a - b })
+	s.WindowStart = h.observations[0].at
+	s.WindowSeconds = int64(d.limits.Window / time.Second)
+	s.LastDetectedAt = now
+
+	var added []string
+	for _, reason := range reasons {
+		if !slices.Contains(h.published, reason) {
+			h.published = append(h.published, reason)
+			added = append(added, reason)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	return &alert.Alert{
+		Time: now,
+		Type: alert.TypeScanSuspect,
+		Message: fmt.Sprintf(
+			"Host %s contacted %d destinations on %d ports with %d reset flows in %s",
+			localIp,
+			s.Destinations,
+			s.Ports,
+			s.ResetFlows,
+			d.limits.Window,
+		),
+		Details: *s,
+	}
+}
+
+// Suspects returns the hosts currently exceeding a limit, sorted by local IP.
+func (d *Detector) Suspects() []Suspect {
+	now := d.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	suspects := make([]Suspect, 0)
+	for localIp, h := range d.hosts {
+		// Alerts are only raised by new observations; refreshing here just
+		// expires old ones.
+		_ = d.evaluate(now, localIp, h)
+		if h.suspect == nil {
+			continue
+		}
+		suspects = append(suspects, *h.suspect)
+	}
+	slices.SortFunc(suspects, func(a, b Suspect) int {
+		return strings.Compare(a.LocalIp, b.LocalIp)
+	})
+	return suspects
+}
+
+// sample returns up to sampleSize sorted keys of values.
+func sample[K comparable](values map[K]struct{}, compare func(a, b K) int) []K {
+	keys := make([]K, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, compare)
+	if len(keys) > sampleSize {
+		keys = keys[:sampleSize]
+	}
+	return keys
+}
@@ -0,0 +1,154 @@
+package scan
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/alert"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+type memorySink struct {
+	alerts []alert.Alert
+}
+
+func (m *memorySink) Publish(a alert.Alert) {
+	m.alerts = append(m.alerts, a)
+}
+
+func flowEvent(localIp, otherIp string, otherPort int, at time.Time, resets int) flows.FlowEvent {
+	return flows.FlowEvent{
+		Type: flows.FlowTypeDpiComplete,
+		Flow: flows.FlowComplete{
+			FlowBase:    flows.FlowBase{Digest: fmt.Sprintf("%s-%s-%d", localIp, otherIp, otherPort)},
+			LocalOrigin: true,
+			LocalIp:     localIp,
+			LocalMac:    "02:00:00:00:00:01",
+			OtherIp:     otherIp,
+			OtherPort:   otherPort,
+			FirstSeenAt: at.UnixMilli(),
+			LastSeenAt:  at.Add(100 * time.Millisecond).UnixMilli(),
+			Tcp:         &flows.Tcp{Resets: resets},
+		},
+	}
+}
+
+func TestDetectorPorts(t *testing.T) {
+	sink := &memorySink{}
+	detector := New(Limits{Window: time.Minute, Ports: 10, ShortFlow: time.Second}, sink)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	detector.now = func() time.Time { return now }
+
+	analyze := func(port int) {
+		event := flowEvent("192.168.1.10", "192.168.1.1", port, now, 1)
+		detector.Analyze(&event)
+	}
+	for port := 1; port < 10; port++ {
+		analyze(port)
+	}
+	// A flow reported again is counted once.
+	analyze(9)
+	assert.Equal(t, 0, len(sink.alerts))
+	assert.Equal(t, 0, len(detector.Suspects()))
+
+	analyze(10)
+	// Further flows refresh the evidence without raising new alerts.
+	analyze(11)
+	assert.Equal(t, 1, len(sink.alerts))
+	assert.Equal(t, alert.TypeScanSuspect, sink.alerts[0].Type)
+	evidence := sink.alerts[0].Details.(Suspect)
+	assert.Equal(t, []string{ReasonPorts}, evidence.Reasons)
+	assert.Equal(t, 10, evidence.Ports)
+	assert.Equal(t, 1, evidence.Destinations)
+	assert.Equal(t, []string{"192.168.1.1"}, evidence.SampleIps)
+
+	suspects := detector.Suspects()
+	assert.Equal(t, 1, len(suspects))
+	assert.Equal(t, "192.168.1.10", suspects[0].LocalIp)
+	assert.Equal(t, "02:00:00:00:00:01", suspects[0].LocalMac)
+	assert.Equal(t, 11, suspects[0].Ports)
+	assert.Equal(t, 11, suspects[0].Flows)
+
+	// The flows leave the window and the host is no longer suspect.
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, 0, len(detector.Suspects()))
+}
+
+func TestDetectorDestinationsAndResets(t *testing.T) {
+	sink := &memorySink{}
+	detector := New(Limits{
+		Window:       time.Minute,
+		Destinations: 5,
+		Resets:       3,
+		ShortFlow:    time.Second,
+	}, sink)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	detector.now = func() time.Time { return now }
+
+	for i := range 3 {
+		event := flowEvent("192.168.1.20", fmt.Sprintf("10.0.0.%d", i), 445, now, 1)
+		detector.Analyze(&event)
+		detector.FlowFinished(event, "")
+	}
+	assert.Equal(t, 1, len(sink.alerts))
+	assert.Equal(t, []string{ReasonResets}, sink.alerts[0].Details.(Suspect).Reasons)
+
+	// Flows without a reset only count once they are ingested.
+	for i := 3; i < 5; i++ {
+		event := flowEvent("192.168.1.20", fmt.Sprintf("10.0.0.%d", i), 445, now, 0)
+		detector.FlowFinished(event, "")
+		assert.Equal(t, 1, len(sink.alerts))
+		detector.Analyze(&event)
+	}
+	assert.Equal(t, 2, len(sink.alerts))
+	last := sink.alerts[1]
+	assert.Equal(
+		t,
+		"Host 192.168.1.20 contacted 5 destinations on 1 ports with 3 reset flows in 1m0s",
+		last.Message,
+	)
+	assert.Equal(t, []string{ReasonDestinations, ReasonResets}, last.Details.(Suspect).Reasons)
+}
+
+func TestDetectorIgnoresInbound(t *testing.T) {
+	detector := New(Limits{Window: time.Minute, Ports: 1}, nil)
+	event := flowEvent("192.168.1.10", "203.0.113.5", 22, time.Now(), 0)
+	flow := event.Flow.(flows.FlowComplete)
+	flow.LocalOrigin = false
+	event.Flow = flow
+	detector.Analyze(&event)
+	detector.FlowFinished(event, "")
+	assert.Equal(t, 0, len(detector.Suspects()))
+}
+
+func TestDetectorCountsLongFlowsAtIngestion(t *testing.T) {
+	sink := &memorySink{}
+	detector := New(Limits{Window: time.Minute, Ports: 2}, sink)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	detector.now = func() time.Time { return now }
+
+	// The flows were opened before the window and finish after it, as
+	// unanswered probes purged on the TCP idle timeout do.
+	opened := now.Add(-2 * time.Minute)
+	first := flowEvent("192.168.1.30", "10.0.0.1", 22, opened, 0)
+	second := flowEvent("192.168.1.30", "10.0.0.1", 23, opened, 0)
+	detector.Analyze(&first)
+	now = now.Add(30 * time.Second)
+	detector.Analyze(&second)
+	assert.Equal(t, 1, len(sink.alerts))
+
+	detector.FlowFinished(first, "")
+	detector.FlowFinished(second, "")
+	suspects := detector.Suspects()
+	assert.Equal(t, 1, len(suspects))
+	assert.Equal(t, 2, suspects[0].Flows)
+	assert.Equal(t, now.Add(-30*time.Second), suspects[0].WindowStart)
+}
+
+func TestLimitsEnabled(t *testing.T) {
+	assert.Equal(t, false, Limits{Ports: 10}.Enabled())
+	assert.Equal(t, false, Limits{Window: time.Minute}.Enabled())
+	assert.Equal(t, true, Limits{Window: time.Minute, Resets: 1}.Enabled())
+}
@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// changesSchema records the reports whose data changed since the last export:
+// the raw stats of a local IP in an hour, or its daily and monthly rollups.
+// Marking a report again replaces its row, giving it a new seq, so that the
+// changes made while an export runs are kept by ClearChanges. Rollup reads the
+// hourly changes too: they are only deleted once both have seen them.
+const changesSchema = `
+CREATE TABLE IF NOT EXISTS stats_changes (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    resolution TEXT NOT NULL,
+    period_start INTEGER NOT NULL,
+    local_ip VARCHAR NOT NULL,
+    UNIQUE (resolution, period_start, local_ip)
+);
+`
+
+// markChanges prefixes the statements selecting the resolution, period_start
+// and local_ip of changed reports.
+const markChanges = `
+INSERT OR REPLACE INTO stats_changes (resolution, period_start, local_ip)
+`
+
+// Change identifies the report of LocalIP for the period of Resolution
+// starting at PeriodStart, a Unix timestamp. Hourly changes come from the raw
+// stats, daily and monthly ones from the rollups.
+type Change struct {
+	Resolution  Resolution
+	PeriodStart int64
+	LocalIP     string
+}
+
+// Changes returns the reports changed since the last ClearChanges, with the
+// sequence number to pass to it once they are exported.
+func (s *Store) Changes(ctx context.Context) ([]Change, int64, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT seq, resolution, period_start, local_ip
+FROM stats_changes
+WHERE seq > COALESCE((SELECT value FROM stats_state WHERE key = ?), 0)
+ORDER BY resolution, period_start, local_ip
+	`, stateKeyExportedThrough)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query changes: %w", err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var changes []Change
+	var through int64
+	for rows.Next() {
+		var change Change
+		var seq int64
+		if err := rows.Scan(
+			&seq,
+			&change.Resolution,
+			&change.PeriodStart,
+			&change.LocalIP,
+		); err != nil {
+			return nil, 0, fmt.Errorf("scan change: %w", err)
+		}
+		through = max(through, seq)
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate changes: %w", err)
+	}
+	return changes, through, nil
+}
+
+// ClearChanges forgets the changes returned by Changes up to the sequence
+// number through. Reports changed again since then are kept, as are the
+// hourly changes Rollup has not seen yet.
+func (s *Store) ClearChanges(ctx context.Context, through int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin clear changes transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = writeState(ctx, tx, stateKeyExportedThrough, through); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `
+DELETE FROM stats_changes
+WHERE seq <= ?
+    AND (resolution != ?
+        OR seq <= COALESCE((SELECT value FROM stats_state WHERE key = ?), 0))
+	`, through, Hourly, stateKeyRolledUpThrough); err != nil {
+		return fmt.Errorf("clear changes: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit clear changes transaction: %w", err)
+	}
+	return nil
+}
+
+// deleteChangesBefore forgets the changes of resolution in periods starting
+// before cutoff, whose data is being deleted.
+func deleteChangesBefore(ctx context.Context, tx *sql.Tx, res Resolution, cutoff int64) error {
+	if _, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM stats_changes WHERE resolution = ? AND period_start < ?`,
+		res,
+		cutoff,
+	); err != nil {
+		return fmt.Errorf("delete expired %s changes: %w", res, err)
+	}
+	return nil
+}
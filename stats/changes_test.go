@@ -0,0 +1,94 @@
+package stats
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStoreChanges(t *testing.T) {
+	store, db := setupStore(t)
+	defer store.Close() //nolint:errcheck
+	defer db.Close()    //nolint:errcheck
+	store.location = time.UTC
+	ctx := context.Background()
+
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	for _, payload := range []AggregatorPayload{
+		rollupPayload(day.Add(9*time.Hour+5*time.Minute), "10.0.0.1", "1.1.1.1", 100),
+		rollupPayload(day.Add(9*time.Hour+6*time.Minute), "10.0.0.1", "8.8.8.8", 100),
+		rollupPayload(day.Add(10*time.Hour+5*time.Minute), "10.0.0.2", "8.8.8.8", 100),
+	} {
+		if err := store.Save(ctx, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes, through, err := store.Changes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Hourly, day.Add(9 * time.Hour).Unix(), "10.0.0.1"},
+		{Hourly, day.Add(10 * time.Hour).Unix(), "10.0.0.2"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("changes mismatch\n got: %#v\nwant: %#v", changes, expected)
+	}
+
+	// Changes made after reading them survive ClearChanges.
+	if err := store.SaveResolvedHost(ctx, "8.8.8.8", "dns.google"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ClearChanges(ctx, through); err != nil {
+		t.Fatal(err)
+	}
+	changes, through, err = store.Changes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected both hours changed by the hostname, got %#v", changes)
+	}
+	if err := store.ClearChanges(ctx, through); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Rollup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	changes, _, err = store.Changes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	month := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+	expected = []Change{
+		{Daily, day.Unix(), "10.0.0.1"},
+		{Daily, day.Unix(), "10.0.0.2"},
+		{Monthly, month, "10.0.0.1"},
+		{Monthly, month, "10.0.0.2"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("changes mismatch\n got: %#v\nwant: %#v", changes, expected)
+	}
+
+	// Pruning forgets the changes of the deleted periods.
+	late := rollupPayload(day.Add(9*time.Hour), "10.0.0.3", "1.1.1.1", 1)
+	if err := store.Save(ctx, late); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteOlderThan(ctx, day.Add(9*time.Hour+30*time.Minute).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteRollupsOlderThan(ctx, Monthly, month+1); err != nil {
+		t.Fatal(err)
+	}
+	changes, _, err = store.Changes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changes, expected[:2]) {
+		t.Fatalf("changes mismatch after pruning\n got: %#v\nwant: %#v", changes, expected[:2])
+	}
+}
@@ -1,34 +1,58 @@
 package stats
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // HourReport represents the aggregated statistics for a single hour and local IP.
 type HourReport struct {
+	Hostname    string           `json:"hostname,omitempty"`
+	Mac         string           `json:"mac,omitempty"`
 	Total       int64            `json:"total"`
 	Protocol    map[string]int64 `json:"protocol"`
 	Application map[string]int64 `json:"application"`
 	Host        map[string]int64 `json:"host"`
+	Country     map[string]int64 `json:"country"`
+	Asn         map[string]int64 `json:"asn"`
+	// Threats counts the entries matching each threat-intelligence feed,
+	// by remote host.
+	Threats map[string]map[string]int64 `json:"threats"`
 }
 
-// BuildReport aggregates HourRow entries into a HourReport.
+// BuildReport aggregates HourRow entries into a HourReport. Its Mac is the
+// MAC address recorded with most of the traffic.
 func BuildReport(rows []HourRow) HourReport {
 	report := HourReport{
 		Protocol:    make(map[string]int64),
 		Application: make(map[string]int64),
 		Host:        make(map[string]int64),
+		Country:     make(map[string]int64),
+		Asn:         make(map[string]int64),
+		Threats:     make(map[string]map[string]int64),
 	}
 
+	macBytes := make(map[string]int64)
 	for _, row := range rows {
 		report.Total += row.TotalBytes
 
+		if row.LocalMac != "" {
+			macBytes[row.LocalMac] += row.TotalBytes
+		}
+
 		// Aggregate by protocol
 		if row.ProtocolName != "" {
 			report.Protocol[row.ProtocolName] += row.TotalBytes
@@ -43,115 +67,342 @@ func BuildReport(rows []HourRow) HourReport {
 		if row.Host != "" {
 			report.Host[row.Host] += row.TotalBytes
 		}
+
+		// Aggregate by remote country (ISO code)
+		if row.Country != "" {
+			report.Country[row.Country] += row.TotalBytes
+		}
+
+		// Aggregate by remote autonomous system ("AS15169 Google LLC")
+		if row.Asn != "" {
+			report.Asn[row.Asn] += row.TotalBytes
+		}
+
+		// Count threat-feed matches by feed and remote host. A feed can
+		// list both the address and the hostname of the same entry.
+		for i, feed := range row.Threats {
+			if slices.Contains(row.Threats[:i], feed) {
+				continue
+			}
+			if report.Threats[feed] == nil {
+				report.Threats[feed] = make(map[string]int64)
+			}
+			report.Threats[feed][row.Host] += row.Entries
+		}
+	}
+
+	for mac, bytes := range macBytes {
+		if bytes > macBytes[report.Mac] || (bytes == macBytes[report.Mac] && mac < report.Mac) {
+			report.Mac = mac
+		}
 	}
 
 	return report
 }
 
+// HostLookup resolves a local IP to the hostname and MAC address assigned by
+// the DHCP server.
+type HostLookup interface {
+	LookupHost(ip string) (hostname, mac string, ok bool)
+}
+
+// SetHostname adds the DHCP hostname of localIP to r when the address is
+// still leased to the MAC address its stats were recorded with. Leases only
+// reflect the present, so reports of devices that have since left, or of
+// stats recorded without a MAC address, get no hostname.
+func (r *HourReport) SetHostname(hosts HostLookup, localIP string) {
+	if hosts == nil || r.Mac == "" {
+		return
+	}
+	if hostname, mac, ok := hosts.LookupHost(localIP); ok && strings.EqualFold(mac, r.Mac) {
+		r.Hostname = hostname
+	}
+}
+
 // Exporter exports hourly statistics to JSON files.
 type Exporter struct {
 	outputDir   string
-	windowHours int
+	windowHours atomic.Int64
+	// full exports the whole window at the next ExportAll, besides the
+	// changed reports.
+	full  atomic.Bool
+	hosts HostLookup
+}
+
+// ExporterOption configures optional Exporter features.
+type ExporterOption func(*Exporter)
+
+// WithHostLookup attaches the DHCP hostname of each local IP to its reports,
+// as HourReport.SetHostname does.
+func WithHostLookup(hosts HostLookup) ExporterOption {
+	return func(e *Exporter) {
+		e.hosts = hosts
+	}
 }
 
 // NewExporter creates a new Exporter with the given output directory and window size in hours.
-func NewExporter(outputDir string, windowHours int) *Exporter {
-	return &Exporter{
-		outputDir:   outputDir,
-		windowHours: windowHours,
+func NewExporter(outputDir string, windowHours int, opts ...ExporterOption) *Exporter {
+	e := &Exporter{outputDir: outputDir}
+	e.windowHours.Store(int64(windowHours))
+	e.full.Store(true)
+	for _, opt := range opts {
+		opt(e)
 	}
+	return e
+}
+
+// SetWindow changes the number of hours exported in full, and exports them
+// again at the next ExportAll.
+func (e *Exporter) SetWindow(hours int) {
+	e.windowHours.Store(int64(hours))
+	e.full.Store(true)
+}
+
+// period identifies the reports of one hour, day or month.
+type period struct {
+	resolution Resolution
+	start      int64
 }
 
-// ExportAll exports the last N hours from the store to JSON files.
-func (e *Exporter) ExportAll(ctx context.Context, store *Store) error {
+// ExportAll writes the reports changed since the last run: the hourly reports
+// of the local IPs with new batches or newly resolved hostnames, and the daily
+// and monthly summaries recomputed by Store.Rollup. The first run, and the
+// first one after SetWindow, also exports the last N hours in full with the
+// summaries of their days and months. Files whose content is unchanged are
+// not rewritten.
+func (e *Exporter) ExportAll(ctx context.Context, store *Store) (err error) {
 	startTime := time.Now()
 	slog.Debug("Starting stats export", "time", startTime.Format(time.RFC3339))
 
-	hours, err := store.QueryableHours(ctx, e.windowHours)
+	full := e.full.Swap(false)
+	defer func() {
+		if err != nil && full {
+			e.full.Store(true)
+		}
+	}()
+
+	changes, through, err := store.Changes(ctx)
 	if err != nil {
-		return fmt.Errorf("query hours: %w", err)
+		return err
 	}
 
-	if len(hours) == 0 {
-		slog.Debug("No hours to export")
-		return nil
+	// pending lists the local IPs to export for each period; the empty
+	// string stands for all of them.
+	pending := make(map[period]map[string]bool)
+	add := func(resolution Resolution, start int64, localIP string) {
+		p := period{resolution, start}
+		if pending[p] == nil {
+			pending[p] = make(map[string]bool)
+		}
+		pending[p][localIP] = true
 	}
-
-	for _, hourEpoch := range hours {
-		hourEnd := hourEpoch + 3600
-		rows, err := store.QueryHour(ctx, hourEpoch, hourEnd)
+	for _, change := range changes {
+		add(change.Resolution, change.PeriodStart, change.LocalIP)
+	}
+	if full {
+		hours, err := store.QueryableHours(ctx, int(e.windowHours.Load()))
 		if err != nil {
-			return fmt.Errorf("query hour %d: %w", hourEpoch, err)
+			return fmt.Errorf("query hours: %w", err)
 		}
-
-		if len(rows) == 0 {
-			continue
+		for _, hourEpoch := range hours {
+			t := time.Unix(hourEpoch, 0).Local()
+			add(Hourly, hourEpoch, "")
+			add(Daily, time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local).Unix(), "")
+			add(Monthly, time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local).Unix(), "")
 		}
+	}
+
+	if len(pending) == 0 {
+		slog.Debug("No reports to export")
+		return nil
+	}
 
-		// Group rows by local_ip
-		reportsByIP := make(map[string][]HourRow)
-		for _, row := range rows {
-			reportsByIP[row.LocalIP] = append(reportsByIP[row.LocalIP], row)
+	periods := slices.Collect(maps.Keys(pending))
+	slices.SortFunc(periods, func(a, b period) int {
+		if c := slices.Index(Resolutions, a.resolution) -
+			slices.Index(Resolutions, b.resolution); c != 0 {
+			return c
 		}
+		return cmp.Compare(a.start, b.start)
+	})
 
-		// Write report for each local_ip
-		for localIP, ipRows := range reportsByIP {
-			report := BuildReport(ipRows)
-			if err := e.writeReport(hourEpoch, localIP, report); err != nil {
-				return fmt.Errorf("write report for %s at %d: %w", localIP, hourEpoch, err)
+	var written, unchanged int
+	for _, p := range periods {
+		rows, err := e.queryPeriod(ctx, store, p)
+		if err != nil {
+			return err
+		}
+		localIPs := pending[p]
+		for localIP, ipRows := range groupByIP(rows) {
+			if !localIPs[""] && !localIPs[localIP] {
+				continue
+			}
+			dir, name := e.reportPath(p, localIP)
+			ok, err := e.writeReport(dir, name, e.buildReport(localIP, ipRows))
+			if err != nil {
+				return fmt.Errorf(
+					"write %s report for %s at %d: %w",
+					p.resolution,
+					localIP,
+					p.start,
+					err,
+				)
 			}
+			if ok {
+				written++
+			} else {
+				unchanged++
+			}
+		}
+	}
+
+	if through > 0 {
+		if err := store.ClearChanges(ctx, through); err != nil {
+			return err
 		}
 	}
 
 	duration := time.Since(startTime)
-	slog.Debug("Completed stats export", "hours_exported", len(hours), "duration", duration)
+	slog.Debug(
+		"Completed stats export",
+		"periods", len(periods),
+		"written", written,
+		"unchanged", unchanged,
+		"duration", duration,
+	)
 	return nil
 }
 
-// writeReport writes a HourReport to a JSON file at the appropriate path.
-// The path follows the pattern: {outputDir}/{year}/{month:02d}/{day:02d}/{local_ip}/{hour:02d}.json
-// Timestamps are converted to the system's local timezone.
-func (e *Exporter) writeReport(hourEpoch int64, localIP string, report HourReport) error {
-	t := time.Unix(hourEpoch, 0).Local()
-	year := t.Year()
-	month := int(t.Month())
-	day := t.Day()
-	hour := t.Hour()
+// queryPeriod returns the rows of the reports of p: the raw stats of an hour,
+// or the rollup of a day or a month.
+func (e *Exporter) queryPeriod(ctx context.Context, store *Store, p period) ([]HourRow, error) {
+	if p.resolution == Hourly {
+		rows, err := store.QueryHour(ctx, p.start, p.start+3600)
+		if err != nil {
+			return nil, fmt.Errorf("query hour %d: %w", p.start, err)
+		}
+		return rows, nil
+	}
+	// Ranges select the periods overlapping them: this one only p's.
+	start := time.Unix(p.start, 0)
+	rows, err := store.QueryRange(
+		ctx,
+		Range{Resolution: p.resolution, From: start, To: start.Add(time.Second)},
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query %s summary at %d: %w", p.resolution, p.start, err)
+	}
+	return rows, nil
+}
+
+// reportPath returns the directory and the file name of the report of localIP
+// for p: {hour:02d}.json, daily.json or monthly.json.
+func (e *Exporter) reportPath(p period, localIP string) (string, string) {
+	t := time.Unix(p.start, 0)
+	switch p.resolution {
+	case Daily:
+		return e.dayDir(t, localIP), "daily.json"
+	case Monthly:
+		return e.monthDir(t, localIP), "monthly.json"
+	}
+	return e.dayDir(t, localIP), fmt.Sprintf("%02d.json", t.Local().Hour())
+}
+
+// groupByIP splits rows by local IP.
+func groupByIP(rows []HourRow) map[string][]HourRow {
+	byIP := make(map[string][]HourRow)
+	for _, row := range rows {
+		byIP[row.LocalIP] = append(byIP[row.LocalIP], row)
+	}
+	return byIP
+}
 
-	// Construct directory path
-	dirPath := filepath.Join(
+// buildReport aggregates the rows of localIP, adding its DHCP hostname when
+// known.
+func (e *Exporter) buildReport(localIP string, rows []HourRow) HourReport {
+	report := BuildReport(rows)
+	report.SetHostname(e.hosts, localIP)
+	return report
+}
+
+// dayDir returns {outputDir}/{year}/{month:02d}/{day:02d}/{local_ip}, holding
+// the hourly reports ({hour:02d}.json) and daily.json. Timestamps are
+// converted to the system's local timezone.
+func (e *Exporter) dayDir(t time.Time, localIP string) string {
+	t = t.Local()
+	return filepath.Join(
 		e.outputDir,
-		fmt.Sprintf("%d", year),
-		fmt.Sprintf("%02d", month),
-		fmt.Sprintf("%02d", day),
+		fmt.Sprintf("%d", t.Year()),
+		fmt.Sprintf("%02d", int(t.Month())),
+		fmt.Sprintf("%02d", t.Day()),
 		localIP,
 	)
+}
 
-	// Create directories if they don't exist
-	if err := os.MkdirAll(dirPath, 0o755); err != nil {
-		return fmt.Errorf("create directory %s: %w", dirPath, err)
-	}
+// monthDir returns {outputDir}/{year}/{month:02d}/{local_ip}, holding
+// monthly.json.
+func (e *Exporter) monthDir(t time.Time, localIP string) string {
+	t = t.Local()
+	return filepath.Join(
+		e.outputDir,
+		fmt.Sprintf("%d", t.Year()),
+		fmt.Sprintf("%02d", int(t.Month())),
+		localIP,
+	)
+}
 
+// writeReport atomically writes a HourReport to dir/name as JSON, unless the
+// file already holds the same content. It reports whether the file was
+// written.
+func (e *Exporter) writeReport(dir, name string, report HourReport) (bool, error) {
 	// File path
-	filePath := filepath.Join(dirPath, fmt.Sprintf("%02d.json", hour))
+	filePath := filepath.Join(dir, name)
 
 	// Marshal report to JSON
 	data, err := json.MarshalIndent(report, "", "  ")
 	if err != nil {
-		return fmt.Errorf("marshal report: %w", err)
+		return false, fmt.Errorf("marshal report: %w", err)
+	}
+
+	// Spare the flash storage a write of identical content
+	if sameContent(filePath, data) {
+		return false, nil
+	}
+
+	// Create directories if they don't exist
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return false, fmt.Errorf("create directory %s: %w", dir, err)
 	}
 
 	// Write to temporary file first (atomic write)
 	tmpFile := filePath + ".tmp"
 	if err := os.WriteFile(tmpFile, data, 0o644); err != nil {
-		return fmt.Errorf("write temp file %s: %w", tmpFile, err)
+		return false, fmt.Errorf("write temp file %s: %w", tmpFile, err)
 	}
 
 	// Atomically rename temp file to final path
 	if err := os.Rename(tmpFile, filePath); err != nil {
 		_ = os.Remove(tmpFile) // best effort cleanup
-		return fmt.Errorf("rename temp file to %s: %w", filePath, err)
+		return false, fmt.Errorf("rename temp file to %s: %w", filePath, err)
 	}
 
-	return nil
+	return true, nil
+}
+
+// sameContent reports whether the file at path has the SHA-256 hash of data.
+// Missing or unreadable files never match.
+func sameContent(path string, data []byte) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close() //nolint:errcheck
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	want := sha256.Sum256(data)
+	return bytes.Equal(h.Sum(nil), want[:])
 }
@@ -0,0 +1,230 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// PruneResult counts the export files removed by PruneExports.
+type PruneResult struct {
+	Days   int
+	Months int
+	Files  int
+	Bytes  int64
+	// Size is the size of the export tree left, in bytes.
+	Size int64
+}
+
+// exportDir is a day directory ({year}/{month}/{day}) or a month directory
+// ({year}/{month}) of the export tree, starting at local midnight.
+type exportDir struct {
+	path  string
+	start time.Time
+}
+
+// PruneExports removes the export files of the days that ended before cutoff,
+// then of the oldest days until the tree fits in maxBytes. The newest day is
+// always kept. A month is removed with its monthly summaries once none of its
+// days are left, and empty directories are removed. A zero cutoff or maxBytes
+// disables that limit.
+func (e *Exporter) PruneExports(cutoff time.Time, maxBytes int64) (PruneResult, error) {
+	var result PruneResult
+	_, size, err := dirUsage(e.outputDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	months, days, err := e.exportDirs()
+	if err != nil {
+		return result, err
+	}
+
+	remove := func(dir string) error {
+		files, bytes, err := dirUsage(dir)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("remove %s: %w", dir, err)
+		}
+		result.Files += files
+		result.Bytes += bytes
+		size -= bytes
+		return nil
+	}
+
+	for i, day := range days {
+		expired := !cutoff.IsZero() && !day.start.AddDate(0, 0, 1).After(cutoff)
+		overQuota := maxBytes > 0 && size > maxBytes && i < len(days)-1
+		if !expired && !overQuota {
+			break
+		}
+		if err := remove(day.path); err != nil {
+			return result, err
+		}
+		result.Days++
+	}
+
+	for i, month := range months {
+		if i == len(months)-1 {
+			break
+		}
+		hasDays, err := hasDayDirs(month.path)
+		if err != nil {
+			return result, err
+		}
+		if hasDays {
+			continue
+		}
+		if err := remove(month.path); err != nil {
+			return result, err
+		}
+		result.Months++
+	}
+
+	if _, err := removeEmptyDirs(e.outputDir); err != nil {
+		return result, err
+	}
+	result.Size = size
+	return result, nil
+}
+
+// exportDirs lists the month and day directories of the export tree, oldest
+// first. Entries not named like dates, such as the local IP directories of
+// the monthly summaries, are skipped.
+func (e *Exporter) exportDirs() ([]exportDir, []exportDir, error) {
+	var months, days []exportDir
+	years, err := os.ReadDir(e.outputDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read export directory: %w", err)
+	}
+	for _, year := range years {
+		y, ok := dateNumber(year, 4, 1, 9999)
+		if !ok {
+			continue
+		}
+		yearPath := filepath.Join(e.outputDir, year.Name())
+		entries, err := os.ReadDir(yearPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read export directory: %w", err)
+		}
+		for _, month := range entries {
+			m, ok := dateNumber(month, 2, 1, 12)
+			if !ok {
+				continue
+			}
+			monthPath := filepath.Join(yearPath, month.Name())
+			months = append(months, exportDir{
+				path:  monthPath,
+				start: time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.Local),
+			})
+			entries, err := os.ReadDir(monthPath)
+			if err != nil {
+				return nil, nil, fmt.Errorf("read export directory: %w", err)
+			}
+			for _, day := range entries {
+				d, ok := dateNumber(day, 2, 1, 31)
+				if !ok {
+					continue
+				}
+				days = append(days, exportDir{
+					path:  filepath.Join(monthPath, day.Name()),
+					start: time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.Local),
+				})
+			}
+		}
+	}
+	byStart := func(a, b exportDir) int { return a.start.Compare(b.start) }
+	slices.SortFunc(months, byStart)
+	slices.SortFunc(days, byStart)
+	return months, days, nil
+}
+
+// dateNumber parses the name of a directory made of digits digits, between
+// lowest and highest.
+func dateNumber(entry fs.DirEntry, digits, lowest, highest int) (int, bool) {
+	if !entry.IsDir() || len(entry.Name()) != digits || !isAllDigits(entry.Name()) {
+		return 0, false
+	}
+	n, err := strconv.Atoi(entry.Name())
+	if err != nil || n < lowest || n > highest {
+		return 0, false
+	}
+	return n, true
+}
+
+// hasDayDirs reports whether the month directory at path still holds days.
+func hasDayDirs(path string) (bool, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return false, fmt.Errorf("read export directory: %w", err)
+	}
+	return slices.ContainsFunc(entries, func(entry fs.DirEntry) bool {
+		_, ok := dateNumber(entry, 2, 1, 31)
+		return ok
+	}), nil
+}
+
+// dirUsage returns the number and the total size of the regular files under
+// dir.
+func dirUsage(dir string) (int, int64, error) {
+	var files int
+	var bytes int64
+	err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files++
+		bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("measure %s: %w", dir, err)
+	}
+	return files, bytes, nil
+}
+
+// removeEmptyDirs removes the empty directories under dir, reporting whether
+// dir itself is left empty. dir is kept.
+func removeEmptyDirs(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, fmt.Errorf("read export directory: %w", err)
+	}
+	empty := true
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			empty = false
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		childEmpty, err := removeEmptyDirs(path)
+		if err != nil {
+			return false, err
+		}
+		if !childEmpty {
+			empty = false
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return false, fmt.Errorf("remove %s: %w", path, err)
+		}
+	}
+	return empty, nil
+}
@@ -0,0 +1,113 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeExportTree creates files of size bytes at the paths, relative to dir.
+func writeExportTree(t *testing.T, dir string, size int, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exists(dir, path string) bool {
+	_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(path)))
+	return err == nil
+}
+
+func TestPruneExports(t *testing.T) {
+	t.Run("removes the days older than the cutoff", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		writeExportTree(t, tmpDir, 100,
+			"2026/03/31/10.0.0.1/23.json",
+			"2026/03/31/10.0.0.1/daily.json",
+			"2026/03/10.0.0.1/monthly.json",
+			"2026/04/01/10.0.0.1/00.json",
+			"2026/04/02/10.0.0.1/00.json",
+			"2026/04/10.0.0.1/monthly.json",
+		)
+		// Not part of the tree.
+		writeExportTree(t, tmpDir, 100, "notes.txt")
+		exporter := NewExporter(tmpDir, 2)
+
+		cutoff := time.Date(2026, 4, 2, 12, 0, 0, 0, time.UTC)
+		result, err := exporter.PruneExports(cutoff, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := PruneResult{Days: 2, Months: 1, Files: 4, Bytes: 400, Size: 300}
+		if result != expected {
+			t.Fatalf("expected %+v, got %+v", expected, result)
+		}
+		if exists(tmpDir, "2026/03") || exists(tmpDir, "2026/04/01") {
+			t.Fatal("expected March and April 1st to be removed")
+		}
+		for _, path := range []string{
+			"2026/04/02/10.0.0.1/00.json",
+			"2026/04/10.0.0.1/monthly.json",
+			"notes.txt",
+		} {
+			if !exists(tmpDir, path) {
+				t.Fatalf("expected %s to be kept", path)
+			}
+		}
+	})
+
+	t.Run("removes the oldest days above the size limit", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		writeExportTree(t, tmpDir, 100,
+			"2025/12/31/10.0.0.1/00.json",
+			"2025/12/31/10.0.0.2/00.json",
+			"2025/12/10.0.0.1/monthly.json",
+			"2026/01/01/10.0.0.1/00.json",
+			"2026/01/02/10.0.0.1/00.json",
+			"2026/01/02/10.0.0.1/01.json",
+		)
+		exporter := NewExporter(tmpDir, 2)
+
+		result, err := exporter.PruneExports(time.Time{}, 300)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := PruneResult{Days: 2, Months: 1, Files: 4, Bytes: 400, Size: 200}
+		if result != expected {
+			t.Fatalf("expected %+v, got %+v", expected, result)
+		}
+		// Empty years are removed.
+		if exists(tmpDir, "2025") || exists(tmpDir, "2026/01/01") {
+			t.Fatal("expected the oldest days to be removed")
+		}
+
+		// The newest day is kept whatever its size.
+		result, err = exporter.PruneExports(time.Time{}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Files != 0 || result.Size != 200 {
+			t.Fatalf("expected the newest day to be kept, got %+v", result)
+		}
+	})
+
+	t.Run("ignores a missing export directory", func(t *testing.T) {
+		exporter := NewExporter(filepath.Join(t.TempDir(), "missing"), 2)
+		result, err := exporter.PruneExports(time.Now(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != (PruneResult{}) {
+			t.Fatalf("expected nothing removed, got %+v", result)
+		}
+	})
+}
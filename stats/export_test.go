@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 )
@@ -444,3 +445,257 @@ func TestExportAll(t *testing.T) {
 		}
 	})
 }
+
+type staticHosts map[string][2]string
+
+func (h staticHosts) LookupHost(ip string) (string, string, bool) {
+	host, ok := h[ip]
+	return host[0], host[1], ok
+}
+
+func TestExportHostLookup(t *testing.T) {
+	store, _ := setupStore(t)
+	defer store.Close() //nolint:errcheck
+
+	payload := AggregatorPayload{
+		LogTimeEnd: 1800,
+		Stats: []AggregatorEntry{
+			{LocalIp: "192.168.1.34", LocalMac: "aa:bb:cc:dd:ee:01", LocalBytes: 10},
+			{LocalIp: "192.168.1.35", LocalMac: "aa:bb:cc:dd:ee:02", LocalBytes: 10},
+			{LocalIp: "192.168.1.36", LocalMac: "aa:bb:cc:dd:ee:03", LocalBytes: 10},
+		},
+	}
+	if err := store.Save(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir := t.TempDir()
+	hosts := staticHosts{
+		"192.168.1.34": {"laptop-anna", "AA:BB:CC:DD:EE:01"},
+		// The address has since been leased to another device.
+		"192.168.1.36": {"phone-bob", "aa:bb:cc:dd:ee:04"},
+	}
+	exporter := NewExporter(tmpDir, 24, WithHostLookup(hosts))
+	if err := exporter.ExportAll(context.Background(), store); err != nil {
+		t.Fatal(err)
+	}
+
+	readReport := func(ip string) HourReport {
+		data, err := os.ReadFile(filepath.Join(tmpDir, "1970", "01", "01", ip, "00.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report HourReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	known := readReport("192.168.1.34")
+	if known.Hostname != "laptop-anna" || known.Mac != "aa:bb:cc:dd:ee:01" {
+		t.Fatalf("expected lease data in report, got %q %q", known.Hostname, known.Mac)
+	}
+	unknown := readReport("192.168.1.35")
+	if unknown.Hostname != "" || unknown.Mac != "aa:bb:cc:dd:ee:02" {
+		t.Fatalf("expected no lease data in report, got %q %q", unknown.Hostname, unknown.Mac)
+	}
+	reassigned := readReport("192.168.1.36")
+	if reassigned.Hostname != "" || reassigned.Mac != "aa:bb:cc:dd:ee:03" {
+		t.Fatalf(
+			"expected the recorded device in report, got %q %q",
+			reassigned.Hostname,
+			reassigned.Mac,
+		)
+	}
+}
+
+func TestBuildReportCountry(t *testing.T) {
+	rows := []HourRow{
+		{LocalIP: "10.0.0.1", Host: "dns.google", Country: "US", TotalBytes: 100},
+		{LocalIP: "10.0.0.1", Host: "example.com", Country: "US", TotalBytes: 50},
+		{LocalIP: "10.0.0.1", Host: "example.fr", Country: "FR", TotalBytes: 20},
+		{LocalIP: "10.0.0.1", Host: "10.0.0.2", TotalBytes: 5},
+	}
+
+	report := BuildReport(rows)
+
+	if report.Country["US"] != 150 {
+		t.Fatalf("expected US 150, got %d", report.Country["US"])
+	}
+	if report.Country["FR"] != 20 {
+		t.Fatalf("expected FR 20, got %d", report.Country["FR"])
+	}
+	if len(report.Country) != 2 {
+		t.Fatalf("expected 2 countries, got %v", report.Country)
+	}
+}
+
+func TestExportSummaries(t *testing.T) {
+	store, _ := setupStore(t)
+	defer store.Close() //nolint:errcheck
+	ctx := context.Background()
+
+	save := func(at time.Time, localBytes int64) {
+		t.Helper()
+		if err := store.Save(ctx, AggregatorPayload{
+			LogTimeEnd: at.Unix(),
+			Stats: []AggregatorEntry{{
+				DetectedApplicationName: "netify.youtube",
+				LocalIp:                 "192.168.1.34",
+				LocalMac:                "aa:bb:cc:dd:ee:01",
+				OtherIp:                 "8.8.8.8",
+				LocalBytes:              localBytes,
+			}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	day := time.Date(2026, 4, 30, 0, 0, 0, 0, time.UTC)
+	save(day.Add(-time.Hour), 1000) // the previous day, same month
+	save(day.Add(10*time.Hour), 100)
+	save(day.Add(11*time.Hour), 10)
+	if err := store.Rollup(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir := t.TempDir()
+	hosts := staticHosts{"192.168.1.34": {"laptop-anna", "aa:bb:cc:dd:ee:01"}}
+	exporter := NewExporter(tmpDir, 2, WithHostLookup(hosts))
+	if err := exporter.ExportAll(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+
+	readReport := func(path ...string) HourReport {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(append([]string{tmpDir}, path...)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report HourReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	daily := readReport("2026", "04", "30", "192.168.1.34", "daily.json")
+	if daily.Total != 110 || daily.Application["netify.youtube"] != 110 {
+		t.Fatalf("unexpected daily report %+v", daily)
+	}
+	if daily.Hostname != "laptop-anna" {
+		t.Fatalf("expected lease data in daily report, got %q", daily.Hostname)
+	}
+	monthly := readReport("2026", "04", "192.168.1.34", "monthly.json")
+	if monthly.Total != 1110 || monthly.Host["8.8.8.8"] != 1110 {
+		t.Fatalf("unexpected monthly report %+v", monthly)
+	}
+	// Changes outside the window are exported too.
+	if daily := readReport("2026", "04", "29", "192.168.1.34", "daily.json"); daily.Total != 1000 {
+		t.Fatalf("expected daily total 1000 on the 29th, got %d", daily.Total)
+	}
+
+	// Summaries follow the rollups when an hour changes.
+	save(day.Add(11*time.Hour+30*time.Minute), 1)
+	if err := store.Rollup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.ExportAll(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	if daily := readReport("2026", "04", "30", "192.168.1.34", "daily.json"); daily.Total != 111 {
+		t.Fatalf("expected daily total 111, got %d", daily.Total)
+	}
+}
+
+func TestExportIncremental(t *testing.T) {
+	store, _ := setupStore(t)
+	defer store.Close() //nolint:errcheck
+	ctx := context.Background()
+
+	hour := time.Date(2026, 5, 4, 9, 0, 0, 0, time.UTC)
+	save := func(localIP string, localBytes int64) {
+		t.Helper()
+		payload := rollupPayload(hour.Add(time.Minute), localIP, "8.8.8.8", localBytes)
+		if err := store.Save(ctx, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save("10.0.0.1", 100)
+	save("10.0.0.2", 100)
+
+	tmpDir := t.TempDir()
+	exporter := NewExporter(tmpDir, 2)
+	paths := map[string]string{}
+	for _, localIP := range []string{"10.0.0.1", "10.0.0.2"} {
+		paths[localIP] = filepath.Join(tmpDir, "2026", "05", "04", localIP, "09.json")
+	}
+	// export runs ExportAll and returns the local IPs whose file was written,
+	// after marking every file as old.
+	export := func() []string {
+		t.Helper()
+		old := time.Unix(0, 0)
+		for _, path := range paths {
+			_ = os.Chtimes(path, old, old)
+		}
+		if err := exporter.ExportAll(ctx, store); err != nil {
+			t.Fatal(err)
+		}
+		var written []string
+		for _, localIP := range []string{"10.0.0.1", "10.0.0.2"} {
+			info, err := os.Stat(paths[localIP])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.ModTime().Equal(old) {
+				written = append(written, localIP)
+			}
+		}
+		return written
+	}
+
+	if written := export(); len(written) != 2 {
+		t.Fatalf("expected both reports on the first export, got %v", written)
+	}
+	if written := export(); len(written) != 0 {
+		t.Fatalf("expected no report without changes, got %v", written)
+	}
+
+	save("10.0.0.1", 10)
+	if written := export(); !slices.Equal(written, []string{"10.0.0.1"}) {
+		t.Fatalf("expected only 10.0.0.1 after its new batch, got %v", written)
+	}
+
+	// A batch leaving the report as it was is not written again.
+	save("10.0.0.2", 0)
+	if written := export(); len(written) != 0 {
+		t.Fatalf("expected identical reports not to be written, got %v", written)
+	}
+
+	if err := store.SaveResolvedHost(ctx, "8.8.8.8", "dns.google"); err != nil {
+		t.Fatal(err)
+	}
+	if written := export(); len(written) != 2 {
+		t.Fatalf("expected both reports after resolving their host, got %v", written)
+	}
+	data, _ := os.ReadFile(paths["10.0.0.2"])
+	var report HourReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Host["dns.google"] != 300 {
+		t.Fatalf("expected the resolved hostname in the report, got %+v", report.Host)
+	}
+
+	// SetWindow exports the window again, without rewriting unchanged files.
+	exporter.SetWindow(1)
+	if err := os.Remove(paths["10.0.0.1"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.ExportAll(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(paths["10.0.0.1"]); err != nil {
+		t.Fatalf("expected the window to be exported again: %v", err)
+	}
+}
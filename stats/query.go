@@ -0,0 +1,257 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Dimension is a rollup column the traffic can be grouped by.
+type Dimension string
+
+const (
+	DimensionLocalIP     Dimension = "local_ip"
+	DimensionLocalMac    Dimension = "local_mac"
+	DimensionApplication Dimension = "application"
+	DimensionProtocol    Dimension = "protocol"
+	DimensionHost        Dimension = "host"
+	DimensionInterface   Dimension = "interface"
+	DimensionOtherPort   Dimension = "other_port"
+)
+
+// Dimensions lists the dimensions accepted by QueryGroups.
+var Dimensions = []Dimension{
+	DimensionLocalIP,
+	DimensionLocalMac,
+	DimensionApplication,
+	DimensionProtocol,
+	DimensionHost,
+	DimensionInterface,
+	DimensionOtherPort,
+}
+
+// Range selects the rollups of Resolution whose period overlaps [From, To).
+type Range struct {
+	Resolution Resolution
+	From       time.Time
+	To         time.Time
+}
+
+// Filter restricts a query to the rollups matching every non-empty field,
+// keyed by dimension.
+type Filter map[Dimension]string
+
+// Totals are the traffic counters of a set of rollups. Total is the sum of
+// LocalBytes and OtherBytes.
+type Totals struct {
+	Entries    int64 `json:"entries"`
+	LocalBytes int64 `json:"local_bytes"`
+	OtherBytes int64 `json:"other_bytes"`
+	Packets    int64 `json:"packets"`
+	Total      int64 `json:"total"`
+}
+
+// Bucket is the traffic of the period starting at Start, a Unix timestamp.
+type Bucket struct {
+	Start int64 `json:"start"`
+	Totals
+}
+
+// Group is the traffic of one value of a dimension.
+type Group struct {
+	Key string
+	Totals
+	Series []Bucket
+}
+
+// GroupQuery selects the traffic of each value of Dimension, largest first.
+// With Series set, each group also carries its traffic per period.
+type GroupQuery struct {
+	Range
+	Filter
+	Dimension Dimension
+	Limit     int
+	Offset    int
+	Series    bool
+}
+
+// totalsColumns sums the counters of the selected rollups, in Totals order.
+const totalsColumns = `SUM(entries), SUM(local_bytes), SUM(other_bytes), SUM(packets),
+    SUM(local_bytes + other_bytes) AS total`
+
+// where returns the condition selecting the rollups of r matching f, and its
+// arguments.
+func (s *Store) where(r Range, f Filter) (string, []any) {
+	conditions := []string{"period_start >= ?", "period_start < ?"}
+	args := []any{s.periodStart(r.Resolution, r.From), r.To.Unix()}
+	// Dimensions are walked in order to keep the statement stable.
+	for _, dimension := range Dimensions {
+		if value := f[dimension]; value != "" {
+			conditions = append(conditions, string(dimension)+" = ?")
+			args = append(args, value)
+		}
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// periodStart returns the start of the period of resolution containing t.
+func (s *Store) periodStart(resolution Resolution, t time.Time) int64 {
+	switch resolution {
+	case Daily:
+		return s.dayStart(t.Unix()).Unix()
+	case Monthly:
+		return s.monthStart(t.Unix()).Unix()
+	}
+	return t.Unix() / 3600 * 3600
+}
+
+// QueryRange returns the traffic of r matching f, grouped like QueryHour, to
+// be summed by BuildReport.
+func (s *Store) QueryRange(ctx context.Context, r Range, f Filter) ([]HourRow, error) {
+	where, args := s.where(r, f)
+	rows, err := s.db.QueryContext(ctx, `
+SELECT local_ip, protocol, application, host, country, asn, threat_feeds,
+    SUM(entries), SUM(local_bytes + other_bytes), MAX(local_mac)
+FROM `+r.Resolution.table()+`
+WHERE `+where+`
+GROUP BY local_ip, protocol, application, host, country, asn, threat_feeds
+ORDER BY local_ip, protocol, application, host, country, asn, threat_feeds
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query %s stats: %w", r.Resolution, err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var result []HourRow
+	for rows.Next() {
+		var row HourRow
+		var threats string
+		if err := rows.Scan(
+			&row.LocalIP,
+			&row.ProtocolName,
+			&row.ApplicationName,
+			&row.Host,
+			&row.Country,
+			&row.Asn,
+			&threats,
+			&row.Entries,
+			&row.TotalBytes,
+			&row.LocalMac,
+		); err != nil {
+			return nil, fmt.Errorf("scan %s stats row: %w", r.Resolution, err)
+		}
+		if threats != "" {
+			row.Threats = strings.Split(threats, ",")
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s stats rows: %w", r.Resolution, err)
+	}
+	return result, nil
+}
+
+// QuerySeries returns the traffic of r matching f per period, oldest first.
+// Periods without traffic are omitted.
+func (s *Store) QuerySeries(ctx context.Context, r Range, f Filter) ([]Bucket, error) {
+	where, args := s.where(r, f)
+	rows, err := s.db.QueryContext(ctx, `
+SELECT period_start, `+totalsColumns+`
+FROM `+r.Resolution.table()+`
+WHERE `+where+`
+GROUP BY period_start
+ORDER BY period_start
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query %s series: %w", r.Resolution, err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	series := []Bucket{}
+	for rows.Next() {
+		var bucket Bucket
+		fields := append([]any{&bucket.Start}, totalsFields(&bucket.Totals)...)
+		if err := rows.Scan(fields...); err != nil {
+			return nil, fmt.Errorf("scan %s series: %w", r.Resolution, err)
+		}
+		series = append(series, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s series: %w", r.Resolution, err)
+	}
+	return series, nil
+}
+
+// QueryGroups ranks the groups selected by q, returning a page of them and
+// the number of groups across all pages. Ties are ordered by key; rollups
+// without a value for the dimension are left out.
+func (s *Store) QueryGroups(ctx context.Context, q GroupQuery) ([]Group, int, error) {
+	if !slices.Contains(Dimensions, q.Dimension) {
+		return nil, 0, fmt.Errorf("unknown dimension %q", q.Dimension)
+	}
+	where, args := s.where(q.Range, q.Filter)
+	table := q.Resolution.table()
+	column := string(q.Dimension)
+	if q.Dimension == DimensionOtherPort {
+		where += " AND other_port != 0"
+	} else {
+		where += " AND " + column + " != ''"
+	}
+
+	var count int
+	if err := s.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(DISTINCT `+column+`) FROM `+table+` WHERE `+where,
+		args...,
+	).Scan(&count); err != nil {
+		return nil, 0, fmt.Errorf("count %s groups: %w", q.Dimension, err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+column+`, `+totalsColumns+`
+FROM `+table+`
+WHERE `+where+`
+GROUP BY `+column+`
+ORDER BY total DESC, `+column+`
+LIMIT ? OFFSET ?
+	`, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query %s groups: %w", q.Dimension, err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	groups := []Group{}
+	for rows.Next() {
+		var group Group
+		fields := append([]any{&group.Key}, totalsFields(&group.Totals)...)
+		if err := rows.Scan(fields...); err != nil {
+			return nil, 0, fmt.Errorf("scan %s group: %w", q.Dimension, err)
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate %s groups: %w", q.Dimension, err)
+	}
+
+	if q.Series {
+		for i := range groups {
+			filter := Filter{q.Dimension: groups[i].Key}
+			for dimension, value := range q.Filter {
+				if dimension != q.Dimension {
+					filter[dimension] = value
+				}
+			}
+			if groups[i].Series, err = s.QuerySeries(ctx, q.Range, filter); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return groups, count, nil
+}
+
+// totalsFields returns the scan destinations of totalsColumns.
+func totalsFields(t *Totals) []any {
+	return []any{&t.Entries, &t.LocalBytes, &t.OtherBytes, &t.Packets, &t.Total}
+}
@@ -0,0 +1,235 @@
+package stats
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreQueries(t *testing.T) {
+	store, db := setupStore(t)
+	defer store.Close() //nolint:errcheck
+	defer db.Close()    //nolint:errcheck
+	store.location = time.UTC
+	ctx := context.Background()
+
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	payloads := []AggregatorPayload{
+		rollupPayload(day.Add(9*time.Hour+5*time.Minute), "10.0.0.1", "1.1.1.1", 100),
+		rollupPayload(day.Add(10*time.Hour+5*time.Minute), "10.0.0.1", "8.8.8.8", 10),
+		rollupPayload(day.Add(10*time.Hour+6*time.Minute), "10.0.0.2", "8.8.8.8", 1000),
+		rollupPayload(day.Add(11*time.Hour+5*time.Minute), "10.0.0.3", "9.9.9.9", 1),
+	}
+	payloads[0].Stats[0].DetectedApplicationName = "netify.cloudflare"
+	for _, payload := range payloads {
+		if err := store.Save(ctx, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Rollup(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The range starts within the 9:00 hour, which is included.
+	r := Range{
+		Resolution: Hourly,
+		From:       day.Add(9*time.Hour + 30*time.Minute),
+		To:         day.Add(11 * time.Hour),
+	}
+
+	series, err := store.QuerySeries(ctx, r, Filter{DimensionLocalIP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSeries := []Bucket{
+		{Start: day.Add(9 * time.Hour).Unix(), Totals: Totals{1, 100, 200, 0, 300}},
+		{Start: day.Add(10 * time.Hour).Unix(), Totals: Totals{1, 10, 20, 0, 30}},
+	}
+	if !reflect.DeepEqual(series, expectedSeries) {
+		t.Fatalf("series mismatch\n got: %#v\nwant: %#v", series, expectedSeries)
+	}
+
+	rows, err := store.QueryRange(ctx, r, Filter{DimensionLocalIP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := BuildReport(rows)
+	if report.Total != 330 || report.Host["1.1.1.1"] != 300 ||
+		report.Application["netify.cloudflare"] != 300 || report.Protocol["HTTP/S"] != 330 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	groups, count, err := store.QueryGroups(ctx, GroupQuery{
+		Range:     r,
+		Dimension: DimensionHost,
+		Limit:     1,
+		Series:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 destinations, got %d", count)
+	}
+	expectedGroups := []Group{{
+		Key:    "8.8.8.8",
+		Totals: Totals{2, 1010, 2020, 0, 3030},
+		Series: []Bucket{
+			{Start: day.Add(10 * time.Hour).Unix(), Totals: Totals{2, 1010, 2020, 0, 3030}},
+		},
+	}}
+	if !reflect.DeepEqual(groups, expectedGroups) {
+		t.Fatalf("groups mismatch\n got: %#v\nwant: %#v", groups, expectedGroups)
+	}
+
+	// Entries without an application are left out of the application groups.
+	groups, count, err = store.QueryGroups(ctx, GroupQuery{
+		Range:     r,
+		Dimension: DimensionApplication,
+		Limit:     10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || len(groups) != 1 || groups[0].Key != "netify.cloudflare" {
+		t.Fatalf("unexpected application groups %#v (count %d)", groups, count)
+	}
+
+	// Days start at local midnight.
+	daily := Range{Resolution: Daily, From: day.Add(12 * time.Hour), To: day.Add(13 * time.Hour)}
+	series, err = store.QuerySeries(ctx, daily, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || series[0].Start != day.Unix() || series[0].Total != 3333 {
+		t.Fatalf("unexpected daily series %#v", series)
+	}
+}
+
+func TestStoreRanking(t *testing.T) {
+	store, db := setupStore(t)
+	defer store.Close() //nolint:errcheck
+	defer db.Close()    //nolint:errcheck
+	ctx := context.Background()
+
+	hour := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	payload := AggregatorPayload{LogTimeEnd: hour.Add(time.Minute).Unix()}
+	for _, entry := range []struct {
+		localIP string
+		app     string
+		iface   string
+		port    int
+		bytes   int64
+	}{
+		{"10.0.0.1", "netify.bittorrent", "br-lan", 6881, 5000},
+		{"10.0.0.2", "netify.bittorrent", "br-lan", 6881, 100},
+		{"10.0.0.2", "netify.youtube", "br-lan", 443, 9000},
+		{"10.0.1.1", "netify.youtube", "br-guest", 443, 1},
+		{"10.0.1.1", "", "br-guest", 0, 1},
+	} {
+		payload.Stats = append(payload.Stats, AggregatorEntry{
+			DetectedApplicationName: entry.app,
+			Interface:               entry.iface,
+			LocalIp:                 entry.localIP,
+			LocalBytes:              entry.bytes,
+			OtherIp:                 "203.0.113.1",
+			OtherPort:               entry.port,
+		})
+	}
+	if err := store.Save(ctx, payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Rollup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r := Range{Resolution: Hourly, From: hour, To: hour.Add(time.Hour)}
+
+	keys := func(q GroupQuery) []string {
+		t.Helper()
+		q.Range = r
+		q.Limit = 10
+		groups, _, err := store.QueryGroups(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, group := range groups {
+			keys = append(keys, group.Key)
+		}
+		return keys
+	}
+
+	// Which hosts used BitTorrent?
+	got := keys(GroupQuery{
+		Dimension: DimensionLocalIP,
+		Filter:    Filter{DimensionApplication: "netify.bittorrent"},
+	})
+	if !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Fatalf("unexpected BitTorrent hosts %v", got)
+	}
+	// Ports without a value are left out.
+	got = keys(GroupQuery{Dimension: DimensionOtherPort})
+	if !reflect.DeepEqual(got, []string{"443", "6881"}) {
+		t.Fatalf("unexpected ports %v", got)
+	}
+	got = keys(GroupQuery{
+		Dimension: DimensionInterface,
+		Filter:    Filter{DimensionOtherPort: "443"},
+	})
+	if !reflect.DeepEqual(got, []string{"br-lan", "br-guest"}) {
+		t.Fatalf("unexpected interfaces %v", got)
+	}
+
+	if _, _, err := store.QueryGroups(ctx, GroupQuery{Range: r, Dimension: "country"}); err == nil {
+		t.Fatal("expected an error for an unknown dimension")
+	}
+}
+
+func TestQueriesUseIndexes(t *testing.T) {
+	store, db := setupStore(t)
+	defer store.Close() //nolint:errcheck
+	defer db.Close()    //nolint:errcheck
+
+	r := Range{Resolution: Daily, From: time.Unix(0, 0), To: time.Unix(86400, 0)}
+	for filter, index := range map[string]string{
+		"":                   "idx_stats_daily_period",
+		"local_ip":           "idx_stats_daily_local_ip",
+		"application":        "idx_stats_daily_application",
+		"host":               "idx_stats_daily_host",
+		"application+others": "idx_stats_daily_application",
+	} {
+		f := Filter{}
+		switch filter {
+		case "application+others":
+			f[DimensionApplication] = "netify.youtube"
+			f[DimensionInterface] = "br-lan"
+		case "":
+		default:
+			f[Dimension(filter)] = "x"
+		}
+		where, args := store.where(r, f)
+		var plan strings.Builder
+		rows, err := db.Query(
+			"EXPLAIN QUERY PLAN SELECT protocol, SUM(local_bytes) FROM stats_daily WHERE "+
+				where+" GROUP BY protocol",
+			args...,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+				t.Fatal(err)
+			}
+			plan.WriteString(detail + "\n")
+		}
+		_ = rows.Close()
+		if !strings.Contains(plan.String(), "USING INDEX "+index) {
+			t.Errorf("filter %q: expected %s in plan:\n%s", filter, index, plan.String())
+		}
+	}
+}
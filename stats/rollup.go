@@ -0,0 +1,419 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Resolution identifies a rollup level.
+type Resolution string
+
+const (
+	Hourly  Resolution = "hourly"
+	Daily   Resolution = "daily"
+	Monthly Resolution = "monthly"
+)
+
+// Resolutions lists the rollup levels, finest first.
+var Resolutions = []Resolution{Hourly, Daily, Monthly}
+
+// table returns the rollup table of the resolution.
+func (r Resolution) table() string {
+	return "stats_" + string(r)
+}
+
+// rollupSchema creates a rollup table. Every row aggregates the raw entries of
+// one period sharing the same dimensions; periods start on the hour, or at
+// local midnight of the day or of the first day of the month.
+const rollupSchema = `
+CREATE TABLE IF NOT EXISTS %[1]s (
+    period_start INTEGER NOT NULL,
+    local_ip VARCHAR NOT NULL,
+    local_mac VARCHAR NOT NULL,
+    protocol TEXT NOT NULL,
+    application TEXT NOT NULL,
+    host TEXT NOT NULL,
+    country TEXT NOT NULL,
+    asn TEXT NOT NULL,
+    threat_feeds TEXT NOT NULL,
+    interface TEXT NOT NULL DEFAULT '',
+    other_port INTEGER NOT NULL DEFAULT 0,
+    entries INTEGER NOT NULL,
+    local_bytes INTEGER NOT NULL,
+    other_bytes INTEGER NOT NULL,
+    packets INTEGER NOT NULL
+);
+`
+
+// rollupIndexes index a rollup table for range scans and for the dimensions
+// most often filtered on, each followed by the period to also bound the
+// range. They are created once the added columns exist.
+const rollupIndexes = `
+CREATE INDEX IF NOT EXISTS idx_%[1]s_period
+    ON %[1]s(period_start, local_ip);
+
+CREATE INDEX IF NOT EXISTS idx_%[1]s_local_ip
+    ON %[1]s(local_ip, period_start);
+
+CREATE INDEX IF NOT EXISTS idx_%[1]s_application
+    ON %[1]s(application, period_start);
+
+CREATE INDEX IF NOT EXISTS idx_%[1]s_host
+    ON %[1]s(host, period_start);
+`
+
+// stateSchema holds the markers that must survive restarts. pruned_before is
+// the highest cutoff passed to DeleteOlderThan: raw hours starting at or after
+// it are complete. rolled_up_through and exported_through are the last
+// stats_changes sequence numbers seen by Rollup and passed to ClearChanges.
+const stateSchema = `
+CREATE TABLE IF NOT EXISTS stats_state (
+    key TEXT PRIMARY KEY,
+    value INTEGER NOT NULL
+);
+`
+
+const (
+	stateKeyPrunedBefore    = "pruned_before"
+	stateKeyRolledUpThrough = "rolled_up_through"
+	stateKeyExportedThrough = "exported_through"
+)
+
+// rollupDimensions are the columns identifying a rollup row.
+const rollupDimensions = `local_ip, local_mac, protocol, application, host, country, asn,
+    threat_feeds, interface, other_port`
+
+// initRollupSchema creates the rollup and state tables; indexRollups adds
+// their indexes after migrateSchema.
+func initRollupSchema(ctx context.Context, db *sql.DB) error {
+	for _, resolution := range Resolutions {
+		schema := fmt.Sprintf(rollupSchema, resolution.table())
+		if _, err := db.ExecContext(ctx, schema); err != nil {
+			return fmt.Errorf("initialize %s rollup schema: %w", resolution, err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, stateSchema); err != nil {
+		return fmt.Errorf("initialize stats state schema: %w", err)
+	}
+	return nil
+}
+
+func indexRollups(ctx context.Context, db *sql.DB) error {
+	for _, resolution := range Resolutions {
+		indexes := fmt.Sprintf(rollupIndexes, resolution.table())
+		if _, err := db.ExecContext(ctx, indexes); err != nil {
+			return fmt.Errorf("index %s rollups: %w", resolution, err)
+		}
+	}
+	return nil
+}
+
+// Rollup compacts the raw stats into the hourly, daily and monthly rollups.
+// Only the hours still complete in the raw tables that received a batch or a
+// resolved hostname since the last run are computed again, followed by the
+// days and months containing them; the first run computes every complete
+// hour. It must run before DeleteOlderThan removes an hour. The days and
+// months computed are marked as changed for the exporter.
+func (s *Store) Rollup(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin rollup transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var prunedBefore, rolledUpThrough int64
+	var rolledUp bool
+	if prunedBefore, _, err = readState(ctx, tx, stateKeyPrunedBefore); err != nil {
+		return err
+	}
+	if rolledUpThrough, rolledUp, err = readState(ctx, tx, stateKeyRolledUpThrough); err != nil {
+		return err
+	}
+	// The hour containing the last cutoff lost some of its batches.
+	completeFrom := (prunedBefore + 3599) / 3600 * 3600
+
+	var hours []int64
+	var through int64
+	if rolledUp {
+		hours, through, err = changedHours(ctx, tx, rolledUpThrough)
+	} else {
+		hours, through, err = allHours(ctx, tx)
+	}
+	if err != nil {
+		return err
+	}
+	hours = slices.DeleteFunc(hours, func(hour int64) bool { return hour < completeFrom })
+
+	var days, months []time.Time
+	for _, hour := range hours {
+		if err = rollupHour(ctx, tx, hour); err != nil {
+			return err
+		}
+		if day := s.dayStart(hour); !slices.Contains(days, day) {
+			days = append(days, day)
+		}
+		if month := s.monthStart(hour); !slices.Contains(months, month) {
+			months = append(months, month)
+		}
+	}
+	for _, day := range days {
+		if err = rollupPeriod(ctx, tx, Hourly, Daily, day, day.AddDate(0, 0, 1)); err != nil {
+			return err
+		}
+	}
+	for _, month := range months {
+		next := month.AddDate(0, 1, 0)
+		if err = rollupPeriod(ctx, tx, Daily, Monthly, month, next); err != nil {
+			return err
+		}
+	}
+
+	through = max(through, rolledUpThrough)
+	if err = writeState(ctx, tx, stateKeyRolledUpThrough, through); err != nil {
+		return err
+	}
+	// Hourly changes are kept until both the rollup and the exporter saw them.
+	if _, err = tx.ExecContext(ctx, `
+DELETE FROM stats_changes
+WHERE resolution = ?
+    AND seq <= MIN(?, COALESCE((SELECT value FROM stats_state WHERE key = ?), 0))
+	`, Hourly, through, stateKeyExportedThrough); err != nil {
+		return fmt.Errorf("delete rolled up changes: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit rollup transaction: %w", err)
+	}
+	if len(hours) > 0 {
+		slog.Debug("Rolled up stats", "hours", len(hours), "days", len(days))
+	}
+	return nil
+}
+
+// changedHours returns the hours with raw changes after the sequence number
+// after, and the last sequence number read.
+func changedHours(ctx context.Context, tx *sql.Tx, after int64) ([]int64, int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT period_start, MAX(seq)
+FROM stats_changes
+WHERE resolution = ? AND seq > ?
+GROUP BY period_start
+ORDER BY period_start
+	`, Hourly, after)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query changed hours: %w", err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var hours []int64
+	through := after
+	for rows.Next() {
+		var hour, seq int64
+		if err := rows.Scan(&hour, &seq); err != nil {
+			return nil, 0, fmt.Errorf("scan changed hour: %w", err)
+		}
+		hours = append(hours, hour)
+		through = max(through, seq)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate changed hours: %w", err)
+	}
+	return hours, through, nil
+}
+
+// allHours returns every hour held in the raw tables, and the last sequence
+// number of the raw changes they include.
+func allHours(ctx context.Context, tx *sql.Tx) ([]int64, int64, error) {
+	var through int64
+	if err := tx.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(MAX(seq), 0) FROM stats_changes WHERE resolution = ?`,
+		Hourly,
+	).Scan(&through); err != nil {
+		return nil, 0, fmt.Errorf("query last change: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+SELECT DISTINCT (log_time_end / 3600) * 3600 AS hour_epoch
+FROM aggregator_batches
+ORDER BY hour_epoch
+	`)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query raw hours: %w", err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var hours []int64
+	for rows.Next() {
+		var hour int64
+		if err := rows.Scan(&hour); err != nil {
+			return nil, 0, fmt.Errorf("scan raw hour: %w", err)
+		}
+		hours = append(hours, hour)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate raw hours: %w", err)
+	}
+	return hours, through, nil
+}
+
+// rollupHour replaces the hourly rollup of the hour starting at hour with
+// aggregates of the raw stats.
+func rollupHour(ctx context.Context, tx *sql.Tx, hour int64) error {
+	if _, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM stats_hourly WHERE period_start = ?`,
+		hour,
+	); err != nil {
+		return fmt.Errorf("delete hourly rollup at %d: %w", hour, err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO stats_hourly (
+    period_start, `+rollupDimensions+`,
+    entries, local_bytes, other_bytes, packets
+)
+SELECT
+    ?,
+    COALESCE(s.local_ip, '') AS local_ip,
+    COALESCE(s.local_mac, '') AS local_mac,
+    COALESCE(s.detected_protocol_name, '') AS protocol,
+    COALESCE(s.detected_application_name, '') AS application,
+    COALESCE(s.other_host, s.other_ip, '') AS host,
+    COALESCE(s.other_country, '') AS country,
+    COALESCE('AS' || s.other_asn || COALESCE(' ' || s.other_as_org, ''), '') AS asn,
+    COALESCE(s.threat_feeds, '') AS threat_feeds,
+    COALESCE(s.interface, '') AS interface,
+    COALESCE(s.other_port, 0) AS other_port,
+    COUNT(*),
+    COALESCE(SUM(s.local_bytes), 0),
+    COALESCE(SUM(s.other_bytes), 0),
+    COALESCE(SUM(s.packets), 0)
+FROM aggregator_stats s
+JOIN aggregator_batches b ON s.batch_id = b.id
+WHERE b.log_time_end >= ? AND b.log_time_end < ?
+GROUP BY `+rollupDimensions+`
+	`, hour, hour, hour+3600); err != nil {
+		return fmt.Errorf("insert hourly rollup at %d: %w", hour, err)
+	}
+	return nil
+}
+
+// readState returns the value of a stats_state key and whether it is set.
+func readState(ctx context.Context, tx *sql.Tx, key string) (int64, bool, error) {
+	var value int64
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT value FROM stats_state WHERE key = ?`,
+		key,
+	).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("query %s marker: %w", key, err)
+	}
+	return value, true, nil
+}
+
+// writeState raises the value of a stats_state key to value.
+func writeState(ctx context.Context, tx *sql.Tx, key string, value int64) error {
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO stats_state (key, value) VALUES (?, ?)
+ON CONFLICT (key) DO UPDATE SET value = MAX(value, excluded.value)
+	`, key, value); err != nil {
+		return fmt.Errorf("save %s marker: %w", key, err)
+	}
+	return nil
+}
+
+// rollupPeriod replaces the rollup of the period [start, end) at resolution
+// to with the sum of its rows at resolution from.
+func rollupPeriod(
+	ctx context.Context,
+	tx *sql.Tx,
+	from, to Resolution,
+	start, end time.Time,
+) error {
+	if _, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM `+to.table()+` WHERE period_start = ?`,
+		start.Unix(),
+	); err != nil {
+		return fmt.Errorf("delete %s rollup at %d: %w", to, start.Unix(), err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO `+to.table()+` (
+    period_start, `+rollupDimensions+`,
+    entries, local_bytes, other_bytes, packets
+)
+SELECT
+    ?, `+rollupDimensions+`,
+    SUM(entries), SUM(local_bytes), SUM(other_bytes), SUM(packets)
+FROM `+from.table()+`
+WHERE period_start >= ? AND period_start < ?
+GROUP BY `+rollupDimensions+`
+	`, start.Unix(), start.Unix(), end.Unix()); err != nil {
+		return fmt.Errorf("insert %s rollup at %d: %w", to, start.Unix(), err)
+	}
+	if _, err := tx.ExecContext(ctx, markChanges+`
+SELECT DISTINCT ?, period_start, local_ip FROM `+to.table()+` WHERE period_start = ?
+	`, to, start.Unix()); err != nil {
+		return fmt.Errorf("mark %s rollup at %d changed: %w", to, start.Unix(), err)
+	}
+	return nil
+}
+
+// DeleteRollupsOlderThan removes the rollups of resolution whose period
+// started before cutoff.
+func (s *Store) DeleteRollupsOlderThan(
+	ctx context.Context,
+	resolution Resolution,
+	cutoff int64,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin %s rollup prune transaction: %w", resolution, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM `+resolution.table()+` WHERE period_start < ?`,
+		cutoff,
+	); err != nil {
+		return fmt.Errorf("delete expired %s rollups: %w", resolution, err)
+	}
+	if err = deleteChangesBefore(ctx, tx, resolution, cutoff); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit %s rollup prune transaction: %w", resolution, err)
+	}
+	return nil
+}
+
+// dayStart returns the local midnight starting the day of epoch.
+func (s *Store) dayStart(epoch int64) time.Time {
+	t := time.Unix(epoch, 0).In(s.location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.location)
+}
+
+// monthStart returns the local midnight starting the month of epoch.
+func (s *Store) monthStart(epoch int64) time.Time {
+	t := time.Unix(epoch, 0).In(s.location)
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, s.location)
+}
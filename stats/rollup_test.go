@@ -0,0 +1,241 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type rollupRow struct {
+	PeriodStart int64
+	LocalIP     string
+	Host        string
+	Entries     int64
+	LocalBytes  int64
+	OtherBytes  int64
+}
+
+func queryRollup(t *testing.T, db *sql.DB, resolution Resolution) []rollupRow {
+	t.Helper()
+
+	rows, err := db.Query(`
+SELECT period_start, local_ip, host, entries, local_bytes, other_bytes
+FROM ` + resolution.table() + `
+ORDER BY period_start, local_ip, host`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var result []rollupRow
+	for rows.Next() {
+		var row rollupRow
+		if err := rows.Scan(
+			&row.PeriodStart,
+			&row.LocalIP,
+			&row.Host,
+			&row.Entries,
+			&row.LocalBytes,
+			&row.OtherBytes,
+		); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func rollupPayload(at time.Time, localIP, otherIP string, bytes int64) AggregatorPayload {
+	return AggregatorPayload{
+		LogTimeEnd: at.Unix(),
+		Stats: []AggregatorEntry{{
+			DetectedProtocolName: "HTTP/S",
+			LocalIp:              localIP,
+			LocalMac:             "02:00:00:00:00:01",
+			LocalBytes:           bytes,
+			OtherBytes:           2 * bytes,
+			OtherIp:              otherIP,
+		}},
+	}
+}
+
+func TestStoreRollup(t *testing.T) {
+	store, db := setupStore(t)
+	defer store.Close() //nolint:errcheck
+	defer db.Close()    //nolint:errcheck
+	store.location = time.UTC
+	ctx := context.Background()
+
+	day := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+	for _, payload := range []AggregatorPayload{
+		rollupPayload(day.Add(22*time.Hour+10*time.Minute), "10.0.0.1", "1.1.1.1", 100),
+		rollupPayload(day.Add(22*time.Hour+20*time.Minute), "10.0.0.1", "1.1.1.1", 10),
+		rollupPayload(day.Add(23*time.Hour+30*time.Minute), "10.0.0.2", "8.8.8.8", 1),
+	} {
+		if err := store.Save(ctx, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Rollup(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	hour22 := day.Add(22 * time.Hour).Unix()
+	hour23 := day.Add(23 * time.Hour).Unix()
+	expected := []rollupRow{
+		{hour22, "10.0.0.1", "1.1.1.1", 2, 110, 220},
+		{hour23, "10.0.0.2", "8.8.8.8", 1, 1, 2},
+	}
+	if got := queryRollup(t, db, Hourly); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("hourly rollup mismatch\n got: %#v\nwant: %#v", got, expected)
+	}
+
+	// Hostnames resolved later replace the address once rolled up again.
+	if err := store.SaveResolvedHost(ctx, "1.1.1.1", "one.one.one.one"); err != nil {
+		t.Fatal(err)
+	}
+	// The 22:00 hour leaves the raw tables; its rollup is kept.
+	if err := store.Rollup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteOlderThan(ctx, hour23); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next day, in the next month, arrives.
+	next := day.AddDate(0, 0, 1)
+	if err := store.Save(
+		ctx,
+		rollupPayload(next.Add(5*time.Minute), "10.0.0.1", "1.1.1.1", 1000),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Rollup(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []rollupRow{
+		{hour22, "10.0.0.1", "one.one.one.one", 2, 110, 220},
+		{hour23, "10.0.0.2", "8.8.8.8", 1, 1, 2},
+		{next.Unix(), "10.0.0.1", "1.1.1.1", 1, 1000, 2000},
+	}
+	if got := queryRollup(t, db, Hourly); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("hourly rollup mismatch\n got: %#v\nwant: %#v", got, expected)
+	}
+
+	expected = []rollupRow{
+		{day.Unix(), "10.0.0.1", "one.one.one.one", 2, 110, 220},
+		{day.Unix(), "10.0.0.2", "8.8.8.8", 1, 1, 2},
+		{next.Unix(), "10.0.0.1", "1.1.1.1", 1, 1000, 2000},
+	}
+	if got := queryRollup(t, db, Daily); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("daily rollup mismatch\n got: %#v\nwant: %#v", got, expected)
+	}
+
+	january := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	expected = []rollupRow{
+		{january, "10.0.0.1", "one.one.one.one", 2, 110, 220},
+		{january, "10.0.0.2", "8.8.8.8", 1, 1, 2},
+		{next.Unix(), "10.0.0.1", "1.1.1.1", 1, 1000, 2000},
+	}
+	if got := queryRollup(t, db, Monthly); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("monthly rollup mismatch\n got: %#v\nwant: %#v", got, expected)
+	}
+
+	if err := store.DeleteRollupsOlderThan(ctx, Hourly, hour23); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(queryRollup(t, db, Hourly)); got != 2 {
+		t.Fatalf("expected 2 hourly rollups after delete, got %d", got)
+	}
+	if got := len(queryRollup(t, db, Daily)); got != 3 {
+		t.Fatalf("expected daily rollups to be kept, got %d", got)
+	}
+}
+
+func TestStoreRollupEmpty(t *testing.T) {
+	store, db := setupStore(t)
+	defer store.Close() //nolint:errcheck
+	defer db.Close()    //nolint:errcheck
+
+	if err := store.Rollup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryRollup(t, db, Hourly); len(got) != 0 {
+		t.Fatalf("expected no rollups, got %v", got)
+	}
+}
+
+func TestStoreRollupChangedHours(t *testing.T) {
+	store, db := setupStore(t)
+	defer store.Close() //nolint:errcheck
+	defer db.Close()    //nolint:errcheck
+	store.location = time.UTC
+	ctx := context.Background()
+
+	day := time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+	hour9 := day.Add(9 * time.Hour).Unix()
+	hour10 := day.Add(10 * time.Hour).Unix()
+	first := rollupPayload(day.Add(9*time.Hour), "10.0.0.1", "1.1.1.1", 100)
+	if err := store.Save(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Rollup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// A rollup rewritten by a later run would lose this marker.
+	if _, err := db.Exec(
+		`UPDATE stats_hourly SET entries = 99 WHERE period_start = ?`,
+		hour9,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// The exporter clearing the changes first does not hide them from Rollup.
+	second := rollupPayload(day.Add(10*time.Hour), "10.0.0.1", "1.1.1.1", 10)
+	if err := store.Save(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	_, through, err := store.Changes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ClearChanges(ctx, through); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Rollup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expected := []rollupRow{
+		{hour9, "10.0.0.1", "1.1.1.1", 99, 100, 200},
+		{hour10, "10.0.0.1", "1.1.1.1", 1, 10, 20},
+	}
+	if got := queryRollup(t, db, Hourly); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("hourly rollup mismatch\n got: %#v\nwant: %#v", got, expected)
+	}
+	expected = []rollupRow{{day.Unix(), "10.0.0.1", "1.1.1.1", 100, 110, 220}}
+	if got := queryRollup(t, db, Daily); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("daily rollup mismatch\n got: %#v\nwant: %#v", got, expected)
+	}
+
+	// Once seen by both, the hourly changes are deleted.
+	changes, through, err := store.Changes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ClearChanges(ctx, through); err != nil {
+		t.Fatal(err)
+	}
+	var left int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM stats_changes`).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || left != 0 {
+		t.Fatalf("expected the daily and monthly changes only, got %v and %d left", changes, left)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -59,7 +61,12 @@ CREATE TABLE IF NOT EXISTS aggregator_stats (
     other_host TEXT,
     other_port INTEGER,
     other_type TEXT,
-    packets INTEGER
+    packets INTEGER,
+    other_country TEXT,
+    other_city TEXT,
+    other_asn INTEGER,
+    other_as_org TEXT,
+    threat_feeds TEXT
 );
 
 CREATE INDEX IF NOT EXISTS idx_stats_batch_id
@@ -84,8 +91,75 @@ CREATE INDEX IF NOT EXISTS idx_batches_log_time_end
     ON aggregator_batches(log_time_end);
 `
 
+// addedColumns lists the columns added to existing tables after the first
+// release, so that databases created by older versions can be upgraded.
+var addedColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"aggregator_stats", "other_country", "TEXT"},
+	{"aggregator_stats", "other_city", "TEXT"},
+	{"aggregator_stats", "other_asn", "INTEGER"},
+	{"aggregator_stats", "other_as_org", "TEXT"},
+	{"aggregator_stats", "threat_feeds", "TEXT"},
+	{"stats_hourly", "interface", "TEXT NOT NULL DEFAULT ''"},
+	{"stats_hourly", "other_port", "INTEGER NOT NULL DEFAULT 0"},
+	{"stats_daily", "interface", "TEXT NOT NULL DEFAULT ''"},
+	{"stats_daily", "other_port", "INTEGER NOT NULL DEFAULT 0"},
+	{"stats_monthly", "interface", "TEXT NOT NULL DEFAULT ''"},
+	{"stats_monthly", "other_port", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// GeoLocator resolves the country and city of a remote IP.
+type GeoLocator interface {
+	Locate(ip string) (country, city string, ok bool)
+}
+
+// AsnLookup resolves the autonomous system announcing a remote IP.
+type AsnLookup interface {
+	LookupAsn(ip string) (number uint32, organization string, ok bool)
+}
+
+// ThreatMatcher returns the threat-intelligence feeds listing an address or a
+// domain name.
+type ThreatMatcher interface {
+	MatchIP(ip string) []string
+	MatchDomain(name string) []string
+}
+
 type Store struct {
-	db *sql.DB
+	db      *sql.DB
+	geo     GeoLocator
+	asn     AsnLookup
+	threats ThreatMatcher
+	// location sets the day and month boundaries of the rollups.
+	location *time.Location
+}
+
+// StoreOption configures optional Store features.
+type StoreOption func(*Store)
+
+// WithGeoLocator stores the country and city of other_ip alongside each entry.
+func WithGeoLocator(geo GeoLocator) StoreOption {
+	return func(s *Store) {
+		s.geo = geo
+	}
+}
+
+// WithAsnLookup stores the autonomous system of other_ip alongside each entry.
+func WithAsnLookup(asn AsnLookup) StoreOption {
+	return func(s *Store) {
+		s.asn = asn
+	}
+}
+
+// WithThreatMatcher records the feeds listing other_ip at ingest, and those
+// listing its hostname once resolved.
+func WithThreatMatcher(threats ThreatMatcher) StoreOption {
+	return func(s *Store) {
+		s.threats = threats
+	}
 }
 
 type Saver interface {
@@ -97,10 +171,17 @@ type HourRow struct {
 	ProtocolName    string
 	ApplicationName string
 	Host            string
+	Country         string
+	Asn             string
+	Threats         []string
+	Entries         int64
 	TotalBytes      int64
+	// LocalMac is the MAC address the stats were recorded with, the greatest
+	// when the grouped rows carry several.
+	LocalMac string
 }
 
-func NewStore(ctx context.Context, dbPath string) (*Store, error) {
+func NewStore(ctx context.Context, dbPath string, opts ...StoreOption) (*Store, error) {
 	db, err := sql.Open("sqlite", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("open sqlite database: %w", err)
@@ -134,7 +215,62 @@ func NewStore(ctx context.Context, dbPath string) (*Store, error) {
 		return nil, fmt.Errorf("initialize stats schema: %w", err)
 	}
 
-	return &Store{db: db}, nil
+	if err := initRollupSchema(ctx, db); err != nil {
+		return nil, err
+	}
+
+	if err := migrateSchema(ctx, db); err != nil {
+		return nil, err
+	}
+
+	if err := indexRollups(ctx, db); err != nil {
+		return nil, err
+	}
+
+	if _, err := db.ExecContext(ctx, changesSchema); err != nil {
+		return nil, fmt.Errorf("initialize stats changes schema: %w", err)
+	}
+
+	store := &Store{db: db, location: time.Local}
+	for _, opt := range opts {
+		opt(store)
+	}
+	return store, nil
+}
+
+// migrateSchema adds the columns missing from tables created by older versions.
+func migrateSchema(ctx context.Context, db *sql.DB) error {
+	for _, added := range addedColumns {
+		exists, err := columnExists(ctx, db, added.table, added.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(
+			"ALTER TABLE %s ADD COLUMN %s %s",
+			added.table,
+			added.column,
+			added.definition,
+		)); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", added.table, added.column, err)
+		}
+	}
+	return nil
+}
+
+func columnExists(ctx context.Context, db *sql.DB, table, column string) (bool, error) {
+	var count int
+	if err := db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`,
+		table,
+		column,
+	).Scan(&count); err != nil {
+		return false, fmt.Errorf("inspect table %s: %w", table, err)
+	}
+	return count > 0, nil
 }
 
 func (s *Store) Close() error {
@@ -219,8 +355,13 @@ INSERT INTO aggregator_stats (
     other_ip,
     other_port,
     other_type,
-    packets
-) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    packets,
+    other_country,
+    other_city,
+    other_asn,
+    other_as_org,
+    threat_feeds
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `)
 	if err != nil {
 		return fmt.Errorf("prepare stats insert: %w", err)
@@ -229,6 +370,27 @@ INSERT INTO aggregator_stats (
 
 	// Insert all entries from the payload
 	for _, stat := range payload.Stats {
+		var country, city sql.NullString
+		if s.geo != nil {
+			if c, ct, ok := s.geo.Locate(stat.OtherIp); ok {
+				country = sql.NullString{String: c, Valid: c != ""}
+				city = sql.NullString{String: ct, Valid: ct != ""}
+			}
+		}
+		var asNumber sql.NullInt64
+		var asOrg sql.NullString
+		if s.asn != nil {
+			if number, org, ok := s.asn.LookupAsn(stat.OtherIp); ok {
+				asNumber = sql.NullInt64{Int64: int64(number), Valid: true}
+				asOrg = sql.NullString{String: org, Valid: org != ""}
+			}
+		}
+		var threatFeeds sql.NullString
+		if s.threats != nil {
+			if feeds := s.threats.MatchIP(stat.OtherIp); len(feeds) > 0 {
+				threatFeeds = sql.NullString{String: strings.Join(feeds, ","), Valid: true}
+			}
+		}
 		_, err = stmt.ExecContext(
 			ctx,
 			batchID,
@@ -248,27 +410,64 @@ INSERT INTO aggregator_stats (
 			stat.OtherPort,
 			stat.OtherType,
 			stat.Packets,
+			country,
+			city,
+			asNumber,
+			asOrg,
+			threatFeeds,
 		)
 		if err != nil {
 			return fmt.Errorf("insert stats entry: %w", err)
 		}
 	}
 
+	// The hourly report of every local IP in the batch must be exported again.
+	if _, err = tx.ExecContext(ctx, markChanges+`
+SELECT DISTINCT ?, ?, local_ip FROM aggregator_stats WHERE batch_id = ?
+	`, Hourly, payload.LogTimeEnd/3600*3600, batchID); err != nil {
+		return fmt.Errorf("mark changed hour: %w", err)
+	}
+
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("commit stats transaction: %w", err)
 	}
 	return nil
 }
 
+// DeleteOlderThan removes the raw batches that ended before cutoff. Call
+// Rollup first to keep their totals.
 func (s *Store) DeleteOlderThan(ctx context.Context, cutoff int64) error {
-	if _, err := s.db.ExecContext(
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin prune transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(
 		ctx,
 		`DELETE FROM aggregator_batches WHERE log_time_end < ?`,
 		cutoff,
 	); err != nil {
 		return fmt.Errorf("delete expired batches: %w", err)
 	}
+	// The hour containing the cutoff lost some of its batches: exporting it
+	// again would truncate its report.
+	if err = deleteChangesBefore(ctx, tx, Hourly, cutoff); err != nil {
+		return err
+	}
+
+	// Remember where complete hours start for Rollup.
+	if err = writeState(ctx, tx, stateKeyPrunedBefore, cutoff); err != nil {
+		return err
+	}
 
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit prune transaction: %w", err)
+	}
 	return nil
 }
 
@@ -312,12 +511,17 @@ SELECT
     s.detected_protocol_name,
     s.detected_application_name,
     COALESCE(s.other_host, s.other_ip) as host,
-    SUM(s.local_bytes + s.other_bytes) as total_bytes
+    COALESCE(s.other_country, '') as country,
+    COALESCE('AS' || s.other_asn || COALESCE(' ' || s.other_as_org, ''), '') as asn,
+    COALESCE(s.threat_feeds, '') as threats,
+    COUNT(*) as entries,
+    SUM(s.local_bytes + s.other_bytes) as total_bytes,
+    MAX(COALESCE(s.local_mac, '')) as local_mac
 FROM aggregator_stats s
 JOIN aggregator_batches b ON s.batch_id = b.id
 WHERE b.log_time_end >= ? AND b.log_time_end < ?
-GROUP BY s.local_ip, s.detected_protocol_name, s.detected_application_name, COALESCE(s.other_host, s.other_ip)
-ORDER BY s.local_ip, s.detected_protocol_name, s.detected_application_name, COALESCE(s.other_host, s.other_ip)
+GROUP BY s.local_ip, s.detected_protocol_name, s.detected_application_name, COALESCE(s.other_host, s.other_ip), country, asn, threats
+ORDER BY s.local_ip, s.detected_protocol_name, s.detected_application_name, COALESCE(s.other_host, s.other_ip), country, asn, threats
 	`, hourStart, hourEnd)
 	if err != nil {
 		return nil, fmt.Errorf("query hour %d-%d: %w", hourStart, hourEnd, err)
@@ -327,15 +531,24 @@ ORDER BY s.local_ip, s.detected_protocol_name, s.detected_application_name, COAL
 	var result []HourRow
 	for rows.Next() {
 		var row HourRow
+		var threats string
 		if err := rows.Scan(
 			&row.LocalIP,
 			&row.ProtocolName,
 			&row.ApplicationName,
 			&row.Host,
+			&row.Country,
+			&row.Asn,
+			&threats,
+			&row.Entries,
 			&row.TotalBytes,
+			&row.LocalMac,
 		); err != nil {
 			return nil, fmt.Errorf("scan hour row: %w", err)
 		}
+		if threats != "" {
+			row.Threats = strings.Split(threats, ",")
+		}
 		result = append(result, row)
 	}
 
@@ -375,12 +588,97 @@ ORDER BY other_ip
 }
 
 func (s *Store) SaveResolvedHost(ctx context.Context, ip, hostname string) error {
-	if _, err := s.db.ExecContext(ctx, `
+	var domainFeeds []string
+	if s.threats != nil {
+		domainFeeds = s.threats.MatchDomain(hostname)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin resolved host transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// The reports of the hours listing ip change with its hostname.
+	if _, err = tx.ExecContext(ctx, markChanges+`
+SELECT DISTINCT ?, (b.log_time_end / 3600) * 3600, s.local_ip
+FROM aggregator_stats s
+JOIN aggregator_batches b ON s.batch_id = b.id
+WHERE s.other_ip = ? AND s.other_host IS NULL
+	`, Hourly, ip); err != nil {
+		return fmt.Errorf("mark hours of %q changed: %w", ip, err)
+	}
+
+	// The feeds listing the hostname are merged into those listing ip, which
+	// differ between entries saved before and after a feed update.
+	var ipFeeds []sql.NullString
+	ipFeeds, err = s.unresolvedFeeds(ctx, tx, ip)
+	if err != nil {
+		return err
+	}
+	for _, feeds := range ipFeeds {
+		if _, err = tx.ExecContext(ctx, `
 UPDATE aggregator_stats
-SET other_host = ?
-WHERE other_ip = ? AND other_host IS NULL
-	`, hostname, ip); err != nil {
-		return fmt.Errorf("save resolved host for %q: %w", ip, err)
+SET other_host = ?, threat_feeds = ?
+WHERE other_ip = ? AND other_host IS NULL AND threat_feeds IS ?
+		`, hostname, mergeFeeds(feeds, domainFeeds), ip, feeds); err != nil {
+			return fmt.Errorf("save resolved host for %q: %w", ip, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit resolved host for %q: %w", ip, err)
 	}
 	return nil
 }
+
+// unresolvedFeeds returns the distinct threat feeds of the entries of ip
+// without a hostname.
+func (s *Store) unresolvedFeeds(
+	ctx context.Context,
+	tx *sql.Tx,
+	ip string,
+) ([]sql.NullString, error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT DISTINCT threat_feeds
+FROM aggregator_stats
+WHERE other_ip = ? AND other_host IS NULL
+	`, ip)
+	if err != nil {
+		return nil, fmt.Errorf("query threat feeds of %q: %w", ip, err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var result []sql.NullString
+	for rows.Next() {
+		var feeds sql.NullString
+		if err := rows.Scan(&feeds); err != nil {
+			return nil, fmt.Errorf("scan threat feeds: %w", err)
+		}
+		result = append(result, feeds)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate threat feeds: %w", err)
+	}
+	return result, nil
+}
+
+// mergeFeeds adds the feeds missing from the comma-separated list existing.
+func mergeFeeds(existing sql.NullString, feeds []string) sql.NullString {
+	var merged []string
+	if existing.Valid && existing.String != "" {
+		merged = strings.Split(existing.String, ",")
+	}
+	for _, feed := range feeds {
+		if !slices.Contains(merged, feed) {
+			merged = append(merged, feed)
+		}
+	}
+	if len(merged) == 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: strings.Join(merged, ","), Valid: true}
+}
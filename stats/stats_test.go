@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	_ "modernc.org/sqlite"
@@ -679,3 +680,234 @@ func TestQueryHour(t *testing.T) {
 		}
 	})
 }
+
+type staticGeo map[string][2]string
+
+func (g staticGeo) Locate(ip string) (string, string, bool) {
+	location, ok := g[ip]
+	return location[0], location[1], ok
+}
+
+func TestStoreGeoLocation(t *testing.T) {
+	t.Run("stores country and city of other_ip", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "stats.db")
+		geo := staticGeo{"8.8.8.8": {"US", "Mountain View"}, "2.2.2.2": {"FR", ""}}
+		store, err := NewStore(context.Background(), dbPath, WithGeoLocator(geo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close() //nolint:errcheck
+
+		payload := AggregatorPayload{
+			LogTimeEnd: 1800,
+			Stats: []AggregatorEntry{
+				{LocalIp: "10.0.0.1", OtherIp: "8.8.8.8", LocalBytes: 100, OtherBytes: 100},
+				{LocalIp: "10.0.0.1", OtherIp: "2.2.2.2", LocalBytes: 10, OtherBytes: 10},
+				{LocalIp: "10.0.0.1", OtherIp: "10.0.0.2", LocalBytes: 1, OtherBytes: 1},
+			},
+		}
+		if err := store.Save(context.Background(), payload); err != nil {
+			t.Fatal(err)
+		}
+
+		var country, city sql.NullString
+		if err := store.db.QueryRow(
+			`SELECT other_country, other_city FROM aggregator_stats WHERE other_ip = ?`,
+			"8.8.8.8",
+		).Scan(&country, &city); err != nil {
+			t.Fatal(err)
+		}
+		if country.String != "US" || city.String != "Mountain View" {
+			t.Fatalf("expected US/Mountain View, got %q/%q", country.String, city.String)
+		}
+
+		if err := store.db.QueryRow(
+			`SELECT other_country, other_city FROM aggregator_stats WHERE other_ip = ?`,
+			"10.0.0.2",
+		).Scan(&country, &city); err != nil {
+			t.Fatal(err)
+		}
+		if country.Valid || city.Valid {
+			t.Fatalf("expected NULL location, got %v/%v", country, city)
+		}
+
+		rows, err := store.QueryHour(context.Background(), 0, 3600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		countries := make(map[string]int64)
+		for _, row := range rows {
+			countries[row.Country] += row.TotalBytes
+		}
+		if countries["US"] != 200 || countries["FR"] != 20 || countries[""] != 2 {
+			t.Fatalf("unexpected per-country totals: %v", countries)
+		}
+	})
+
+	t.Run("upgrades databases created without location columns", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "stats.db")
+		db, err := sql.Open("sqlite", dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`
+CREATE TABLE aggregator_batches (id INTEGER PRIMARY KEY AUTOINCREMENT, log_time_end INTEGER NOT NULL);
+CREATE TABLE aggregator_stats (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    batch_id INTEGER NOT NULL REFERENCES aggregator_batches(id) ON DELETE CASCADE,
+    detected_application INTEGER, detected_application_name TEXT,
+    detected_protocol INTEGER, detected_protocol_name TEXT,
+    interface TEXT, ip_protocol INTEGER, ip_version INTEGER,
+    local_bytes BIGINT, local_ip VARCHAR, local_mac VARCHAR, local_origin BOOLEAN,
+    other_bytes BIGINT, other_ip VARCHAR, other_host TEXT, other_port INTEGER,
+    other_type TEXT, packets INTEGER
+);`); err != nil {
+			t.Fatal(err)
+		}
+		db.Close() //nolint:errcheck
+
+		store, err := NewStore(context.Background(), dbPath, WithGeoLocator(staticGeo{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close() //nolint:errcheck
+
+		payload := AggregatorPayload{
+			LogTimeEnd: 1800,
+			Stats:      []AggregatorEntry{{LocalIp: "10.0.0.1", OtherIp: "8.8.8.8"}},
+		}
+		if err := store.Save(context.Background(), payload); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+type staticAsn map[string]struct {
+	number uint32
+	org    string
+}
+
+func (a staticAsn) LookupAsn(ip string) (uint32, string, bool) {
+	info, ok := a[ip]
+	return info.number, info.org, ok
+}
+
+func TestStoreAsn(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "stats.db")
+	asns := staticAsn{
+		"8.8.8.8": {15169, "Google LLC"},
+		"8.8.4.4": {15169, "Google LLC"},
+		"3.3.3.3": {16509, ""},
+	}
+	store, err := NewStore(context.Background(), dbPath, WithAsnLookup(asns))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close() //nolint:errcheck
+
+	payload := AggregatorPayload{
+		LogTimeEnd: 1800,
+		Stats: []AggregatorEntry{
+			{LocalIp: "10.0.0.1", OtherIp: "8.8.8.8", LocalBytes: 100, OtherBytes: 100},
+			{LocalIp: "10.0.0.1", OtherIp: "8.8.4.4", LocalBytes: 50, OtherBytes: 50},
+			{LocalIp: "10.0.0.1", OtherIp: "3.3.3.3", LocalBytes: 10, OtherBytes: 10},
+			{LocalIp: "10.0.0.1", OtherIp: "10.0.0.2", LocalBytes: 1, OtherBytes: 1},
+		},
+	}
+	if err := store.Save(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := store.QueryHour(context.Background(), 0, 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := BuildReport(rows)
+	if report.Asn["AS15169 Google LLC"] != 300 {
+		t.Fatalf("expected AS15169 300, got %v", report.Asn)
+	}
+	if report.Asn["AS16509"] != 20 {
+		t.Fatalf("expected AS16509 20, got %v", report.Asn)
+	}
+	if len(report.Asn) != 2 {
+		t.Fatalf("expected 2 autonomous systems, got %v", report.Asn)
+	}
+}
+
+type staticThreats struct {
+	ips     map[string][]string
+	domains map[string][]string
+}
+
+func (s staticThreats) MatchIP(ip string) []string {
+	return s.ips[ip]
+}
+
+func (s staticThreats) MatchDomain(name string) []string {
+	return s.domains[name]
+}
+
+func TestStoreThreats(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "stats.db")
+	threats := staticThreats{
+		ips: map[string][]string{
+			"5.6.7.8": {"firehol_level1"},
+			"6.6.6.6": {"firehol_level1", "spamhaus_drop"},
+		},
+		domains: map[string][]string{
+			"c2.evil.example": {"firehol_level1", "malware"},
+		},
+	}
+	store, err := NewStore(context.Background(), dbPath, WithThreatMatcher(threats))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close() //nolint:errcheck
+
+	payload := AggregatorPayload{
+		LogTimeEnd: 1800,
+		Stats: []AggregatorEntry{
+			{LocalIp: "10.0.0.1", OtherIp: "5.6.7.8", LocalBytes: 10},
+			{LocalIp: "10.0.0.1", OtherIp: "5.6.7.8", LocalBytes: 10},
+			{LocalIp: "10.0.0.1", OtherIp: "6.6.6.6", LocalBytes: 10},
+			{LocalIp: "10.0.0.1", OtherIp: "9.9.9.9", LocalBytes: 10},
+		},
+	}
+	if err := store.Save(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveResolvedHost(context.Background(), "5.6.7.8", "c2.evil.example"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveResolvedHost(context.Background(), "9.9.9.9", "dns.quad9.net"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := store.QueryHour(context.Background(), 0, 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := BuildReport(rows)
+	expected := map[string]map[string]int64{
+		"firehol_level1": {"c2.evil.example": 2, "6.6.6.6": 1},
+		"malware":        {"c2.evil.example": 2},
+		"spamhaus_drop":  {"6.6.6.6": 1},
+	}
+	if !reflect.DeepEqual(report.Threats, expected) {
+		t.Fatalf("unexpected threat counts: %v", report.Threats)
+	}
+	if report.Total != 40 {
+		t.Fatalf("expected total 40, got %d", report.Total)
+	}
+
+	// Feeds listing both the address and the hostname are stored once.
+	var feeds string
+	if err := store.db.QueryRow(
+		`SELECT DISTINCT threat_feeds FROM aggregator_stats WHERE other_ip = '5.6.7.8'`,
+	).Scan(&feeds); err != nil {
+		t.Fatal(err)
+	}
+	if feeds != "firehol_level1,malware" {
+		t.Fatalf("expected merged feeds, got %q", feeds)
+	}
+}
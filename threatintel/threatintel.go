@@ -0,0 +1,239 @@
+// Package threatintel matches flow endpoints against blocklists kept on disk.
+// A feed file lists one indicator per line: IP addresses and CIDR prefixes
+// (FireHOL netset/ipset style) or domain names, either bare or in hosts-file
+// form ("0.0.0.0 example.com"). A domain also matches all its subdomains.
+package threatintel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+// Matched flow fields.
+const (
+	FieldOtherIp        = "other_ip"
+	FieldHostServerName = "host_server_name"
+	FieldClientSni      = "ssl.client_sni"
+	FieldDnsHostName    = "dns_host_name"
+)
+
+// indicators is the parsed content of all feeds.
+type indicators struct {
+	names   []string
+	ips     prefixTrie
+	domains map[string][]int
+}
+
+// Intel is a reloadable set of feeds. Reload swaps the in-memory copy only
+// once every feed file has been read.
+type Intel struct {
+	paths      []string
+	indicators atomic.Pointer[indicators]
+}
+
+// New creates an Intel reading the given feed files. Each feed is named after
+// its file, without extension. Call Reload to load the files.
+func New(paths ...string) *Intel {
+	return &Intel{paths: paths}
+}
+
+// FeedName returns the name given to the feed stored at path.
+func FeedName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// Paths returns the feed file paths, for change detection.
+func (i *Intel) Paths() []string {
+	return i.paths
+}
+
+// Reload reads all feed files. On failure the previously loaded feeds, if any,
+// stay in use.
+func (i *Intel) Reload() error {
+	loaded := &indicators{domains: make(map[string][]int)}
+	for index, path := range i.paths {
+		name := FeedName(path)
+		if slices.Contains(loaded.names, name) {
+			return fmt.Errorf("load threat feed %s: duplicate feed name %q", path, name)
+		}
+		loaded.names = append(loaded.names, name)
+
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("load threat feed %s: %w", path, err)
+		}
+		ips, domains, skipped, err := loaded.parse(file, index)
+		_ = file.Close()
+		if err != nil {
+			return fmt.Errorf("load threat feed %s: %w", path, err)
+		}
+		slog.Debug(
+			"Loaded threat feed",
+			"feed", name,
+			"ips", ips,
+			"domains", domains,
+			"skipped", skipped,
+		)
+	}
+
+	i.indicators.Store(loaded)
+	return nil
+}
+
+// parse adds the indicators read from r to feed.
+func (l *indicators) parse(r io.Reader, feed int) (ips, domains, skipped int, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+			continue
+		case 2:
+			// hosts-file form: the address is a sinkhole, the name is listed.
+			if _, err := netip.ParseAddr(fields[0]); err != nil {
+				skipped++
+				continue
+			}
+			fields = fields[1:]
+		case 1:
+		default:
+			skipped++
+			continue
+		}
+
+		indicator := fields[0]
+		if prefix, err := netip.ParsePrefix(indicator); err == nil {
+			l.ips.insert(prefix, feed)
+			ips++
+		} else if addr, err := netip.ParseAddr(indicator); err == nil {
+			l.ips.insert(netip.PrefixFrom(addr, addr.BitLen()), feed)
+			ips++
+		} else if domain, ok := normalizeDomain(indicator); ok {
+			if !slices.Contains(l.domains[domain], feed) {
+				l.domains[domain] = append(l.domains[domain], feed)
+			}
+			domains++
+		} else {
+			skipped++
+		}
+	}
+	return ips, domains, skipped, scanner.Err()
+}
+
+// normalizeDomain lowercases name and strips wildcard prefixes and the
+// trailing dot. It rejects anything that is not a plausible host name.
+func normalizeDomain(name string) (string, bool) {
+	name = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(name), "*."), ".")
+	if name == "" || !strings.Contains(name, ".") || name == "localhost" {
+		return "", false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '.' && c != '_' {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// feedNames maps feed indexes to sorted, unique names.
+func (l *indicators) feedNames(feeds []int) []string {
+	if len(feeds) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(feeds))
+	for _, feed := range feeds {
+		names = append(names, l.names[feed])
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// MatchIP returns the names of the feeds listing ip.
+func (i *Intel) MatchIP(ip string) []string {
+	loaded := i.indicators.Load()
+	if loaded == nil {
+		return nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	return loaded.feedNames(loaded.ips.lookup(addr))
+}
+
+// MatchDomain returns the names of the feeds listing name or one of its parent
+// domains.
+func (i *Intel) MatchDomain(name string) []string {
+	loaded := i.indicators.Load()
+	if loaded == nil {
+		return nil
+	}
+	name, ok := normalizeDomain(name)
+	if !ok {
+		return nil
+	}
+	var feeds []int
+	for {
+		feeds = append(feeds, loaded.domains[name]...)
+		_, parent, found := strings.Cut(name, ".")
+		if !found || !strings.Contains(parent, ".") {
+			break
+		}
+		name = parent
+	}
+	return loaded.feedNames(feeds)
+}
+
+// Match checks the remote address and the host names of a flow.
+func (i *Intel) Match(flow flows.FlowComplete) []flows.ThreatMatch {
+	var matches []flows.ThreatMatch
+	add := func(field, indicator string, feeds []string) {
+		for _, feed := range feeds {
+			matches = append(matches, flows.ThreatMatch{
+				Feed:      feed,
+				Field:     field,
+				Indicator: indicator,
+			})
+		}
+	}
+
+	add(FieldOtherIp, flow.OtherIp, i.MatchIP(flow.OtherIp))
+	var sni string
+	if flow.Ssl != nil {
+		sni = flow.Ssl.ClientSni
+	}
+	domains := []struct{ field, name string }{
+		{FieldHostServerName, flow.HostServerName},
+		{FieldClientSni, sni},
+		{FieldDnsHostName, flow.DnsHostName},
+	}
+	for _, domain := range domains {
+		if domain.name != "" {
+			add(domain.field, domain.name, i.MatchDomain(domain.name))
+		}
+	}
+	return matches
+}
+
+// Enrich tags a flow with the feeds matching its endpoints.
+func (i *Intel) Enrich(event *flows.FlowEvent) {
+	flow, ok := event.Flow.(flows.FlowComplete)
+	if !ok {
+		return
+	}
+	if matches := i.Match(flow); len(matches) > 0 {
+		event.EnsureEnrichment().Threats = matches
+	}
+}
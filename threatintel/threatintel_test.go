@@ -0,0 +1,142 @@
+package threatintel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/nethserver/nethsecurity-monitoring/flows"
+)
+
+const netset = `#
+# firehol_level1
+#
+1.2.3.0/24
+5.6.7.8
+10.0.0.0/8
+10.1.0.0/16
+2001:db8::/32
+not an ip
+`
+
+const domainList = `# malware domains
+evil.example
+*.tracker.example
+0.0.0.0 phish.example   # hosts-file form
+127.0.0.1 localhost
+1.2.3.4
+`
+
+func writeFeeds(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	ipFeed := filepath.Join(dir, "firehol_level1.netset")
+	domainFeed := filepath.Join(dir, "malware.domains")
+	if err := os.WriteFile(ipFeed, []byte(netset), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(domainFeed, []byte(domainList), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return ipFeed, domainFeed
+}
+
+func TestIntel(t *testing.T) {
+	ipFeed, domainFeed := writeFeeds(t)
+	intel := New(ipFeed, domainFeed)
+
+	t.Run("matches nothing before loading", func(t *testing.T) {
+		assert.Equal(t, []string(nil), intel.MatchIP("1.2.3.4"))
+		assert.Equal(t, []string(nil), intel.MatchDomain("evil.example"))
+	})
+
+	if err := intel.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("matches addresses", func(t *testing.T) {
+		tests := []struct {
+			ip       string
+			expected []string
+		}{
+			{"1.2.3.4", []string{"firehol_level1", "malware"}},
+			{"1.2.3.255", []string{"firehol_level1"}},
+			{"1.2.4.1", nil},
+			{"5.6.7.8", []string{"firehol_level1"}},
+			{"5.6.7.9", nil},
+			{"10.1.2.3", []string{"firehol_level1"}},
+			{"::ffff:10.9.9.9", []string{"firehol_level1"}},
+			{"2001:db8:1::1", []string{"firehol_level1"}},
+			{"2001:db9::1", nil},
+			{"invalid", nil},
+		}
+		for _, tt := range tests {
+			assert.Equal(t, tt.expected, intel.MatchIP(tt.ip))
+		}
+	})
+
+	t.Run("matches domains and subdomains", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			expected []string
+		}{
+			{"evil.example", []string{"malware"}},
+			{"WWW.Evil.Example.", []string{"malware"}},
+			{"a.b.tracker.example", []string{"malware"}},
+			{"phish.example", []string{"malware"}},
+			{"notevil.example", nil},
+			{"example", nil},
+			{"localhost", nil},
+		}
+		for _, tt := range tests {
+			assert.Equal(t, tt.expected, intel.MatchDomain(tt.name))
+		}
+	})
+
+	t.Run("tags flows", func(t *testing.T) {
+		event := flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{
+				OtherIp:        "5.6.7.8",
+				HostServerName: "cdn.example",
+				DnsHostName:    "www.evil.example",
+				Ssl:            &flows.Ssl{ClientSni: "login.phish.example"},
+			},
+		}
+		intel.Enrich(&event)
+		assert.Equal(t, []flows.ThreatMatch{
+			{Feed: "firehol_level1", Field: FieldOtherIp, Indicator: "5.6.7.8"},
+			{Feed: "malware", Field: FieldClientSni, Indicator: "login.phish.example"},
+			{Feed: "malware", Field: FieldDnsHostName, Indicator: "www.evil.example"},
+		}, event.Enrichment.Threats)
+
+		clean := flows.FlowEvent{
+			Type: flows.FlowTypeDpiComplete,
+			Flow: flows.FlowComplete{OtherIp: "9.9.9.9"},
+		}
+		intel.Enrich(&clean)
+		assert.Equal(t, (*flows.Enrichment)(nil), clean.Enrichment)
+	})
+
+	t.Run("keeps previous feeds on failure", func(t *testing.T) {
+		if err := os.Remove(domainFeed); err != nil {
+			t.Fatal(err)
+		}
+		if err := intel.Reload(); err == nil {
+			t.Fatal("expected reload error")
+		}
+		assert.Equal(t, []string{"malware"}, intel.MatchDomain("evil.example"))
+	})
+}
+
+func TestDuplicateFeedNames(t *testing.T) {
+	ipFeed, _ := writeFeeds(t)
+	copyPath := filepath.Join(t.TempDir(), filepath.Base(ipFeed))
+	if err := os.WriteFile(copyPath, []byte(netset), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(ipFeed, copyPath).Reload(); err == nil {
+		t.Fatal("expected duplicate feed name error")
+	}
+}
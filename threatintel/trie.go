@@ -0,0 +1,58 @@
+package threatintel
+
+import "net/netip"
+
+// prefixTrie is a binary trie of IP prefixes. Each node that ends a prefix
+// records the feeds listing it, so a lookup collects every feed whose
+// prefixes contain the address in at most 32 (IPv4) or 128 (IPv6) steps.
+type prefixTrie struct {
+	v4 trieNode
+	v6 trieNode
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	feeds    []int
+}
+
+func (t *prefixTrie) insert(prefix netip.Prefix, feed int) {
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+	node := &t.v6
+	if addr.Is4() {
+		node = &t.v4
+	}
+	bytes := addr.AsSlice()
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := bytes[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	for _, existing := range node.feeds {
+		if existing == feed {
+			return
+		}
+	}
+	node.feeds = append(node.feeds, feed)
+}
+
+// lookup returns the feeds with a prefix containing addr, possibly repeated.
+func (t *prefixTrie) lookup(addr netip.Addr) []int {
+	addr = addr.Unmap()
+	node := &t.v6
+	if addr.Is4() {
+		node = &t.v4
+	}
+	bytes := addr.AsSlice()
+	matches := node.feeds
+	for i := 0; i < len(bytes)*8; i++ {
+		node = node.children[bytes[i/8]>>(7-i%8)&1]
+		if node == nil {
+			break
+		}
+		matches = append(matches[:len(matches):len(matches)], node.feeds...)
+	}
+	return matches
+}